@@ -0,0 +1,424 @@
+package avatar
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/andreykaipov/goobs/api/events"
+	"github.com/jnrprgmr/strmr/pkg/twitch"
+)
+
+const (
+	// EventState is sent first to every subscriber with how the avatar is now.
+	EventState         = "state"
+	EventSpeakingStart = "speaking_start"
+	EventSpeakingStop  = "speaking_stop"
+	EventExpression    = "expression"
+)
+
+const (
+	// ExpressionIdle is shown when nothing else is.
+	ExpressionIdle = "idle"
+	// ExpressionTalking is shown while speaking without a reaction.
+	ExpressionTalking   = "talking"
+	ExpressionHappy     = "happy"
+	ExpressionSurprised = "surprised"
+	// ExpressionSleeping is shown after nothing has happened for a while.
+	ExpressionSleeping = "sleeping"
+)
+
+const (
+	// TriggerLive is triggered when the stream starts.
+	TriggerLive       = "live"
+	TriggerFollow     = "follow"
+	TriggerRaid       = "raid"
+	TriggerSubscribe  = "subscribe"
+	TriggerCheer      = "cheer"
+	TriggerRedemption = "redemption"
+)
+
+// twitchTriggers are the triggers of the twitch events.
+var twitchTriggers = map[string]string{
+	twitch.EventStreamOnline: TriggerLive,
+	twitch.EventFollow:       TriggerFollow,
+	twitch.EventRaid:         TriggerRaid,
+	twitch.EventSubscribe:    TriggerSubscribe,
+	twitch.EventCheer:        TriggerCheer,
+	twitch.EventRedemption:   TriggerRedemption,
+}
+
+const (
+	defaultSleepAfter = 10 * time.Minute
+	defaultReaction   = 5 * time.Second
+)
+
+var ErrUnknownTrigger = errors.New("trigger is not configured")
+
+// subscriberBuffer is how many events a subscriber can fall behind before
+// it is dropped.
+const subscriberBuffer = 16
+
+type Config struct {
+	// SleepAfter is how long the avatar waits without speech or reactions
+	// before it falls asleep, 10m when 0.
+	SleepAfter time.Duration `yaml:"sleep_after"`
+	// Reaction is how long a reaction is shown, 5s when 0.
+	Reaction time.Duration `yaml:"reaction"`
+	// Expressions are the assets of every expression by name. The built in
+	// expressions are drawn by the avatar page when they have no assets.
+	Expressions map[string]Assets `yaml:"expressions"`
+	// Triggers picks the expression a stream event reacts with, one of live,
+	// follow, raid, subscribe, cheer or redemption. Without any, live and
+	// follow are happy and raid is surprised.
+	Triggers map[string]string `yaml:"triggers"`
+	// Keywords react to words in the text being spoken.
+	Keywords []Keyword `yaml:"keywords"`
+}
+
+// Assets are the images of an expression with the mouth closed and open. A
+// reaction with the expression lasts Duration, Config.Reaction when 0.
+type Assets struct {
+	Closed   string        `yaml:"closed" json:"closed"`
+	Open     string        `yaml:"open" json:"open"`
+	Duration time.Duration `yaml:"duration" json:"-"`
+}
+
+// Keyword reacts with Expression when any of Words is spoken. Words with a
+// space in them match as a phrase, the case of the text does not matter.
+type Keyword struct {
+	Words      []string `yaml:"words"`
+	Expression string   `yaml:"expression"`
+}
+
+// Speaking is the speech the avatar is speaking. Envelope is its loudness
+// every StepMillis from 0 to 1, for moving the mouth in time with it.
+type Speaking struct {
+	ID             int64     `json:"id"`
+	Source         string    `json:"source"`
+	Text           string    `json:"text"`
+	StartMillis    int64     `json:"start_millis"`
+	DurationMillis int64     `json:"duration_millis"`
+	StepMillis     int64     `json:"step_millis"`
+	Envelope       []float64 `json:"envelope"`
+}
+
+// Event is a change of the avatar along with its whole state, so a client
+// only has to apply the latest event. TimeMillis is when the event was made,
+// it tells how far into the speech a client that connects late is.
+type Event struct {
+	Type       string    `json:"type"`
+	TimeMillis int64     `json:"time_millis"`
+	Expression string    `json:"expression"`
+	Assets     Assets    `json:"assets"`
+	Speaking   *Speaking `json:"speaking"`
+}
+
+// Hub is the state machine of the avatar. It is idle, talking while speech
+// plays and sleeping after SleepAfter without anything happening. Reactions
+// from the API, stream events and keywords show over those for a while.
+// Every change is pushed to the subscribers.
+type Hub struct {
+	sleep_after    time.Duration
+	reaction_time  time.Duration
+	expressions    map[string]Assets
+	triggers       map[string]string
+	keywords       []Keyword
+	mu             sync.Mutex
+	speaking       *Speaking
+	sleeping       bool
+	reaction       string
+	reaction_until time.Time
+	last_active    time.Time
+	subscribers    map[chan Event]struct{}
+}
+
+func NewHub(c Config) (*Hub, error) {
+	h := &Hub{
+		sleep_after:   c.SleepAfter,
+		reaction_time: c.Reaction,
+		expressions: map[string]Assets{
+			ExpressionIdle:      {},
+			ExpressionTalking:   {},
+			ExpressionHappy:     {},
+			ExpressionSurprised: {},
+			ExpressionSleeping:  {},
+		},
+		triggers:    c.Triggers,
+		last_active: time.Now(),
+		subscribers: map[chan Event]struct{}{},
+	}
+	if h.sleep_after <= 0 {
+		h.sleep_after = defaultSleepAfter
+	}
+	if h.reaction_time <= 0 {
+		h.reaction_time = defaultReaction
+	}
+	for name, assets := range c.Expressions {
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.New("expressions need a name")
+		}
+		h.expressions[name] = assets
+	}
+	if len(h.triggers) == 0 {
+		h.triggers = map[string]string{
+			TriggerLive:   ExpressionHappy,
+			TriggerFollow: ExpressionHappy,
+			TriggerRaid:   ExpressionSurprised,
+		}
+	}
+	for trigger, name := range h.triggers {
+		if _, ok := h.expressions[name]; !ok {
+			return nil, errors.New("expression [" + name + "] of trigger [" + trigger + "] is not one of " + strings.Join(h.Expressions(), ", "))
+		}
+	}
+	for _, keyword := range c.Keywords {
+		if _, ok := h.expressions[keyword.Expression]; !ok {
+			return nil, errors.New("expression [" + keyword.Expression + "] of keywords [" + strings.Join(keyword.Words, ", ") + "] is not one of " + strings.Join(h.Expressions(), ", "))
+		}
+		words := []string{}
+		for _, word := range keyword.Words {
+			word = strings.ToLower(strings.TrimSpace(word))
+			if word != "" {
+				words = append(words, word)
+			}
+		}
+		h.keywords = append(h.keywords, Keyword{
+			Words:      words,
+			Expression: keyword.Expression,
+		})
+	}
+	return h, nil
+}
+
+// Expressions are the names of the expressions in order.
+func (h *Hub) Expressions() []string {
+	names := []string{}
+	for name := range h.expressions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expression is what the avatar shows now, the hub must be locked.
+func (h *Hub) expression() string {
+	switch {
+	case h.reaction != "":
+		return h.reaction
+	case h.speaking != nil:
+		return ExpressionTalking
+	case h.sleeping:
+		return ExpressionSleeping
+	}
+	return ExpressionIdle
+}
+
+// event is the state of the hub as an event of type, the hub must be locked.
+func (h *Hub) event(event_type string) Event {
+	expression := h.expression()
+	event := Event{
+		Type:       event_type,
+		TimeMillis: time.Now().UnixMilli(),
+		Expression: expression,
+		Assets:     h.expressions[expression],
+	}
+	if h.speaking != nil {
+		speaking := *h.speaking
+		event.Speaking = &speaking
+	}
+	return event
+}
+
+// State is how the avatar is now.
+func (h *Hub) State() Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.event(EventState)
+}
+
+// Subscribe returns the events of the avatar, starting with its state, and a
+// func to stop them. The events are closed if the subscriber falls behind.
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := make(chan Event, subscriberBuffer)
+	events <- h.event(EventState)
+	h.subscribers[events] = struct{}{}
+	return events, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(events)
+	}
+}
+
+// remove closes events once, the hub must be locked.
+func (h *Hub) remove(events chan Event) {
+	if _, ok := h.subscribers[events]; ok {
+		delete(h.subscribers, events)
+		close(events)
+	}
+}
+
+// publish sends an event of type to every subscriber, the hub must be locked.
+func (h *Hub) publish(event_type string) {
+	event := h.event(event_type)
+	for events := range h.subscribers {
+		select {
+		case events <- event:
+		default:
+			// a late client would animate out of step, it gets the state
+			// again when it reconnects
+			h.remove(events)
+		}
+	}
+}
+
+// wake keeps the avatar awake for another SleepAfter, the hub must be locked.
+func (h *Hub) wake() {
+	h.sleeping = false
+	h.last_active = time.Now()
+}
+
+// react shows expression for as long as its reaction lasts, the hub must be
+// locked.
+func (h *Hub) react(expression string) {
+	duration := h.expressions[expression].Duration
+	if duration <= 0 {
+		duration = h.reaction_time
+	}
+	h.wake()
+	h.reaction = expression
+	h.reaction_until = time.Now().Add(duration)
+}
+
+// keyword is the expression of the first keyword in text, empty without one.
+func (h *Hub) keyword(text string) string {
+	text = strings.ToLower(text)
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	}) {
+		words[word] = true
+	}
+	for _, keyword := range h.keywords {
+		for _, word := range keyword.Words {
+			if words[word] || (strings.Contains(word, " ") && strings.Contains(text, word)) {
+				return keyword.Expression
+			}
+		}
+	}
+	return ""
+}
+
+func (h *Hub) StartSpeaking(speaking Speaking) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.wake()
+	h.speaking = &speaking
+	if expression := h.keyword(speaking.Text); expression != "" {
+		h.react(expression)
+	}
+	h.publish(EventSpeakingStart)
+}
+
+func (h *Hub) StopSpeaking() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.speaking == nil {
+		return
+	}
+	h.wake()
+	h.speaking = nil
+	h.publish(EventSpeakingStop)
+}
+
+// SetExpression shows expression. Idle ends the reaction being shown and
+// sleeping puts the avatar to sleep, any other expression is a reaction.
+func (h *Hub) SetExpression(expression string) error {
+	if _, ok := h.expressions[expression]; !ok {
+		return errors.New("expression must be one of " + strings.Join(h.Expressions(), ", "))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch expression {
+	case ExpressionIdle:
+		h.wake()
+		h.reaction = ""
+	case ExpressionSleeping:
+		h.sleeping = true
+		h.reaction = ""
+	default:
+		h.react(expression)
+	}
+	h.publish(EventExpression)
+	return nil
+}
+
+// Trigger reacts to a stream event with the expression configured for it.
+func (h *Hub) Trigger(trigger string) error {
+	expression, ok := h.triggers[trigger]
+	if !ok {
+		return ErrUnknownTrigger
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.react(expression)
+	h.publish(EventExpression)
+	return nil
+}
+
+// tick ends reactions that are over and puts the avatar to sleep.
+func (h *Hub) tick(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	before := h.expression()
+	if h.reaction != "" && !now.Before(h.reaction_until) {
+		h.reaction = ""
+		h.last_active = now
+	}
+	if !h.sleeping && h.speaking == nil && h.reaction == "" && now.Sub(h.last_active) >= h.sleep_after {
+		h.sleeping = true
+	}
+	if h.expression() != before {
+		h.publish(EventExpression)
+	}
+}
+
+// Run keeps the state of the avatar over time and triggers live when the
+// stream starts, from the OBS events, and the triggers of the twitch events
+// until ctx is cancelled.
+func (h *Hub) Run(ctx context.Context, obs_events <-chan interface{}, twitch_events <-chan twitch.Event) {
+	ticker := time.NewTicker(time.Second / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.tick(now)
+		case event, ok := <-obs_events:
+			if !ok {
+				obs_events = nil
+				continue
+			}
+			e, ok := event.(*events.StreamStateChanged)
+			if ok && e.OutputActive && e.OutputState == "OBS_WEBSOCKET_OUTPUT_STARTED" {
+				// fine without a live trigger configured
+				h.Trigger(TriggerLive)
+			}
+		case event, ok := <-twitch_events:
+			if !ok {
+				twitch_events = nil
+				continue
+			}
+			if trigger, ok := twitchTriggers[event.Type]; ok {
+				h.Trigger(trigger)
+			}
+		}
+	}
+}
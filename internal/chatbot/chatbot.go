@@ -0,0 +1,241 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jnrprgmr/strmr/internal/speech"
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/twitch"
+	"github.com/jnrprgmr/strmr/pkg/twitch/chat"
+)
+
+// Bot answers the commands of chat from the state of strmr. The built in
+// commands are task, title, uptime, socials, say, approve and reject, any
+// other command in the chat_command table answers with its response.
+type Bot struct {
+	chat     *chat.Client
+	router   *chat.Router
+	database *database.Database
+	twitch   *twitch.Twitch
+	speech   *speech.Moderator
+	// account is the account of the profile, its socials and twitch channel
+	// are the ones answered with
+	account string
+}
+
+func New(client *chat.Client, db *database.Database, t *twitch.Twitch, moderator *speech.Moderator, account string) *Bot {
+	b := &Bot{
+		chat:     client,
+		router:   chat.NewRouter(db),
+		database: db,
+		twitch:   t,
+		speech:   moderator,
+		account:  account,
+	}
+	b.router.Handle("task", b.task)
+	b.router.Handle("title", b.title)
+	b.router.Handle("uptime", b.uptime)
+	b.router.Handle("socials", b.socials)
+	b.router.Handle("say", b.say)
+	b.router.Handle("approve", b.approve)
+	b.router.Handle("reject", b.reject)
+	return b
+}
+
+// Run answers commands until ctx is cancelled.
+func (b *Bot) Run(ctx context.Context) {
+	messages, stop := b.chat.Subscribe()
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-messages:
+			answer, err := b.router.Route(ctx, msg)
+			if err != nil {
+				fmt.Println("Cannot answer [" + msg.Text + "] in twitch chat: " + err.Error())
+				continue
+			}
+			err = b.chat.Say(answer, msg.ID)
+			if err != nil {
+				fmt.Println("Cannot answer [" + msg.Text + "] in twitch chat: " + err.Error())
+			}
+		}
+	}
+}
+
+// accountOf is the account of the profile, the only account when the
+// profile has none.
+func (b *Bot) accountOf(ctx context.Context) (*database.Account, error) {
+	if b.account != "" {
+		account, err := b.database.GetAccountByNameContext(ctx, b.account)
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			return nil, errors.New("account [" + b.account + "] does not exist")
+		}
+		return account, nil
+	}
+	accounts, err := b.database.GetAccountsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) != 1 {
+		return nil, errors.New("pick an account of the profile")
+	}
+	return &accounts[0], nil
+}
+
+func (b *Bot) task(ctx context.Context, command chat.Command) (string, error) {
+	tasks, err := b.database.GetLatestMetadataByKeyContext(ctx, "task", 1)
+	if err != nil {
+		return "", err
+	}
+	if len(tasks) == 0 || strings.TrimSpace(tasks[0].MetadataValue) == "" {
+		return "No task right now, just hanging out.", nil
+	}
+	return "Working on: " + tasks[0].MetadataValue, nil
+}
+
+func (b *Bot) title(ctx context.Context, command chat.Command) (string, error) {
+	account, err := b.accountOf(ctx)
+	if err != nil {
+		return "", err
+	}
+	logins := []string{}
+	if account.TwitchLogin != "" {
+		logins = append(logins, account.TwitchLogin)
+	}
+	users, err := b.twitch.GetUsersContext(ctx, logins)
+	if err != nil {
+		return "", err
+	}
+	ids := []string{}
+	for _, id := range users {
+		ids = append(ids, id)
+	}
+	if len(ids) != 1 {
+		return "", errors.New("cannot find the twitch channel of account [" + account.Name + "]")
+	}
+	channels, err := b.twitch.GetChannelInformationContext(ctx, ids)
+	if err != nil {
+		return "", err
+	}
+	for _, channel := range channels {
+		if channel.CategoryName == "" {
+			return channel.Title, nil
+		}
+		return channel.Title + " (" + channel.CategoryName + ")", nil
+	}
+	return "", errors.New("twitch has no channel information of account [" + account.Name + "]")
+}
+
+// formatUptime is d in hours and minutes, e.g. 2h 5m.
+func formatUptime(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	hours := int(d / time.Hour)
+	minutes := int((d % time.Hour) / time.Minute)
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+func (b *Bot) uptime(ctx context.Context, command chat.Command) (string, error) {
+	stream, err := b.database.GetLatestStreamContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if stream == nil {
+		return "The stream is offline.", nil
+	}
+	return "Live for " + formatUptime(time.Since(time.Unix(stream.StartTime, 0))) + ".", nil
+}
+
+func (b *Bot) socials(ctx context.Context, command chat.Command) (string, error) {
+	account, err := b.accountOf(ctx)
+	if err != nil {
+		return "", err
+	}
+	socials := []string{}
+	for _, social := range account.Socials {
+		socials = append(socials, social.Name+": "+social.URL)
+	}
+	if len(socials) == 0 {
+		return "", nil
+	}
+	return strings.Join(socials, " | "), nil
+}
+
+// say speaks the text after the command through the avatar once it is
+// moderated, it answers when the text waits for approval or is refused.
+func (b *Bot) say(ctx context.Context, command chat.Command) (string, error) {
+	pending, err := b.speech.Submit(speech.Submission{
+		Source:    speech.SourceChat,
+		UserID:    command.Message.UserID,
+		UserLogin: command.Message.UserLogin,
+		Text:      command.Args,
+		Moderator: command.Message.Moderator(),
+	})
+	switch err {
+	case nil:
+		if pending != nil {
+			return "Waiting for a moderator to approve #" + strconv.FormatInt(pending.ID, 10) + ".", nil
+		}
+		return "", nil
+	case speech.ErrEmpty:
+		return "Usage: " + chat.Prefix + "say <text>", nil
+	case speech.ErrBanned, speech.ErrCooldown, speech.ErrTooLong, speech.ErrTooMany, speech.ErrQueueFull, speech.ErrRateLimited:
+		return "Cannot say that: " + err.Error(), nil
+	}
+	return "", err
+}
+
+// pendingID is the id of a pending text after !approve or !reject, 0 for
+// the oldest.
+func pendingID(args string) (int64, bool) {
+	args = strings.TrimPrefix(strings.TrimSpace(args), "#")
+	if args == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(args, 10, 64)
+	return id, err == nil && id > 0
+}
+
+func (b *Bot) approve(ctx context.Context, command chat.Command) (string, error) {
+	id, ok := pendingID(command.Args)
+	if !ok {
+		return "Usage: " + chat.Prefix + "approve [id]", nil
+	}
+	pending, err := b.speech.Approve(id)
+	switch err {
+	case nil:
+		return "Approved #" + strconv.FormatInt(pending.ID, 10) + " from " + pending.Submission.UserLogin + ".", nil
+	case speech.ErrNotPending:
+		return "Nothing to approve.", nil
+	case speech.ErrQueueFull, speech.ErrRateLimited:
+		return "Cannot say #" + strconv.FormatInt(pending.ID, 10) + " yet, it is still waiting: " + err.Error(), nil
+	}
+	return "", err
+}
+
+func (b *Bot) reject(ctx context.Context, command chat.Command) (string, error) {
+	id, ok := pendingID(command.Args)
+	if !ok {
+		return "Usage: " + chat.Prefix + "reject [id]", nil
+	}
+	pending, err := b.speech.Reject(id)
+	if err == speech.ErrNotPending {
+		return "Nothing to reject.", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "Rejected #" + strconv.FormatInt(pending.ID, 10) + " from " + pending.Submission.UserLogin + ".", nil
+}
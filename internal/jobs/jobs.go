@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Job states, a job is queued until the worker takes it and then ends done
+// or failed.
+const (
+	StateQueued  = "queued"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+const (
+	maxQueued = 32
+	// keepFinished is how long a finished job can still be polled
+	keepFinished = time.Hour
+)
+
+var ErrQueueFull = errors.New("job queue is full, try again later")
+
+// Work is what a job does, its result is reported once the job is done.
+type Work func(ctx context.Context) (interface{}, error)
+
+// Job is a job as reported to the page polling it.
+type Job struct {
+	ID         int64       `json:"id"`
+	Kind       string      `json:"kind"`
+	State      string      `json:"state"`
+	Error      *string     `json:"error"`
+	Result     interface{} `json:"result"`
+	CreateTime int64       `json:"create_time"`
+	EndTime    *int64      `json:"end_time"`
+	key        string
+	work       Work
+}
+
+// Queue runs slow work like ffmpeg in the background, one job at a time,
+// so a request can return straight away and the page polls the job. Jobs
+// are only kept in memory, a restart forgets them.
+type Queue struct {
+	mu      sync.Mutex
+	next_id int64
+	jobs    map[int64]*Job
+	// running holds the id of the queued or running job of each key
+	running map[string]int64
+	waiting []int64
+	wake    chan struct{}
+}
+
+func New() *Queue {
+	return &Queue{
+		jobs:    map[int64]*Job{},
+		running: map[string]int64{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Add queues work as a job of kind. When a job with the same key is
+// already queued or running that job is returned instead, so asking twice
+// for the same thing does the work once.
+func (q *Queue) Add(kind string, key string, work Work) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if id, ok := q.running[key]; ok {
+		return *q.jobs[id], nil
+	}
+	if len(q.waiting) >= maxQueued {
+		return Job{}, ErrQueueFull
+	}
+	q.prune()
+	q.next_id++
+	job := &Job{
+		ID:         q.next_id,
+		Kind:       kind,
+		State:      StateQueued,
+		CreateTime: time.Now().Unix(),
+		key:        key,
+		work:       work,
+	}
+	q.jobs[job.ID] = job
+	q.running[key] = job.ID
+	q.waiting = append(q.waiting, job.ID)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return *job, nil
+}
+
+// Get returns the job with id, if it is still known.
+func (q *Queue) Get(id int64) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// prune forgets jobs that finished longer than keepFinished ago.
+func (q *Queue) prune() {
+	before := time.Now().Add(-keepFinished).Unix()
+	for id, job := range q.jobs {
+		if job.EndTime != nil && *job.EndTime < before {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Run works through the queued jobs until ctx is done, which also cancels
+// the running job.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		job, ok := q.take(ctx)
+		if ok {
+			q.run(ctx, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+	}
+}
+
+// take marks the next queued job running.
+func (q *Queue) take(ctx context.Context) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) == 0 || ctx.Err() != nil {
+		return nil, false
+	}
+	job := q.jobs[q.waiting[0]]
+	q.waiting = q.waiting[1:]
+	job.State = StateRunning
+	return job, true
+}
+
+func (q *Queue) run(ctx context.Context, job *Job) {
+	name := job.Kind + " job " + strconv.FormatInt(job.ID, 10)
+	fmt.Println("Running " + name)
+	result, err := job.work(ctx)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	end := time.Now().Unix()
+	job.EndTime = &end
+	job.work = nil
+	delete(q.running, job.key)
+	if err != nil {
+		fmt.Println("Cannot finish " + name + ": " + err.Error())
+		message := err.Error()
+		job.State = StateFailed
+		job.Error = &message
+		return
+	}
+	fmt.Println("Finished " + name)
+	job.State = StateDone
+	job.Result = result
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/twitch"
+)
+
+// AccountHeader picks the account of a request when there is no account
+// query parameter.
+const AccountHeader = "X-Strmr-Account"
+
+type AccountView struct {
+	database.Account
+	Identities []database.User `json:"identities"`
+	Active     bool            `json:"active"`
+}
+
+// requestAccountName is the account asked for by the request, the account of
+// the profile otherwise.
+func (h *Handlers) requestAccountName(r *http.Request) string {
+	if name := r.URL.Query().Get("account"); name != "" {
+		return name
+	}
+	if name := r.Header.Get(AccountHeader); name != "" {
+		return name
+	}
+	return h.account
+}
+
+// namedAccount responds with an error and returns false when the account of
+// name does not exist. Without a name the only account is used.
+func (h *Handlers) namedAccount(ctx context.Context, w http.ResponseWriter, name string) (*database.Account, bool) {
+	if name == "" {
+		accounts, err := h.database.GetAccountsContext(ctx)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		if len(accounts) != 1 {
+			h.ErrorResponse(w, "no account selected, pass ?account= or set account in conf/local.yaml", http.StatusBadRequest)
+			return nil, false
+		}
+		return &accounts[0], true
+	}
+	account, err := h.database.GetAccountByNameContext(ctx, name)
+	if err != nil {
+		h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if account == nil {
+		h.ErrorResponse(w, "account ["+name+"] does not exist", http.StatusNotFound)
+		return nil, false
+	}
+	return account, true
+}
+
+// requestAccount is the active account of the request, see
+// requestAccountName.
+func (h *Handlers) requestAccount(w http.ResponseWriter, r *http.Request) (*database.Account, bool) {
+	return h.namedAccount(r.Context(), w, h.requestAccountName(r))
+}
+
+// accountTwitch is the twitch client of the account, responding with an
+// error and returning false when its tokens cannot be loaded.
+func (h *Handlers) accountTwitch(ctx context.Context, w http.ResponseWriter, account database.Account) (*twitch.Twitch, bool) {
+	client, err := h.twitch.Account(ctx, account.ID)
+	if err != nil {
+		h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return client, true
+}
+
+// AccountsHandler lists the accounts and their identities on GET, and
+// creates or updates an account by name on POST.
+func (h *Handlers) AccountsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		accounts, err := h.database.GetAccountsContext(r.Context())
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		active := h.requestAccountName(r)
+		views := []AccountView{}
+		for _, account := range accounts {
+			identities, err := h.database.GetUsersByAccountContext(r.Context(), account.ID)
+			if err != nil {
+				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			views = append(views, AccountView{
+				Account:    account,
+				Identities: identities,
+				Active:     len(accounts) == 1 && active == "" || strings.EqualFold(account.Name, active),
+			})
+		}
+		b, err := json.Marshal(views)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	case http.MethodPost:
+		var data database.Account
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = data.Validate()
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		account, err := h.database.SaveAccountContext(r.Context(), data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(account)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
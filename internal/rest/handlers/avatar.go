@@ -4,17 +4,41 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"os/exec"
 	"text/template"
 	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jnrprgmr/strmr/internal/avatar"
+	"github.com/jnrprgmr/strmr/internal/speech"
+)
+
+const (
+	// avatarWriteWait is how long an event may take to reach the avatar.
+	avatarWriteWait = 10 * time.Second
+	// avatarPingPeriod is how often the avatar is pinged to keep the
+	// connection open and notice when it is gone.
+	avatarPingPeriod = 30 * time.Second
 )
 
-var talking = false
+var avatarUpgrader = websocket.Upgrader{}
+
+type AvatarExpression struct {
+	Expression string `json:"expression"`
+}
+
+type AvatarTrigger struct {
+	Trigger string `json:"trigger"`
+}
 
 type avatarRequest struct {
 	Text string `json:"text"`
+	// Source is where the text comes from, it picks the voice, priority and
+	// rate limit.
+	Source string `json:"source"`
 }
 
+// AvatarStatus queues text for the avatar to speak on POST, and reports
+// whether it is speaking on GET.
 func (h *Handlers) AvatarStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		var data avatarRequest
@@ -24,24 +48,32 @@ func (h *Handlers) AvatarStatus(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		json.Unmarshal(reqBody, &data)
-		talking = true
-		cmd := exec.Command("espeak", "-v", "en+m4", data.Text)
-		start := time.Now()
-		if err := cmd.Run(); err != nil {
+		msg, err := h.speech.Enqueue(data.Source, data.Text)
+		switch err {
+		case nil:
+		case speech.ErrEmpty, speech.ErrTooLong:
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		case speech.ErrRateLimited:
+			h.ErrorResponse(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case speech.ErrQueueFull:
+			h.ErrorResponse(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		default:
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		end := time.Now()
-		t := end.Sub(start)
-		err = h.database.InsertSubtitle(data.Text, t.Seconds())
+		b, err := json.Marshal(msg)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		//fmt.Println(t.Seconds())
-		talking = false
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(b)
 	} else if r.Method == http.MethodGet {
-		if talking {
+		if h.speech.Speaking() {
 			w.WriteHeader(http.StatusOK)
 		} else {
 			w.WriteHeader(http.StatusNotFound)
@@ -68,3 +100,116 @@ func (h *Handlers) Avatar(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 }
+
+// AvatarEventsHandler pushes the state of the avatar and every change of it
+// over a websocket, starting with the state it is in now.
+func (h *Handlers) AvatarEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		conn, err := avatarUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has replied with the error
+			return
+		}
+		defer conn.Close()
+		events, unsubscribe := h.avatar.Subscribe()
+		defer unsubscribe()
+		// nothing is expected from the avatar, reading notices it going away
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				_, _, err := conn.NextReader()
+				if err != nil {
+					return
+				}
+			}
+		}()
+		ping := time.NewTicker(avatarPingPeriod)
+		defer ping.Stop()
+		for {
+			select {
+			case <-gone:
+				return
+			case <-ping.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(avatarWriteWait))
+			case event, ok := <-events:
+				if !ok {
+					// fell behind, the avatar reconnects for the state
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(avatarWriteWait))
+				err = conn.WriteJSON(event)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// AvatarExpressionHandler changes the expression of the avatar on POST, and
+// reports the state of the avatar on GET.
+func (h *Handlers) AvatarExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(h.avatar.State())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	case http.MethodPost:
+		var data AvatarExpression
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = h.avatar.SetExpression(data.Expression)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// AvatarTriggerHandler reacts to a stream event, e.g. a follow or a raid sent
+// by a chat bot, with the expression configured for it.
+func (h *Handlers) AvatarTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data AvatarTrigger
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = h.avatar.Trigger(data.Trigger)
+		if err == avatar.ErrUnknownTrigger {
+			h.ErrorResponse(w, "trigger ["+data.Trigger+"] is not configured", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+)
+
+type ChatCommandDeleted struct {
+	Deleted bool `json:"deleted"`
+}
+
+// ChatCommandsHandler lists the commands of the chat bot on GET, creates or
+// updates one by name on POST and deletes the one named name on DELETE.
+func (h *Handlers) ChatCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		commands, err := h.database.GetChatCommandsContext(r.Context())
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(commands)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	case http.MethodPost:
+		// commands are enabled unless the request says otherwise
+		data := database.ChatCommand{
+			Permission: database.PermissionEveryone,
+			Enabled:    true,
+		}
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = data.Validate()
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		taken, err := h.database.ChatCommandNameTakenContext(r.Context(), data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if taken != "" {
+			h.ErrorResponse(w, "["+taken+"] is already used by another command", http.StatusConflict)
+			return
+		}
+		command, err := h.database.SaveChatCommandContext(r.Context(), data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(command)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			h.ErrorResponse(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		deleted, err := h.database.DeleteChatCommandContext(r.Context(), name)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			h.ErrorResponse(w, "command ["+name+"] does not exist", http.StatusNotFound)
+			return
+		}
+		b, err := json.Marshal(ChatCommandDeleted{Deleted: deleted})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -4,17 +4,33 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/jnrprgmr/strmr/internal/avatar"
+	"github.com/jnrprgmr/strmr/internal/jobs"
+	"github.com/jnrprgmr/strmr/internal/speech"
+	"github.com/jnrprgmr/strmr/internal/upload"
+	"github.com/jnrprgmr/strmr/pkg/clip"
 	"github.com/jnrprgmr/strmr/pkg/database"
 	"github.com/jnrprgmr/strmr/pkg/obs"
+	"github.com/jnrprgmr/strmr/pkg/thumbnail"
 	"github.com/jnrprgmr/strmr/pkg/twitch"
 	"github.com/jnrprgmr/strmr/pkg/youtube"
 )
 
 type Handlers struct {
-	twitch   *twitch.Twitch
-	obs      *obs.OBS
-	youtube  *youtube.YouTube
-	database *database.Database
+	twitch     *twitch.Twitch
+	obs        obs.Controller
+	youtube    *youtube.YouTube
+	database   *database.Database
+	uploads    *upload.Queue
+	thumbnails *thumbnail.Generator
+	clips      *clip.Cutter
+	jobs       *jobs.Queue
+	speech     *speech.Queue
+	moderator  *speech.Moderator
+	avatar     *avatar.Hub
+	// account is the account of the profile, used when a request does not
+	// pick one
+	account string
 }
 
 type HTTPError struct {
@@ -37,11 +53,19 @@ func (h *Handlers) ErrorResponse(w http.ResponseWriter, message string, code int
 	w.Write(b)
 }
 
-func New(twitchCli *twitch.Twitch, obsCli *obs.OBS, yt *youtube.YouTube, db *database.Database) *Handlers {
+func New(twitchCli *twitch.Twitch, obsCli obs.Controller, yt *youtube.YouTube, db *database.Database, uploads *upload.Queue, thumbnails *thumbnail.Generator, clips *clip.Cutter, jobs *jobs.Queue, speech *speech.Queue, moderator *speech.Moderator, hub *avatar.Hub, account string) *Handlers {
 	return &Handlers{
-		twitch:   twitchCli,
-		obs:      obsCli,
-		youtube:  yt,
-		database: db,
+		twitch:     twitchCli,
+		obs:        obsCli,
+		youtube:    yt,
+		database:   db,
+		uploads:    uploads,
+		thumbnails: thumbnails,
+		clips:      clips,
+		jobs:       jobs,
+		speech:     speech,
+		moderator:  moderator,
+		avatar:     hub,
+		account:    account,
 	}
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"text/template"
 
@@ -30,40 +31,24 @@ type FlatOverlay struct {
 }
 
 func (h *Handlers) ObsHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOBS(w) {
+		return
+	}
 	if r.Method == http.MethodGet {
-		scenes, err := h.obs.GetSceneList()
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		input_settings, err := h.obs.GetInputSettings(h.obs.TaskSourceName)
-		input_exists := true
-		if err != nil {
-			input_exists = false
-		}
-		background_settings, err := h.obs.GetInputSettings(h.obs.BackgroundSourceName)
-		background_exists := true
-		if err != nil {
-			background_exists = false
+		state := h.obs.Snapshot()
+		scenes := []*typedefs.Scene{}
+		for i := range state.Scenes {
+			scenes = append(scenes, &typedefs.Scene{
+				SceneIndex: i,
+				SceneName:  state.Scenes[i],
+			})
 		}
-		overlay_text_settings, err := h.obs.GetInputSettings(h.obs.OverlayTextSourceName)
-		overlay_text_exists := true
-		if err != nil {
-			overlay_text_exists = false
-		}
-		overlay_background_settings, err := h.obs.GetInputSettings(h.obs.OverlayBackgroundSourceName)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		stream_status, err := h.obs.GetStreamStatus()
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		record_status, err := h.obs.GetRecordStatus()
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		input_settings, input_exists := state.InputSettings(h.obs.Names().TaskSourceName)
+		background_settings, background_exists := state.InputSettings(h.obs.Names().BackgroundSourceName)
+		overlay_text_settings, overlay_text_exists := state.InputSettings(h.obs.Names().OverlayTextSourceName)
+		overlay_background_settings, ok := state.InputSettings(h.obs.Names().OverlayBackgroundSourceName)
+		if !ok {
+			h.ErrorResponse(w, "No source with name ["+h.obs.Names().OverlayBackgroundSourceName+"] found", http.StatusBadRequest)
 			return
 		}
 		task := Task{
@@ -76,46 +61,33 @@ func (h *Handlers) ObsHandler(w http.ResponseWriter, r *http.Request) {
 			Background: background_exists,
 		}
 		if input_exists {
-			current_scene, err := h.obs.GetCurrentScene()
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			transform, err := h.obs.GetSceneItemTransform(h.obs.GetSceneItemId(current_scene, h.obs.TaskSourceName), current_scene)
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			item, ok := state.SceneItem(state.CurrentScene, h.obs.Names().TaskSourceName)
+			if !ok {
+				h.ErrorResponse(w, "No scene item ["+h.obs.Names().TaskSourceName+"] in scene ["+state.CurrentScene+"]", http.StatusBadRequest)
 				return
 			}
-			hex, err := h.obs.ConvertIntToHex(int64(input_settings.InputSettings["color1"].(float64)))
+			transform := item.Transform
+			color, err := h.settingsColor(input_settings, "color1")
 			if err != nil {
 				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			hx := *hex
-			bHex := hx[2:4]
-			gHex := hx[4:6]
-			rHex := hx[6:8]
-			color := rHex + gHex + bHex
+			text, _ := input_settings["text"].(string)
 			task = Task{
-				Text:       input_settings.InputSettings["text"].(string),
+				Text:       text,
 				Color:      color,
-				PosX:       transform.SceneItemTransform.PositionX,
-				PosY:       transform.SceneItemTransform.PositionY,
-				Width:      transform.SceneItemTransform.BoundsWidth,
-				Height:     transform.SceneItemTransform.BoundsHeight,
+				PosX:       transform.PositionX,
+				PosY:       transform.PositionY,
+				Width:      transform.BoundsWidth,
+				Height:     transform.BoundsHeight,
 				Background: background_exists,
 			}
 			if background_exists {
-				hex, err := h.obs.ConvertIntToHex(int64(background_settings.InputSettings["color"].(float64)))
+				task.BackgroundColor, err = h.settingsColor(background_settings, "color")
 				if err != nil {
 					h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 					return
 				}
-				hx := *hex
-				bHex := hx[2:4]
-				gHex := hx[4:6]
-				rHex := hx[6:8]
-				task.BackgroundColor = rHex + gHex + bHex
 			}
 		}
 		overlay := FlatOverlay{
@@ -129,49 +101,29 @@ func (h *Handlers) ObsHandler(w http.ResponseWriter, r *http.Request) {
 			Enabled:         false,
 		}
 		if overlay_text_exists {
-			current_scene, err := h.obs.GetCurrentScene()
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			item, ok := state.SceneItem(state.CurrentScene, h.obs.Names().OverlayTextSourceName)
+			if !ok {
+				h.ErrorResponse(w, "No scene item ["+h.obs.Names().OverlayTextSourceName+"] in scene ["+state.CurrentScene+"]", http.StatusBadRequest)
 				return
 			}
-			overlay_text_transform, err := h.obs.GetSceneItemTransform(h.obs.GetSceneItemId(current_scene, h.obs.OverlayTextSourceName), current_scene)
+			color, err := h.settingsColor(overlay_text_settings, "color1")
 			if err != nil {
 				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			overlay_text_hex, err := h.obs.ConvertIntToHex(int64(overlay_text_settings.InputSettings["color1"].(float64)))
+			background_color, err := h.settingsColor(overlay_background_settings, "color")
 			if err != nil {
 				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			overlay_background_hex, err := h.obs.ConvertIntToHex(int64(overlay_background_settings.InputSettings["color"].(float64)))
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			overlay.Text = overlay_text_settings.InputSettings["text"].(string)
-			hx := *overlay_text_hex
-			bHex := hx[2:4]
-			gHex := hx[4:6]
-			rHex := hx[6:8]
-			color := rHex + gHex + bHex
+			overlay.Text, _ = overlay_text_settings["text"].(string)
 			overlay.TextColor = color
-			overlay.TextPosX = overlay_text_transform.SceneItemTransform.PositionX
-			overlay.TextPosY = overlay_text_transform.SceneItemTransform.PositionY
-			overlay.TextWidth = overlay_text_transform.SceneItemTransform.BoundsWidth
-			overlay.TextHeight = overlay_text_transform.SceneItemTransform.BoundsHeight
-			bghx := *overlay_background_hex
-			bgbHex := bghx[2:4]
-			bggHex := bghx[4:6]
-			bgrHex := bghx[6:8]
-			bgcolor := bgrHex + bggHex + bgbHex
-			overlay.BackgroundColor = bgcolor
-			visible, err := h.obs.GetSceneSourceVisible(h.obs.GetSceneItemId(current_scene, h.obs.OverlayTextSourceName), current_scene)
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			overlay.Enabled = *visible
+			overlay.TextPosX = item.Transform.PositionX
+			overlay.TextPosY = item.Transform.PositionY
+			overlay.TextWidth = item.Transform.BoundsWidth
+			overlay.TextHeight = item.Transform.BoundsHeight
+			overlay.BackgroundColor = background_color
+			overlay.Enabled = item.Enabled
 		}
 		tmpl := template.Must(template.ParseFiles("./templates/obs.html"))
 		tmpl.Execute(w, struct {
@@ -183,6 +135,7 @@ func (h *Handlers) ObsHandler(w http.ResponseWriter, r *http.Request) {
 			StreamStatus bool
 			RecordStatus bool
 			Overlay      FlatOverlay
+			Expressions  []string
 		}{
 			Title: "OBS stream settings",
 			Javascript: []string{
@@ -192,11 +145,30 @@ func (h *Handlers) ObsHandler(w http.ResponseWriter, r *http.Request) {
 			CSS: []string{
 				"obs",
 			},
-			Scenes:       scenes.Scenes,
+			Scenes:       scenes,
 			Task:         task,
-			StreamStatus: stream_status,
-			RecordStatus: record_status,
+			StreamStatus: state.Streaming,
+			RecordStatus: state.Recording,
 			Overlay:      overlay,
+			Expressions:  h.avatar.Expressions(),
 		})
 	}
 }
+
+// settingsColor converts an OBS ABGR color setting to the RRGGBB hex used by
+// the color inputs.
+func (h *Handlers) settingsColor(settings map[string]interface{}, key string) (string, error) {
+	c, ok := settings[key].(float64)
+	if !ok {
+		return "", errors.New("setting [" + key + "] is not a color")
+	}
+	hex, err := h.obs.ConvertIntToHex(int64(c))
+	if err != nil {
+		return "", err
+	}
+	hx := *hex
+	bHex := hx[2:4]
+	gHex := hx[4:6]
+	rHex := hx[6:8]
+	return rHex + gHex + bHex, nil
+}
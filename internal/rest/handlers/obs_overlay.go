@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/jnrprgmr/strmr/pkg/database"
 	"github.com/jnrprgmr/strmr/pkg/obs"
 )
 
@@ -20,6 +21,9 @@ type Overlay struct {
 }
 
 func (h *Handlers) UpdateOBSOverlay(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOBS(w) {
+		return
+	}
 	if r.Method == http.MethodPost {
 		var data Overlay
 		reqBody, err := ioutil.ReadAll(r.Body)
@@ -28,14 +32,15 @@ func (h *Handlers) UpdateOBSOverlay(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		json.Unmarshal(reqBody, &data)
-		current_scene, err := h.obs.GetCurrentScene()
+		controller := h.obs.WithContext(r.Context())
+		current_scene, err := controller.GetCurrentScene()
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
-		transform, err := h.obs.GetSceneItemTransform(h.obs.GetSceneItemId(current_scene, h.obs.ScreenSourceName), current_scene)
+		transform, err := controller.GetSceneItemTransform(controller.GetSceneItemId(current_scene, controller.Names().ScreenSourceName), current_scene)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
 		color, err := obs.ConvertColor(data.BackgroundColor)
@@ -48,9 +53,9 @@ func (h *Handlers) UpdateOBSOverlay(w http.ResponseWriter, r *http.Request) {
 			"width":  transform.SceneItemTransform.Width,
 			"height": transform.SceneItemTransform.Height,
 		}
-		_, err = h.obs.SetInputSettings(h.obs.OverlayBackgroundSourceName, overlay_background_settings)
+		_, err = controller.SetInputSettings(controller.Names().OverlayBackgroundSourceName, overlay_background_settings)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
 		text_color, err := obs.ConvertColor(data.TextColor)
@@ -58,29 +63,49 @@ func (h *Handlers) UpdateOBSOverlay(w http.ResponseWriter, r *http.Request) {
 			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		config := &database.OverlayConfig{
+			Text:            data.Text,
+			TextColor:       text_color,
+			BackgroundColor: color,
+			Width:           data.TextWidth,
+			Height:          data.TextHeight,
+			PosX:            data.TextPosX,
+			PosY:            data.TextPosY,
+			Enabled:         data.Enabled,
+		}
+		err = config.Validate()
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		overlay_text_settings := map[string]interface{}{
 			"text":   data.Text,
 			"color1": text_color,
 			"color2": text_color,
 		}
-		_, err = h.obs.SetInputSettings(h.obs.OverlayTextSourceName, overlay_text_settings)
+		_, err = controller.SetInputSettings(controller.Names().OverlayTextSourceName, overlay_text_settings)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
-		_, err = h.obs.SetSceneItemTransform(h.obs.GetSceneItemId(current_scene, h.obs.OverlayTextSourceName), current_scene, data.TextPosX, data.TextPosY, data.TextWidth, data.TextHeight)
+		_, err = controller.SetSceneItemTransform(controller.GetSceneItemId(current_scene, controller.Names().OverlayTextSourceName), current_scene, data.TextPosX, data.TextPosY, data.TextWidth, data.TextHeight)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
-		err = h.obs.SetSceneItemEnabled(h.obs.GetSceneItemId(current_scene, h.obs.OverlayBackgroundSourceName), current_scene, data.Enabled)
+		err = controller.SetSceneItemEnabled(controller.GetSceneItemId(current_scene, controller.Names().OverlayBackgroundSourceName), current_scene, data.Enabled)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
-		err = h.obs.SetSceneItemEnabled(h.obs.GetSceneItemId(current_scene, h.obs.OverlayTextSourceName), current_scene, data.Enabled)
+		err = controller.SetSceneItemEnabled(controller.GetSceneItemId(current_scene, controller.Names().OverlayTextSourceName), current_scene, data.Enabled)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
+			return
+		}
+		err = h.database.SaveSettingContext(r.Context(), config)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		return
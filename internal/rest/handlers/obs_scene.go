@@ -15,6 +15,9 @@ type SceneResp struct {
 }
 
 func (h *Handlers) CreateScene(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOBS(w) {
+		return
+	}
 	if r.Method == http.MethodPost {
 		var data CreateSceneReq
 		reqBody, err := ioutil.ReadAll(r.Body)
@@ -23,9 +26,10 @@ func (h *Handlers) CreateScene(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		json.Unmarshal(reqBody, &data)
-		scenes, err := h.obs.GetSceneList()
+		controller := h.obs.WithContext(r.Context())
+		scenes, err := controller.GetSceneList()
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
 		if len(data.Name) == 0 {
@@ -41,9 +45,9 @@ func (h *Handlers) CreateScene(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		_, err = h.obs.CreateScene(data.Name)
+		_, err = controller.CreateScene(data.Name)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
 		names = append(names, data.Name)
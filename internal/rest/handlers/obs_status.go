@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jnrprgmr/strmr/pkg/obs"
+)
+
+// requireOBS writes a 503 when OBS is not connected, handlers that talk to
+// OBS return early when it reports false.
+func (h *Handlers) requireOBS(w http.ResponseWriter) bool {
+	if h.obs.Connected() {
+		return true
+	}
+	status := h.obs.Status()
+	message := "OBS is " + string(status.State)
+	if status.LastError != "" {
+		message = message + ": " + status.LastError
+	}
+	h.obsUnavailable(w, message)
+	return false
+}
+
+func (h *Handlers) obsUnavailable(w http.ResponseWriter, message string) {
+	w.Header().Set("Retry-After", "5")
+	h.ErrorResponse(w, message, http.StatusServiceUnavailable)
+}
+
+// obsError writes err from a call to OBS with status, or a 503 when OBS went
+// away while the handler was talking to it.
+func (h *Handlers) obsError(w http.ResponseWriter, err error, status int) {
+	if errors.Is(err, obs.ErrNotConnected) || !h.obs.Connected() {
+		h.obsUnavailable(w, err.Error())
+		return
+	}
+	h.ErrorResponse(w, err.Error(), status)
+}
+
+func (h *Handlers) OBSStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		b, err := json.Marshal(h.obs.Status())
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
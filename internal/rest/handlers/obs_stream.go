@@ -14,6 +14,9 @@ type Stream struct {
 }
 
 func (h *Handlers) UpdateOBSStream(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOBS(w) {
+		return
+	}
 	if r.Method == http.MethodPost {
 		var data Stream
 		reqBody, err := ioutil.ReadAll(r.Body)
@@ -22,67 +25,68 @@ func (h *Handlers) UpdateOBSStream(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		json.Unmarshal(reqBody, &data)
-		stream_status, err := h.obs.GetStreamStatus()
+		controller := h.obs.WithContext(r.Context())
+		stream_status, err := controller.GetStreamStatus()
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
 		if data.Stream != stream_status {
-			err = h.database.EndActiveStreams()
+			err = h.database.EndActiveStreamsContext(r.Context())
 			if err != nil {
 				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			if data.Stream {
-				err = h.database.InsertStream()
+				err = h.database.InsertStreamContext(r.Context())
 				if err != nil {
 					h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 					return
 				}
 			}
-			_, err := h.obs.ToggleStream()
+			_, err := controller.ToggleStream()
 			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+				h.obsError(w, err, http.StatusBadRequest)
 				return
 			}
 		}
-		record_status, err := h.obs.GetRecordStatus()
+		record_status, err := controller.GetRecordStatus()
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
 		if data.Record != record_status {
-			err = h.database.EndActiveMediaRecordings()
+			err = h.database.EndActiveMediaRecordingsContext(r.Context())
 			if err != nil {
 				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			if data.Record {
-				dir, err := h.obs.GetRecordDirectory()
+				dir, err := controller.GetRecordDirectory()
 				if err != nil {
-					h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+					h.obsError(w, err, http.StatusInternalServerError)
 					return
 				}
 				file_name_format := strings.ReplaceAll(time.Now().UTC().Format(time.RFC3339), ":", "_")
-				err = h.obs.SetProfileParameter("FilenameFormatting", file_name_format)
+				err = controller.SetProfileParameter("FilenameFormatting", file_name_format)
 				if err != nil {
-					h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+					h.obsError(w, err, http.StatusInternalServerError)
 					return
 				}
-				file_name, err := h.obs.GetProfileParameter("FilenameFormatting")
+				file_name, err := controller.GetProfileParameter("FilenameFormatting")
 				if err != nil {
-					h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+					h.obsError(w, err, http.StatusInternalServerError)
 					return
 				}
-				err = h.database.InsertMediaRecording(file_name, dir)
+				err = h.database.InsertMediaRecordingContext(r.Context(), file_name, dir)
 				if err != nil {
 					h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
 			}
-			err := h.obs.ToggleRecord()
+			err := controller.ToggleRecord()
 			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+				h.obsError(w, err, http.StatusBadRequest)
 				return
 			}
 		}
@@ -2,15 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
 
+	"github.com/jnrprgmr/strmr/pkg/database"
 	"github.com/jnrprgmr/strmr/pkg/obs"
 )
 
 func (h *Handlers) UpdateOBSTask(w http.ResponseWriter, r *http.Request) {
+	if !h.requireOBS(w) {
+		return
+	}
 	if r.Method == http.MethodPost {
 		var data obs.Task
 		reqBody, err := ioutil.ReadAll(r.Body)
@@ -19,8 +21,9 @@ func (h *Handlers) UpdateOBSTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		json.Unmarshal(reqBody, &data)
+		controller := h.obs.WithContext(r.Context())
 		if len(data.Text) != 0 {
-			err = h.database.InsertMetadata("task", data.Text)
+			err = h.database.InsertMetadataContext(r.Context(), "task", data.Text)
 			if err != nil {
 				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 				return
@@ -30,8 +33,15 @@ func (h *Handlers) UpdateOBSTask(w http.ResponseWriter, r *http.Request) {
 				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			config := strconv.Itoa(int(text_color)) + "," + fmt.Sprintf("%f", data.Width) + "," + fmt.Sprintf("%f", data.Height) + "," + fmt.Sprintf("%f", data.PosX) + "," + fmt.Sprintf("%f", data.PosY)
-			err = h.database.InsertMetadata("task_config", config)
+			config := &database.TaskConfig{
+				SourceConfig: sourceConfig(text_color, data),
+			}
+			err = config.Validate()
+			if err != nil {
+				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = h.database.SaveSettingContext(r.Context(), config)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
@@ -43,18 +53,35 @@ func (h *Handlers) UpdateOBSTask(w http.ResponseWriter, r *http.Request) {
 				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			config := strconv.Itoa(int(background_color)) + "," + fmt.Sprintf("%f", data.Width) + "," + fmt.Sprintf("%f", data.Height) + "," + fmt.Sprintf("%f", data.PosX) + "," + fmt.Sprintf("%f", data.PosY)
-			err = h.database.InsertMetadata("task_background_config", config)
+			config := &database.BackgroundConfig{
+				SourceConfig: sourceConfig(background_color, data),
+			}
+			err = config.Validate()
+			if err != nil {
+				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = h.database.SaveSettingContext(r.Context(), config)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 		}
-		err = h.obs.SetTask(data)
+		err = controller.SetTask(data)
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			h.obsError(w, err, http.StatusBadRequest)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func sourceConfig(color int64, task obs.Task) database.SourceConfig {
+	return database.SourceConfig{
+		Color:  color,
+		Width:  task.Width,
+		Height: task.Height,
+		PosX:   task.PosX,
+		PosY:   task.PosY,
+	}
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreykaipov/goobs"
+	"github.com/jmoiron/sqlx"
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/obs"
+	"github.com/jnrprgmr/strmr/pkg/obs/obstest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newOBSHandlers connects handlers to a fake OBS and a fresh database.
+func newOBSHandlers(t *testing.T) (*Handlers, *obstest.Server, *database.Database) {
+	t.Helper()
+	server := obstest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "strmr.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	err = database.Migrate(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.New(conn)
+	controller := obs.New(client, "strmr-screen", "strmr-task-text", "strmr-task-background", "strmr-avatar", "strmr-overlay-text", "strmr-overlay-background")
+	return New(nil, controller, nil, db, nil, nil, nil, nil, nil, nil, nil, ""), server, db
+}
+
+// post calls handler and returns the response along with the requests OBS
+// received while it ran.
+func post(t *testing.T, server *obstest.Server, handler http.HandlerFunc, body string) (*httptest.ResponseRecorder, []string) {
+	t.Helper()
+	before := len(server.Requests())
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return w, server.Requests()[before:]
+}
+
+func countRequests(requests []string, request_type string) int {
+	n := 0
+	for _, r := range requests {
+		if r == request_type {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUpdateOBSTask(t *testing.T) {
+	h, server, db := newOBSHandlers(t)
+	server.AddInput("Scene", "strmr-task-text", obs.SourceTextType, map[string]interface{}{"text": "old"})
+	server.AddInput("Scene", "strmr-task-background", obs.SourceColorBlockType, nil)
+
+	w, requests := post(t, server, h.UpdateOBSTask, `{
+		"text": "writing tests",
+		"pos_x": 30, "pos_y": 800, "width": 300, "height": 90,
+		"color": {"r": 255, "g": 0, "b": 20, "a": 255},
+		"background": {"color": {"r": 200, "g": 200, "b": 200, "a": 255}}
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if countRequests(requests, "SetInputSettings") != 2 {
+		t.Errorf("want task and background settings set, OBS got %v", requests)
+	}
+	if countRequests(requests, "CreateInput") != 0 {
+		t.Errorf("want existing inputs reused, OBS got %v", requests)
+	}
+	settings, _ := server.Input("strmr-task-text")
+	if settings["text"] != "writing tests" {
+		t.Errorf("task text is %v", settings["text"])
+	}
+	color, _ := obs.ConvertColor(obs.Color{R: 255, G: 0, B: 20, A: 255})
+	if settings["color1"] != float64(color) {
+		t.Errorf("task color is %v, want %d", settings["color1"], color)
+	}
+	background, _ := server.Input("strmr-task-background")
+	if background["width"] != float64(304) || background["height"] != float64(94) {
+		t.Errorf("background is %vx%v, want 304x94", background["width"], background["height"])
+	}
+	tasks, err := db.GetLatestMetadataByKey("task", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].MetadataValue != "writing tests" {
+		t.Errorf("stored tasks are %v", tasks)
+	}
+}
+
+func TestUpdateOBSOverlay(t *testing.T) {
+	h, server, _ := newOBSHandlers(t)
+	server.AddInput("Scene", "strmr-screen", obs.SourceScreenType, nil)
+	server.AddInput("Scene", "strmr-overlay-background", obs.SourceColorBlockType, nil)
+	server.AddInput("Scene", "strmr-overlay-text", obs.SourceTextType, nil)
+
+	w, requests := post(t, server, h.UpdateOBSOverlay, `{
+		"text": "be right back",
+		"text_width": 400, "text_height": 100, "text_posx": 600, "text_posy": 400,
+		"text_color": {"r": 255, "g": 255, "b": 255, "a": 255},
+		"background_color": {"r": 0, "g": 0, "b": 0, "a": 200},
+		"enabled": false
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if countRequests(requests, "SetSceneItemEnabled") != 2 {
+		t.Errorf("want overlay background and text hidden, OBS got %v", requests)
+	}
+	text, _ := server.Input("strmr-overlay-text")
+	if text["text"] != "be right back" {
+		t.Errorf("overlay text is %v", text["text"])
+	}
+	for _, item := range server.SceneItems("Scene") {
+		switch item.SourceName {
+		case "strmr-overlay-background", "strmr-overlay-text":
+			if item.SceneItemEnabled {
+				t.Errorf("%s is still shown", item.SourceName)
+			}
+		case "strmr-screen":
+			if !item.SceneItemEnabled {
+				t.Errorf("the screen was hidden")
+			}
+		}
+		if item.SourceName == "strmr-overlay-text" && (item.SceneItemTransform.PositionX != 600 || item.SceneItemTransform.PositionY != 400) {
+			t.Errorf("overlay text is at %v,%v", item.SceneItemTransform.PositionX, item.SceneItemTransform.PositionY)
+		}
+	}
+}
+
+func TestUpdateOBSStream(t *testing.T) {
+	h, server, db := newOBSHandlers(t)
+
+	w, requests := post(t, server, h.UpdateOBSStream, `{"stream": true, "record": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	if countRequests(requests, "ToggleStream") != 1 || countRequests(requests, "ToggleRecord") != 1 {
+		t.Errorf("want the stream and recording toggled once, OBS got %v", requests)
+	}
+	if countRequests(requests, "SetProfileParameter") != 1 {
+		t.Errorf("want the recording file name set, OBS got %v", requests)
+	}
+	if !server.Streaming() || !server.Recording() {
+		t.Errorf("streaming %v, recording %v", server.Streaming(), server.Recording())
+	}
+	stream, err := db.GetLatestStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream == nil || stream.EndTime != nil {
+		t.Errorf("want an active stream, got %v", stream)
+	}
+	recording, err := db.GetLatestMediaRecording()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording == nil {
+		t.Errorf("want the recording stored")
+	}
+
+	// asking for what OBS already does toggles nothing
+	_, requests = post(t, server, h.UpdateOBSStream, `{"stream": true, "record": true}`)
+	if countRequests(requests, "ToggleStream") != 0 || countRequests(requests, "ToggleRecord") != 0 {
+		t.Errorf("want nothing toggled, OBS got %v", requests)
+	}
+
+	_, requests = post(t, server, h.UpdateOBSStream, `{"stream": false, "record": false}`)
+	if countRequests(requests, "ToggleStream") != 1 || countRequests(requests, "ToggleRecord") != 1 {
+		t.Errorf("want the stream and recording toggled off, OBS got %v", requests)
+	}
+	if server.Streaming() || server.Recording() {
+		t.Errorf("streaming %v, recording %v", server.Streaming(), server.Recording())
+	}
+}
+
+func TestOBSHandlersWhileDisconnected(t *testing.T) {
+	h, server, _ := newOBSHandlers(t)
+	h.obs = obs.New(nil, "strmr-screen", "strmr-task-text", "strmr-task-background", "strmr-avatar", "strmr-overlay-text", "strmr-overlay-background")
+
+	w, requests := post(t, server, h.UpdateOBSStream, `{"stream": true}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want 503", w.Code)
+	}
+	if len(requests) != 0 {
+		t.Errorf("want no requests, OBS got %v", requests)
+	}
+}
+
+func TestOBSHandlersWhenOBSDrops(t *testing.T) {
+	h, server, _ := newOBSHandlers(t)
+	controller := obs.New(nil, "strmr-screen", "strmr-task-text", "strmr-task-background", "strmr-avatar", "strmr-overlay-text", "strmr-overlay-background")
+	h.obs = controller
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		controller.Supervise(ctx, func() (*goobs.Client, error) {
+			// OBS crashed on ToggleStream and stays down
+			if countRequests(server.Requests(), "ToggleStream") > 0 {
+				return nil, errors.New("connection refused")
+			}
+			// a request the dropped connection never answers fails quickly
+			return server.Connect(goobs.WithResponseTimeout(300))
+		}, nil)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	for start := time.Now(); !controller.Connected(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("did not connect to OBS")
+		}
+	}
+
+	server.DropOn("ToggleStream")
+	w, requests := post(t, server, h.UpdateOBSStream, `{"stream": true}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want 503: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After on the 503")
+	}
+	if countRequests(requests, "GetStreamStatus") != 1 || countRequests(requests, "ToggleStream") != 1 {
+		t.Errorf("OBS got %v, want it to drop on ToggleStream", requests)
+	}
+	// while OBS is down the next call fails without reaching it
+	w, requests = post(t, server, h.UpdateOBSTask, `{"text": "still down"}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d after the drop, want 503: %s", w.Code, w.Body.String())
+	}
+	if len(requests) != 0 {
+		t.Errorf("want no requests after the drop, OBS got %v", requests)
+	}
+}
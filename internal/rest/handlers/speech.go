@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/jnrprgmr/strmr/internal/speech"
+)
+
+type SpeechPause struct {
+	Paused bool `json:"paused"`
+}
+
+type SpeechSkipped struct {
+	Skipped bool `json:"skipped"`
+}
+
+type SpeechCleared struct {
+	Cleared int `json:"cleared"`
+}
+
+// SpeechDecision approves or rejects the pending text of ID, the oldest
+// when ID is 0.
+type SpeechDecision struct {
+	ID       int64 `json:"id"`
+	Approved bool  `json:"approved"`
+}
+
+// SpeechHandler reports what the avatar is speaking and what is waiting.
+func (h *Handlers) SpeechHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		b, err := json.Marshal(h.speech.Status())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func (h *Handlers) SpeechSkipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		b, err := json.Marshal(SpeechSkipped{
+			Skipped: h.speech.Skip(),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func (h *Handlers) SpeechClearHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		b, err := json.Marshal(SpeechCleared{
+			Cleared: h.speech.Clear(),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func (h *Handlers) SpeechPauseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data SpeechPause
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.speech.SetPaused(data.Paused)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// SpeechLogHandler lists the latest messages taken from the speech queue,
+// 50 unless limit is given.
+func (h *Handlers) SpeechLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		limit := int64(50)
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			limit, err = strconv.ParseInt(l, 10, 64)
+			if err != nil || limit <= 0 {
+				h.ErrorResponse(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		logs, err := h.database.GetLatestSpeechLogsContext(r.Context(), limit)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(logs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// SpeechPendingHandler lists the texts of viewers waiting for a moderator and
+// approves or rejects them.
+func (h *Handlers) SpeechPendingHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(h.moderator.Pending())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	case http.MethodPost:
+		var data SpeechDecision
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if data.ID < 0 {
+			h.ErrorResponse(w, "id cannot be negative", http.StatusBadRequest)
+			return
+		}
+		var pending speech.Pending
+		if data.Approved {
+			pending, err = h.moderator.Approve(data.ID)
+		} else {
+			pending, err = h.moderator.Reject(data.ID)
+		}
+		switch err {
+		case nil:
+		case speech.ErrNotPending:
+			h.ErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		case speech.ErrQueueFull, speech.ErrRateLimited:
+			h.ErrorResponse(w, err.Error(), http.StatusTooManyRequests)
+			return
+		default:
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(pending)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
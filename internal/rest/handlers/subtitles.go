@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/subtitle"
+)
+
+var subtitleContentTypes = map[string]string{
+	subtitle.FormatSRT:    "application/x-subrip; charset=utf-8",
+	subtitle.FormatWebVTT: "text/vtt; charset=utf-8",
+	subtitle.FormatASS:    "text/x-ssa; charset=utf-8",
+}
+
+// subtitleStyle returns the saved subtitle style or the default one.
+func (h *Handlers) subtitleStyle(ctx context.Context) (database.SubtitleStyle, error) {
+	s := database.SubtitleStyle{}
+	found, err := h.database.GetSettingContext(ctx, &s)
+	if err != nil {
+		return s, err
+	}
+	if !found {
+		s.Style = subtitle.DefaultStyle()
+	}
+	return s, nil
+}
+
+// SubtitlesHandler downloads the subtitles of a finished recording as SRT,
+// WebVTT or ASS, the format defaults to SRT.
+func (h *Handlers) SubtitlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		id, err := strconv.ParseInt(r.URL.Query().Get("recording_id"), 10, 64)
+		if err != nil {
+			h.ErrorResponse(w, "recording_id must be a number", http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = subtitle.FormatSRT
+		}
+		content_type, ok := subtitleContentTypes[format]
+		if !ok {
+			h.ErrorResponse(w, "unknown subtitle format ["+format+"], use srt, vtt or ass", http.StatusBadRequest)
+			return
+		}
+		media_record, err := h.database.GetMediaRecordingByIDContext(r.Context(), id)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if media_record == nil {
+			h.ErrorResponse(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		if media_record.EndTime == nil {
+			h.ErrorResponse(w, "recording has not ended", http.StatusBadRequest)
+			return
+		}
+		subtitles, err := h.database.GetSubtitlesByTimeRangeContext(r.Context(), media_record.StartTime, *media_record.EndTime)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cues, err := ConvertRecordingSubtitlesToCues(*media_record, subtitles)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		style, err := h.subtitleStyle(r.Context())
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		text, err := subtitle.Render(format, cues, style.Style)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", content_type)
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(media_record.FileName+subtitle.Extension(format)))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(text))
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func (h *Handlers) SubtitleStyleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s, err := h.subtitleStyle(r.Context())
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	case http.MethodPost:
+		var data database.SubtitleStyle
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = data.Validate()
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = h.database.SaveSettingContext(r.Context(), &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// recordingSubtitleFile is where the captions uploaded with a recording are
+// kept, next to the recording.
+func recordingSubtitleFile(recording database.MediaRecording) string {
+	return recording.Directory + "/" + recording.FileName + subtitle.Extension(subtitle.FormatSRT)
+}
+
+// writeRecordingSubtitles writes the captions of a recording for upload. An
+// upload job reads the file only when it adds the captions, so it is written
+// again after every edit to use edits made while the video uploads.
+func (h *Handlers) writeRecordingSubtitles(ctx context.Context, recording database.MediaRecording) (string, error) {
+	subtitles, err := h.database.GetSubtitlesByTimeRangeContext(ctx, recording.StartTime, *recording.EndTime)
+	if err != nil {
+		return "", err
+	}
+	cues, err := ConvertRecordingSubtitlesToCues(recording, subtitles)
+	if err != nil {
+		return "", err
+	}
+	file := recordingSubtitleFile(recording)
+	err = ioutil.WriteFile(file, []byte(subtitle.SRT(cues)), 0666)
+	if err != nil {
+		return "", errors.New("Cannot write subtitles " + file + ": " + err.Error())
+	}
+	return file, nil
+}
+
+// SubtitleCue is a stored subtitle timed in milliseconds from the start of
+// its recording, as shown in the subtitle editor.
+type SubtitleCue struct {
+	ID    int64  `json:"id"`
+	Text  string `json:"text"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+}
+
+type SubtitleCues struct {
+	Cues     []SubtitleCue `json:"cues"`
+	Problems []string      `json:"problems"`
+}
+
+type SubtitleUpdate struct {
+	RecordingID int64  `json:"recording_id"`
+	ID          int64  `json:"id"`
+	Text        string `json:"text"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+}
+
+type SubtitleDelete struct {
+	RecordingID int64 `json:"recording_id"`
+	ID          int64 `json:"id"`
+}
+
+type SubtitleMerge struct {
+	RecordingID int64   `json:"recording_id"`
+	IDs         []int64 `json:"ids"`
+}
+
+type SubtitleSplit struct {
+	RecordingID int64  `json:"recording_id"`
+	ID          int64  `json:"id"`
+	At          int64  `json:"at"`
+	Text        string `json:"text"`
+	SecondText  string `json:"second_text"`
+}
+
+// editableRecording gets a finished recording and the subtitles of it named
+// by ids, writing the error response and returning false when one is missing.
+func (h *Handlers) editableRecording(ctx context.Context, w http.ResponseWriter, recording_id int64, ids ...int64) (*database.MediaRecording, bool) {
+	media_record, err := h.database.GetMediaRecordingByIDContext(ctx, recording_id)
+	if err != nil {
+		h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if media_record == nil {
+		h.ErrorResponse(w, "recording not found", http.StatusNotFound)
+		return nil, false
+	}
+	if media_record.EndTime == nil {
+		h.ErrorResponse(w, "recording has not ended", http.StatusBadRequest)
+		return nil, false
+	}
+	for i := range ids {
+		s, err := h.database.GetSubtitleByIDContext(ctx, ids[i])
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		// the same range GetSubtitlesByTimeRange reads for the recording
+		if s == nil || s.EndMillis < media_record.StartTime*1000 || s.EndMillis >= (*media_record.EndTime+1)*1000 {
+			h.ErrorResponse(w, "subtitle ["+strconv.FormatInt(ids[i], 10)+"] not found in recording", http.StatusNotFound)
+			return nil, false
+		}
+	}
+	return media_record, true
+}
+
+// writeSubtitleCues responds with the stored subtitles of a recording as they
+// are, before the fixes applied when they are exported, with what those fixes
+// would change.
+func (h *Handlers) writeSubtitleCues(ctx context.Context, w http.ResponseWriter, recording database.MediaRecording) {
+	subtitles, err := h.database.GetSubtitlesByTimeRangeContext(ctx, recording.StartTime, *recording.EndTime)
+	if err != nil {
+		h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	start := recording.StartTime * 1000
+	data := SubtitleCues{
+		Cues: []SubtitleCue{},
+	}
+	cues := []subtitle.Cue{}
+	for i := range subtitles {
+		s := subtitles[i]
+		data.Cues = append(data.Cues, SubtitleCue{
+			ID:    s.ID,
+			Text:  s.Subtitle,
+			Start: s.StartMillis - start,
+			End:   s.EndMillis - start,
+		})
+		cues = append(cues, subtitle.Cue{
+			Text:  s.Subtitle,
+			Start: time.Duration(s.StartMillis-start) * time.Millisecond,
+			End:   time.Duration(s.EndMillis-start) * time.Millisecond,
+		})
+	}
+	data.Problems = subtitle.Validate(cues, recordingLength(recording))
+	b, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// SubtitleEditorHandler is the page to review and edit the subtitles of a
+// recording before its captions are uploaded.
+func (h *Handlers) SubtitleEditorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		id, err := strconv.ParseInt(r.URL.Query().Get("recording_id"), 10, 64)
+		if err != nil {
+			h.ErrorResponse(w, "recording_id must be a number", http.StatusBadRequest)
+			return
+		}
+		media_record, ok := h.editableRecording(r.Context(), w, id)
+		if !ok {
+			return
+		}
+		tmpl := template.Must(template.ParseFiles("./templates/subtitles.html"))
+		tmpl.Execute(w, struct {
+			Title      string
+			Javascript []string
+			CSS        []string
+			Recording  database.MediaRecording
+			Length     int64
+		}{
+			Title: "Subtitles",
+			Javascript: []string{
+				"vendor/jquery/jquery-3.6.3.min",
+				"subtitles",
+			},
+			CSS: []string{
+				"subtitles",
+			},
+			Recording: *media_record,
+			Length:    (*media_record.EndTime - media_record.StartTime) * 1000,
+		})
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// SubtitleCuesHandler lists the stored subtitles of a recording.
+func (h *Handlers) SubtitleCuesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		id, err := strconv.ParseInt(r.URL.Query().Get("recording_id"), 10, 64)
+		if err != nil {
+			h.ErrorResponse(w, "recording_id must be a number", http.StatusBadRequest)
+			return
+		}
+		media_record, ok := h.editableRecording(r.Context(), w, id)
+		if !ok {
+			return
+		}
+		h.writeSubtitleCues(r.Context(), w, *media_record)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// SubtitleUpdateHandler changes the text of a subtitle and retimes it inside
+// its recording.
+func (h *Handlers) SubtitleUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data SubtitleUpdate
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data.Text = strings.TrimSpace(data.Text)
+		if data.Text == "" {
+			h.ErrorResponse(w, "text cannot be empty, delete the subtitle instead", http.StatusBadRequest)
+			return
+		}
+		media_record, ok := h.editableRecording(r.Context(), w, data.RecordingID, data.ID)
+		if !ok {
+			return
+		}
+		length := (*media_record.EndTime - media_record.StartTime) * 1000
+		if data.Start < 0 || data.End <= data.Start || data.End > length {
+			h.ErrorResponse(w, "start and end must be in order inside the recording", http.StatusBadRequest)
+			return
+		}
+		start := media_record.StartTime * 1000
+		err = h.database.UpdateSubtitleContext(r.Context(), data.ID, data.Text, start+data.Start, start+data.End)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = h.writeRecordingSubtitles(r.Context(), *media_record)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.writeSubtitleCues(r.Context(), w, *media_record)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func (h *Handlers) SubtitleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data SubtitleDelete
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		media_record, ok := h.editableRecording(r.Context(), w, data.RecordingID, data.ID)
+		if !ok {
+			return
+		}
+		err = h.database.DeleteSubtitleContext(r.Context(), data.ID)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = h.writeRecordingSubtitles(r.Context(), *media_record)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.writeSubtitleCues(r.Context(), w, *media_record)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func (h *Handlers) SubtitleMergeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data SubtitleMerge
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		seen := map[int64]bool{}
+		for i := range data.IDs {
+			if seen[data.IDs[i]] {
+				h.ErrorResponse(w, "subtitle ["+strconv.FormatInt(data.IDs[i], 10)+"] is listed twice", http.StatusBadRequest)
+				return
+			}
+			seen[data.IDs[i]] = true
+		}
+		if len(data.IDs) < 2 {
+			h.ErrorResponse(w, "select at least two subtitles to merge", http.StatusBadRequest)
+			return
+		}
+		media_record, ok := h.editableRecording(r.Context(), w, data.RecordingID, data.IDs...)
+		if !ok {
+			return
+		}
+		err = h.database.MergeSubtitlesContext(r.Context(), data.IDs)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, err = h.writeRecordingSubtitles(r.Context(), *media_record)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.writeSubtitleCues(r.Context(), w, *media_record)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// SubtitleSplitHandler splits a subtitle in two at a time inside it, each
+// part with its own text.
+func (h *Handlers) SubtitleSplitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data SubtitleSplit
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data.Text = strings.TrimSpace(data.Text)
+		data.SecondText = strings.TrimSpace(data.SecondText)
+		if data.Text == "" || data.SecondText == "" {
+			h.ErrorResponse(w, "both parts of a split need text", http.StatusBadRequest)
+			return
+		}
+		media_record, ok := h.editableRecording(r.Context(), w, data.RecordingID, data.ID)
+		if !ok {
+			return
+		}
+		err = h.database.SplitSubtitleContext(r.Context(), data.ID, media_record.StartTime*1000+data.At, data.Text, data.SecondText)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = h.writeRecordingSubtitles(r.Context(), *media_record)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.writeSubtitleCues(r.Context(), w, *media_record)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
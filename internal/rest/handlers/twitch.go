@@ -1,11 +1,11 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
 	"text/template"
 
 	"github.com/jnrprgmr/strmr/pkg/twitch"
+	"github.com/jnrprgmr/strmr/pkg/twitch/chat"
 	"github.com/nicklaw5/helix/v2"
 )
 
@@ -14,62 +14,37 @@ type GameElement struct {
 	Selected bool
 }
 
+// scopes are the scopes twitch is authorized with.
+func scopes() []string {
+	scopes := []string{"channel:manage:broadcast"}
+	scopes = append(scopes, twitch.EventScopes...)
+	return append(scopes, chat.Scopes...)
+}
+
 func (h *Handlers) TwitchHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		access_token, err := h.database.GetLatestMetadataByKey("access_token", 1)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		account, ok := h.requestAccount(w, r)
+		if !ok {
 			return
 		}
-		if len(access_token) == 1 {
-			h.twitch.Token = access_token[0].MetadataValue
-			h.twitch.Client.SetUserAccessToken(h.twitch.Token)
-		}
-		refresh_token, err := h.database.GetLatestMetadataByKey("refresh_token", 1)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		twitch_client, ok := h.accountTwitch(r.Context(), w, *account)
+		if !ok {
 			return
 		}
-		if len(refresh_token) == 1 {
-			h.twitch.RefreshToken = refresh_token[0].MetadataValue
-		}
-		userAccessToken := h.twitch.Client.GetUserAccessToken()
-		authorized, _, err := h.twitch.Client.ValidateToken(userAccessToken)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		url := h.twitch.Client.GetAuthorizationURL(&helix.AuthorizationURLParams{
+		// the account comes back in the state to link the authorized user to,
+		// EventSub and chat need their scopes on the same token
+		url := twitch_client.Client.GetAuthorizationURL(&helix.AuthorizationURLParams{
 			ResponseType: "code",
-			Scopes:       []string{"channel:manage:broadcast"},
-			State:        "some-statedasd",
+			Scopes:       scopes(),
+			State:        account.Name,
 			ForceVerify:  false,
 		})
-		if !authorized {
-			if h.twitch.RefreshToken != "" {
-				resp, err := h.twitch.Client.RefreshUserAccessToken(h.twitch.RefreshToken)
-				if err != nil {
-					fmt.Println("coult not refresh access token")
-				} else {
-					h.twitch.Token = resp.Data.AccessToken
-					h.twitch.RefreshToken = resp.Data.RefreshToken
-					err = h.database.InsertMetadata("refresh_token", h.twitch.RefreshToken)
-					if err != nil {
-						h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-						return
-					}
-					err = h.database.InsertMetadata("access_token", h.twitch.Token)
-					if err != nil {
-						h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-						return
-					}
-					h.twitch.Client.SetUserAccessToken(h.twitch.Token)
-					userAccessToken := h.twitch.Client.GetUserAccessToken()
-					authorized, _, _ = h.twitch.Client.ValidateToken(userAccessToken)
-				}
-			}
+		authorized := twitch_client.Tokens.Status().Authorized
+		logins := []string{}
+		if account.TwitchLogin != "" {
+			logins = append(logins, account.TwitchLogin)
 		}
-		users, err := h.twitch.GetUsers([]string{})
+		users, err := twitch_client.GetUsersContext(r.Context(), logins)
 		if err != nil || len(users) != 1 {
 			users = map[string]string{}
 		}
@@ -80,7 +55,7 @@ func (h *Handlers) TwitchHandler(w http.ResponseWriter, r *http.Request) {
 			user_id = users[k]
 		}
 		description := ""
-		descriptions_hist, err := h.database.GetLatestMetadataByKey("description", 1)
+		descriptions_hist, err := h.database.GetLatestMetadataByKeyContext(r.Context(), "description", 1)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -89,18 +64,18 @@ func (h *Handlers) TwitchHandler(w http.ResponseWriter, r *http.Request) {
 			description = descriptions_hist[0].MetadataValue
 		}
 		game_titles := []string{}
-		categories, err := h.database.GetDistinctMetadataValuesByKey("category")
+		categories, err := h.database.GetDistinctMetadataValuesByKeyContext(r.Context(), "category")
 		if err != nil {
 			game_titles = []string{}
 		}
 		for k := range categories {
 			game_titles = append(game_titles, categories[k].MetadataValue)
 		}
-		games, err := h.twitch.GetGames(game_titles)
+		games, err := twitch_client.GetGamesContext(r.Context(), game_titles)
 		if err != nil {
 			games = map[string]string{}
 		}
-		title_hist, err := h.database.GetLatestMetadataByKey("title", 5)
+		title_hist, err := h.database.GetLatestMetadataByKeyContext(r.Context(), "title", 5)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -110,7 +85,7 @@ func (h *Handlers) TwitchHandler(w http.ResponseWriter, r *http.Request) {
 			titles = append(titles, title_hist[i].MetadataValue)
 		}
 		channel := twitch.Channel{}
-		channels, _ := h.twitch.GetChannelInformation([]string{user_id})
+		channels, _ := twitch_client.GetChannelInformationContext(r.Context(), []string{user_id})
 		if ch, ok := channels[user_login]; ok {
 			channel = ch
 		}
@@ -129,6 +104,7 @@ func (h *Handlers) TwitchHandler(w http.ResponseWriter, r *http.Request) {
 		tmpl := template.Must(template.ParseFiles("./templates/twitch.html"))
 		tmpl.Execute(w, struct {
 			Title       string
+			Account     string
 			Authorized  bool
 			AuthURL     string
 			Description string
@@ -140,6 +116,7 @@ func (h *Handlers) TwitchHandler(w http.ResponseWriter, r *http.Request) {
 			CSS         []string
 		}{
 			Title:       "Twitch stream settings",
+			Account:     account.Name,
 			Authorized:  authorized,
 			Games:       g,
 			Users:       users,
@@ -1,41 +1,105 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
 )
 
 func (h *Handlers) TwitchAuthHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
-	h.twitch.Code = code
-	resp, err := h.twitch.Client.RequestUserAccessToken(h.twitch.Code)
+	account, ok := h.namedAccount(r.Context(), w, r.URL.Query().Get("state"))
+	if !ok {
+		return
+	}
+	twitch_client, ok := h.accountTwitch(r.Context(), w, *account)
+	if !ok {
+		return
+	}
+	err := twitch_client.Tokens.Authorize(r.Context(), code)
 	if err != nil {
 		h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// Set the access token on the client
-	h.twitch.Token = resp.Data.AccessToken
-	h.twitch.RefreshToken = resp.Data.RefreshToken
-	h.twitch.Client.SetUserAccessToken(h.twitch.Token)
-	users, err := h.twitch.GetUsers([]string{})
+	// without logins twitch returns the user who authorized
+	users, err := twitch_client.GetUsersContext(r.Context(), []string{})
 	if err != nil {
-		users = map[string]string{}
+		h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if id, ok := users["jnrprgmr"]; ok {
-		err = h.database.InsertUser("twitch", id)
+	for login, id := range users {
+		err = h.database.LinkUserContext(r.Context(), account.ID, database.UserTwitch, id)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = h.database.InsertMetadata("refresh_token", h.twitch.RefreshToken)
+		if account.TwitchLogin == "" {
+			account.TwitchLogin = login
+			_, err = h.database.SaveAccountContext(r.Context(), *account)
+			if err != nil {
+				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	http.Redirect(w, r, "http://localhost:8080/twitch?account="+url.QueryEscape(account.Name), http.StatusSeeOther)
+}
+
+// TwitchTokenHandler reports whether the twitch token of the account is
+// valid and when it expires, /twitch authorizes it again when it is not.
+func (h *Handlers) TwitchTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		account, ok := h.requestAccount(w, r)
+		if !ok {
+			return
+		}
+		twitch_client, ok := h.accountTwitch(r.Context(), w, *account)
+		if !ok {
+			return
+		}
+		b, err := json.Marshal(twitch_client.Tokens.Status())
 		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		err = h.database.InsertMetadata("access_token", h.twitch.Token)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// TwitchEventsHandler lists the latest events from twitch EventSub, 50
+// unless limit is given, of every type unless type is given.
+func (h *Handlers) TwitchEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		limit := int64(50)
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			limit, err = strconv.ParseInt(l, 10, 64)
+			if err != nil || limit <= 0 {
+				h.ErrorResponse(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		events, err := h.database.GetLatestTwitchEventsContext(r.Context(), r.URL.Query().Get("type"), limit)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		b, err := json.Marshal(events)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
 	}
-	http.Redirect(w, r, "http://localhost:8080/twitch", http.StatusSeeOther)
+	w.WriteHeader(http.StatusMethodNotAllowed)
 }
@@ -19,7 +19,7 @@ func (h *Handlers) TwitchSearchCategoriesHandler(w http.ResponseWriter, r *http.
 			return
 		}
 		json.Unmarshal(reqBody, &data)
-		categories, err := h.twitch.SearchCategories(data.Query)
+		categories, err := h.twitch.SearchCategoriesContext(r.Context(), data.Query)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
 )
 
 type TwitchUpdate struct {
@@ -24,72 +26,52 @@ func (h *Handlers) TwitchUpdateHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		json.Unmarshal(reqBody, &data)
-		err = h.twitch.ChangeStream("jnrprgmr", data.Title, data.CategoryID, data.Tags)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		account, ok := h.requestAccount(w, r)
+		if !ok {
 			return
 		}
-		t, err := h.database.GetLatestMetadataByKey("title", 1)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		if account.TwitchLogin == "" {
+			h.ErrorResponse(w, "account ["+account.Name+"] has no twitch login", http.StatusBadRequest)
 			return
 		}
-		if len(t) != 1 || t[0].MetadataValue != data.Title {
-			err = h.database.InsertMetadata("title", data.Title)
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		twitch_client, ok := h.accountTwitch(r.Context(), w, *account)
+		if !ok {
+			return
 		}
-		d, err := h.database.GetLatestMetadataByKey("description", 1)
+		err = twitch_client.ChangeStreamContext(r.Context(), account.TwitchLogin, data.Title, data.CategoryID, data.Tags)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if len(d) != 1 || d[0].MetadataValue != data.Description {
-			err = h.database.InsertMetadata("description", data.Description)
+		err = h.database.Transaction(r.Context(), nil, func(tx *database.Tx) error {
+			err := tx.InsertMetadataIfChanged("title", data.Title)
 			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-				return
+				return err
 			}
-		}
-		c, err := h.database.GetLatestMetadataByKey("category", 1)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if len(c) != 1 || c[0].MetadataValue != data.CategoryName {
-			err = h.database.InsertMetadata("category", data.CategoryName)
+			err = tx.InsertMetadataIfChanged("description", data.Description)
 			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-				return
+				return err
 			}
-		}
-		ca, err := h.database.GetCategoryByName(data.CategoryName)
-		if err != nil {
-			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if ca == nil {
-			err = h.database.InsertCategory(data.CategoryName)
+			err = tx.InsertMetadataIfChanged("category", data.CategoryName)
 			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-				return
+				return err
 			}
-		}
-		ts, err := h.database.GetLatestMetadataByKey("tags", 1)
+			ca, err := tx.GetCategoryByName(data.CategoryName)
+			if err != nil {
+				return err
+			}
+			if ca == nil {
+				err = tx.InsertCategory(data.CategoryName)
+				if err != nil {
+					return err
+				}
+			}
+			return tx.InsertMetadataIfChanged("tags", strings.Join(data.Tags, ","))
+		})
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		tags_string := strings.Join(data.Tags, ",")
-		if len(ts) != 1 || t[0].MetadataValue != tags_string {
-			err = h.database.InsertMetadata("tags", tags_string)
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"log"
-	"math"
 	"net/http"
 	"text/template"
+	"time"
 
 	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/subtitle"
 	"github.com/jnrprgmr/strmr/pkg/youtube"
 )
 
@@ -16,27 +18,32 @@ type WrappedMediaRecording struct {
 	Metadata youtube.YouTubeData
 }
 
-func ConvertRecordingSubtitlesToYouTubeSubtitles(recording database.MediaRecording, subtitles []database.Subtitle) ([]youtube.Subtitle, error) {
+// ConvertRecordingSubtitlesToCues times subtitles from the start of the
+// recording, overlaps and cues cut off by the start of the recording are
+// fixed so every format can be written from the result.
+func ConvertRecordingSubtitlesToCues(recording database.MediaRecording, subtitles []database.Subtitle) ([]subtitle.Cue, error) {
 	if recording.EndTime == nil {
 		return nil, errors.New("recording has not ended")
 	}
-	yt_subtitles := []youtube.Subtitle{}
-	if len(subtitles) < 1 {
-		return yt_subtitles, nil
-	}
+	cues := []subtitle.Cue{}
+	start := recording.StartTime * 1000
 	for i := range subtitles {
 		s := subtitles[i]
-		if recording.StartTime > s.InsertTime || *recording.EndTime < s.InsertTime+int64(s.Duration) {
-			return nil, errors.New("recording start or end time is outside the subtitle time")
+		if s.EndMillis > (*recording.EndTime+1)*1000 {
+			return nil, errors.New("recording end time is outside the subtitle time")
 		}
-		yt_s := youtube.Subtitle{
+		cues = append(cues, subtitle.Cue{
 			Text:  s.Subtitle,
-			Start: s.InsertTime - recording.StartTime - int64(math.Ceil(s.Duration)),
-			End:   s.InsertTime - recording.StartTime,
-		}
-		yt_subtitles = append(yt_subtitles, yt_s)
+			Start: time.Duration(s.StartMillis-start) * time.Millisecond,
+			End:   time.Duration(s.EndMillis-start) * time.Millisecond,
+		})
 	}
-	return yt_subtitles, nil
+	return subtitle.Fix(cues, recordingLength(recording)), nil
+}
+
+// recordingLength is how long the ended recording is.
+func recordingLength(recording database.MediaRecording) time.Duration {
+	return time.Duration(*recording.EndTime-recording.StartTime) * time.Second
 }
 
 func ConvertRecordingMetadataToYouTubeMetadata(recording database.MediaRecording, metadata []database.Metadata) ([]youtube.Metadata, error) {
@@ -52,92 +59,67 @@ func ConvertRecordingMetadataToYouTubeMetadata(recording database.MediaRecording
 		if *recording.EndTime < m.InsertTime {
 			return nil, errors.New("recording end time is outside the medatada time")
 		}
+		// values set before the recording started apply from its start
+		start := m.InsertTime - recording.StartTime
+		if start < 0 {
+			start = 0
+		}
 		yt_m := youtube.Metadata{
 			Text:  m.MetadataValue,
-			Start: m.InsertTime - recording.StartTime,
+			Start: start,
 		}
 		yt_metadata = append(yt_metadata, yt_m)
 	}
 	return yt_metadata, nil
 }
 
-func (h *Handlers) convertToYouTubeMetadata(media_recordings database.MediaRecording) (*youtube.YouTubeData, error) {
-	tasks, err := h.database.GetMetadataByKeyAndTimeRange("task", media_recordings.StartTime, *media_recordings.EndTime)
-	if err != nil {
-		return nil, err
-	}
-	yt_tasks, err := ConvertRecordingMetadataToYouTubeMetadata(media_recordings, tasks)
-	if err != nil {
-		return nil, err
-	}
-	categories, err := h.database.GetMetadataByKeyAndTimeRange("category", media_recordings.StartTime, *media_recordings.EndTime)
-	if err != nil {
-		return nil, err
-	}
-	initial_category, err := h.database.GetLatestMetadataByKeyBeforeTime("category", media_recordings.StartTime, 1)
-	if err != nil {
-		return nil, err
-	}
-	if len(initial_category) != 0 {
-
-		categories = append(categories, initial_category...)
-	}
-	yt_categories, err := ConvertRecordingMetadataToYouTubeMetadata(media_recordings, categories)
+// recordingMetadata returns the values of key set during the recording in
+// order, preceded by the value that was current when the recording started.
+func (h *Handlers) recordingMetadata(ctx context.Context, key string, recording database.MediaRecording) ([]youtube.Metadata, error) {
+	metadata, err := h.database.GetLatestMetadataByKeyBeforeTimeContext(ctx, key, recording.StartTime, 1)
 	if err != nil {
 		return nil, err
 	}
-	tags, err := h.database.GetMetadataByKeyAndTimeRange("tags", media_recordings.StartTime, *media_recordings.EndTime)
+	during, err := h.database.GetMetadataByKeyAndTimeRangeContext(ctx, key, recording.StartTime, *recording.EndTime)
 	if err != nil {
 		return nil, err
 	}
-	initial_tags, err := h.database.GetLatestMetadataByKeyBeforeTime("tags", media_recordings.StartTime, 1)
-	if err != nil {
-		return nil, err
-	}
-	if len(initial_tags) != 0 {
-		tags = append(tags, initial_tags...)
-	}
-	yt_tags, err := ConvertRecordingMetadataToYouTubeMetadata(media_recordings, tags)
-	if err != nil {
-		return nil, err
+	for i := range during {
+		// a value set exactly at the start is found by both queries
+		if len(metadata) == 1 && during[i].ID == metadata[0].ID {
+			continue
+		}
+		metadata = append(metadata, during[i])
 	}
+	return ConvertRecordingMetadataToYouTubeMetadata(recording, metadata)
+}
 
-	descriptions, err := h.database.GetMetadataByKeyAndTimeRange("description", media_recordings.StartTime, *media_recordings.EndTime)
-	if err != nil {
-		return nil, err
-	}
-	initial_descriptions, err := h.database.GetLatestMetadataByKeyBeforeTime("description", media_recordings.StartTime, 1)
+func (h *Handlers) convertToYouTubeMetadata(ctx context.Context, media_recordings database.MediaRecording) (*youtube.YouTubeData, error) {
+	yt_tasks, err := h.recordingMetadata(ctx, "task", media_recordings)
 	if err != nil {
 		return nil, err
 	}
-	if len(initial_descriptions) != 0 {
-		descriptions = append(descriptions, initial_descriptions...)
-	}
-	yt_descriptions, err := ConvertRecordingMetadataToYouTubeMetadata(media_recordings, descriptions)
+	yt_categories, err := h.recordingMetadata(ctx, "category", media_recordings)
 	if err != nil {
 		return nil, err
 	}
-
-	titles, err := h.database.GetMetadataByKeyAndTimeRange("title", media_recordings.StartTime, *media_recordings.EndTime)
+	yt_tags, err := h.recordingMetadata(ctx, "tags", media_recordings)
 	if err != nil {
 		return nil, err
 	}
-	initial_title, err := h.database.GetLatestMetadataByKeyBeforeTime("title", media_recordings.StartTime, 1)
+	yt_descriptions, err := h.recordingMetadata(ctx, "description", media_recordings)
 	if err != nil {
 		return nil, err
 	}
-	if len(initial_title) != 0 {
-		titles = append(titles, initial_title...)
-	}
-	yt_titles, err := ConvertRecordingMetadataToYouTubeMetadata(media_recordings, titles)
+	yt_titles, err := h.recordingMetadata(ctx, "title", media_recordings)
 	if err != nil {
 		return nil, err
 	}
-	subtitles, err := h.database.GetSubtitlesByTimeRange(media_recordings.StartTime, *media_recordings.EndTime)
+	subtitles, err := h.database.GetSubtitlesByTimeRangeContext(ctx, media_recordings.StartTime, *media_recordings.EndTime)
 	if err != nil {
 		return nil, err
 	}
-	yt_subtitles, err := ConvertRecordingSubtitlesToYouTubeSubtitles(media_recordings, subtitles)
+	cues, err := ConvertRecordingSubtitlesToCues(media_recordings, subtitles)
 	if err != nil {
 		return nil, err
 	}
@@ -145,8 +127,9 @@ func (h *Handlers) convertToYouTubeMetadata(media_recordings database.MediaRecor
 		Categories:   yt_categories,
 		Titles:       yt_titles,
 		Tasks:        yt_tasks,
-		Subtitles:    yt_subtitles,
+		Subtitles:    cues,
 		Descriptions: yt_descriptions,
+		Chapters:     youtube.BuildChapters(yt_tasks, yt_categories, *media_recordings.EndTime-media_recordings.StartTime, "Starting stream"),
 		Tags:         yt_tags,
 	}
 	return yt_data, nil
@@ -154,22 +137,22 @@ func (h *Handlers) convertToYouTubeMetadata(media_recordings database.MediaRecor
 
 func (h *Handlers) YouTubeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		media_recordings, err := h.database.GetAllMediaRecordingsByUploaded(false) // get all non-uploaded videos
+		media_recordings, err := h.database.GetAllMediaRecordingsByUploadedContext(r.Context(), false) // get all non-uploaded videos
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		cats, err := h.database.GetAllCategories()
+		cats, err := h.database.GetAllCategoriesContext(r.Context())
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		yt_cats, err := h.youtube.GetCategories()
+		yt_cats, err := h.youtube.GetCategoriesContext(r.Context())
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		yt_playlists, err := h.youtube.GetPlaylists()
+		yt_playlists, err := h.youtube.GetPlaylistsContext(r.Context())
 		if err != nil {
 			log.Fatalf("Cannot get playlists:%+v", err)
 		}
@@ -181,7 +164,7 @@ func (h *Handlers) YouTubeHandler(w http.ResponseWriter, r *http.Request) {
 		}, yt_playlists...)
 		data := map[int64]youtube.YouTubeData{}
 		for i := range media_recordings {
-			yt_data, err := h.convertToYouTubeMetadata(media_recordings[i])
+			yt_data, err := h.convertToYouTubeMetadata(r.Context(), media_recordings[i])
 			if err != nil {
 				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -203,6 +186,7 @@ func (h *Handlers) YouTubeHandler(w http.ResponseWriter, r *http.Request) {
 			YouTubeCategories []youtube.Category
 			YouTubePlaylists  []youtube.Playlist
 			Categories        []database.Category
+			Privacies         []string
 		}{
 			Title: "YouTube settings",
 			Javascript: []string{
@@ -219,6 +203,7 @@ func (h *Handlers) YouTubeHandler(w http.ResponseWriter, r *http.Request) {
 			YouTubeCategories: yt_cats,
 			YouTubePlaylists:  yt_playlists,
 			Categories:        cats,
+			Privacies:         []string{database.PrivacyPrivate, database.PrivacyUnlisted, database.PrivacyPublic},
 		})
 	}
 }
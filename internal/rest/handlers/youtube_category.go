@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
 )
 
 type YouTubeCategory struct {
-	CategoryName string `json:"category_name"`
-	RelatedID    string `json:"related_id"`
+	CategoryName  string                  `json:"category_name"`
+	RelatedID     string                  `json:"related_id"`
+	PublishPolicy *database.PublishPolicy `json:"publish_policy"`
 }
 
 func (h *Handlers) YouTubeCategoryHandler(w http.ResponseWriter, r *http.Request) {
@@ -24,7 +27,14 @@ func (h *Handlers) YouTubeCategoryHandler(w http.ResponseWriter, r *http.Request
 			h.ErrorResponse(w, "Cannot use empty values when updating category", http.StatusBadRequest)
 			return
 		}
-		cat, err := h.database.GetCategoryByName(data.CategoryName)
+		if data.PublishPolicy != nil {
+			err = data.PublishPolicy.Validate()
+			if err != nil {
+				h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		cat, err := h.database.GetCategoryByNameContext(r.Context(), data.CategoryName)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -33,7 +43,16 @@ func (h *Handlers) YouTubeCategoryHandler(w http.ResponseWriter, r *http.Request
 			h.ErrorResponse(w, "Attempting to correlate category that has never been used", http.StatusBadRequest)
 			return
 		}
-		err = h.database.UpdateCategoryByName(data.RelatedID, data.CategoryName)
+		err = h.database.Transaction(r.Context(), nil, func(tx *database.Tx) error {
+			err := tx.UpdateCategoryByName(data.RelatedID, data.CategoryName)
+			if err != nil {
+				return err
+			}
+			if data.PublishPolicy == nil {
+				return nil
+			}
+			return tx.UpdateCategoryPublishPolicyByName(data.PublishPolicy, data.CategoryName)
+		})
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
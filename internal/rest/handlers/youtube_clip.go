@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jnrprgmr/strmr/internal/jobs"
+	"github.com/jnrprgmr/strmr/pkg/clip"
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/subtitle"
+	"github.com/jnrprgmr/strmr/pkg/youtube"
+)
+
+type YouTubeClips struct {
+	RecordingID   int64                   `json:"recording_id"`
+	Short         bool                    `json:"short"`
+	Upload        bool                    `json:"upload"`
+	PlaylistID    string                  `json:"playlist_id"`
+	PublishPolicy *database.PublishPolicy `json:"publish_policy"`
+}
+
+type ClipResult struct {
+	ID          int64  `json:"id"`
+	Task        string `json:"task"`
+	File        string `json:"file"`
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+	Skipped     string `json:"skipped,omitempty"`
+	UploadJobID *int64 `json:"upload_job_id"`
+	UploadError string `json:"upload_error,omitempty"`
+}
+
+const shortsTag = " #Shorts"
+
+// clipTitle is the task, cut to fit the title limit with room for the tag
+// YouTube looks for on Shorts.
+func clipTitle(task string, short bool) string {
+	max := youtube.MaxTitleLength
+	if short {
+		max = max - utf8.RuneCountInString(shortsTag)
+	}
+	title := strings.TrimSpace(task)
+	if runes := []rune(title); len(runes) > max {
+		title = strings.TrimSpace(string(runes[:max]))
+	}
+	if short {
+		title = title + shortsTag
+	}
+	return title
+}
+
+// clipDescription renders the description of a clip of task that starts at
+// start in the stream titled stream_title.
+func clipDescription(task string, stream_title string, start time.Time, length time.Duration, tags []string) (string, error) {
+	titles := []youtube.Metadata{}
+	if stream_title != "" {
+		titles = append(titles, youtube.Metadata{Text: stream_title})
+	}
+	return youtube.RenderTemplate("clip description", youtube.ClipDescriptionTemplate, youtube.TemplateData{
+		Data:     youtube.YouTubeData{Titles: titles},
+		Tags:     tags,
+		Task:     task,
+		Start:    start,
+		End:      start.Add(length),
+		Duration: length,
+	})
+}
+
+// YouTubeClipsHandler queues a job cutting a recording into a clip per task,
+// or a Short per task short enough for one, and optionally queueing each for
+// upload. The returned job is polled for the clips.
+func (h *Handlers) YouTubeClipsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data YouTubeClips
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		media_record, err := h.database.GetMediaRecordingByIDContext(r.Context(), data.RecordingID)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if media_record == nil {
+			h.ErrorResponse(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		if media_record.EndTime == nil {
+			h.ErrorResponse(w, "recording has not ended", http.StatusBadRequest)
+			return
+		}
+		yt_data, err := h.convertToYouTubeMetadata(r.Context(), *media_record)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tasks, err := h.recordingMetadata(r.Context(), "task", *media_record)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		segments := clip.Segments(tasks, *media_record.EndTime-media_record.StartTime)
+		if len(segments) == 0 {
+			h.ErrorResponse(w, "recording has no tasks to clip", http.StatusBadRequest)
+			return
+		}
+		recording := *media_record
+		key := "clips:" + strconv.FormatInt(recording.ID, 10)
+		if data.Short {
+			key = key + ":shorts"
+		}
+		job, err := h.jobs.Add("clips", key, func(ctx context.Context) (interface{}, error) {
+			return h.cutClips(ctx, data, recording, *yt_data, segments)
+		})
+		if err == jobs.ErrQueueFull {
+			h.ErrorResponse(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(job)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// cutClips cuts the segments of a recording and queues the clips for upload
+// when asked to, it runs as a background job since ffmpeg takes a while.
+func (h *Handlers) cutClips(ctx context.Context, data YouTubeClips, recording database.MediaRecording, yt_data youtube.YouTubeData, segments []clip.Segment) ([]ClipResult, error) {
+	file := recording.Directory + "/" + recording.FileName + "." + recording.Extension
+	directory := recording.Directory + "/" + recording.FileName + "-clips"
+	extension := recording.Extension
+	if data.Short {
+		directory = recording.Directory + "/" + recording.FileName + "-shorts"
+		extension = "mp4"
+	}
+	err := os.MkdirAll(directory, 0777)
+	if err != nil {
+		return nil, errors.New("Cannot create clip directory: " + err.Error())
+	}
+	tags := youtube.UniqueTags(yt_data.Tags)
+	results := []ClipResult{}
+	for i := range segments {
+		segment := segments[i]
+		result := ClipResult{
+			Task:  segment.Task,
+			Start: segment.Start,
+			End:   segment.End,
+		}
+		if segment.Length() < clip.MinLength {
+			result.Skipped = "shorter than " + strconv.Itoa(clip.MinLength) + "s"
+			results = append(results, result)
+			continue
+		}
+		if data.Short && segment.Length() > clip.MaxShortLength {
+			result.Skipped = "longer than " + strconv.Itoa(clip.MaxShortLength) + "s"
+			results = append(results, result)
+			continue
+		}
+		name := clip.FileName(i, segment.Task)
+		result.File = directory + "/" + name + "." + extension
+		if data.Short {
+			err = h.clips.CutShort(ctx, file, result.File, segment)
+		} else {
+			err = h.clips.Cut(ctx, file, result.File, segment)
+		}
+		if err != nil {
+			return nil, err
+		}
+		subtitle_file := ""
+		cues := clip.SliceSubtitles(yt_data.Subtitles, segment)
+		if len(cues) > 0 {
+			subtitle_file = directory + "/" + name + subtitle.Extension(subtitle.FormatSRT)
+			err = ioutil.WriteFile(subtitle_file, []byte(subtitle.SRT(cues)), 0666)
+			if err != nil {
+				return nil, errors.New("Cannot save clip subtitles: " + err.Error())
+			}
+		}
+		result.ID, err = h.database.SaveMediaClipContext(ctx, database.MediaClip{
+			MediaRecordingID: recording.ID,
+			Task:             segment.Task,
+			FileName:         name,
+			Extension:        extension,
+			Directory:        directory,
+			SubtitleFile:     subtitle_file,
+			StartOffset:      segment.Start,
+			EndOffset:        segment.End,
+			Short:            data.Short,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !data.Upload {
+			results = append(results, result)
+			continue
+		}
+		category := metadataAt(yt_data.Categories, segment.Start)
+		db_category, err := h.database.GetCategoryByNameContext(ctx, category)
+		if err != nil {
+			return nil, err
+		}
+		if db_category == nil {
+			return nil, errors.New("category [" + category + "] not found")
+		}
+		policy, err := resolvePublishPolicy(*db_category, data.PublishPolicy)
+		if err != nil {
+			result.UploadError = err.Error()
+			results = append(results, result)
+			continue
+		}
+		start := time.Unix(recording.StartTime+segment.Start, 0)
+		title := clipTitle(segment.Task, data.Short)
+		description, err := clipDescription(segment.Task, metadataAt(yt_data.Titles, segment.Start), start, time.Duration(segment.Length())*time.Second, tags)
+		if err != nil {
+			return nil, err
+		}
+		problems := youtube.CheckVideoText(title, description)
+		if len(problems) > 0 {
+			result.UploadError = strings.Join(problems, ", ")
+			results = append(results, result)
+			continue
+		}
+		clip_id := result.ID
+		id, err := h.uploads.Enqueue(ctx, database.UploadJob{
+			MediaRecordingID: recording.ID,
+			MediaClipID:      &clip_id,
+			File:             result.File,
+			SubtitleFile:     subtitle_file,
+			Title:            title,
+			Description:      description,
+			Tags:             tags,
+			CategoryID:       db_category.RelatedID,
+			PlaylistID:       data.PlaylistID,
+			RecordingTime:    start.UTC().Format(time.RFC3339Nano),
+			PublishPolicy:    policy,
+		})
+		if err == database.ErrUploadJobExists {
+			result.UploadError = err.Error()
+		} else if err != nil {
+			return nil, err
+		} else {
+			result.UploadJobID = &id
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
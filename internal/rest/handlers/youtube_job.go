@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// YouTubeJobHandler reports a background job, the /youtube page polls it
+// while thumbnails are made or clips are cut.
+func (h *Handlers) YouTubeJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			h.ErrorResponse(w, "id must be a number", http.StatusBadRequest)
+			return
+		}
+		job, ok := h.jobs.Get(id)
+		if !ok {
+			h.ErrorResponse(w, "job not found", http.StatusNotFound)
+			return
+		}
+		b, err := json.Marshal(job)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
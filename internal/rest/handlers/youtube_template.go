@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/youtube"
+)
+
+type YouTubeTemplatePreview struct {
+	RecordingID int64  `json:"recording_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type RenderedUpload struct {
+	Title         string   `json:"title"`
+	Description   string   `json:"description"`
+	Tags          []string `json:"tags"`
+	Categories    []string `json:"categories"`
+	RecordingTime string   `json:"recording_time"`
+	Problems      []string `json:"problems"`
+	chapters      youtube.ChapterList
+}
+
+// uploadTemplate returns the upload template saved for account, the one
+// saved without an account or the default one.
+func (h *Handlers) uploadTemplate(ctx context.Context, account database.Account) (database.UploadTemplate, error) {
+	t := database.UploadTemplate{AccountID: account.ID}
+	found, err := h.database.GetSettingContext(ctx, &t)
+	if err != nil || found {
+		return t, err
+	}
+	t = database.UploadTemplate{}
+	found, err = h.database.GetSettingContext(ctx, &t)
+	if err != nil {
+		return t, err
+	}
+	if !found {
+		t.Title = youtube.DefaultTitleTemplate
+		t.Description = youtube.DefaultDescriptionTemplate
+	}
+	t.AccountID = account.ID
+	return t, nil
+}
+
+// renderUpload renders the title and description of a finished recording
+// uploaded for account.
+func (h *Handlers) renderUpload(ctx context.Context, recording database.MediaRecording, t database.UploadTemplate, account database.Account) (*RenderedUpload, error) {
+	if recording.EndTime == nil {
+		return nil, errors.New("recording has not ended")
+	}
+	yt_data, err := h.convertToYouTubeMetadata(ctx, recording)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Unix(recording.StartTime, 0)
+	end := time.Unix(*recording.EndTime, 0)
+	data := youtube.TemplateData{
+		Account:  account,
+		Data:     *yt_data,
+		Tags:     youtube.UniqueTags(yt_data.Tags),
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+	}
+	title, err := youtube.RenderTemplate("title", t.Title, data)
+	if err != nil {
+		return nil, err
+	}
+	description, err := youtube.RenderTemplate("description", t.Description, data)
+	if err != nil {
+		return nil, err
+	}
+	categories := []string{}
+	for i := range yt_data.Categories {
+		categories = append(categories, yt_data.Categories[i].Text)
+	}
+	return &RenderedUpload{
+		Title:         title,
+		Description:   description,
+		Tags:          data.Tags,
+		Categories:    categories,
+		RecordingTime: start.UTC().Format(time.RFC3339Nano),
+		Problems:      youtube.CheckVideoText(title, description),
+		chapters:      yt_data.Chapters,
+	}, nil
+}
+
+func (h *Handlers) YouTubeTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		account, ok := h.requestAccount(w, r)
+		if !ok {
+			return
+		}
+		t, err := h.uploadTemplate(r.Context(), *account)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b, err := json.Marshal(t)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	case http.MethodPost:
+		var data database.UploadTemplate
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = data.Validate()
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = youtube.ParseTemplate("title", data.Title)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = youtube.ParseTemplate("description", data.Description)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		account, ok := h.requestAccount(w, r)
+		if !ok {
+			return
+		}
+		data.AccountID = account.ID
+		err = h.database.SaveSettingContext(r.Context(), &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// YouTubeTemplatePreviewHandler renders a recording with the saved templates
+// or with the templates in the request, so changes can be previewed before
+// they are saved.
+func (h *Handlers) YouTubeTemplatePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data YouTubeTemplatePreview
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		account, ok := h.requestAccount(w, r)
+		if !ok {
+			return
+		}
+		t, err := h.uploadTemplate(r.Context(), *account)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if data.Title != "" {
+			t.Title = data.Title
+		}
+		if data.Description != "" {
+			t.Description = data.Description
+		}
+		media_record, err := h.database.GetMediaRecordingByIDContext(r.Context(), data.RecordingID)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if media_record == nil {
+			h.ErrorResponse(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		rendered, err := h.renderUpload(r.Context(), *media_record, t, *account)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := json.Marshal(rendered)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
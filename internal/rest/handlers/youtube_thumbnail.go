@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jnrprgmr/strmr/internal/jobs"
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/thumbnail"
+	"github.com/jnrprgmr/strmr/pkg/youtube"
+)
+
+// YouTubeThumbnails asks for the candidate thumbnails of a recording,
+// Regenerate makes them again instead of using the ones made before.
+type YouTubeThumbnails struct {
+	RecordingID int64 `json:"recording_id"`
+	Regenerate  bool  `json:"regenerate"`
+}
+
+type ThumbnailCandidate struct {
+	Index  int    `json:"index"`
+	Offset int64  `json:"offset"`
+	URL    string `json:"url"`
+}
+
+// thumbnailFile is where candidate index of a recording is kept, next to the
+// recording like its subtitles.
+func thumbnailFile(recording database.MediaRecording, index int) string {
+	return recording.Directory + "/" + recording.FileName + ".thumb-" + strconv.Itoa(index) + ".jpg"
+}
+
+// metadataAt is the text of the last metadata set at or before offset.
+func metadataAt(metadata []youtube.Metadata, offset int64) string {
+	text := ""
+	for i := range metadata {
+		if metadata[i].Start <= offset {
+			text = metadata[i].Text
+		}
+	}
+	return text
+}
+
+// categoryBoxArt gets the box art of a category, looking the url up on
+// Twitch and caching it on the category the first time. Missing box art is
+// not an error, the thumbnail is made without it.
+func (h *Handlers) categoryBoxArt(ctx context.Context, category_name string) image.Image {
+	if category_name == "" {
+		return nil
+	}
+	category, err := h.database.GetCategoryByNameContext(ctx, category_name)
+	if err != nil || category == nil {
+		return nil
+	}
+	if category.BoxArtURL == nil {
+		categories, err := h.twitch.SearchCategoriesContext(ctx, category_name)
+		if err != nil {
+			fmt.Println("Cannot search box art of " + category_name + ": " + err.Error())
+			return nil
+		}
+		for name := range categories {
+			if strings.EqualFold(name, category_name) {
+				url := categories[name].BoxArtUrl
+				category.BoxArtURL = &url
+				break
+			}
+		}
+		if category.BoxArtURL == nil {
+			fmt.Println("No box art found for " + category_name)
+			return nil
+		}
+		err = h.database.UpdateCategoryBoxArtByNameContext(ctx, *category.BoxArtURL, category_name)
+		if err != nil {
+			fmt.Println("Cannot cache box art of " + category_name + ": " + err.Error())
+		}
+	}
+	art, err := h.thumbnails.BoxArt(ctx, *category.BoxArtURL)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil
+	}
+	return art
+}
+
+// cachedThumbnails are the candidates made for a recording before, they are
+// only used when every candidate is still there.
+func (h *Handlers) cachedThumbnails(recording database.MediaRecording) ([]ThumbnailCandidate, bool) {
+	length := time.Duration(*recording.EndTime-recording.StartTime) * time.Second
+	candidates := []ThumbnailCandidate{}
+	for i, offset := range h.thumbnails.Offsets(length) {
+		info, err := os.Stat(thumbnailFile(recording, i))
+		if err != nil {
+			return nil, false
+		}
+		candidates = append(candidates, thumbnailCandidate(recording, i, offset, info.ModTime()))
+	}
+	return candidates, true
+}
+
+func thumbnailCandidate(recording database.MediaRecording, index int, offset time.Duration, made time.Time) ThumbnailCandidate {
+	return ThumbnailCandidate{
+		Index:  index,
+		Offset: int64(offset.Seconds()),
+		// the time makes the browser fetch a regenerated candidate
+		URL: "/youtube/thumbnail?recording_id=" + strconv.FormatInt(recording.ID, 10) + "&index=" + strconv.Itoa(index) + "&t=" + strconv.FormatInt(made.Unix(), 10),
+	}
+}
+
+// makeThumbnails makes a candidate per frame taken from the recording with
+// the title, category and task at that time.
+func (h *Handlers) makeThumbnails(ctx context.Context, recording database.MediaRecording) ([]ThumbnailCandidate, error) {
+	var err error
+	metadata := map[string][]youtube.Metadata{}
+	for _, key := range []string{"title", "category", "task"} {
+		metadata[key], err = h.recordingMetadata(ctx, key, recording)
+		if err != nil {
+			return nil, err
+		}
+	}
+	box_art := map[string]image.Image{}
+	file := recording.Directory + "/" + recording.FileName + "." + recording.Extension
+	length := time.Duration(*recording.EndTime-recording.StartTime) * time.Second
+	candidates := []ThumbnailCandidate{}
+	for i, offset := range h.thumbnails.Offsets(length) {
+		frame, err := h.thumbnails.ExtractFrame(ctx, file, offset)
+		if err != nil {
+			return nil, err
+		}
+		seconds := int64(offset.Seconds())
+		category := metadataAt(metadata["category"], seconds)
+		art, ok := box_art[category]
+		if !ok {
+			art = h.categoryBoxArt(ctx, category)
+			box_art[category] = art
+		}
+		img := h.thumbnails.Compose(frame, thumbnail.Overlay{
+			Title:    metadataAt(metadata["title"], seconds),
+			Category: category,
+			Task:     metadataAt(metadata["task"], seconds),
+			BoxArt:   art,
+		})
+		b, err := thumbnail.Encode(img)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(thumbnailFile(recording, i), b, 0666)
+		if err != nil {
+			return nil, errors.New("Cannot save thumbnail: " + err.Error())
+		}
+		candidates = append(candidates, thumbnailCandidate(recording, i, offset, time.Now()))
+	}
+	return candidates, nil
+}
+
+// YouTubeThumbnailsHandler gets the candidate thumbnails of a recording. The
+// candidates made before are returned as a finished job, otherwise they are
+// made in the background and the returned job is polled until it is done.
+func (h *Handlers) YouTubeThumbnailsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var data YouTubeThumbnails
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(reqBody, &data)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		media_record, err := h.database.GetMediaRecordingByIDContext(r.Context(), data.RecordingID)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if media_record == nil {
+			h.ErrorResponse(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		if media_record.EndTime == nil {
+			h.ErrorResponse(w, "recording has not ended", http.StatusBadRequest)
+			return
+		}
+		var job jobs.Job
+		candidates, ok := h.cachedThumbnails(*media_record)
+		if ok && !data.Regenerate {
+			// the candidates were made before so there is nothing to queue
+			job = jobs.Job{Kind: "thumbnails", State: jobs.StateDone, Result: candidates}
+		} else {
+			recording := *media_record
+			job, err = h.jobs.Add("thumbnails", "thumbnails:"+strconv.FormatInt(recording.ID, 10), func(ctx context.Context) (interface{}, error) {
+				return h.makeThumbnails(ctx, recording)
+			})
+			if err == jobs.ErrQueueFull {
+				h.ErrorResponse(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			} else if err != nil {
+				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		b, err := json.Marshal(job)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// YouTubeThumbnailHandler serves a candidate thumbnail made by
+// YouTubeThumbnailsHandler.
+func (h *Handlers) YouTubeThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		id, err := strconv.ParseInt(r.URL.Query().Get("recording_id"), 10, 64)
+		if err != nil {
+			h.ErrorResponse(w, "recording_id must be a number", http.StatusBadRequest)
+			return
+		}
+		index, err := strconv.Atoi(r.URL.Query().Get("index"))
+		if err != nil || index < 0 {
+			h.ErrorResponse(w, "index must be a positive number", http.StatusBadRequest)
+			return
+		}
+		media_record, err := h.database.GetMediaRecordingByIDContext(r.Context(), id)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if media_record == nil {
+			h.ErrorResponse(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		file := thumbnailFile(*media_record, index)
+		_, err = os.Stat(file)
+		if err != nil {
+			h.ErrorResponse(w, "thumbnail not found", http.StatusNotFound)
+			return
+		}
+		http.ServeFile(w, r, file)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
@@ -2,32 +2,44 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jnrprgmr/strmr/pkg/database"
 	"github.com/jnrprgmr/strmr/pkg/youtube"
 )
 
 type YouTubeUpload struct {
-	RecordingID int64  `json:"recording_id"`
-	PlaylistID  string `json:"playlist_id"`
+	RecordingID   int64                   `json:"recording_id"`
+	PlaylistID    string                  `json:"playlist_id"`
+	PublishPolicy *database.PublishPolicy `json:"publish_policy"`
+	Thumbnail     *int                    `json:"thumbnail"`
 }
 
-func CreateSocialText() string {
-	text := "Socials\n" +
-		"YouTube: https://youtube.com/@jnrprgmr\n" +
-		"Twitch: https://twitch.tv/jnrprgmr\n" +
-		"Github: https://github.com/jnrprgmr\n" +
-		"Discord: https://discord.gg/mHWp8AchaX\n" +
-		"Reddit: https://reddit.com/user/jnrprgmr\n" +
-		"Subreddit: https://www.reddit.com/r/jnrprgmr\n" +
-		"Twitter: https://twitter.com/jnrprgmr\n" +
-		"Tumblr: https://jnrprgmr.tumblr.com\n" +
-		"Trello: https://trello.com/w/jnr_prgmr\n" +
-		"Steam: https://steamcommunity.com/id/jnrprgmr\n"
-	return text
+// resolvePublishPolicy layers the policy of an upload request over the
+// category's policy over the defaults and schedules it from now.
+func resolvePublishPolicy(category database.Category, request *database.PublishPolicy) (database.PublishPolicy, error) {
+	policy := database.DefaultPublishPolicy()
+	if category.PublishPolicy != nil {
+		policy = policy.Merge(*category.PublishPolicy)
+	}
+	if request != nil {
+		err := request.Validate()
+		if err != nil {
+			return policy, err
+		}
+		policy = policy.Merge(*request)
+	}
+	err := policy.Validate()
+	if err != nil {
+		return policy, err
+	}
+	return policy.Schedule(time.Now())
 }
 
 func (h *Handlers) YouTubeUploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -39,94 +51,123 @@ func (h *Handlers) YouTubeUploadHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		json.Unmarshal(reqBody, &data)
-		media_record, err := h.database.GetMediaRecordingByID(data.RecordingID)
+		media_record, err := h.database.GetMediaRecordingByIDContext(r.Context(), data.RecordingID)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		if media_record == nil {
-			h.ErrorResponse(w, err.Error(), http.StatusNotFound)
+			h.ErrorResponse(w, "recording not found", http.StatusNotFound)
 			return
 		}
-		yt_data, err := h.convertToYouTubeMetadata(*media_record)
+		account, ok := h.requestAccount(w, r)
+		if !ok {
+			return
+		}
+		t, err := h.uploadTemplate(r.Context(), *account)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		description := ""
-		categories := []string{}
-		for i := range yt_data.Categories {
-			categories = append(categories, yt_data.Categories[i].Text)
+		rendered, err := h.renderUpload(r.Context(), *media_record, t, *account)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(rendered.Problems) > 0 {
+			h.ErrorResponse(w, strings.Join(rendered.Problems, ", "), http.StatusBadRequest)
+			return
 		}
 		// we will just use the starting category to set in youtube
-		if len(categories) == 0 {
+		if len(rendered.Categories) == 0 {
 			h.ErrorResponse(w, "no categories found for youtube upload", http.StatusInternalServerError)
 			return
 		}
-		cat := categories[0]
-		db_category, err := h.database.GetCategoryByName(cat)
+		db_category, err := h.database.GetCategoryByNameContext(r.Context(), rendered.Categories[0])
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		tags := []string{}
-		hash_tags := []string{}
-		unique_tags := map[string]bool{}
-		for i := range yt_data.Tags {
-			real_tags := strings.Split(yt_data.Tags[i].Text, ",")
-			for j := range real_tags {
-				unique_tags[real_tags[j]] = true
+		if db_category == nil {
+			h.ErrorResponse(w, "category ["+rendered.Categories[0]+"] not found", http.StatusInternalServerError)
+			return
+		}
+		policy, err := resolvePublishPolicy(*db_category, data.PublishPolicy)
+		if err != nil {
+			h.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i := range rendered.chapters.Dropped {
+			dropped := rendered.chapters.Dropped[i]
+			fmt.Println("Dropped chapter " + youtube.GetTimestamp(dropped.Start) + " " + dropped.Title + ": " + dropped.Reason)
+		}
+		if !rendered.chapters.Valid() {
+			fmt.Println("Recording " + strconv.FormatInt(media_record.ID, 10) + " has too few chapters for YouTube to recognise them")
+		}
+		thumbnail_file := ""
+		if data.Thumbnail != nil {
+			thumbnail_file = thumbnailFile(*media_record, *data.Thumbnail)
+			_, err = os.Stat(thumbnail_file)
+			if err != nil {
+				h.ErrorResponse(w, "thumbnail "+strconv.Itoa(*data.Thumbnail)+" not found, make the thumbnails first", http.StatusBadRequest)
+				return
 			}
 		}
-		for tag := range unique_tags {
-			hash_tags = append(hash_tags, "#"+tag)
-			tags = append(tags, tag)
-		}
-		descriptions := []string{}
-		for i := range yt_data.Descriptions {
-			descriptions = append(descriptions, yt_data.Descriptions[i].Text)
-		}
-		recording_time := time.Unix(media_record.StartTime, 0).UTC().Format(time.RFC3339Nano)
-		description = description + "Categories:\n" + strings.Join(categories, "\n") + "\n\n"
-		description = description + strings.Join(descriptions, "\n") + "\n\n"
-		description = description + "Timestamps:\n" + youtube.CreateMetadataText(yt_data.Tasks, "Starting stream") + "\n"
-		description = description + CreateSocialText() + "\n"
-		description = description + strings.Join(hash_tags, " ") + "\n"
-		description = description + "Streamed: " + recording_time
-		subtitle_file := youtube.CreateSubtitleText(yt_data.Subtitles)
-		title := youtube.CreateTitleText(yt_data.Titles, "/")
-		partial_file := media_record.Directory + "/" + media_record.FileName
-		subtitle_file_name := partial_file + ".srt"
-		err = ioutil.WriteFile(subtitle_file_name, []byte(subtitle_file), 0666)
+		subtitle_file_name, err := h.writeRecordingSubtitles(r.Context(), *media_record)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		video_id, err := h.youtube.UploadVideo(partial_file+"."+media_record.Extension, title, description, tags, recording_time, db_category.RelatedID)
+		id, err := h.uploads.Enqueue(r.Context(), database.UploadJob{
+			MediaRecordingID: media_record.ID,
+			File:             media_record.Directory + "/" + media_record.FileName + "." + media_record.Extension,
+			SubtitleFile:     subtitle_file_name,
+			ThumbnailFile:    thumbnail_file,
+			Title:            rendered.Title,
+			Description:      rendered.Description,
+			Tags:             rendered.Tags,
+			CategoryID:       db_category.RelatedID,
+			PlaylistID:       data.PlaylistID,
+			RecordingTime:    rendered.RecordingTime,
+			PublishPolicy:    policy,
+		})
+		if err == database.ErrUploadJobExists {
+			h.ErrorResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if video_id != nil {
-			err = h.youtube.InsertCaption(*video_id, subtitle_file_name)
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
-		if data.PlaylistID != "" {
-			err = h.youtube.InsertPlaylist(*video_id, data.PlaylistID)
-			if err != nil {
-				h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		b, err := json.Marshal(map[string]int64{"id": id})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
-		err = h.database.SetMediaRecordingUploadedByID(data.RecordingID, true)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// YouTubeUploadsHandler reports the progress of the latest upload jobs.
+func (h *Handlers) YouTubeUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		progress, err := h.uploads.Progress(r.Context(), 20)
 		if err != nil {
 			h.ErrorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		b, err := json.Marshal(progress)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		w.Write(b)
 		return
 	}
 	w.WriteHeader(http.StatusMethodNotAllowed)
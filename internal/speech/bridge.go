@@ -0,0 +1,124 @@
+package speech
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jnrprgmr/strmr/pkg/twitch"
+	"github.com/jnrprgmr/strmr/pkg/twitch/chat"
+)
+
+// The sources of the text of viewers, their priority and limits are
+// configured with the other sources.
+const (
+	SourceChat       = "chat"
+	SourceRedemption = "redemption"
+	SourceBits       = "bits"
+)
+
+// globalCheermotes are the prefixes of the cheermotes every channel has,
+// channels can add their own with Moderation.Cheermotes.
+var globalCheermotes = []string{
+	"Cheer", "DoodleCheer", "BibleThump", "cheerwhal", "Corgo", "uni",
+	"ShowLove", "Party", "SeemsGood", "Pride", "Kappa", "FrankerZ",
+	"HeyGuys", "DansGame", "EleGiggle", "TriHard", "Kreygasm", "4Head",
+	"SwiftRage", "NotLikeThis", "FailFish", "VoHiYo", "PJSalt",
+	"MrDestructoid", "bday", "RIPCheer", "Shamrock", "Scoops", "BitBoss",
+	"Streamlabs", "Muxy", "HolidayCheer", "Goal", "Anon", "Charity",
+}
+
+// cheermotePattern matches the cheermotes of prefixes, a prefix followed by
+// the bits cheered, e.g. Cheer100, in any case like twitch does.
+func cheermotePattern(prefixes []string) *regexp.Regexp {
+	quoted := []string{}
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" {
+			quoted = append(quoted, regexp.QuoteMeta(prefix))
+		}
+	}
+	return regexp.MustCompile(`(?i)^(?:` + strings.Join(quoted, "|") + `)[0-9]+$`)
+}
+
+// withoutCheermotes is text without the cheermotes in it.
+func (m *Moderator) withoutCheermotes(text string) string {
+	words := []string{}
+	for _, word := range strings.Fields(text) {
+		if !m.cheermotes.MatchString(word) {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Run speaks chat messages, the input of the configured reward and the
+// message of cheers with enough bits until ctx is cancelled. Every text is
+// moderated first.
+func (m *Moderator) Run(ctx context.Context, chat_messages <-chan chat.PrivateMessage, twitch_events <-chan twitch.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-chat_messages:
+			if !ok {
+				chat_messages = nil
+				continue
+			}
+			if !m.chat || strings.HasPrefix(strings.TrimSpace(msg.Text), chat.Prefix) {
+				continue
+			}
+			// chat is too busy to answer, what is refused is left unsaid
+			m.Submit(Submission{
+				Source:    SourceChat,
+				UserID:    msg.UserID,
+				UserLogin: msg.UserLogin,
+				Text:      msg.Text,
+				Moderator: msg.Moderator(),
+			})
+		case event, ok := <-twitch_events:
+			if !ok {
+				twitch_events = nil
+				continue
+			}
+			m.event(event)
+		}
+	}
+}
+
+func (m *Moderator) event(event twitch.Event) {
+	var s Submission
+	switch event.Type {
+	case twitch.EventRedemption:
+		var e twitch.RedemptionEvent
+		if event.Decode(&e) != nil || m.reward == "" || !strings.EqualFold(e.Reward.Title, m.reward) {
+			return
+		}
+		s = Submission{
+			Source:    SourceRedemption,
+			UserID:    e.UserID,
+			UserLogin: e.UserLogin,
+			Text:      e.UserInput,
+			Paid:      true,
+		}
+	case twitch.EventCheer:
+		var e twitch.CheerEvent
+		if event.Decode(&e) != nil || m.bits == 0 || e.Bits < m.bits {
+			return
+		}
+		s = Submission{
+			Source:    SourceBits,
+			UserID:    e.UserID,
+			UserLogin: e.UserLogin,
+			Text:      m.withoutCheermotes(e.Message),
+			Paid:      true,
+		}
+	default:
+		return
+	}
+	_, err := m.Submit(s)
+	if err != nil {
+		fmt.Println("Cannot speak the " + s.Source + " of " + s.UserLogin + ": " + err.Error())
+	}
+}
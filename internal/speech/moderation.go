@@ -0,0 +1,255 @@
+package speech
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultModeratedLength = 200
+	defaultUserCooldown    = 30 * time.Second
+	maxPending             = 50
+)
+
+var (
+	ErrBanned     = errors.New("text has a banned word")
+	ErrCooldown   = errors.New("wait a little before sending more text to speak")
+	ErrNotPending = errors.New("message is not waiting for approval")
+	ErrTooMany    = errors.New("too many messages are waiting for approval")
+)
+
+// urlPattern matches links with a scheme or www. and bare domains of the
+// common top level domains, so chat cannot have the avatar read them out.
+var urlPattern = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)\S+|\b(?:[a-z0-9-]+\.)+(?:com|net|org|tv|gg|io|co|me|ly|be|dev|app|xyz|info|link)\b(?:/\S*)?`)
+
+// Moderation is what text from viewers goes through before it is spoken.
+type Moderation struct {
+	// BannedWords reject the whole text, in any case. Words with a space in
+	// them match as a phrase.
+	BannedWords []string `yaml:"banned_words"`
+	// KeepURLs speaks links, they are taken out of the text otherwise.
+	KeepURLs bool `yaml:"keep_urls"`
+	// MaxLength is the most characters spoken of a viewer after links are
+	// taken out, 200 when 0.
+	MaxLength int `yaml:"max_length"`
+	// UserCooldown is how long a viewer waits between texts, 30s when 0.
+	// Paid texts do not wait.
+	UserCooldown time.Duration `yaml:"user_cooldown"`
+	// Approval holds every text that is not from a moderator until a
+	// moderator approves it.
+	Approval bool `yaml:"approval"`
+	// Chat speaks every chat message that is not a command, not only !say.
+	Chat bool `yaml:"chat"`
+	// Reward is the title of the channel point reward that speaks its
+	// input, none when empty.
+	Reward string `yaml:"reward"`
+	// Bits is the fewest bits a cheer needs to speak its message, cheers are
+	// not spoken when 0.
+	Bits int `yaml:"bits"`
+	// Cheermotes are the prefixes of the custom cheermotes of the channel,
+	// taken out of cheers like the global ones.
+	Cheermotes []string `yaml:"cheermotes"`
+}
+
+// Submission is text a viewer wants spoken. Moderators are trusted and skip
+// the cooldown and approval, paid texts skip the cooldown.
+type Submission struct {
+	Source    string `json:"source"`
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	Text      string `json:"text"`
+	Moderator bool   `json:"moderator"`
+	Paid      bool   `json:"paid"`
+}
+
+// Pending is a text waiting for a moderator to approve it.
+type Pending struct {
+	ID         int64      `json:"id"`
+	Submission Submission `json:"submission"`
+	SubmitTime time.Time  `json:"submit_time"`
+}
+
+// Moderator filters the text of viewers before it reaches the queue, text it
+// refuses is never spoken nor kept as subtitles.
+type Moderator struct {
+	queue         *Queue
+	banned        []string
+	keep_urls     bool
+	max_length    int
+	user_cooldown time.Duration
+	approval      bool
+	chat          bool
+	reward        string
+	bits          int
+	cheermotes    *regexp.Regexp
+	mu            sync.Mutex
+	next_id       int64
+	pending       []Pending
+	user_sent     map[string]time.Time
+}
+
+func NewModerator(q *Queue, c Moderation) (*Moderator, error) {
+	if c.MaxLength < 0 || c.UserCooldown < 0 || c.Bits < 0 {
+		return nil, errors.New("moderation limits cannot be negative")
+	}
+	m := &Moderator{
+		queue:         q,
+		keep_urls:     c.KeepURLs,
+		max_length:    c.MaxLength,
+		user_cooldown: c.UserCooldown,
+		approval:      c.Approval,
+		chat:          c.Chat,
+		reward:        strings.TrimSpace(c.Reward),
+		bits:          c.Bits,
+		cheermotes:    cheermotePattern(append(append([]string{}, globalCheermotes...), c.Cheermotes...)),
+		user_sent:     map[string]time.Time{},
+	}
+	if m.max_length == 0 {
+		m.max_length = defaultModeratedLength
+	}
+	if m.user_cooldown == 0 {
+		m.user_cooldown = defaultUserCooldown
+	}
+	for _, word := range c.BannedWords {
+		word = strings.ToLower(strings.Join(strings.Fields(word), " "))
+		if word != "" {
+			m.banned = append(m.banned, word)
+		}
+	}
+	return m, nil
+}
+
+// clean takes the links out of text and closes the gaps they leave.
+func (m *Moderator) clean(text string) string {
+	if !m.keep_urls {
+		text = urlPattern.ReplaceAllString(text, "")
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// bannedWord is the first banned word in text, empty without one.
+func (m *Moderator) bannedWord(text string) string {
+	text = strings.ToLower(text)
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	}) {
+		words[word] = true
+	}
+	for _, word := range m.banned {
+		if words[word] || (strings.Contains(word, " ") && strings.Contains(text, word)) {
+			return word
+		}
+	}
+	return ""
+}
+
+// Submit moderates the text of s and queues it, or holds it for approval
+// and returns it as pending.
+func (m *Moderator) Submit(s Submission) (*Pending, error) {
+	s.Text = m.clean(s.Text)
+	if s.Text == "" {
+		return nil, ErrEmpty
+	}
+	if m.bannedWord(s.Text) != "" {
+		return nil, ErrBanned
+	}
+	if utf8.RuneCountInString(s.Text) > m.max_length {
+		return nil, ErrTooLong
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for user_id, sent := range m.user_sent {
+		if now.Sub(sent) >= m.user_cooldown {
+			delete(m.user_sent, user_id)
+		}
+	}
+	if !s.Moderator && !s.Paid && s.UserID != "" {
+		if now.Sub(m.user_sent[s.UserID]) < m.user_cooldown {
+			return nil, ErrCooldown
+		}
+	}
+	if m.approval && !s.Moderator {
+		if len(m.pending) >= maxPending {
+			return nil, ErrTooMany
+		}
+		m.next_id = m.next_id + 1
+		pending := Pending{
+			ID:         m.next_id,
+			Submission: s,
+			SubmitTime: now,
+		}
+		m.pending = append(m.pending, pending)
+		m.user_sent[s.UserID] = now
+		return &pending, nil
+	}
+	_, err := m.queue.Enqueue(s.Source, s.Text)
+	if err != nil {
+		return nil, err
+	}
+	m.user_sent[s.UserID] = now
+	return nil, nil
+}
+
+// Pending are the texts waiting for approval, oldest first.
+func (m *Moderator) Pending() []Pending {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pending := make([]Pending, len(m.pending))
+	copy(pending, m.pending)
+	return pending
+}
+
+// find is the index of the pending text of id, the oldest when id is 0, or
+// -1. m.mu is held.
+func (m *Moderator) find(id int64) int {
+	for i, pending := range m.pending {
+		if id == 0 || pending.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// take removes the pending text of id, the oldest when id is 0.
+func (m *Moderator) take(id int64) (Pending, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.find(id)
+	if i < 0 {
+		return Pending{}, ErrNotPending
+	}
+	pending := m.pending[i]
+	m.pending = append(m.pending[:i], m.pending[i+1:]...)
+	return pending, nil
+}
+
+// Approve queues the pending text of id, the oldest when id is 0. While the
+// queue is full or the source is rate limited the text stays pending to be
+// approved again.
+func (m *Moderator) Approve(id int64) (Pending, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.find(id)
+	if i < 0 {
+		return Pending{}, ErrNotPending
+	}
+	pending := m.pending[i]
+	_, err := m.queue.Enqueue(pending.Submission.Source, pending.Submission.Text)
+	if err == ErrQueueFull || err == ErrRateLimited {
+		return pending, err
+	}
+	m.pending = append(m.pending[:i], m.pending[i+1:]...)
+	return pending, err
+}
+
+// Reject drops the pending text of id, the oldest when id is 0.
+func (m *Moderator) Reject(id int64) (Pending, error) {
+	return m.take(id)
+}
@@ -0,0 +1,205 @@
+package speech
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/jnrprgmr/strmr/pkg/twitch"
+)
+
+// newModerator moderates into a queue holding at most max_queued messages,
+// nothing is spoken as the queue is not run.
+func newModerator(t *testing.T, max_queued int, c Moderation) (*Moderator, *Queue) {
+	t.Helper()
+	q, err := New(nil, nil, nil, Config{MaxQueued: max_queued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewModerator(q, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, q
+}
+
+func waiting(q *Queue) []string {
+	texts := []string{}
+	for _, msg := range q.Status().Waiting {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func TestSubmit(t *testing.T) {
+	m, q := newModerator(t, 3, Moderation{
+		BannedWords: []string{"Darn", "  bad   PHRASE "},
+		MaxLength:   40,
+	})
+	tests := []struct {
+		text string
+		err  error
+	}{
+		{"well DARN it", ErrBanned},
+		{"a bad  phrase here", ErrBanned},
+		{"https://strmr.tv www.example.org example.com/path", ErrEmpty},
+		{"this text is far too long to be spoken out loud", ErrTooLong},
+	}
+	for i, test := range tests {
+		_, err := m.Submit(Submission{Source: SourceChat, UserID: strconv.Itoa(i), Text: test.text})
+		if err != test.err {
+			t.Errorf("submitting %q returned %v, want %v", test.text, err, test.err)
+		}
+	}
+	if len(q.Status().Waiting) != 0 {
+		t.Fatalf("refused text was queued: %v", waiting(q))
+	}
+
+	// banned words only match whole words, links are taken out
+	pending, err := m.Submit(Submission{Source: SourceChat, UserID: "10", Text: "darning socks, see https://strmr.tv/socks and strmr.tv"})
+	if err != nil || pending != nil {
+		t.Fatalf("got %v, %v", pending, err)
+	}
+	if texts := waiting(q); len(texts) != 1 || texts[0] != "darning socks, see and" {
+		t.Errorf("queued %q", texts)
+	}
+
+	// a viewer waits between texts, moderators and paid texts do not
+	_, err = m.Submit(Submission{Source: SourceChat, UserID: "10", Text: "again"})
+	if err != ErrCooldown {
+		t.Errorf("second text returned %v, want ErrCooldown", err)
+	}
+	_, err = m.Submit(Submission{Source: SourceRedemption, UserID: "10", Text: "paid", Paid: true})
+	if err != nil {
+		t.Errorf("paid text returned %v", err)
+	}
+	_, err = m.Submit(Submission{Source: SourceChat, UserID: "11", Text: "mod", Moderator: true})
+	if err != nil {
+		t.Errorf("moderator text returned %v", err)
+	}
+
+	// a full queue does not start the cooldown
+	_, err = m.Submit(Submission{Source: SourceChat, UserID: "12", Text: "full"})
+	if err != ErrQueueFull {
+		t.Fatalf("got %v with a full queue, want ErrQueueFull", err)
+	}
+	q.Clear()
+	_, err = m.Submit(Submission{Source: SourceChat, UserID: "12", Text: "not full"})
+	if err != nil {
+		t.Errorf("got %v after the queue was cleared", err)
+	}
+}
+
+func TestSubmitKeepURLs(t *testing.T) {
+	m, q := newModerator(t, 1, Moderation{KeepURLs: true})
+	_, err := m.Submit(Submission{Source: SourceChat, UserID: "1", Text: "see  https://strmr.tv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts := waiting(q); len(texts) != 1 || texts[0] != "see https://strmr.tv" {
+		t.Errorf("queued %q", texts)
+	}
+}
+
+func TestApprove(t *testing.T) {
+	m, q := newModerator(t, 1, Moderation{Approval: true})
+	first, err := m.Submit(Submission{Source: SourceChat, UserID: "1", Text: "first"})
+	if err != nil || first == nil {
+		t.Fatalf("got %v, %v, want the text pending", first, err)
+	}
+	second, err := m.Submit(Submission{Source: SourceChat, UserID: "2", Text: "second"})
+	if err != nil || second == nil {
+		t.Fatalf("got %v, %v, want the text pending", second, err)
+	}
+	if len(q.Status().Waiting) != 0 {
+		t.Fatalf("pending text was queued: %v", waiting(q))
+	}
+
+	approved, err := m.Approve(0)
+	if err != nil || approved.ID != first.ID {
+		t.Fatalf("approved %v, %v, want the oldest", approved, err)
+	}
+	if texts := waiting(q); len(texts) != 1 || texts[0] != "first" {
+		t.Errorf("queued %q", texts)
+	}
+
+	// the queue is full, the text waits to be approved again
+	_, err = m.Approve(second.ID)
+	if err != ErrQueueFull {
+		t.Fatalf("got %v, want ErrQueueFull", err)
+	}
+	if pending := m.Pending(); len(pending) != 1 || pending[0].ID != second.ID {
+		t.Fatalf("pending %v, want the second text kept", pending)
+	}
+	q.Clear()
+	_, err = m.Approve(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Pending()) != 0 {
+		t.Errorf("approved text still pending: %v", m.Pending())
+	}
+	_, err = m.Approve(second.ID)
+	if err != ErrNotPending {
+		t.Errorf("approving twice returned %v, want ErrNotPending", err)
+	}
+
+	// moderators are not held, rejected text is dropped
+	q.Clear()
+	pending, err := m.Submit(Submission{Source: SourceChat, UserID: "3", Text: "mod", Moderator: true})
+	if err != nil || pending != nil {
+		t.Errorf("moderator text got %v, %v", pending, err)
+	}
+	third, err := m.Submit(Submission{Source: SourceChat, UserID: "4", Text: "third"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Reject(third.ID)
+	if err != nil || len(m.Pending()) != 0 {
+		t.Errorf("reject returned %v, pending %v", err, m.Pending())
+	}
+
+	for i := 0; i < maxPending; i++ {
+		_, err = m.Submit(Submission{Source: SourceChat, UserID: "user" + strconv.Itoa(i), Text: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = m.Submit(Submission{Source: SourceChat, UserID: "one more", Text: "hi"})
+	if err != ErrTooMany {
+		t.Errorf("got %v with %d pending, want ErrTooMany", err, maxPending)
+	}
+}
+
+func TestWithoutCheermotes(t *testing.T) {
+	m, _ := newModerator(t, 1, Moderation{Cheermotes: []string{"strmrHype", " "}})
+	tests := map[string]string{
+		"Cheer100 great stream":            "great stream",
+		"cheer1 Kappa50 BibleThump10 hi":   "hi",
+		"strmrhype500 STRMRHYPE1 hype":     "hype",
+		"Cheers! mp3 h264 covid19 Cheer":   "Cheers! mp3 h264 covid19 Cheer",
+		"Cheer10x otherHype100 Cheer100.5": "Cheer10x otherHype100 Cheer100.5",
+	}
+	for text, want := range tests {
+		if got := m.withoutCheermotes(text); got != want {
+			t.Errorf("withoutCheermotes(%q) is %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestCheerEvent(t *testing.T) {
+	m, q := newModerator(t, 5, Moderation{Bits: 100})
+	cheer := func(bits int, message string) twitch.Event {
+		data, err := json.Marshal(twitch.CheerEvent{UserID: "1", UserLogin: "viewer", Message: message, Bits: bits})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return twitch.Event{Type: twitch.EventCheer, Data: data}
+	}
+	m.event(cheer(99, "Cheer99 too few bits"))
+	m.event(cheer(100, "Cheer100 Cheers for the stream"))
+	m.event(cheer(500, "Cheer500"))
+	if texts := waiting(q); len(texts) != 1 || texts[0] != "Cheers for the stream" {
+		t.Errorf("queued %q", texts)
+	}
+}
@@ -0,0 +1,319 @@
+package speech
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jnrprgmr/strmr/internal/avatar"
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/tts"
+)
+
+const (
+	defaultMaxLength = 300
+	defaultMaxQueued = 50
+)
+
+var (
+	ErrEmpty       = errors.New("there is no text to speak")
+	ErrTooLong     = errors.New("text is too long to speak")
+	ErrQueueFull   = errors.New("speech queue is full")
+	ErrRateLimited = errors.New("source has sent too many messages, try again later")
+)
+
+type Config struct {
+	// MaxLength is the most characters spoken in one message, 300 when 0.
+	MaxLength int `yaml:"max_length"`
+	// MaxQueued is how many messages can wait at once, 50 when 0.
+	MaxQueued int `yaml:"max_queued"`
+	// Sources limits messages by where they come from, e.g. chat or alerts.
+	Sources map[string]Source `yaml:"sources"`
+	// Moderation filters the text of viewers from chat, rewards and bits.
+	Moderation Moderation `yaml:"moderation"`
+}
+
+// Source gives the messages of a source their priority, higher is spoken
+// first, and accepts at most Limit of them every Per. A Limit of 0 does not
+// limit the source.
+type Source struct {
+	Priority int64         `yaml:"priority"`
+	Limit    int           `yaml:"limit"`
+	Per      time.Duration `yaml:"per"`
+}
+
+type Message struct {
+	ID        int64     `json:"id"`
+	Source    string    `json:"source"`
+	Text      string    `json:"text"`
+	Priority  int64     `json:"priority"`
+	QueueTime time.Time `json:"queue_time"`
+}
+
+// Status is the queue as reported by /speech.
+type Status struct {
+	Paused   bool      `json:"paused"`
+	Speaking *Message  `json:"speaking"`
+	Waiting  []Message `json:"waiting"`
+}
+
+// Queue speaks one message at a time in order of priority, then in the order
+// they came in. Everything taken from the queue is logged in the database
+// and what was heard is kept as subtitles. The avatar is told when speech
+// starts and stops.
+type Queue struct {
+	database   *database.Database
+	speaker    *tts.Speaker
+	avatar     *avatar.Hub
+	max_length int
+	max_queued int
+	sources    map[string]Source
+	wake       chan struct{}
+	mu         sync.Mutex
+	next_id    int64
+	waiting    []Message
+	speaking   *Message
+	playing    bool
+	paused     bool
+	skip       context.CancelFunc
+	sent       map[string][]time.Time
+}
+
+func New(db *database.Database, speaker *tts.Speaker, hub *avatar.Hub, c Config) (*Queue, error) {
+	for name, source := range c.Sources {
+		if source.Limit < 0 || (source.Limit > 0 && source.Per <= 0) {
+			return nil, errors.New("source [" + name + "] needs a positive limit and time to limit it per")
+		}
+	}
+	q := &Queue{
+		database:   db,
+		speaker:    speaker,
+		avatar:     hub,
+		max_length: c.MaxLength,
+		max_queued: c.MaxQueued,
+		sources:    c.Sources,
+		wake:       make(chan struct{}, 1),
+		sent:       map[string][]time.Time{},
+	}
+	if q.max_length <= 0 {
+		q.max_length = defaultMaxLength
+	}
+	if q.max_queued <= 0 {
+		q.max_queued = defaultMaxQueued
+	}
+	return q, nil
+}
+
+// Enqueue queues text from source to be spoken.
+func (q *Queue) Enqueue(source string, text string) (Message, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Message{}, ErrEmpty
+	}
+	if utf8.RuneCountInString(text) > q.max_length {
+		return Message{}, ErrTooLong
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) >= q.max_queued {
+		return Message{}, ErrQueueFull
+	}
+	now := time.Now()
+	config := q.sources[source]
+	if config.Limit > 0 {
+		sent := []time.Time{}
+		for _, t := range q.sent[source] {
+			if now.Sub(t) < config.Per {
+				sent = append(sent, t)
+			}
+		}
+		if len(sent) >= config.Limit {
+			q.sent[source] = sent
+			return Message{}, ErrRateLimited
+		}
+		q.sent[source] = append(sent, now)
+	}
+	q.next_id = q.next_id + 1
+	msg := Message{
+		ID:        q.next_id,
+		Source:    source,
+		Text:      text,
+		Priority:  config.Priority,
+		QueueTime: now,
+	}
+	i := len(q.waiting)
+	for i > 0 && q.waiting[i-1].Priority < msg.Priority {
+		i--
+	}
+	q.waiting = append(q.waiting[:i], append([]Message{msg}, q.waiting[i:]...)...)
+	q.signal()
+	return msg, nil
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Speaking reports whether speech is playing right now.
+func (q *Queue) Speaking() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.playing
+}
+
+func (q *Queue) Status() Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := Status{
+		Paused:  q.paused,
+		Waiting: append([]Message{}, q.waiting...),
+	}
+	if q.speaking != nil {
+		msg := *q.speaking
+		status.Speaking = &msg
+	}
+	return status
+}
+
+// Skip stops the message being spoken and reports whether there was one.
+func (q *Queue) Skip() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.skip == nil {
+		return false
+	}
+	q.skip()
+	return true
+}
+
+// Clear drops every waiting message and returns how many there were, the
+// message being spoken is finished.
+func (q *Queue) Clear() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cleared := len(q.waiting)
+	q.waiting = nil
+	return cleared
+}
+
+// SetPaused stops or starts taking messages from the queue, the message
+// being spoken is finished.
+func (q *Queue) SetPaused(paused bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = paused
+	q.signal()
+}
+
+// Run speaks queued messages until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		msg, speak_ctx, ok := q.next(ctx)
+		if ok {
+			q.speak(ctx, speak_ctx, msg)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+	}
+}
+
+// next takes the next message with a context that Skip cancels.
+func (q *Queue) next(ctx context.Context) (Message, context.Context, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused || len(q.waiting) == 0 || ctx.Err() != nil {
+		return Message{}, nil, false
+	}
+	msg := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	q.speaking = &msg
+	speak_ctx, cancel := context.WithCancel(ctx)
+	q.skip = cancel
+	return msg, speak_ctx, true
+}
+
+func (q *Queue) setPlaying(playing bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.playing = playing
+}
+
+func (q *Queue) speak(ctx context.Context, speak_ctx context.Context, msg Message) {
+	name := "speech " + strconv.FormatInt(msg.ID, 10)
+	log := database.SpeechLog{
+		Source:      msg.Source,
+		Voice:       q.speaker.VoiceFor(msg.Source),
+		Text:        msg.Text,
+		Priority:    msg.Priority,
+		QueueMillis: msg.QueueTime.UnixMilli(),
+	}
+	speech, err := q.speaker.Render(speak_ctx, msg.Source, msg.Text)
+	start := time.Now()
+	end := start
+	if err == nil {
+		q.setPlaying(true)
+		q.avatar.StartSpeaking(avatar.Speaking{
+			ID:             msg.ID,
+			Source:         msg.Source,
+			Text:           msg.Text,
+			StartMillis:    start.UnixMilli(),
+			DurationMillis: speech.Duration.Milliseconds(),
+			StepMillis:     tts.EnvelopeStep.Milliseconds(),
+			Envelope:       speech.Envelope,
+		})
+		err = q.speaker.Play(speak_ctx, speech)
+		q.avatar.StopSpeaking()
+		q.setPlaying(false)
+		// timed by the audio, the player takes a moment to start and stop
+		end = start.Add(speech.Duration)
+		if err != nil {
+			end = time.Now()
+		}
+		speech.Remove()
+	}
+	skipped := speak_ctx.Err() != nil
+	q.mu.Lock()
+	q.skip()
+	q.skip = nil
+	q.speaking = nil
+	q.mu.Unlock()
+	switch {
+	case err == nil:
+		log.State = database.SpeechSpoken
+	case skipped:
+		log.State = database.SpeechSkipped
+		if ctx.Err() != nil {
+			fmt.Println("Stopped " + name + " to shut down")
+		}
+	default:
+		log.State = database.SpeechFailed
+		last_error := err.Error()
+		log.Error = &last_error
+		fmt.Println("Cannot speak " + name + ": " + last_error)
+	}
+	log.StartMillis = start.UnixMilli()
+	log.EndMillis = end.UnixMilli()
+	// written without ctx so a message cut short by a shut down is still kept
+	if log.State != database.SpeechFailed && end.After(start) {
+		err = q.database.InsertSubtitle(msg.Text, start, end)
+		if err != nil {
+			fmt.Println("Cannot save subtitle of " + name + ": " + err.Error())
+		}
+	}
+	err = q.database.InsertSpeechLog(log)
+	if err != nil {
+		fmt.Println("Cannot log " + name + ": " + err.Error())
+	}
+}
@@ -0,0 +1,343 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/youtube"
+)
+
+const (
+	pollInterval    = 30 * time.Second
+	retryMinDelay   = 30 * time.Second
+	retryMaxDelay   = 30 * time.Minute
+	defaultAttempts = 8
+)
+
+// Queue uploads recordings to YouTube in the background. Jobs are stored in
+// the database so a restart resumes the upload session where it stopped.
+type Queue struct {
+	database    *database.Database
+	youtube     *youtube.YouTube
+	ChunkSize   int64
+	MaxAttempts int64
+	wake        chan struct{}
+	mu          sync.Mutex
+	rates       map[int64]*rate
+}
+
+// rate tracks throughput of the running attempt of a job for its ETA.
+type rate struct {
+	start       time.Time
+	start_bytes int64
+	bytes       int64
+}
+
+// Progress is a job as reported to the /youtube page. Reschedule is set when
+// the video missed its publish time and waits private to be scheduled again.
+type Progress struct {
+	ID               int64   `json:"id"`
+	MediaRecordingID int64   `json:"media_recording_id"`
+	Title            string  `json:"title"`
+	State            string  `json:"state"`
+	BytesSent        int64   `json:"bytes_sent"`
+	BytesTotal       int64   `json:"bytes_total"`
+	BytesPerSecond   int64   `json:"bytes_per_second"`
+	ETASeconds       *int64  `json:"eta_seconds"`
+	Attempts         int64   `json:"attempts"`
+	LastError        *string `json:"last_error"`
+	NextAttemptTime  int64   `json:"next_attempt_time"`
+	VideoID          *string `json:"video_id"`
+	Reschedule       bool    `json:"reschedule"`
+}
+
+func New(db *database.Database, yt *youtube.YouTube) *Queue {
+	return &Queue{
+		database:    db,
+		youtube:     yt,
+		ChunkSize:   youtube.ChunkSize,
+		MaxAttempts: defaultAttempts,
+		wake:        make(chan struct{}, 1),
+		rates:       map[int64]*rate{},
+	}
+}
+
+// Enqueue stores the job and wakes the worker, it returns the job id.
+func (q *Queue) Enqueue(ctx context.Context, job database.UploadJob) (int64, error) {
+	id, err := q.database.InsertUploadJobContext(ctx, job)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+func (q *Queue) Progress(ctx context.Context, limit int64) ([]Progress, error) {
+	jobs, err := q.database.GetLatestUploadJobsContext(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	progress := []Progress{}
+	for i := range jobs {
+		job := jobs[i]
+		p := Progress{
+			ID:               job.ID,
+			MediaRecordingID: job.MediaRecordingID,
+			Title:            job.Title,
+			State:            job.State,
+			BytesSent:        job.BytesSent,
+			BytesTotal:       job.BytesTotal,
+			Attempts:         job.Attempts,
+			LastError:        job.LastError,
+			NextAttemptTime:  job.NextAttemptTime,
+			VideoID:          job.VideoID,
+			Reschedule:       job.Reschedule,
+		}
+		r, ok := q.rates[job.ID]
+		if ok && job.State == database.UploadJobUploading {
+			// the rate is more current than the row, it is updated per chunk too
+			p.BytesSent = r.bytes
+			elapsed := time.Since(r.start).Seconds()
+			if elapsed > 0 && r.bytes > r.start_bytes {
+				p.BytesPerSecond = int64(float64(r.bytes-r.start_bytes) / elapsed)
+			}
+			if p.BytesPerSecond > 0 {
+				eta := (p.BytesTotal - p.BytesSent) / p.BytesPerSecond
+				p.ETASeconds = &eta
+			}
+		}
+		progress = append(progress, p)
+	}
+	return progress, nil
+}
+
+// Run works through the queue until ctx is cancelled. Jobs left running by
+// a previous process are queued again first.
+func (q *Queue) Run(ctx context.Context) {
+	err := q.database.RequeueInterruptedUploadJobsContext(ctx)
+	if err != nil {
+		fmt.Println("Cannot requeue interrupted uploads: " + err.Error())
+	}
+	for {
+		job, err := q.database.GetNextUploadJobContext(ctx, time.Now().Unix())
+		if err != nil && ctx.Err() == nil {
+			fmt.Println("Cannot get next upload job: " + err.Error())
+		}
+		if job != nil {
+			q.process(ctx, *job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (q *Queue) process(ctx context.Context, job database.UploadJob) {
+	name := "upload job " + strconv.FormatInt(job.ID, 10)
+	job.State = database.UploadJobUploading
+	err := q.database.UpdateUploadJobContext(ctx, job)
+	if err == nil {
+		err = q.upload(ctx, &job)
+	}
+	q.mu.Lock()
+	delete(q.rates, job.ID)
+	q.mu.Unlock()
+	if err == nil {
+		fmt.Println("Finished " + name)
+		return
+	}
+	if ctx.Err() != nil {
+		// shutting down, leave the job to resume on the next start
+		job.State = database.UploadJobQueued
+		err = q.database.UpdateUploadJob(job)
+		if err != nil {
+			fmt.Println("Cannot requeue " + name + ": " + err.Error())
+		}
+		return
+	}
+	msg := err.Error()
+	job.LastError = &msg
+	job.Attempts = job.Attempts + 1
+	if errors.Is(err, youtube.ErrSessionExpired) {
+		job.SessionURL = nil
+		job.BytesSent = 0
+	}
+	if youtube.Retryable(err) && job.Attempts < q.MaxAttempts {
+		delay := retryDelay(job.Attempts)
+		job.State = database.UploadJobQueued
+		job.NextAttemptTime = time.Now().Add(delay).Unix()
+		fmt.Println("Retrying " + name + " in " + delay.String() + ": " + msg)
+	} else {
+		job.State = database.UploadJobFailed
+		fmt.Println("Giving up on " + name + ": " + msg)
+	}
+	err = q.database.UpdateUploadJob(job)
+	if err != nil {
+		fmt.Println("Cannot save " + name + ": " + err.Error())
+	}
+}
+
+func retryDelay(attempts int64) time.Duration {
+	delay := retryMinDelay
+	for i := int64(1); i < attempts && delay < retryMaxDelay; i++ {
+		delay = delay * 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// upload sends whatever part of the file the session does not have yet and
+// then adds captions, the thumbnail and the playlist, a job with a video id
+// skips straight to those. Captions and the playlist are only added once,
+// the thumbnail replaces itself so it is set again on every attempt.
+func (q *Queue) upload(ctx context.Context, job *database.UploadJob) error {
+	if job.VideoID == nil {
+		video_id, err := q.send(ctx, job)
+		if err != nil {
+			return err
+		}
+		job.VideoID = video_id
+		job.State = database.UploadJobFinishing
+		err = q.database.UpdateUploadJobContext(ctx, *job)
+		if err != nil {
+			return err
+		}
+	}
+	if job.SubtitleFile != "" && !job.CaptionDone {
+		// every subtitle can be deleted in the editor after the job is queued,
+		// and a missing file would fail every attempt
+		info, err := os.Stat(job.SubtitleFile)
+		if err != nil {
+			fmt.Println("Skipping captions of upload job " + strconv.FormatInt(job.ID, 10) + ": " + err.Error())
+		} else if info.Size() > 0 {
+			err = q.youtube.InsertCaptionContext(ctx, *job.VideoID, job.SubtitleFile)
+			if err != nil {
+				return err
+			}
+		}
+		job.CaptionDone = true
+		err = q.database.UpdateUploadJobContext(ctx, *job)
+		if err != nil {
+			return err
+		}
+	}
+	if job.ThumbnailFile != "" {
+		err := q.youtube.SetThumbnailContext(ctx, *job.VideoID, job.ThumbnailFile)
+		if err != nil && ctx.Err() == nil {
+			// custom thumbnails need a verified channel, the video is fine
+			// without one so this does not fail the job
+			fmt.Println("Cannot set thumbnail of upload job " + strconv.FormatInt(job.ID, 10) + ": " + err.Error())
+		} else if err != nil {
+			return err
+		}
+	}
+	if job.PlaylistID != "" && !job.PlaylistDone {
+		err := q.youtube.InsertPlaylistContext(ctx, *job.VideoID, job.PlaylistID)
+		if err != nil {
+			return err
+		}
+		job.PlaylistDone = true
+		err = q.database.UpdateUploadJobContext(ctx, *job)
+		if err != nil {
+			return err
+		}
+	}
+	return q.database.FinishUploadJobContext(ctx, *job)
+}
+
+func (q *Queue) send(ctx context.Context, job *database.UploadJob) (*string, error) {
+	file, err := os.Open(job.File)
+	if err != nil {
+		return nil, errors.New("Error opening " + job.File + ": " + err.Error())
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.New("Error reading " + job.File + ": " + err.Error())
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, errors.New(job.File + " is empty")
+	}
+	offset := int64(0)
+	var video_id *string
+	if job.SessionURL != nil && job.BytesTotal == size {
+		offset, video_id, err = q.youtube.ResumableUploadOffset(ctx, *job.SessionURL, size)
+		if errors.Is(err, youtube.ErrSessionExpired) {
+			fmt.Println("Upload session of upload job " + strconv.FormatInt(job.ID, 10) + " expired, starting over")
+			job.SessionURL = nil
+		} else if err != nil {
+			return nil, err
+		}
+	} else {
+		job.SessionURL = nil
+	}
+	if job.SessionURL == nil {
+		policy := database.DefaultPublishPolicy().Merge(job.PublishPolicy)
+		if policy.PublishAt != "" {
+			at, err := time.Parse(time.RFC3339, policy.PublishAt)
+			if err == nil && !at.After(time.Now()) {
+				// YouTube rejects a publish time in the past, the upload was
+				// delayed beyond it so the video stays private until it is
+				// scheduled again
+				fmt.Println("Publish time of upload job " + strconv.FormatInt(job.ID, 10) + " has passed, uploading private to be scheduled again")
+				policy.PublishAt = ""
+				policy.Privacy = database.PrivacyPrivate
+				job.Reschedule = true
+			}
+		}
+		video := youtube.NewVideo(job.Title, job.Description, job.Tags, job.RecordingTime, job.CategoryID, policy)
+		session_url, err := q.youtube.StartResumableUpload(ctx, video, size, *policy.NotifySubscribers)
+		if err != nil {
+			return nil, err
+		}
+		job.SessionURL = &session_url
+		offset = 0
+		video_id = nil
+	}
+	job.BytesTotal = size
+	job.BytesSent = offset
+	err = q.database.UpdateUploadJobContext(ctx, *job)
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	q.rates[job.ID] = &rate{start: time.Now(), start_bytes: offset, bytes: offset}
+	q.mu.Unlock()
+	for video_id == nil {
+		length := q.ChunkSize
+		if offset+length > size {
+			length = size - offset
+		}
+		offset, video_id, err = q.youtube.UploadChunk(ctx, *job.SessionURL, file, offset, length, size)
+		if err != nil {
+			return nil, err
+		}
+		job.BytesSent = offset
+		q.mu.Lock()
+		q.rates[job.ID].bytes = offset
+		q.mu.Unlock()
+		err = q.database.UpdateUploadJobContext(ctx, *job)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return video_id, nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,11 +15,21 @@ import (
 	"time"
 
 	"github.com/andreykaipov/goobs"
+	"github.com/andreykaipov/goobs/api/events/subscriptions"
+	"github.com/jnrprgmr/strmr/internal/avatar"
+	"github.com/jnrprgmr/strmr/internal/chatbot"
+	"github.com/jnrprgmr/strmr/internal/jobs"
 	"github.com/jnrprgmr/strmr/internal/rest/handlers"
+	"github.com/jnrprgmr/strmr/internal/speech"
+	"github.com/jnrprgmr/strmr/internal/upload"
 	"github.com/jnrprgmr/strmr/pkg/brdcstr"
+	"github.com/jnrprgmr/strmr/pkg/clip"
 	"github.com/jnrprgmr/strmr/pkg/database"
 	"github.com/jnrprgmr/strmr/pkg/obs"
+	"github.com/jnrprgmr/strmr/pkg/thumbnail"
+	"github.com/jnrprgmr/strmr/pkg/tts"
 	"github.com/jnrprgmr/strmr/pkg/twitch"
+	"github.com/jnrprgmr/strmr/pkg/twitch/chat"
 	"github.com/jnrprgmr/strmr/pkg/youtube"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nicklaw5/helix/v2"
@@ -30,9 +41,18 @@ import (
 )
 
 type Config struct {
-	Database database.Config `yaml:"db"`
-	OBS      obs.Config      `yaml:"obs"`
-	Brdcstr  brdcstr.Config  `yaml:"brdcstr"`
+	// Account is the account of this profile, requests can pick another one.
+	Account   string           `yaml:"account"`
+	Database  database.Config  `yaml:"db"`
+	OBS       obs.Config       `yaml:"obs"`
+	Brdcstr   brdcstr.Config   `yaml:"brdcstr"`
+	Thumbnail thumbnail.Config `yaml:"thumbnail"`
+	Clip      clip.Config      `yaml:"clip"`
+	TTS       tts.Config       `yaml:"tts"`
+	Speech    speech.Config    `yaml:"speech"`
+	Avatar    avatar.Config    `yaml:"avatar"`
+	Twitch    twitch.Config    `yaml:"twitch"`
+	Chat      chat.Config      `yaml:"chat"`
 }
 
 func loadConfig() (*Config, error) {
@@ -59,39 +79,121 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 	return t, err
 }
 
+// profileAccount is the account of the profile, made the first time it is
+// configured. Without one configured the only account is used, if there is
+// exactly one, otherwise it is nil.
+func profileAccount(db *database.Database, name string) (*database.Account, error) {
+	if name == "" {
+		accounts, err := db.GetAccounts()
+		if err != nil || len(accounts) != 1 {
+			return nil, err
+		}
+		return &accounts[0], nil
+	}
+	account, err := db.GetAccountByName(name)
+	if err != nil || account != nil {
+		return account, err
+	}
+	account, err = db.SaveAccount(database.Account{Name: name})
+	if err != nil {
+		return nil, errors.New("Cannot make account " + name + ": " + err.Error())
+	}
+	fmt.Println("Made account " + name + ", link its twitch user at http://localhost:8080/twitch")
+	return account, nil
+}
+
+// loadSourceSettings reads the stored settings for the OBS sources, invalid
+// settings are skipped so the layout defaults are used instead.
+func loadSourceSettings(db *database.Database) (obs.SourceSettings, error) {
+	settings := obs.SourceSettings{}
+	task_metadata, err := db.GetLatestMetadataByKey("task", 1)
+	if err != nil {
+		return settings, fmt.Errorf("Cannot get task metadata: %+v", err)
+	}
+	if len(task_metadata) == 1 {
+		settings.TaskText = task_metadata[0].MetadataValue
+	}
+	task_config := &database.TaskConfig{}
+	found, err := db.GetSetting(task_config)
+	if err != nil {
+		fmt.Println("Ignoring task config: " + err.Error())
+	} else if found {
+		settings.Task = task_config
+	}
+	background_config := &database.BackgroundConfig{}
+	found, err = db.GetSetting(background_config)
+	if err != nil {
+		fmt.Println("Ignoring task background config: " + err.Error())
+	} else if found {
+		settings.Background = background_config
+	}
+	overlay_config := &database.OverlayConfig{}
+	found, err = db.GetSetting(overlay_config)
+	if err != nil {
+		fmt.Println("Ignoring overlay config: " + err.Error())
+	} else if found {
+		settings.Overlay = overlay_config
+	}
+	return settings, nil
+}
+
 func main() {
 	c, err := loadConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
-	obs_password := os.Getenv("OBS_PASSWORD")
-	obsCli, err := goobs.New(c.OBS.Host+":"+c.OBS.Port, goobs.WithPassword(obs_password))
+	layout, err := obs.LoadLayout(c.OBS.Layout)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer obsCli.Disconnect()
-	obs := obs.New(obsCli, "strmr-screen", "strmr-task-text", "strmr-task-background", "strmr-avatar", "strmr-overlay-text", "strmr-overlay-background")
+	obs := obs.New(nil, "strmr-screen", "strmr-task-text", "strmr-task-background", "strmr-avatar", "strmr-overlay-text", "strmr-overlay-background")
 	sqlxConn, err := database.GetDB(c.Database.Name)
 	if err != nil {
 		log.Fatal(err)
 	}
 	db := database.New(sqlxConn)
+	account, err := profileAccount(db, c.Account)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// EventSub and chat run with the tokens of the account of the profile,
+	// without one there are none until an account is configured
+	account_id := int64(0)
+	if account != nil {
+		account_id = account.ID
+	}
 	client_id := os.Getenv("CLIENT_ID")
 	client_secret := os.Getenv("CLIENT_SECRET")
-	twitchCli, err := helix.NewClient(&helix.Options{
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	twitchCli, err := twitch.New(&helix.Options{
 		ClientID:     client_id,
 		ClientSecret: client_secret,
 		RedirectURI:  "http://localhost:8080/twitch/auth",
-	})
+	}, db, c.Twitch, account_id)
 	if err != nil {
 		panic("error making twitch client: " + err.Error())
 	}
+	token_events, stop_token_events := twitchCli.Tokens.Subscribe()
+	defer stop_token_events()
+	go func() {
+		for event := range token_events {
+			if event.Type == twitch.TokenReauthorize {
+				fmt.Println("Twitch needs to be authorized again at http://localhost:8080/twitch: " + event.Error)
+			}
+		}
+	}()
+	if account == nil {
+		fmt.Println("No account configured, twitch events and chat are off until account is set in conf/local.yaml")
+	} else if !twitchCli.Tokens.Status().Authorized {
+		fmt.Println("Twitch is not authorized, authorize it at http://localhost:8080/twitch")
+	}
+	go twitchCli.Run(ctx)
 	brdcstr_client := brdcstr.New(c.Brdcstr.Host, c.Brdcstr.Port)
-	_, err = brdcstr_client.Alive()
+	_, err = brdcstr_client.AliveContext(ctx)
 	if err != nil {
 		panic("error checking brdcstr alive: " + err.Error())
 	}
-	ctx := context.Background()
 	// Used this video to help setup google coud project and get client secrets https://www.youtube.com/watch?v=aFwZgth790Q
 	// run auth.py to generate oauth token and allow youtube API
 	b, err := ioutil.ReadFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
@@ -114,19 +216,76 @@ func main() {
 		panic(err)
 	}
 	client := config.Client(ctx, tok)
-	service, err := youtubeApi.New(client)
+	yt, err := youtube.New(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploads := upload.New(db, yt)
+	go uploads.Run(ctx)
+	thumbnails, err := thumbnail.New(c.Thumbnail)
+	if err != nil {
+		log.Fatal(err)
+	}
+	background_jobs := jobs.New()
+	go background_jobs.Run(ctx)
+	speaker, err := tts.New(c.TTS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	avatar_hub, err := avatar.NewHub(c.Avatar)
+	if err != nil {
+		log.Fatal(err)
+	}
+	obs_events, stop_obs_events := obs.State.Subscribe()
+	defer stop_obs_events()
+	// without EventSub there are no twitch events and stream events come
+	// from OBS and the API only
+	var twitch_events, speech_events <-chan twitch.Event
+	if c.Twitch.EventSub.Enabled {
+		eventsub, err := twitch.NewEventSub(twitchCli, db, c.Twitch.EventSub)
+		if err != nil {
+			log.Fatal(err)
+		}
+		events, stop_twitch_events := eventsub.Subscribe()
+		defer stop_twitch_events()
+		twitch_events = events
+		events, stop_speech_events := eventsub.Subscribe(twitch.EventRedemption, twitch.EventCheer)
+		defer stop_speech_events()
+		speech_events = events
+		go eventsub.Run(ctx)
+	}
+	go avatar_hub.Run(ctx, obs_events, twitch_events)
+	speech_queue, err := speech.New(db, speaker, avatar_hub, c.Speech)
 	if err != nil {
 		log.Fatal(err)
 	}
-	yt := youtube.New(service)
-	twitch := twitch.New(twitchCli)
-	h := handlers.New(twitch, obs, yt, db)
+	go speech_queue.Run(ctx)
+	moderator, err := speech.NewModerator(speech_queue, c.Speech.Moderation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var chat_messages <-chan chat.PrivateMessage
+	if c.Chat.Enabled {
+		chat_client := chat.New(twitchCli, c.Chat)
+		messages, stop_chat_messages := chat_client.Subscribe()
+		defer stop_chat_messages()
+		chat_messages = messages
+		go chat_client.Run(ctx)
+		go chatbot.New(chat_client, db, twitchCli, moderator, c.Account).Run(ctx)
+	}
+	go moderator.Run(ctx, chat_messages, speech_events)
+	h := handlers.New(twitchCli, obs, yt, db, uploads, thumbnails, clip.New(c.Clip), background_jobs, speech_queue, moderator, avatar_hub, c.Account)
+	http.HandleFunc("/accounts", h.AccountsHandler)
 	http.HandleFunc("/twitch", h.TwitchHandler)
 	http.HandleFunc("/twitch/update", h.TwitchUpdateHandler)
 	http.HandleFunc("/twitch/auth", h.TwitchAuthHandler)
+	http.HandleFunc("/twitch/token", h.TwitchTokenHandler)
+	http.HandleFunc("/twitch/events", h.TwitchEventsHandler)
+	http.HandleFunc("/chat/commands", h.ChatCommandsHandler)
 	http.HandleFunc("/twitch/search/categories", h.TwitchSearchCategoriesHandler)
 
 	http.HandleFunc("/obs", h.ObsHandler)
+	http.HandleFunc("/obs/status", h.OBSStatusHandler)
 	http.HandleFunc("/obs/task", h.UpdateOBSTask)
 	http.HandleFunc("/obs/scene/create", h.CreateScene)
 	http.HandleFunc("/obs/stream", h.UpdateOBSStream)
@@ -134,29 +293,58 @@ func main() {
 
 	http.HandleFunc("/youtube", h.YouTubeHandler)
 	http.HandleFunc("/youtube_upload", h.YouTubeUploadHandler)
+	http.HandleFunc("/youtube/uploads", h.YouTubeUploadsHandler)
+	http.HandleFunc("/youtube/template", h.YouTubeTemplateHandler)
+	http.HandleFunc("/youtube/template/preview", h.YouTubeTemplatePreviewHandler)
+	http.HandleFunc("/youtube/thumbnails", h.YouTubeThumbnailsHandler)
+	http.HandleFunc("/youtube/thumbnail", h.YouTubeThumbnailHandler)
+	http.HandleFunc("/youtube/clips", h.YouTubeClipsHandler)
+	http.HandleFunc("/youtube/job", h.YouTubeJobHandler)
 	http.HandleFunc("/youtube_category", h.YouTubeCategoryHandler)
+	http.HandleFunc("/subtitles", h.SubtitlesHandler)
+	http.HandleFunc("/subtitles/style", h.SubtitleStyleHandler)
+	http.HandleFunc("/subtitles/editor", h.SubtitleEditorHandler)
+	http.HandleFunc("/subtitles/cues", h.SubtitleCuesHandler)
+	http.HandleFunc("/subtitles/cues/update", h.SubtitleUpdateHandler)
+	http.HandleFunc("/subtitles/cues/delete", h.SubtitleDeleteHandler)
+	http.HandleFunc("/subtitles/cues/merge", h.SubtitleMergeHandler)
+	http.HandleFunc("/subtitles/cues/split", h.SubtitleSplitHandler)
 
 	http.HandleFunc("/avatar_status", h.AvatarStatus)
 	http.HandleFunc("/avatar", h.Avatar)
+	http.HandleFunc("/avatar/events", h.AvatarEventsHandler)
+	http.HandleFunc("/avatar/expression", h.AvatarExpressionHandler)
+	http.HandleFunc("/avatar/trigger", h.AvatarTriggerHandler)
+	http.HandleFunc("/speech", h.SpeechHandler)
+	http.HandleFunc("/speech/skip", h.SpeechSkipHandler)
+	http.HandleFunc("/speech/clear", h.SpeechClearHandler)
+	http.HandleFunc("/speech/pause", h.SpeechPauseHandler)
+	http.HandleFunc("/speech/log", h.SpeechLogHandler)
+	http.HandleFunc("/speech/pending", h.SpeechPendingHandler)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	background_config_metadata, err := db.GetLatestMetadataByKey("task_background_config", 1)
-	if err != nil || len(background_config_metadata) != 1 {
-		log.Fatalf("Cannot get background config metadata:%+v", err)
-	}
-	task_config_metadata, err := db.GetLatestMetadataByKey("task_config", 1)
-	if err != nil || len(task_config_metadata) != 1 {
-		log.Fatalf("Cannot get task config metadata:%+v", err)
-	}
-	task_metadata, err := db.GetLatestMetadataByKey("task", 1)
-	if err != nil || len(task_metadata) != 1 {
-		log.Fatalf("Cannot get task metadata:%+v", err)
+	obs_password := os.Getenv("OBS_PASSWORD")
+	connectOBS := func() (*goobs.Client, error) {
+		return goobs.New(c.OBS.Host+":"+c.OBS.Port, goobs.WithPassword(obs_password), goobs.WithEventSubscriptions(subscriptions.All|subscriptions.SceneItemTransformChanged))
 	}
-	err = obs.RefreshSources(background_config_metadata[0], task_metadata[0].MetadataValue, task_config_metadata[0])
-	if err != nil {
-		fmt.Println(err.Error())
+	refreshOBS := func() error {
+		settings, err := loadSourceSettings(db)
+		if err != nil {
+			return err
+		}
+		err = obs.RefreshSources(layout, settings)
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+		return obs.SyncState()
 	}
+	go obs.Supervise(ctx, connectOBS, refreshOBS)
 	s := &http.Server{
 		Addr: "0.0.0.0:8080",
+		// request contexts derive from ctx so stopping it aborts uploads
+		// and other in-flight work before Shutdown waits on the handlers
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
 	}
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
@@ -169,13 +357,14 @@ func main() {
 
 	<-done
 	log.Print("Server Stopped")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	stop()
+	shutdown_ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
 		// extra handling here
 		cancel()
 	}()
 
-	if err := s.Shutdown(ctx); err != nil {
+	if err := s.Shutdown(shutdown_ctx); err != nil {
 		log.Fatalf("Server Shutdown Failed:%+v", err)
 	}
 	fmt.Println("Server Exited Properly")
@@ -1,6 +1,7 @@
 package brdcstr
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -29,11 +30,20 @@ func New(url string, port string) *Brdcstr {
 }
 
 func (b *Brdcstr) Alive() (bool, error) {
+	return b.AliveContext(context.Background())
+}
+
+func (b *Brdcstr) AliveContext(ctx context.Context) (bool, error) {
 	url := fmt.Sprintf("%s:%s/-/ping", b.URL, b.Port)
-	resp, err := b.client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, errors.New("cannot create brdcstr alive request: " + err.Error())
+	}
+	resp, err := b.client.Do(req)
 	if err != nil {
 		return false, errors.New("cannot check status on brdcstr: " + err.Error())
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return false, errors.New("non 200 OK status from brdcstr alive check: " + strconv.Itoa(resp.StatusCode))
 	}
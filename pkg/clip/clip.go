@@ -0,0 +1,165 @@
+package clip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jnrprgmr/strmr/pkg/subtitle"
+	"github.com/jnrprgmr/strmr/pkg/youtube"
+)
+
+const (
+	// MinLength is the shortest task worth its own clip in seconds.
+	MinLength = 10
+	// MaxShortLength is the longest video YouTube accepts as a Short in
+	// seconds.
+	MaxShortLength = 180
+	// maxNameLength keeps file names well under file system limits.
+	maxNameLength = 80
+)
+
+type Config struct {
+	// FFmpeg is the ffmpeg executable, found on the PATH when empty.
+	FFmpeg string `yaml:"ffmpeg"`
+}
+
+// Segment is the part of a recording spent on one task, in seconds from
+// the start of the recording.
+type Segment struct {
+	Task  string
+	Start int64
+	End   int64
+}
+
+func (s Segment) Length() int64 {
+	return s.End - s.Start
+}
+
+// Segments splits a recording of length seconds at every task change. A task
+// set again right after itself continues the same segment.
+func Segments(tasks []youtube.Metadata, length int64) []Segment {
+	segments := []Segment{}
+	for i := range tasks {
+		start := tasks[i].Start
+		if start >= length {
+			break
+		}
+		if len(segments) > 0 {
+			last := &segments[len(segments)-1]
+			if last.Task == tasks[i].Text {
+				continue
+			}
+			last.End = start
+		}
+		segments = append(segments, Segment{
+			Task:  tasks[i].Text,
+			Start: start,
+			End:   length,
+		})
+	}
+	return segments
+}
+
+// FileName names the clip of a task, numbered so clips sort in the order they
+// happened and tasks with the same name do not collide.
+func FileName(index int, task string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|`, r), unicode.IsControl(r):
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(task))
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = strings.TrimSpace(string(runes[:maxNameLength]))
+	}
+	if name == "" {
+		name = "task"
+	}
+	return fmt.Sprintf("%02d %s", index+1, name)
+}
+
+// SliceSubtitles keeps the cues shown during segment, moved to start at the
+// start of the segment and cut to fit inside it.
+func SliceSubtitles(cues []subtitle.Cue, segment Segment) []subtitle.Cue {
+	start := time.Duration(segment.Start) * time.Second
+	end := time.Duration(segment.End) * time.Second
+	sliced := []subtitle.Cue{}
+	for i := range cues {
+		c := cues[i]
+		if c.End <= start || c.Start >= end {
+			continue
+		}
+		if c.Start < start {
+			c.Start = start
+		}
+		if c.End > end {
+			c.End = end
+		}
+		c.Start = c.Start - start
+		c.End = c.End - start
+		sliced = append(sliced, c)
+	}
+	return sliced
+}
+
+type Cutter struct {
+	ffmpeg string
+}
+
+func New(c Config) *Cutter {
+	ffmpeg := c.FFmpeg
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	return &Cutter{
+		ffmpeg: ffmpeg,
+	}
+}
+
+// Cut copies segment of file to out without encoding it again. Streams can
+// only be cut on key frames so the clip starts at the key frame before the
+// segment.
+func (c *Cutter) Cut(ctx context.Context, file string, out string, segment Segment) error {
+	return c.run(ctx, out,
+		"-ss", strconv.FormatInt(segment.Start, 10), "-i", file,
+		"-t", strconv.FormatInt(segment.Length(), 10),
+		"-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero",
+		out,
+	)
+}
+
+// CutShort crops the middle of segment of file to a 1080x1920 portrait video
+// for a Short, which has to be encoded again.
+func (c *Cutter) CutShort(ctx context.Context, file string, out string, segment Segment) error {
+	if segment.Length() > MaxShortLength {
+		return errors.New("Segment " + segment.Task + " is longer than " + strconv.Itoa(MaxShortLength) + "s, too long for a Short")
+	}
+	return c.run(ctx, out,
+		"-ss", strconv.FormatInt(segment.Start, 10), "-i", file,
+		"-t", strconv.FormatInt(segment.Length(), 10),
+		"-vf", "crop=ih*9/16:ih,scale=1080:1920",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20",
+		"-c:a", "aac", "-b:a", "160k", "-movflags", "+faststart",
+		out,
+	)
+}
+
+func (c *Cutter) run(ctx context.Context, out string, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	cmd := exec.CommandContext(ctx, c.ffmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return errors.New("Cannot cut " + out + ": " + err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
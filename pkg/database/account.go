@@ -0,0 +1,280 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	UserTwitch  = "twitch"
+	UserYouTube = "youtube"
+)
+
+// Account is a streamer with their own channels. The twitch and youtube
+// identities of an account are users linked to it.
+type Account struct {
+	ID            int64   `db:"id" json:"id"`
+	Name          string  `db:"name" json:"name"`
+	TwitchLogin   string  `db:"twitch_login" json:"twitch_login"`
+	YouTubeHandle string  `db:"youtube_handle" json:"youtube_handle"`
+	Socials       Socials `db:"socials" json:"socials"`
+	InsertTime    int64   `db:"insert_time" json:"insert_time"`
+}
+
+// Social is a link to the account elsewhere, listed in video descriptions.
+type Social struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type Socials []Social
+
+func (s Socials) Value() (driver.Value, error) {
+	if s == nil {
+		s = Socials{}
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (s *Socials) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("cannot scan %T into Socials", src)
+	}
+	return json.Unmarshal(b, s)
+}
+
+func (a Account) Validate() error {
+	if strings.TrimSpace(a.Name) == "" {
+		return errors.New("account needs a name")
+	}
+	if strings.ContainsAny(a.TwitchLogin, " /") {
+		return errors.New("twitch login [" + a.TwitchLogin + "] is not a login")
+	}
+	for _, social := range a.Socials {
+		if social.Name == "" || social.URL == "" {
+			return errors.New("socials need a name and url")
+		}
+	}
+	return nil
+}
+
+const accountCols = `id, name, twitch_login, youtube_handle, socials, insert_time`
+
+func (database *Database) GetAccounts() ([]Account, error) {
+	return database.GetAccountsContext(context.Background())
+}
+
+func (database *Database) GetAccountsContext(ctx context.Context) ([]Account, error) {
+	var a []Account
+	err := database.read(ctx, "GetAccounts", func(tx *sqlx.Tx) error {
+		var err error
+		a, err = database.getAccounts(tx)
+		return err
+	})
+	return a, err
+}
+
+func (database *Database) getAccounts(tx *sqlx.Tx) ([]Account, error) {
+	query := fmt.Sprintf(`SELECT %s FROM account ORDER BY name`, accountCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getAccounts: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx()
+	if err != nil {
+		msg := "cannot query in getAccounts: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	accounts := []Account{}
+	err = scanRows(rows, func() error {
+		var a Account
+		err := rows.StructScan(&a)
+		if err != nil {
+			return errors.New("cannot unmarshal account from getAccounts: " + err.Error())
+		}
+		accounts = append(accounts, a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// GetAccountByName returns the account of name in any case, nil without one.
+func (database *Database) GetAccountByName(name string) (*Account, error) {
+	return database.GetAccountByNameContext(context.Background(), name)
+}
+
+func (database *Database) GetAccountByNameContext(ctx context.Context, name string) (*Account, error) {
+	var a *Account
+	err := database.read(ctx, "GetAccountByName", func(tx *sqlx.Tx) error {
+		var err error
+		a, err = database.getAccountByName(tx, name)
+		return err
+	})
+	return a, err
+}
+
+func (database *Database) getAccountByName(tx *sqlx.Tx, name string) (*Account, error) {
+	query := fmt.Sprintf(`SELECT %s FROM account WHERE name = $1 COLLATE NOCASE`, accountCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getAccountByName: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowx(name)
+	var a Account
+	err = row.StructScan(&a)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, nil
+		default:
+			msg := "cannot unmarshal account from getAccountByName: " + err.Error()
+			return nil, errors.New(msg)
+		}
+	}
+	return &a, nil
+}
+
+// SaveAccount creates the account or updates the one with the same name and
+// returns it as it is stored.
+func (database *Database) SaveAccount(account Account) (*Account, error) {
+	return database.SaveAccountContext(context.Background(), account)
+}
+
+func (database *Database) SaveAccountContext(ctx context.Context, account Account) (*Account, error) {
+	var a *Account
+	err := database.write(ctx, "SaveAccount", func(tx *sqlx.Tx) error {
+		var err error
+		a, err = database.saveAccount(tx, account)
+		return err
+	})
+	return a, err
+}
+
+func (tx *Tx) SaveAccount(account Account) (*Account, error) {
+	return tx.database.saveAccount(tx.tx, account)
+}
+
+func (database *Database) saveAccount(tx *sqlx.Tx, account Account) (*Account, error) {
+	err := account.Validate()
+	if err != nil {
+		return nil, err
+	}
+	query := `INSERT INTO account (name, twitch_login, youtube_handle, socials) VALUES($1, LOWER($2), $3, $4)
+		ON CONFLICT(name) DO UPDATE SET twitch_login = excluded.twitch_login, youtube_handle = excluded.youtube_handle, socials = excluded.socials`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in saveAccount: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(strings.TrimSpace(account.Name), account.TwitchLogin, account.YouTubeHandle, account.Socials)
+	if err != nil {
+		msg := "cannot execute query in saveAccount: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	return database.getAccountByName(tx, strings.TrimSpace(account.Name))
+}
+
+// LinkUser makes the user of user_type and user_id an identity of the
+// account, moving it from the account it was linked to before.
+func (database *Database) LinkUser(account_id int64, user_type string, user_id string) error {
+	return database.LinkUserContext(context.Background(), account_id, user_type, user_id)
+}
+
+func (database *Database) LinkUserContext(ctx context.Context, account_id int64, user_type string, user_id string) error {
+	return database.write(ctx, "LinkUser", func(tx *sqlx.Tx) error {
+		return database.linkUser(tx, account_id, user_type, user_id)
+	})
+}
+
+func (tx *Tx) LinkUser(account_id int64, user_type string, user_id string) error {
+	return tx.database.linkUser(tx.tx, account_id, user_type, user_id)
+}
+
+func (database *Database) linkUser(tx *sqlx.Tx, account_id int64, user_type string, user_id string) error {
+	err := database.insertUserIfMissing(tx, user_type, user_id)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE user SET account_id = $1 WHERE user_type = LOWER($2) AND user_id = LOWER($3)`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in linkUser: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(account_id, user_type, user_id)
+	if err != nil {
+		msg := "cannot execute query in linkUser: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// GetUsersByAccount returns the identities linked to an account.
+func (database *Database) GetUsersByAccount(account_id int64) ([]User, error) {
+	return database.GetUsersByAccountContext(context.Background(), account_id)
+}
+
+func (database *Database) GetUsersByAccountContext(ctx context.Context, account_id int64) ([]User, error) {
+	var u []User
+	err := database.read(ctx, "GetUsersByAccount", func(tx *sqlx.Tx) error {
+		var err error
+		u, err = database.getUsersByAccount(tx, account_id)
+		return err
+	})
+	return u, err
+}
+
+func (database *Database) getUsersByAccount(tx *sqlx.Tx, account_id int64) ([]User, error) {
+	query := fmt.Sprintf(`SELECT %s FROM user WHERE account_id = $1 ORDER BY user_type, id`, userCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getUsersByAccount: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx(account_id)
+	if err != nil {
+		msg := "cannot query in getUsersByAccount: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	users := []User{}
+	err = scanRows(rows, func() error {
+		var u User
+		err := rows.StructScan(&u)
+		if err != nil {
+			return errors.New("cannot unmarshal user from getUsersByAccount: " + err.Error())
+		}
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -9,38 +10,30 @@ import (
 )
 
 type Category struct {
-	ID           int64  `db:"id"`
-	CategoryName string `db:"category_name"`
-	RelatedID    string `db:"related_id"`
-	InsertTime   int64  `db:"insert_time"`
+	ID            int64          `db:"id"`
+	CategoryName  string         `db:"category_name"`
+	RelatedID     string         `db:"related_id"`
+	PublishPolicy *PublishPolicy `db:"publish_policy"`
+	BoxArtURL     *string        `db:"box_art_url"`
+	InsertTime    int64          `db:"insert_time"`
 }
 
 func (database *Database) GetCategoryByID(id int64) (*Category, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetCategoryByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	c, err := database.getCategoryByID(tx, id)
-	if err != nil {
-		msg := "cannot get category in GetCategoryByID: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetCategoryByID: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetCategoryByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return c, nil
+	return database.GetCategoryByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetCategoryByIDContext(ctx context.Context, id int64) (*Category, error) {
+	var c *Category
+	err := database.read(ctx, "GetCategoryByID", func(tx *sqlx.Tx) error {
+		var err error
+		c, err = database.getCategoryByID(tx, id)
+		return err
+	})
+	return c, err
 }
 
 func (database *Database) getCategoryByID(tx *sqlx.Tx, id int64) (*Category, error) {
-	cols := `id, category_name, related_id, insert_time`
+	cols := `id, category_name, related_id, publish_policy, box_art_url, insert_time`
 	query := fmt.Sprintf(`SELECT %s FROM category WHERE id = $1`, cols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
@@ -64,27 +57,17 @@ func (database *Database) getCategoryByID(tx *sqlx.Tx, id int64) (*Category, err
 }
 
 func (database *Database) InsertCategory(category_name string) error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for InsertCategory: " + err.Error()
-		return errors.New(msg)
-	}
-	err = database.insertCategory(tx, category_name)
-	if err != nil {
-		msg := "cannot insert category in InsertCategory: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback from insert in InsertCategory: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
-		return errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in InsertCategory: " + err.Error()
-		return errors.New(msg)
-	}
-	return nil
+	return database.InsertCategoryContext(context.Background(), category_name)
+}
+
+func (database *Database) InsertCategoryContext(ctx context.Context, category_name string) error {
+	return database.write(ctx, "InsertCategory", func(tx *sqlx.Tx) error {
+		return database.insertCategory(tx, category_name)
+	})
+}
+
+func (tx *Tx) InsertCategory(category_name string) error {
+	return tx.database.insertCategory(tx.tx, category_name)
 }
 
 func (database *Database) insertCategory(tx *sqlx.Tx, category_name string) error {
@@ -105,31 +88,25 @@ func (database *Database) insertCategory(tx *sqlx.Tx, category_name string) erro
 }
 
 func (database *Database) GetCategoryByName(category_name string) (*Category, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetCategoryByName: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	c, err := database.getCategoryByName(tx, category_name)
-	if err != nil {
-		msg := "cannot get category in GetCategoryByName: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetCategoryByName: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetCategoryByName: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return c, nil
+	return database.GetCategoryByNameContext(context.Background(), category_name)
+}
+
+func (database *Database) GetCategoryByNameContext(ctx context.Context, category_name string) (*Category, error) {
+	var c *Category
+	err := database.read(ctx, "GetCategoryByName", func(tx *sqlx.Tx) error {
+		var err error
+		c, err = database.getCategoryByName(tx, category_name)
+		return err
+	})
+	return c, err
+}
+
+func (tx *Tx) GetCategoryByName(category_name string) (*Category, error) {
+	return tx.database.getCategoryByName(tx.tx, category_name)
 }
 
 func (database *Database) getCategoryByName(tx *sqlx.Tx, category_name string) (*Category, error) {
-	cols := `id, category_name, related_id, insert_time`
+	cols := `id, category_name, related_id, publish_policy, box_art_url, insert_time`
 	query := fmt.Sprintf(`SELECT %s FROM category WHERE category_name = $1`, cols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
@@ -153,31 +130,21 @@ func (database *Database) getCategoryByName(tx *sqlx.Tx, category_name string) (
 }
 
 func (database *Database) GetAllCategories() ([]Category, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetAllCategories: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	c, err := database.getAllCategories(tx)
-	if err != nil {
-		msg := "cannot get metadata in GetAllCategories: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetAllCategories: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetAllCategories: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return c, nil
+	return database.GetAllCategoriesContext(context.Background())
+}
+
+func (database *Database) GetAllCategoriesContext(ctx context.Context) ([]Category, error) {
+	var c []Category
+	err := database.read(ctx, "GetAllCategories", func(tx *sqlx.Tx) error {
+		var err error
+		c, err = database.getAllCategories(tx)
+		return err
+	})
+	return c, err
 }
 
 func (database *Database) getAllCategories(tx *sqlx.Tx) ([]Category, error) {
-	cols := `id, category_name, related_id, insert_time`
+	cols := `id, category_name, related_id, publish_policy, box_art_url, insert_time`
 	query := fmt.Sprintf(`SELECT %s FROM category`, cols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
@@ -187,62 +154,107 @@ func (database *Database) getAllCategories(tx *sqlx.Tx) ([]Category, error) {
 	defer stmt.Close()
 	rows, err := stmt.Queryx()
 	if err != nil {
-		msg := "cannot query metadata from getAllCategories: " + err.Error()
+		msg := "cannot query categories from getAllCategories: " + err.Error()
 		return nil, errors.New(msg)
 	}
 	categories := []Category{}
-	for rows.Next() {
+	err = scanRows(rows, func() error {
 		var c Category
-		err = rows.StructScan(&c)
+		err := rows.StructScan(&c)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return nil, nil
-			default:
-				msg := "cannot unmarshal metadata from getAllCategories: " + err.Error()
-				return nil, errors.New(msg)
-			}
+			return err
 		}
 		categories = append(categories, c)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal category from getAllCategories: " + err.Error()
+		return nil, errors.New(msg)
 	}
 	return categories, nil
 }
 
 func (database *Database) UpdateCategoryByName(related_id string, category_name string) error {
-	tx, err := database.db.Beginx()
+	return database.UpdateCategoryByNameContext(context.Background(), related_id, category_name)
+}
+
+func (database *Database) UpdateCategoryByNameContext(ctx context.Context, related_id string, category_name string) error {
+	return database.write(ctx, "UpdateCategoryByName", func(tx *sqlx.Tx) error {
+		return database.updateCategoryByName(tx, related_id, category_name)
+	})
+}
+
+func (tx *Tx) UpdateCategoryByName(related_id string, category_name string) error {
+	return tx.database.updateCategoryByName(tx.tx, related_id, category_name)
+}
+
+func (database *Database) updateCategoryByName(tx *sqlx.Tx, related_id string, category_name string) error {
+	query := `UPDATE category SET related_id = $1 WHERE category_name = $2`
+	stmt, err := tx.Preparex(query)
 	if err != nil {
-		msg := "cannot begin transaction for UpdateCategoryByName: " + err.Error()
+		msg := "cannot prepare statement in updateCategoryByName: " + err.Error()
 		return errors.New(msg)
 	}
-	err = database.updateCategoryByName(tx, related_id, category_name)
+	defer stmt.Close()
+	_, err = stmt.Exec(related_id, category_name)
 	if err != nil {
-		msg := "cannot update category in UpdateCategoryByName: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in UpdateCategoryByName: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
+		msg := "cannot execute query in updateCategoryByName: " + err.Error()
 		return errors.New(msg)
 	}
-	err = tx.Commit()
+	return nil
+}
+
+func (database *Database) UpdateCategoryPublishPolicyByName(publish_policy *PublishPolicy, category_name string) error {
+	return database.UpdateCategoryPublishPolicyByNameContext(context.Background(), publish_policy, category_name)
+}
+
+func (database *Database) UpdateCategoryPublishPolicyByNameContext(ctx context.Context, publish_policy *PublishPolicy, category_name string) error {
+	return database.write(ctx, "UpdateCategoryPublishPolicyByName", func(tx *sqlx.Tx) error {
+		return database.updateCategoryPublishPolicyByName(tx, publish_policy, category_name)
+	})
+}
+
+func (tx *Tx) UpdateCategoryPublishPolicyByName(publish_policy *PublishPolicy, category_name string) error {
+	return tx.database.updateCategoryPublishPolicyByName(tx.tx, publish_policy, category_name)
+}
+
+func (database *Database) updateCategoryPublishPolicyByName(tx *sqlx.Tx, publish_policy *PublishPolicy, category_name string) error {
+	query := `UPDATE category SET publish_policy = $1 WHERE category_name = $2`
+	stmt, err := tx.Preparex(query)
 	if err != nil {
-		msg := "cannot commit transaction in UpdateCategoryByName: " + err.Error()
+		msg := "cannot prepare statement in updateCategoryPublishPolicyByName: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(publish_policy, category_name)
+	if err != nil {
+		msg := "cannot execute query in updateCategoryPublishPolicyByName: " + err.Error()
 		return errors.New(msg)
 	}
 	return nil
 }
 
-func (database *Database) updateCategoryByName(tx *sqlx.Tx, related_id string, category_name string) error {
-	query := `UPDATE category SET related_id = $1 WHERE category_name = $2`
+func (database *Database) UpdateCategoryBoxArtByName(box_art_url string, category_name string) error {
+	return database.UpdateCategoryBoxArtByNameContext(context.Background(), box_art_url, category_name)
+}
+
+func (database *Database) UpdateCategoryBoxArtByNameContext(ctx context.Context, box_art_url string, category_name string) error {
+	return database.write(ctx, "UpdateCategoryBoxArtByName", func(tx *sqlx.Tx) error {
+		return database.updateCategoryBoxArtByName(tx, box_art_url, category_name)
+	})
+}
+
+func (database *Database) updateCategoryBoxArtByName(tx *sqlx.Tx, box_art_url string, category_name string) error {
+	query := `UPDATE category SET box_art_url = $1 WHERE category_name = $2`
 	stmt, err := tx.Preparex(query)
 	if err != nil {
-		msg := "cannot prepare statement in updateCategoryByName: " + err.Error()
+		msg := "cannot prepare statement in updateCategoryBoxArtByName: " + err.Error()
 		return errors.New(msg)
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(related_id, category_name)
+	_, err = stmt.Exec(box_art_url, category_name)
 	if err != nil {
-		msg := "cannot execute query in updateCategoryByName: " + err.Error()
+		msg := "cannot execute query in updateCategoryBoxArtByName: " + err.Error()
 		return errors.New(msg)
 	}
 	return nil
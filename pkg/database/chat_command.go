@@ -0,0 +1,339 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Who may use a chat command, each level includes the ones above it.
+const (
+	PermissionBroadcaster = "broadcaster"
+	PermissionModerator   = "moderator"
+	PermissionSubscriber  = "subscriber"
+	PermissionEveryone    = "everyone"
+)
+
+// ChatCommand is a command of the chat bot, used as !name or !alias. A
+// command can be used again after CooldownSeconds, and by the same viewer
+// after UserCooldownSeconds. Commands that are not built in answer with
+// Response.
+type ChatCommand struct {
+	Name                string   `db:"name" json:"name"`
+	Permission          string   `db:"permission" json:"permission"`
+	CooldownSeconds     int64    `db:"cooldown_seconds" json:"cooldown_seconds"`
+	UserCooldownSeconds int64    `db:"user_cooldown_seconds" json:"user_cooldown_seconds"`
+	Response            string   `db:"response" json:"response"`
+	Enabled             bool     `db:"enabled" json:"enabled"`
+	Aliases             []string `db:"-" json:"aliases"`
+	InsertTime          int64    `db:"insert_time" json:"insert_time"`
+}
+
+// commandName is name without the ! chat types before it.
+func commandName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "!"))
+}
+
+func (c ChatCommand) Validate() error {
+	names := append([]string{c.Name}, c.Aliases...)
+	for _, name := range names {
+		name = commandName(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n") {
+			return errors.New("command name [" + name + "] must be one word")
+		}
+	}
+	switch c.Permission {
+	case PermissionBroadcaster, PermissionModerator, PermissionSubscriber, PermissionEveryone:
+	default:
+		return errors.New("permission must be one of broadcaster, moderator, subscriber, everyone")
+	}
+	if c.CooldownSeconds < 0 || c.UserCooldownSeconds < 0 {
+		return errors.New("cooldowns cannot be negative")
+	}
+	return nil
+}
+
+const chatCommandCols = `name, permission, cooldown_seconds, user_cooldown_seconds, response, enabled, insert_time`
+
+func (database *Database) GetChatCommands() ([]ChatCommand, error) {
+	return database.GetChatCommandsContext(context.Background())
+}
+
+func (database *Database) GetChatCommandsContext(ctx context.Context) ([]ChatCommand, error) {
+	var c []ChatCommand
+	err := database.read(ctx, "GetChatCommands", func(tx *sqlx.Tx) error {
+		var err error
+		c, err = database.getChatCommands(tx)
+		return err
+	})
+	return c, err
+}
+
+func (database *Database) getChatCommands(tx *sqlx.Tx) ([]ChatCommand, error) {
+	query := fmt.Sprintf(`SELECT %s FROM chat_command ORDER BY name`, chatCommandCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getChatCommands: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx()
+	if err != nil {
+		msg := "cannot query in getChatCommands: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	commands := []ChatCommand{}
+	err = scanRows(rows, func() error {
+		var c ChatCommand
+		err := rows.StructScan(&c)
+		if err != nil {
+			return errors.New("cannot unmarshal chat command from getChatCommands: " + err.Error())
+		}
+		commands = append(commands, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range commands {
+		commands[i].Aliases, err = database.getChatCommandAliases(tx, commands[i].Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return commands, nil
+}
+
+func (database *Database) getChatCommandAliases(tx *sqlx.Tx, name string) ([]string, error) {
+	query := `SELECT alias FROM chat_command_alias WHERE command = $1 ORDER BY alias`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getChatCommandAliases: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx(name)
+	if err != nil {
+		msg := "cannot query in getChatCommandAliases: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	aliases := []string{}
+	err = scanRows(rows, func() error {
+		var alias string
+		err := rows.Scan(&alias)
+		if err != nil {
+			return errors.New("cannot unmarshal alias from getChatCommandAliases: " + err.Error())
+		}
+		aliases = append(aliases, alias)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// GetChatCommand returns the command named or aliased name in any case, nil
+// without one.
+func (database *Database) GetChatCommand(name string) (*ChatCommand, error) {
+	return database.GetChatCommandContext(context.Background(), name)
+}
+
+func (database *Database) GetChatCommandContext(ctx context.Context, name string) (*ChatCommand, error) {
+	var c *ChatCommand
+	err := database.read(ctx, "GetChatCommand", func(tx *sqlx.Tx) error {
+		var err error
+		c, err = database.getChatCommand(tx, name)
+		return err
+	})
+	return c, err
+}
+
+func (database *Database) getChatCommand(tx *sqlx.Tx, name string) (*ChatCommand, error) {
+	query := fmt.Sprintf(`SELECT %s FROM chat_command WHERE name = $1
+		OR name = (SELECT command FROM chat_command_alias WHERE alias = $1)`, chatCommandCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getChatCommand: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowx(commandName(name))
+	var c ChatCommand
+	err = row.StructScan(&c)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, nil
+		default:
+			msg := "cannot unmarshal chat command from getChatCommand: " + err.Error()
+			return nil, errors.New(msg)
+		}
+	}
+	c.Aliases, err = database.getChatCommandAliases(tx, c.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SaveChatCommand creates the command or updates the one with the same name,
+// its aliases are replaced, and returns it as it is stored. An alias cannot
+// be the name or alias of another command.
+func (database *Database) SaveChatCommand(command ChatCommand) (*ChatCommand, error) {
+	return database.SaveChatCommandContext(context.Background(), command)
+}
+
+func (database *Database) SaveChatCommandContext(ctx context.Context, command ChatCommand) (*ChatCommand, error) {
+	var c *ChatCommand
+	err := database.write(ctx, "SaveChatCommand", func(tx *sqlx.Tx) error {
+		var err error
+		c, err = database.saveChatCommand(tx, command)
+		return err
+	})
+	return c, err
+}
+
+func (tx *Tx) SaveChatCommand(command ChatCommand) (*ChatCommand, error) {
+	return tx.database.saveChatCommand(tx.tx, command)
+}
+
+func (database *Database) saveChatCommand(tx *sqlx.Tx, command ChatCommand) (*ChatCommand, error) {
+	err := command.Validate()
+	if err != nil {
+		return nil, err
+	}
+	name := commandName(command.Name)
+	taken, err := database.chatCommandNameTaken(tx, command)
+	if err != nil {
+		return nil, err
+	}
+	if taken != "" {
+		return nil, errors.New("[" + taken + "] is already used by another command")
+	}
+	query := `INSERT INTO chat_command (name, permission, cooldown_seconds, user_cooldown_seconds, response, enabled) VALUES($1, $2, $3, $4, $5, $6)
+		ON CONFLICT(name) DO UPDATE SET permission = excluded.permission, cooldown_seconds = excluded.cooldown_seconds,
+		user_cooldown_seconds = excluded.user_cooldown_seconds, response = excluded.response, enabled = excluded.enabled`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in saveChatCommand: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(name, command.Permission, command.CooldownSeconds, command.UserCooldownSeconds, command.Response, command.Enabled)
+	if err != nil {
+		msg := "cannot execute query in saveChatCommand: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	err = database.replaceChatCommandAliases(tx, name, command.Aliases)
+	if err != nil {
+		return nil, err
+	}
+	return database.getChatCommand(tx, name)
+}
+
+// ChatCommandNameTaken returns the name or alias of command that is the name
+// or an alias of another command, empty when there is none.
+func (database *Database) ChatCommandNameTaken(command ChatCommand) (string, error) {
+	return database.ChatCommandNameTakenContext(context.Background(), command)
+}
+
+func (database *Database) ChatCommandNameTakenContext(ctx context.Context, command ChatCommand) (string, error) {
+	taken := ""
+	err := database.read(ctx, "ChatCommandNameTaken", func(tx *sqlx.Tx) error {
+		var err error
+		taken, err = database.chatCommandNameTaken(tx, command)
+		return err
+	})
+	return taken, err
+}
+
+func (database *Database) chatCommandNameTaken(tx *sqlx.Tx, command ChatCommand) (string, error) {
+	name := commandName(command.Name)
+	for _, n := range append([]string{name}, command.Aliases...) {
+		other, err := database.getChatCommand(tx, n)
+		if err != nil {
+			return "", err
+		}
+		if other != nil && !strings.EqualFold(other.Name, name) {
+			return commandName(n), nil
+		}
+	}
+	return "", nil
+}
+
+func (database *Database) replaceChatCommandAliases(tx *sqlx.Tx, name string, aliases []string) error {
+	stmt, err := tx.Preparex(`DELETE FROM chat_command_alias WHERE command = $1`)
+	if err != nil {
+		msg := "cannot prepare statement in replaceChatCommandAliases: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(name)
+	if err != nil {
+		msg := "cannot execute query in replaceChatCommandAliases: " + err.Error()
+		return errors.New(msg)
+	}
+	insert, err := tx.Preparex(`INSERT INTO chat_command_alias (alias, command) VALUES($1, $2) ON CONFLICT(alias) DO NOTHING`)
+	if err != nil {
+		msg := "cannot prepare statement in replaceChatCommandAliases: " + err.Error()
+		return errors.New(msg)
+	}
+	defer insert.Close()
+	for _, alias := range aliases {
+		alias = commandName(alias)
+		if alias == name {
+			continue
+		}
+		_, err = insert.Exec(alias, name)
+		if err != nil {
+			msg := "cannot execute query in replaceChatCommandAliases: " + err.Error()
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+// DeleteChatCommand deletes the command named name along with its aliases
+// and reports whether there was one.
+func (database *Database) DeleteChatCommand(name string) (bool, error) {
+	return database.DeleteChatCommandContext(context.Background(), name)
+}
+
+func (database *Database) DeleteChatCommandContext(ctx context.Context, name string) (bool, error) {
+	deleted := false
+	err := database.write(ctx, "DeleteChatCommand", func(tx *sqlx.Tx) error {
+		var err error
+		deleted, err = database.deleteChatCommand(tx, name)
+		return err
+	})
+	return deleted, err
+}
+
+func (tx *Tx) DeleteChatCommand(name string) (bool, error) {
+	return tx.database.deleteChatCommand(tx.tx, name)
+}
+
+func (database *Database) deleteChatCommand(tx *sqlx.Tx, name string) (bool, error) {
+	stmt, err := tx.Preparex(`DELETE FROM chat_command WHERE name = $1`)
+	if err != nil {
+		msg := "cannot prepare statement in deleteChatCommand: " + err.Error()
+		return false, errors.New(msg)
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(commandName(name))
+	if err != nil {
+		msg := "cannot execute query in deleteChatCommand: " + err.Error()
+		return false, errors.New(msg)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		msg := "cannot get affected rows in deleteChatCommand: " + err.Error()
+		return false, errors.New(msg)
+	}
+	return affected == 1, nil
+}
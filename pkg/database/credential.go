@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Credential is a secret of a connected service by name. It is stored as it
+// was sealed, the database never sees the secret itself.
+type Credential struct {
+	Name       string `db:"name"`
+	Nonce      []byte `db:"nonce"`
+	Secret     []byte `db:"secret"`
+	UpdateTime int64  `db:"update_time"`
+}
+
+func (database *Database) GetCredential(name string) (*Credential, error) {
+	return database.GetCredentialContext(context.Background(), name)
+}
+
+func (database *Database) GetCredentialContext(ctx context.Context, name string) (*Credential, error) {
+	var c *Credential
+	err := database.read(ctx, "GetCredential", func(tx *sqlx.Tx) error {
+		var err error
+		c, err = database.getCredential(tx, name)
+		return err
+	})
+	return c, err
+}
+
+func (database *Database) getCredential(tx *sqlx.Tx, name string) (*Credential, error) {
+	cols := `name, nonce, secret, update_time`
+	query := fmt.Sprintf(`SELECT %s FROM credential WHERE name = $1`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getCredential: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowx(name)
+	var c Credential
+	err = row.StructScan(&c)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, nil
+		default:
+			msg := "cannot unmarshal credential from getCredential: " + err.Error()
+			return nil, errors.New(msg)
+		}
+	}
+	return &c, nil
+}
+
+// SaveCredential stores the sealed secret of name, replacing the one before.
+func (database *Database) SaveCredential(name string, nonce []byte, secret []byte) error {
+	return database.SaveCredentialContext(context.Background(), name, nonce, secret)
+}
+
+func (database *Database) SaveCredentialContext(ctx context.Context, name string, nonce []byte, secret []byte) error {
+	return database.write(ctx, "SaveCredential", func(tx *sqlx.Tx) error {
+		return database.saveCredential(tx, name, nonce, secret)
+	})
+}
+
+func (tx *Tx) SaveCredential(name string, nonce []byte, secret []byte) error {
+	return tx.database.saveCredential(tx.tx, name, nonce, secret)
+}
+
+func (database *Database) saveCredential(tx *sqlx.Tx, name string, nonce []byte, secret []byte) error {
+	query := `INSERT INTO credential (name, nonce, secret) VALUES($1, $2, $3)
+		ON CONFLICT(name) DO UPDATE SET nonce = excluded.nonce, secret = excluded.secret, update_time = CAST(strftime('%s', 'now') AS INTEGER)`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in saveCredential: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(name, nonce, secret)
+	if err != nil {
+		msg := "cannot execute query in saveCredential: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// DeleteCredential removes the secret of name, if there is one.
+func (database *Database) DeleteCredential(name string) error {
+	return database.DeleteCredentialContext(context.Background(), name)
+}
+
+func (database *Database) DeleteCredentialContext(ctx context.Context, name string) error {
+	return database.write(ctx, "DeleteCredential", func(tx *sqlx.Tx) error {
+		return database.deleteCredential(tx, name)
+	})
+}
+
+func (tx *Tx) DeleteCredential(name string) error {
+	return tx.database.deleteCredential(tx.tx, name)
+}
+
+func (database *Database) deleteCredential(tx *sqlx.Tx, name string) error {
+	query := `DELETE FROM credential WHERE name = $1`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in deleteCredential: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(name)
+	if err != nil {
+		msg := "cannot execute query in deleteCredential: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
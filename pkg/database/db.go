@@ -2,7 +2,6 @@ package database
 
 import (
 	"errors"
-	"os"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -15,12 +14,10 @@ type Database struct {
 	db *sqlx.DB
 }
 
+// GetDB opens the database, creating it if needed, and migrates it to the
+// latest schema.
 func GetDB(name string) (*sqlx.DB, error) {
 	db_name := "./" + name + ".db"
-	if _, err := os.Stat(db_name); err != nil {
-		msg := "Could not get database file: " + err.Error()
-		return nil, errors.New(msg)
-	}
 	database, err := sqlx.Open("sqlite3", db_name+"?_foreign_keys=on")
 	if err != nil {
 		msg := "Could not open database: " + err.Error()
@@ -31,6 +28,12 @@ func GetDB(name string) (*sqlx.DB, error) {
 		msg := "Could not ping database: " + err.Error()
 		return nil, errors.New(msg)
 	}
+	err = Migrate(database)
+	if err != nil {
+		database.Close()
+		msg := "Could not migrate database: " + err.Error()
+		return nil, errors.New(msg)
+	}
 	return database, nil
 }
 
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MediaClip is the part of a recording spent on one task, cut into its own
+// file. Offsets are seconds from the start of the recording.
+type MediaClip struct {
+	ID               int64  `db:"id"`
+	MediaRecordingID int64  `db:"media_recording_id"`
+	Task             string `db:"task"`
+	FileName         string `db:"file_name"`
+	Extension        string `db:"extension"`
+	Directory        string `db:"directory"`
+	SubtitleFile     string `db:"subtitle_file"`
+	StartOffset      int64  `db:"start_offset"`
+	EndOffset        int64  `db:"end_offset"`
+	Short            bool   `db:"short"`
+	InsertTime       int64  `db:"insert_time"`
+}
+
+const mediaClipCols = `id, media_recording_id, task, file_name, extension, directory, subtitle_file, start_offset, end_offset, short, insert_time`
+
+func (database *Database) GetMediaClipByID(id int64) (*MediaClip, error) {
+	return database.GetMediaClipByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetMediaClipByIDContext(ctx context.Context, id int64) (*MediaClip, error) {
+	var mc *MediaClip
+	err := database.read(ctx, "GetMediaClipByID", func(tx *sqlx.Tx) error {
+		var err error
+		mc, err = database.getMediaClip(tx, "getMediaClipByID", `WHERE id = $1`, id)
+		return err
+	})
+	return mc, err
+}
+
+func (database *Database) getMediaClip(tx *sqlx.Tx, name string, where string, args ...interface{}) (*MediaClip, error) {
+	query := fmt.Sprintf(`SELECT %s FROM media_clip %s`, mediaClipCols, where)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in " + name + ": " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowx(args...)
+	var mc MediaClip
+	err = row.StructScan(&mc)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, nil
+		default:
+			msg := "cannot unmarshal media clip from " + name + ": " + err.Error()
+			return nil, errors.New(msg)
+		}
+	}
+	return &mc, nil
+}
+
+func (database *Database) GetMediaClipsByMediaRecordingID(media_recording_id int64) ([]MediaClip, error) {
+	return database.GetMediaClipsByMediaRecordingIDContext(context.Background(), media_recording_id)
+}
+
+func (database *Database) GetMediaClipsByMediaRecordingIDContext(ctx context.Context, media_recording_id int64) ([]MediaClip, error) {
+	var mc []MediaClip
+	err := database.read(ctx, "GetMediaClipsByMediaRecordingID", func(tx *sqlx.Tx) error {
+		var err error
+		mc, err = database.getMediaClipsByMediaRecordingID(tx, media_recording_id)
+		return err
+	})
+	return mc, err
+}
+
+func (database *Database) getMediaClipsByMediaRecordingID(tx *sqlx.Tx, media_recording_id int64) ([]MediaClip, error) {
+	query := fmt.Sprintf(`SELECT %s FROM media_clip WHERE media_recording_id = $1 ORDER BY start_offset, short`, mediaClipCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getMediaClipsByMediaRecordingID: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx(media_recording_id)
+	if err != nil {
+		msg := "cannot query media clips from getMediaClipsByMediaRecordingID: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	clips := []MediaClip{}
+	err = scanRows(rows, func() error {
+		var mc MediaClip
+		err := rows.StructScan(&mc)
+		if err != nil {
+			return err
+		}
+		clips = append(clips, mc)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal media clip from getMediaClipsByMediaRecordingID: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	return clips, nil
+}
+
+// SaveMediaClip stores a clip and returns its id. Cutting the same part of a
+// recording again replaces the clip that was there, keeping its id so upload
+// jobs still point at it.
+func (database *Database) SaveMediaClip(clip MediaClip) (int64, error) {
+	return database.SaveMediaClipContext(context.Background(), clip)
+}
+
+func (database *Database) SaveMediaClipContext(ctx context.Context, clip MediaClip) (int64, error) {
+	var id int64
+	err := database.write(ctx, "SaveMediaClip", func(tx *sqlx.Tx) error {
+		err := database.saveMediaClip(tx, clip)
+		if err != nil {
+			return err
+		}
+		saved, err := database.getMediaClip(tx, "saveMediaClip", `WHERE media_recording_id = $1 AND start_offset = $2 AND short = $3`, clip.MediaRecordingID, clip.StartOffset, clip.Short)
+		if err != nil {
+			return err
+		}
+		if saved == nil {
+			return errors.New("saved media clip not found")
+		}
+		id = saved.ID
+		return nil
+	})
+	return id, err
+}
+
+func (database *Database) saveMediaClip(tx *sqlx.Tx, clip MediaClip) error {
+	cols := `media_recording_id, task, file_name, extension, directory, subtitle_file, start_offset, end_offset, short`
+	query := fmt.Sprintf(`INSERT INTO media_clip (%s) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (media_recording_id, start_offset, short) DO UPDATE SET
+		task = excluded.task, file_name = excluded.file_name, extension = excluded.extension, directory = excluded.directory,
+		subtitle_file = excluded.subtitle_file, end_offset = excluded.end_offset`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in saveMediaClip: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(clip.MediaRecordingID, clip.Task, clip.FileName, clip.Extension, clip.Directory, clip.SubtitleFile, clip.StartOffset, clip.EndOffset, clip.Short)
+	if err != nil {
+		msg := "cannot execute query in saveMediaClip: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
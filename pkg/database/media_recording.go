@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -20,26 +21,17 @@ type MediaRecording struct {
 }
 
 func (database *Database) GetMediaRecordingByID(id int64) (*MediaRecording, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetMediaRecordingByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	mr, err := database.getMediaRecordingByID(tx, id)
-	if err != nil {
-		msg := "cannot get media recording in GetMediaRecordingByID: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetMediaRecordingByID: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetMediaRecordingByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return mr, nil
+	return database.GetMediaRecordingByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetMediaRecordingByIDContext(ctx context.Context, id int64) (*MediaRecording, error) {
+	var mr *MediaRecording
+	err := database.read(ctx, "GetMediaRecordingByID", func(tx *sqlx.Tx) error {
+		var err error
+		mr, err = database.getMediaRecordingByID(tx, id)
+		return err
+	})
+	return mr, err
 }
 
 func (database *Database) getMediaRecordingByID(tx *sqlx.Tx, id int64) (*MediaRecording, error) {
@@ -67,27 +59,17 @@ func (database *Database) getMediaRecordingByID(tx *sqlx.Tx, id int64) (*MediaRe
 }
 
 func (database *Database) GetLatestMediaRecording() (*MediaRecording, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetLatestMediaRecording: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	mr, err := database.getLatestMediaRecording(tx)
-	if err != nil {
-		msg := "cannot get media recording in GetLatestMediaRecording: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetLatestMediaRecording: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetLatestMediaRecording: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return mr, nil
+	return database.GetLatestMediaRecordingContext(context.Background())
+}
+
+func (database *Database) GetLatestMediaRecordingContext(ctx context.Context) (*MediaRecording, error) {
+	var mr *MediaRecording
+	err := database.read(ctx, "GetLatestMediaRecording", func(tx *sqlx.Tx) error {
+		var err error
+		mr, err = database.getLatestMediaRecording(tx)
+		return err
+	})
+	return mr, err
 }
 
 func (database *Database) getLatestMediaRecording(tx *sqlx.Tx) (*MediaRecording, error) {
@@ -115,27 +97,17 @@ func (database *Database) getLatestMediaRecording(tx *sqlx.Tx) (*MediaRecording,
 }
 
 func (database *Database) EndActiveMediaRecordings() error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for EndActiveMediaRecordings: " + err.Error()
-		return errors.New(msg)
-	}
-	err = database.endActiveMediaRecordings(tx)
-	if err != nil {
-		msg := "cannot end active media recordings in EndActiveMediaRecordings: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in EndActiveMediaRecordings: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
-		return errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in EndActiveMediaRecordings: " + err.Error()
-		return errors.New(msg)
-	}
-	return nil
+	return database.EndActiveMediaRecordingsContext(context.Background())
+}
+
+func (database *Database) EndActiveMediaRecordingsContext(ctx context.Context) error {
+	return database.write(ctx, "EndActiveMediaRecordings", func(tx *sqlx.Tx) error {
+		return database.endActiveMediaRecordings(tx)
+	})
+}
+
+func (tx *Tx) EndActiveMediaRecordings() error {
+	return tx.database.endActiveMediaRecordings(tx.tx)
 }
 
 func (database *Database) endActiveMediaRecordings(tx *sqlx.Tx) error {
@@ -155,27 +127,13 @@ func (database *Database) endActiveMediaRecordings(tx *sqlx.Tx) error {
 }
 
 func (database *Database) SetMediaRecordingUploadedByID(id int64, uploaded bool) error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for SetMediaRecordingUploadedByID: " + err.Error()
-		return errors.New(msg)
-	}
-	err = database.setMediaRecordingUploadedByID(tx, id, uploaded)
-	if err != nil {
-		msg := "cannot end active media recordings in SetMediaRecordingUploadedByID: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in SetMediaRecordingUploadedByID: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
-		return errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in SetMediaRecordingUploadedByID: " + err.Error()
-		return errors.New(msg)
-	}
-	return nil
+	return database.SetMediaRecordingUploadedByIDContext(context.Background(), id, uploaded)
+}
+
+func (database *Database) SetMediaRecordingUploadedByIDContext(ctx context.Context, id int64, uploaded bool) error {
+	return database.write(ctx, "SetMediaRecordingUploadedByID", func(tx *sqlx.Tx) error {
+		return database.setMediaRecordingUploadedByID(tx, id, uploaded)
+	})
 }
 
 func (database *Database) setMediaRecordingUploadedByID(tx *sqlx.Tx, id int64, uploaded bool) error {
@@ -199,27 +157,17 @@ func (database *Database) setMediaRecordingUploadedByID(tx *sqlx.Tx, id int64, u
 }
 
 func (database *Database) InsertMediaRecording(file_name string, directory string) error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for InsertMediaRecording: " + err.Error()
-		return errors.New(msg)
-	}
-	err = database.insertMediaRecording(tx, file_name, directory)
-	if err != nil {
-		msg := "cannot insert media recording in InsertMediaRecording: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback from insert in InsertMediaRecording: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
-		return errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in InsertMediaRecording: " + err.Error()
-		return errors.New(msg)
-	}
-	return nil
+	return database.InsertMediaRecordingContext(context.Background(), file_name, directory)
+}
+
+func (database *Database) InsertMediaRecordingContext(ctx context.Context, file_name string, directory string) error {
+	return database.write(ctx, "InsertMediaRecording", func(tx *sqlx.Tx) error {
+		return database.insertMediaRecording(tx, file_name, directory)
+	})
+}
+
+func (tx *Tx) InsertMediaRecording(file_name string, directory string) error {
+	return tx.database.insertMediaRecording(tx.tx, file_name, directory)
 }
 
 func (database *Database) insertMediaRecording(tx *sqlx.Tx, file_name string, directory string) error {
@@ -240,27 +188,17 @@ func (database *Database) insertMediaRecording(tx *sqlx.Tx, file_name string, di
 }
 
 func (database *Database) GetAllMediaRecordingsByUploaded(uploaded bool) ([]MediaRecording, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetAllMediaRecordingsByUploaded: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	m, err := database.getAllMediaRecordingsByUploaded(tx, uploaded)
-	if err != nil {
-		msg := "cannot get media recordings in GetAllMediaRecordingsByUploaded: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetAllMediaRecordingsByUploaded: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetAllMediaRecordingsByUploaded: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return m, nil
+	return database.GetAllMediaRecordingsByUploadedContext(context.Background(), uploaded)
+}
+
+func (database *Database) GetAllMediaRecordingsByUploadedContext(ctx context.Context, uploaded bool) ([]MediaRecording, error) {
+	var m []MediaRecording
+	err := database.read(ctx, "GetAllMediaRecordingsByUploaded", func(tx *sqlx.Tx) error {
+		var err error
+		m, err = database.getAllMediaRecordingsByUploaded(tx, uploaded)
+		return err
+	})
+	return m, err
 }
 
 func (database *Database) getAllMediaRecordingsByUploaded(tx *sqlx.Tx, uploaded bool) ([]MediaRecording, error) {
@@ -282,19 +220,18 @@ func (database *Database) getAllMediaRecordingsByUploaded(tx *sqlx.Tx, uploaded
 		return nil, errors.New(msg)
 	}
 	media_recordings := []MediaRecording{}
-	for rows.Next() {
+	err = scanRows(rows, func() error {
 		var mr MediaRecording
-		err = rows.StructScan(&mr)
+		err := rows.StructScan(&mr)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return nil, nil
-			default:
-				msg := "cannot unmarshal media recording from getAllMediaRecordingsByUploaded: " + err.Error()
-				return nil, errors.New(msg)
-			}
+			return err
 		}
 		media_recordings = append(media_recordings, mr)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal media recording from getAllMediaRecordingsByUploaded: " + err.Error()
+		return nil, errors.New(msg)
 	}
 	return media_recordings, nil
 }
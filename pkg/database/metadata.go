@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -16,27 +17,17 @@ type Metadata struct {
 }
 
 func (database *Database) GetMetadataByID(id int64) (*Metadata, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetMetadataByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	sm, err := database.getMetadataByID(tx, id)
-	if err != nil {
-		msg := "cannot get metadata in GetMetadataByID: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetMetadataByID: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetMetadataByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return sm, nil
+	return database.GetMetadataByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetMetadataByIDContext(ctx context.Context, id int64) (*Metadata, error) {
+	var m *Metadata
+	err := database.read(ctx, "GetMetadataByID", func(tx *sqlx.Tx) error {
+		var err error
+		m, err = database.getMetadataByID(tx, id)
+		return err
+	})
+	return m, err
 }
 
 func (database *Database) getMetadataByID(tx *sqlx.Tx, id int64) (*Metadata, error) {
@@ -64,27 +55,30 @@ func (database *Database) getMetadataByID(tx *sqlx.Tx, id int64) (*Metadata, err
 }
 
 func (database *Database) InsertMetadata(metadata_key string, metadata_value string) error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for InsertMetadata: " + err.Error()
-		return errors.New(msg)
-	}
-	err = database.insertMetadata(tx, metadata_key, metadata_value)
+	return database.InsertMetadataContext(context.Background(), metadata_key, metadata_value)
+}
+
+func (database *Database) InsertMetadataContext(ctx context.Context, metadata_key string, metadata_value string) error {
+	return database.write(ctx, "InsertMetadata", func(tx *sqlx.Tx) error {
+		return database.insertMetadata(tx, metadata_key, metadata_value)
+	})
+}
+
+func (tx *Tx) InsertMetadata(metadata_key string, metadata_value string) error {
+	return tx.database.insertMetadata(tx.tx, metadata_key, metadata_value)
+}
+
+// InsertMetadataIfChanged only inserts the value when it differs from the
+// latest value stored for the key.
+func (tx *Tx) InsertMetadataIfChanged(metadata_key string, metadata_value string) error {
+	m, err := tx.database.getLatestMetadataByKey(tx.tx, metadata_key, 1)
 	if err != nil {
-		msg := "cannot insert metadata in InsertMetadata: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback from insert in InsertMetadata: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
-		return errors.New(msg)
+		return err
 	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in InsertMetadata: " + err.Error()
-		return errors.New(msg)
+	if len(m) == 1 && m[0].MetadataValue == metadata_value {
+		return nil
 	}
-	return nil
+	return tx.database.insertMetadata(tx.tx, metadata_key, metadata_value)
 }
 
 func (database *Database) insertMetadata(tx *sqlx.Tx, metadata_key string, metadata_value string) error {
@@ -104,33 +98,58 @@ func (database *Database) insertMetadata(tx *sqlx.Tx, metadata_key string, metad
 	return nil
 }
 
-func (database *Database) GetLatestMetadataByKey(metadata_key string, limit int) ([]Metadata, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetLatestMetadataByKey: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	m, err := database.getLatestMetadataByKey(tx, metadata_key, limit)
+// DeleteMetadataByKey deletes every value stored for a key.
+func (database *Database) DeleteMetadataByKey(metadata_key string) error {
+	return database.DeleteMetadataByKeyContext(context.Background(), metadata_key)
+}
+
+func (database *Database) DeleteMetadataByKeyContext(ctx context.Context, metadata_key string) error {
+	return database.write(ctx, "DeleteMetadataByKey", func(tx *sqlx.Tx) error {
+		return database.deleteMetadataByKey(tx, metadata_key)
+	})
+}
+
+func (tx *Tx) DeleteMetadataByKey(metadata_key string) error {
+	return tx.database.deleteMetadataByKey(tx.tx, metadata_key)
+}
+
+func (database *Database) deleteMetadataByKey(tx *sqlx.Tx, metadata_key string) error {
+	query := `DELETE FROM metadata WHERE metadata_key = $1`
+	stmt, err := tx.Preparex(query)
 	if err != nil {
-		msg := "cannot get metadata in GetLatestMetadataByKey: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetLatestMetadataByKey: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
+		msg := "cannot prepare statement in deleteMetadataByKey: " + err.Error()
+		return errors.New(msg)
 	}
-	err = tx.Commit()
+	defer stmt.Close()
+	_, err = stmt.Exec(metadata_key)
 	if err != nil {
-		msg := "cannot commit transaction in GetLatestMetadataByKey: " + err.Error()
-		return nil, errors.New(msg)
+		msg := "cannot execute query in deleteMetadataByKey: " + err.Error()
+		return errors.New(msg)
 	}
-	return m, nil
+	return nil
+}
+
+func (database *Database) GetLatestMetadataByKey(metadata_key string, limit int) ([]Metadata, error) {
+	return database.GetLatestMetadataByKeyContext(context.Background(), metadata_key, limit)
+}
+
+func (database *Database) GetLatestMetadataByKeyContext(ctx context.Context, metadata_key string, limit int) ([]Metadata, error) {
+	var m []Metadata
+	err := database.read(ctx, "GetLatestMetadataByKey", func(tx *sqlx.Tx) error {
+		var err error
+		m, err = database.getLatestMetadataByKey(tx, metadata_key, limit)
+		return err
+	})
+	return m, err
+}
+
+func (tx *Tx) GetLatestMetadataByKey(metadata_key string, limit int) ([]Metadata, error) {
+	return tx.database.getLatestMetadataByKey(tx.tx, metadata_key, limit)
 }
 
 func (database *Database) getLatestMetadataByKey(tx *sqlx.Tx, metadata_key string, limit int) ([]Metadata, error) {
 	cols := `id, metadata_key, metadata_value, insert_time`
-	query := fmt.Sprintf(`SELECT %s FROM metadata WHERE metadata_key = $1 ORDER BY insert_time DESC LIMIT $2`, cols)
+	query := fmt.Sprintf(`SELECT %s FROM metadata WHERE metadata_key = $1 ORDER BY insert_time DESC, id DESC LIMIT $2`, cols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
 		msg := "cannot prepare statement in getLatestMetadataByKey: " + err.Error()
@@ -143,50 +162,39 @@ func (database *Database) getLatestMetadataByKey(tx *sqlx.Tx, metadata_key strin
 		return nil, errors.New(msg)
 	}
 	metadatas := []Metadata{}
-	for rows.Next() {
+	err = scanRows(rows, func() error {
 		var m Metadata
-		err = rows.StructScan(&m)
+		err := rows.StructScan(&m)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return nil, nil
-			default:
-				msg := "cannot unmarshal metadata from getLatestMetadataByKey: " + err.Error()
-				return nil, errors.New(msg)
-			}
+			return err
 		}
 		metadatas = append(metadatas, m)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal metadata from getLatestMetadataByKey: " + err.Error()
+		return nil, errors.New(msg)
 	}
 	return metadatas, nil
 }
 
 func (database *Database) GetLatestMetadataByKeyBeforeTime(metadata_key string, timestamp int64, limit int) ([]Metadata, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetLatestMetadataByKeyBeforeTime: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	m, err := database.getLatestMetadataByKeyBeforeTime(tx, metadata_key, timestamp, limit)
-	if err != nil {
-		msg := "cannot get metadata in GetLatestMetadataByKeyBeforeTime: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetLatestMetadataByKeyBeforeTime: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetLatestMetadataByKeyBeforeTime: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return m, nil
+	return database.GetLatestMetadataByKeyBeforeTimeContext(context.Background(), metadata_key, timestamp, limit)
+}
+
+func (database *Database) GetLatestMetadataByKeyBeforeTimeContext(ctx context.Context, metadata_key string, timestamp int64, limit int) ([]Metadata, error) {
+	var m []Metadata
+	err := database.read(ctx, "GetLatestMetadataByKeyBeforeTime", func(tx *sqlx.Tx) error {
+		var err error
+		m, err = database.getLatestMetadataByKeyBeforeTime(tx, metadata_key, timestamp, limit)
+		return err
+	})
+	return m, err
 }
 
 func (database *Database) getLatestMetadataByKeyBeforeTime(tx *sqlx.Tx, metadata_key string, timestamp int64, limit int) ([]Metadata, error) {
 	cols := `id, metadata_key, metadata_value, insert_time`
-	query := fmt.Sprintf(`SELECT %s FROM metadata WHERE metadata_key = $1 AND insert_time <= $2 ORDER BY insert_time DESC LIMIT $3`, cols)
+	query := fmt.Sprintf(`SELECT %s FROM metadata WHERE metadata_key = $1 AND insert_time <= $2 ORDER BY insert_time DESC, id DESC LIMIT $3`, cols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
 		msg := "cannot prepare statement in getLatestMetadataByKeyBeforeTime: " + err.Error()
@@ -199,45 +207,34 @@ func (database *Database) getLatestMetadataByKeyBeforeTime(tx *sqlx.Tx, metadata
 		return nil, errors.New(msg)
 	}
 	metadatas := []Metadata{}
-	for rows.Next() {
+	err = scanRows(rows, func() error {
 		var m Metadata
-		err = rows.StructScan(&m)
+		err := rows.StructScan(&m)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return nil, nil
-			default:
-				msg := "cannot unmarshal metadata from getLatestMetadataByKeyBeforeTime: " + err.Error()
-				return nil, errors.New(msg)
-			}
+			return err
 		}
 		metadatas = append(metadatas, m)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal metadata from getLatestMetadataByKeyBeforeTime: " + err.Error()
+		return nil, errors.New(msg)
 	}
 	return metadatas, nil
 }
 
 func (database *Database) GetDistinctMetadataValuesByKey(metadata_key string) ([]Metadata, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetDistinctMetadataValuesByKey: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	m, err := database.getDistinctMetadataValuesByKey(tx, metadata_key)
-	if err != nil {
-		msg := "cannot get metadata in GetDistinctMetadataValuesByKey: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetDistinctMetadataValuesByKey: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetDistinctMetadataValuesByKey: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return m, nil
+	return database.GetDistinctMetadataValuesByKeyContext(context.Background(), metadata_key)
+}
+
+func (database *Database) GetDistinctMetadataValuesByKeyContext(ctx context.Context, metadata_key string) ([]Metadata, error) {
+	var m []Metadata
+	err := database.read(ctx, "GetDistinctMetadataValuesByKey", func(tx *sqlx.Tx) error {
+		var err error
+		m, err = database.getDistinctMetadataValuesByKey(tx, metadata_key)
+		return err
+	})
+	return m, err
 }
 
 func (database *Database) getDistinctMetadataValuesByKey(tx *sqlx.Tx, metadata_key string) ([]Metadata, error) {
@@ -255,54 +252,43 @@ func (database *Database) getDistinctMetadataValuesByKey(tx *sqlx.Tx, metadata_k
 		return nil, errors.New(msg)
 	}
 	metadatas := []Metadata{}
-	for rows.Next() {
+	err = scanRows(rows, func() error {
 		var m Metadata
-		err = rows.StructScan(&m)
+		err := rows.StructScan(&m)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return nil, nil
-			default:
-				msg := "cannot unmarshal metadata from getDistinctMetadataValuesByKey: " + err.Error()
-				return nil, errors.New(msg)
-			}
+			return err
 		}
 		metadatas = append(metadatas, m)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal metadata from getDistinctMetadataValuesByKey: " + err.Error()
+		return nil, errors.New(msg)
 	}
 	return metadatas, nil
 }
 
 func (database *Database) GetMetadataByKeyAndTimeRange(key string, start int64, end int64) ([]Metadata, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetMetadataByKeyAndTimeRange: " + err.Error()
-		return nil, errors.New(msg)
-	}
+	return database.GetMetadataByKeyAndTimeRangeContext(context.Background(), key, start, end)
+}
+
+func (database *Database) GetMetadataByKeyAndTimeRangeContext(ctx context.Context, key string, start int64, end int64) ([]Metadata, error) {
 	if start >= end {
-		msg := "cannot get metadatabecause start >= end for GetMetadataByKeyAndTimeRange: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	m, err := database.getMetadataByKeyAndTimeRange(tx, key, start, end)
-	if err != nil {
-		msg := "cannot get metadata in GetMetadataByKeyAndTimeRange: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetMetadataByKeyAndTimeRange: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
+		msg := "cannot get metadata because start >= end for GetMetadataByKeyAndTimeRange"
 		return nil, errors.New(msg)
 	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetMetadataByKeyAndTimeRange: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return m, nil
+	var m []Metadata
+	err := database.read(ctx, "GetMetadataByKeyAndTimeRange", func(tx *sqlx.Tx) error {
+		var err error
+		m, err = database.getMetadataByKeyAndTimeRange(tx, key, start, end)
+		return err
+	})
+	return m, err
 }
 
 func (database *Database) getMetadataByKeyAndTimeRange(tx *sqlx.Tx, metadata_key string, start int64, end int64) ([]Metadata, error) {
 	cols := `id, metadata_key, metadata_value, insert_time`
-	query := fmt.Sprintf(`SELECT %s FROM metadata WHERE metadata_key = $1 AND insert_time >= $2 AND insert_time <= $3 ORDER BY insert_time ASC`, cols)
+	query := fmt.Sprintf(`SELECT %s FROM metadata WHERE metadata_key = $1 AND insert_time >= $2 AND insert_time <= $3 ORDER BY insert_time ASC, id ASC`, cols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
 		msg := "cannot prepare statement in getMetadataByKeyAndTimeRange: " + err.Error()
@@ -315,19 +301,18 @@ func (database *Database) getMetadataByKeyAndTimeRange(tx *sqlx.Tx, metadata_key
 		return nil, errors.New(msg)
 	}
 	metadatas := []Metadata{}
-	for rows.Next() {
+	err = scanRows(rows, func() error {
 		var m Metadata
-		err = rows.StructScan(&m)
+		err := rows.StructScan(&m)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return nil, nil
-			default:
-				msg := "cannot unmarshal metadata from getMetadataByKeyAndTimeRange: " + err.Error()
-				return nil, errors.New(msg)
-			}
+			return err
 		}
 		metadatas = append(metadatas, m)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal metadata from getMetadataByKeyAndTimeRange: " + err.Error()
+		return nil, errors.New(msg)
 	}
 	return metadatas, nil
 }
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.up.sql
+var migrationFiles embed.FS
+
+// Migration is a numbered up migration, files are named
+// <version>_<name>.up.sql and applied in version order.
+type Migration struct {
+	Version int64
+	Name    string
+	Query   string
+}
+
+func Migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.up.sql")
+	if err != nil {
+		return nil, errors.New("cannot list migrations: " + err.Error())
+	}
+	migrations := []Migration{}
+	seen := map[int64]string{}
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".up.sql")
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("migration [" + file + "] is not named <version>_<name>.up.sql")
+		}
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, errors.New("migration [" + file + "] does not start with a positive version")
+		}
+		if other, ok := seen[version]; ok {
+			return nil, errors.New("migrations [" + other + "] and [" + file + "] share a version")
+		}
+		seen[version] = file
+		b, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, errors.New("cannot read migration [" + file + "]: " + err.Error())
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    parts[1],
+			Query:   string(b),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrate applies every pending migration, each in its own transaction
+// together with its schema_migration row. It refuses to touch a database
+// migrated by a newer strmr.
+func Migrate(db *sqlx.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migration (
+    version      INTEGER NOT NULL CHECK(TYPEOF(version) = 'integer')      PRIMARY KEY,
+    name         TEXT NOT NULL CHECK(TYPEOF(name) = 'text'),
+    insert_time  INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')  DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
+)`)
+	if err != nil {
+		return errors.New("cannot create schema_migration table: " + err.Error())
+	}
+	err = baselineExistingSchema(db)
+	if err != nil {
+		return err
+	}
+	var current int64
+	err = db.Get(&current, `SELECT COALESCE(MAX(version), 0) FROM schema_migration`)
+	if err != nil {
+		return errors.New("cannot get schema version: " + err.Error())
+	}
+	latest := int64(0)
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest known migration %d, refusing to run", current, latest)
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		err = applyMigration(db, m)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied migration %d %s\n", m.Version, m.Name)
+	}
+	return nil
+}
+
+// baselineExistingSchema records the initial migration as applied for
+// databases created by the old sql/schema.sql, so their data is kept.
+func baselineExistingSchema(db *sqlx.DB) error {
+	var applied int
+	err := db.Get(&applied, `SELECT COUNT(*) FROM schema_migration`)
+	if err != nil {
+		return errors.New("cannot count applied migrations: " + err.Error())
+	}
+	if applied > 0 {
+		return nil
+	}
+	var tables int
+	err = db.Get(&tables, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'stream'`)
+	if err != nil {
+		return errors.New("cannot check for an existing schema: " + err.Error())
+	}
+	if tables == 0 {
+		return nil
+	}
+	_, err = db.Exec(`INSERT INTO schema_migration (version, name) VALUES($1, $2)`, 1, "initial")
+	if err != nil {
+		return errors.New("cannot baseline existing schema: " + err.Error())
+	}
+	fmt.Println("Found a schema without migrations, recorded it as migration 1")
+	return nil
+}
+
+func applyMigration(db *sqlx.DB, m Migration) error {
+	name := strconv.FormatInt(m.Version, 10) + "_" + m.Name
+	tx, err := db.Beginx()
+	if err != nil {
+		msg := "cannot begin transaction for migration [" + name + "]: " + err.Error()
+		return errors.New(msg)
+	}
+	_, err = tx.Exec(m.Query)
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO schema_migration (version, name) VALUES($1, $2)`, m.Version, m.Name)
+	}
+	if err != nil {
+		msg := "cannot apply migration [" + name + "]: " + err.Error()
+		roll_err := tx.Rollback()
+		if roll_err != nil {
+			fatal := "cannot rollback migration: " + msg + ": " + roll_err.Error()
+			return errors.New(fatal)
+		}
+		return errors.New(msg)
+	}
+	err = tx.Commit()
+	if err != nil {
+		msg := "cannot commit migration [" + name + "]: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
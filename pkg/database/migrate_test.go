@@ -0,0 +1,135 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "strmr.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func appliedVersions(t *testing.T, db *sqlx.DB) []int64 {
+	t.Helper()
+	versions := []int64{}
+	err := db.Select(&versions, `SELECT version FROM schema_migration ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return versions
+}
+
+func migrationVersions(t *testing.T) []int64 {
+	t.Helper()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []int64{}
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+func TestMigrate(t *testing.T) {
+	db := openTestDB(t)
+	err := Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := migrationVersions(t)
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, want) {
+		t.Fatalf("applied %v, want %v", got, want)
+	}
+	var accounts int
+	err = db.Get(&accounts, `SELECT COUNT(*) FROM account`)
+	if err != nil || accounts != 0 {
+		t.Errorf("a new database has %d accounts: %v", accounts, err)
+	}
+
+	// running again changes nothing
+	err = Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("applied %v after running again, want %v", got, want)
+	}
+}
+
+func TestMigrateExistingSchema(t *testing.T) {
+	db := openTestDB(t)
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a database made by the old sql/schema.sql, with data in it
+	_, err = db.Exec(migrations[0].Query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO user (user_id, user_type) VALUES('1234', 'twitch')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO stream (start_time) VALUES(1000)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := appliedVersions(t, db), migrationVersions(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("applied %v, want %v", got, want)
+	}
+	var name string
+	err = db.Get(&name, `SELECT name FROM schema_migration WHERE version = 1`)
+	if err != nil || name != "initial" {
+		t.Errorf("migration 1 recorded as %q: %v", name, err)
+	}
+	var start int64
+	err = db.Get(&start, `SELECT start_time FROM stream`)
+	if err != nil || start != 1000 {
+		t.Errorf("stream not kept, got start %d: %v", start, err)
+	}
+	var account string
+	err = db.Get(&account, `SELECT account.name FROM user JOIN account ON account.id = user.account_id WHERE user.user_id = '1234'`)
+	if err != nil || account != "jnrprgmr" {
+		t.Errorf("existing user has account %q: %v", account, err)
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	db := openTestDB(t)
+	err := Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := migrationVersions(t)
+	newer := versions[len(versions)-1] + 1
+	_, err = db.Exec(`INSERT INTO schema_migration (version, name) VALUES($1, 'from_the_future')`, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Migrate(db)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("migrating a newer schema returned %v, want it refused", err)
+	}
+	if got, want := appliedVersions(t, db), append(versions, newer); !reflect.DeepEqual(got, want) {
+		t.Errorf("applied %v after refusing, want %v", got, want)
+	}
+}
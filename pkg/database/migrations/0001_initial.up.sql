@@ -1,4 +1,4 @@
-PRAGMA foreign_key = ON;
+-- strmr schema as of the first release, before migrations were tracked
 
 CREATE TABLE user (
     id           INTEGER NOT NULL CHECK(TYPEOF(id) = 'integer')           PRIMARY KEY AUTOINCREMENT,
@@ -48,4 +48,4 @@ CREATE TABLE subtitles (
     subtitle     TEXT NOT NULL CHECK(TYPEOF(subtitle) = 'text'),
     duration     REAL NOT NULL CHECK(TYPEOF(duration) = 'real'),
     insert_time  INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')  DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
-);
\ No newline at end of file
+);
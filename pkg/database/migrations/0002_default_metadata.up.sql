@@ -0,0 +1,13 @@
+-- defaults the task overlay needs, only added when the key has never been set
+
+INSERT INTO metadata (metadata_key, metadata_value)
+    SELECT 'task_background_config', '4271296285,1600,50,0,0'
+    WHERE NOT EXISTS (SELECT 1 FROM metadata WHERE metadata_key = 'task_background_config');
+
+INSERT INTO metadata (metadata_key, metadata_value)
+    SELECT 'task_config', '4291297280,1600,50,0,0'
+    WHERE NOT EXISTS (SELECT 1 FROM metadata WHERE metadata_key = 'task_config');
+
+INSERT INTO metadata (metadata_key, metadata_value)
+    SELECT 'task', 'Create Task'
+    WHERE NOT EXISTS (SELECT 1 FROM metadata WHERE metadata_key = 'task');
@@ -0,0 +1,43 @@
+-- typed settings stored as JSON, every row is kept as history for its key
+
+CREATE TABLE setting (
+    id             INTEGER NOT NULL CHECK(TYPEOF(id) = 'integer')                                  PRIMARY KEY AUTOINCREMENT,
+    setting_key    TEXT NOT NULL CHECK(TYPEOF(setting_key) = 'text'),
+    setting_value  TEXT NOT NULL CHECK(TYPEOF(setting_value) = 'text' AND json_valid(setting_value)),
+    insert_time    INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                         DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE INDEX setting_key_insert_time ON setting (setting_key, insert_time);
+
+-- convert the "color,width,height,posx,posy" metadata rows, rows that do not
+-- have exactly five values are left behind in metadata
+WITH RECURSIVE split(id, metadata_key, insert_time, idx, part, rest) AS (
+    SELECT id, metadata_key, insert_time, 0, '', metadata_value || ','
+        FROM metadata
+        WHERE metadata_key IN ('task_config', 'task_background_config')
+    UNION ALL
+    SELECT id, metadata_key, insert_time, idx + 1,
+        TRIM(substr(rest, 1, instr(rest, ',') - 1)),
+        substr(rest, instr(rest, ',') + 1)
+        FROM split
+        WHERE rest <> ''
+)
+INSERT INTO setting (setting_key, setting_value, insert_time)
+    SELECT metadata_key,
+        json_object(
+            'color', CAST(MAX(CASE idx WHEN 1 THEN part END) AS INTEGER),
+            'width', CAST(MAX(CASE idx WHEN 2 THEN part END) AS REAL),
+            'height', CAST(MAX(CASE idx WHEN 3 THEN part END) AS REAL),
+            'pos_x', CAST(MAX(CASE idx WHEN 4 THEN part END) AS REAL),
+            'pos_y', CAST(MAX(CASE idx WHEN 5 THEN part END) AS REAL)
+        ),
+        insert_time
+        FROM split
+        WHERE idx > 0
+        GROUP BY id
+        HAVING COUNT(*) = 5
+        ORDER BY id;
+
+DELETE FROM metadata
+    WHERE metadata_key IN ('task_config', 'task_background_config')
+    AND (length(metadata_value) - length(replace(metadata_value, ',', ''))) = 4;
@@ -0,0 +1,27 @@
+-- youtube uploads run in the background, a job keeps everything needed to
+-- resume its upload session after a restart
+
+CREATE TABLE upload_job (
+    id                  INTEGER NOT NULL CHECK(TYPEOF(id) = 'integer')                                    PRIMARY KEY AUTOINCREMENT,
+    media_recording_id  INTEGER NOT NULL CHECK(TYPEOF(media_recording_id) = 'integer')                    REFERENCES media_recording (id),
+    file                TEXT NOT NULL CHECK(TYPEOF(file) = 'text'),
+    subtitle_file       TEXT NOT NULL CHECK(TYPEOF(subtitle_file) = 'text'),
+    title               TEXT NOT NULL CHECK(TYPEOF(title) = 'text'),
+    description         TEXT NOT NULL CHECK(TYPEOF(description) = 'text'),
+    tags                TEXT NOT NULL CHECK(TYPEOF(tags) = 'text' AND json_valid(tags)),
+    category_id         TEXT NOT NULL CHECK(TYPEOF(category_id) = 'text'),
+    playlist_id         TEXT NOT NULL CHECK(TYPEOF(playlist_id) = 'text'),
+    recording_time      TEXT NOT NULL CHECK(TYPEOF(recording_time) = 'text'),
+    state               TEXT NOT NULL CHECK(state IN ('queued', 'uploading', 'finishing', 'done', 'failed'))   DEFAULT('queued'),
+    session_url         TEXT NULL CHECK(session_url IS NULL OR TYPEOF(session_url) = 'text'),
+    video_id            TEXT NULL CHECK(video_id IS NULL OR TYPEOF(video_id) = 'text'),
+    bytes_sent          INTEGER NOT NULL CHECK(TYPEOF(bytes_sent) = 'integer')                            DEFAULT(0),
+    bytes_total         INTEGER NOT NULL CHECK(TYPEOF(bytes_total) = 'integer')                           DEFAULT(0),
+    attempts            INTEGER NOT NULL CHECK(TYPEOF(attempts) = 'integer')                              DEFAULT(0),
+    last_error          TEXT NULL CHECK(last_error IS NULL OR TYPEOF(last_error) = 'text'),
+    next_attempt_time   INTEGER NOT NULL CHECK(TYPEOF(next_attempt_time) = 'integer')                     DEFAULT(0),
+    update_time         INTEGER NOT NULL CHECK(TYPEOF(update_time) = 'integer')                           DEFAULT(CAST(strftime('%s', 'now') AS INTEGER)),
+    insert_time         INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                           DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE INDEX upload_job_state_next_attempt_time ON upload_job (state, next_attempt_time);
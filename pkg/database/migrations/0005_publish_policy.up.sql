@@ -0,0 +1,5 @@
+-- publishing defaults per category and the resolved policy of each upload
+
+ALTER TABLE category ADD COLUMN publish_policy TEXT NULL CHECK(publish_policy IS NULL OR (TYPEOF(publish_policy) = 'text' AND json_valid(publish_policy)));
+
+ALTER TABLE upload_job ADD COLUMN publish_policy TEXT NOT NULL CHECK(TYPEOF(publish_policy) = 'text' AND json_valid(publish_policy)) DEFAULT('{}');
@@ -0,0 +1,5 @@
+-- twitch box art cached per category and the chosen thumbnail of each upload
+
+ALTER TABLE category ADD COLUMN box_art_url TEXT NULL CHECK(box_art_url IS NULL OR TYPEOF(box_art_url) = 'text');
+
+ALTER TABLE upload_job ADD COLUMN thumbnail_file TEXT NOT NULL CHECK(TYPEOF(thumbnail_file) = 'text') DEFAULT('');
@@ -0,0 +1,18 @@
+-- clips cut from a recording per task, they can be uploaded on their own
+
+CREATE TABLE media_clip (
+    id                  INTEGER NOT NULL CHECK(TYPEOF(id) = 'integer')                                   PRIMARY KEY AUTOINCREMENT,
+    media_recording_id  INTEGER NOT NULL CHECK(TYPEOF(media_recording_id) = 'integer')                   REFERENCES media_recording (id),
+    task                TEXT NOT NULL CHECK(TYPEOF(task) = 'text'),
+    file_name           TEXT NOT NULL CHECK(TYPEOF(file_name) = 'text'),
+    extension           TEXT NOT NULL CHECK(TYPEOF(extension) = 'text' AND extension IN ('mkv', 'mp4')),
+    directory           TEXT NOT NULL CHECK(TYPEOF(directory) = 'text'),
+    subtitle_file       TEXT NOT NULL CHECK(TYPEOF(subtitle_file) = 'text'),
+    start_offset        INTEGER NOT NULL CHECK(TYPEOF(start_offset) = 'integer'),
+    end_offset          INTEGER NOT NULL CHECK(TYPEOF(end_offset) = 'integer' AND end_offset > start_offset),
+    short               INTEGER NOT NULL CHECK(TYPEOF(short) = 'integer' AND short IN (0, 1))           DEFAULT(0),
+    insert_time         INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                          DEFAULT(CAST(strftime('%s', 'now') AS INTEGER)),
+    UNIQUE(media_recording_id, start_offset, short)
+);
+
+ALTER TABLE upload_job ADD COLUMN media_clip_id INTEGER NULL CHECK(media_clip_id IS NULL OR TYPEOF(media_clip_id) = 'integer') REFERENCES media_clip (id);
@@ -0,0 +1,10 @@
+-- subtitles keep millisecond start and end times, rows from before are
+-- filled in from the second they were saved and their duration
+
+ALTER TABLE subtitles ADD COLUMN start_millis INTEGER NOT NULL CHECK(TYPEOF(start_millis) = 'integer') DEFAULT(0);
+
+ALTER TABLE subtitles ADD COLUMN end_millis INTEGER NOT NULL CHECK(TYPEOF(end_millis) = 'integer') DEFAULT(0);
+
+UPDATE subtitles SET end_millis = insert_time * 1000, start_millis = insert_time * 1000 - CAST(ROUND(duration * 1000) AS INTEGER);
+
+CREATE INDEX subtitles_end_millis ON subtitles (end_millis);
@@ -0,0 +1,17 @@
+-- every message the avatar took from the speech queue and what became of it
+
+CREATE TABLE speech_log (
+    id            INTEGER NOT NULL CHECK(TYPEOF(id) = 'integer')                                               PRIMARY KEY AUTOINCREMENT,
+    source        TEXT NOT NULL CHECK(TYPEOF(source) = 'text'),
+    voice         TEXT NOT NULL CHECK(TYPEOF(voice) = 'text'),
+    text          TEXT NOT NULL CHECK(TYPEOF(text) = 'text'),
+    priority      INTEGER NOT NULL CHECK(TYPEOF(priority) = 'integer'),
+    state         TEXT NOT NULL CHECK(TYPEOF(state) = 'text' AND state IN ('spoken', 'skipped', 'failed')),
+    error         TEXT NULL CHECK(error IS NULL OR TYPEOF(error) = 'text'),
+    queue_millis  INTEGER NOT NULL CHECK(TYPEOF(queue_millis) = 'integer'),
+    start_millis  INTEGER NOT NULL CHECK(TYPEOF(start_millis) = 'integer'),
+    end_millis    INTEGER NOT NULL CHECK(TYPEOF(end_millis) = 'integer'),
+    insert_time   INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                                      DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE INDEX speech_log_start_millis ON speech_log (start_millis);
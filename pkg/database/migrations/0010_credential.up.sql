@@ -0,0 +1,8 @@
+-- secrets of connected services, sealed with a key kept outside the database
+
+CREATE TABLE credential (
+    name          TEXT NOT NULL CHECK(TYPEOF(name) = 'text')                                                   PRIMARY KEY,
+    nonce         BLOB NOT NULL CHECK(TYPEOF(nonce) = 'blob'),
+    secret        BLOB NOT NULL CHECK(TYPEOF(secret) = 'blob'),
+    update_time   INTEGER NOT NULL CHECK(TYPEOF(update_time) = 'integer')                                      DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
+);
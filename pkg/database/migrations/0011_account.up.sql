@@ -0,0 +1,32 @@
+-- accounts group the twitch and youtube identities of one streamer. A
+-- database that already has users was the channel strmr was written for,
+-- which becomes their account, new databases start without accounts
+
+CREATE TABLE account (
+    id              INTEGER NOT NULL CHECK(TYPEOF(id) = 'integer')                                           PRIMARY KEY AUTOINCREMENT,
+    name            TEXT NOT NULL CHECK(TYPEOF(name) = 'text' AND name <> ''),
+    twitch_login    TEXT NOT NULL CHECK(TYPEOF(twitch_login) = 'text')                                       DEFAULT(''),
+    youtube_handle  TEXT NOT NULL CHECK(TYPEOF(youtube_handle) = 'text')                                     DEFAULT(''),
+    socials         TEXT NOT NULL CHECK(TYPEOF(socials) = 'text' AND json_valid(socials))                    DEFAULT('[]'),
+    insert_time     INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                                  DEFAULT(CAST(strftime('%s', 'now') AS INTEGER)),
+    UNIQUE(name COLLATE NOCASE)
+);
+
+ALTER TABLE user ADD COLUMN account_id INTEGER NULL CHECK(account_id IS NULL OR TYPEOF(account_id) = 'integer') REFERENCES account(id) ON DELETE SET NULL;
+
+INSERT INTO account (name, twitch_login, youtube_handle, socials) SELECT 'jnrprgmr', 'jnrprgmr', '@jnrprgmr', json('[
+    {"name": "YouTube", "url": "https://youtube.com/@jnrprgmr"},
+    {"name": "Twitch", "url": "https://twitch.tv/jnrprgmr"},
+    {"name": "Github", "url": "https://github.com/jnrprgmr"},
+    {"name": "Discord", "url": "https://discord.gg/mHWp8AchaX"},
+    {"name": "Reddit", "url": "https://reddit.com/user/jnrprgmr"},
+    {"name": "Subreddit", "url": "https://www.reddit.com/r/jnrprgmr"},
+    {"name": "Twitter", "url": "https://twitter.com/jnrprgmr"},
+    {"name": "Tumblr", "url": "https://jnrprgmr.tumblr.com"},
+    {"name": "Trello", "url": "https://trello.com/w/jnr_prgmr"},
+    {"name": "Steam", "url": "https://steamcommunity.com/id/jnrprgmr"}
+]')
+WHERE EXISTS (SELECT 1 FROM user);
+
+-- only the twitch user of jnrprgmr was ever stored
+UPDATE user SET account_id = (SELECT id FROM account WHERE name = 'jnrprgmr');
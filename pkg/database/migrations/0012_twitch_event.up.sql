@@ -0,0 +1,15 @@
+-- notifications from twitch EventSub, twitch can send a message more than
+-- once so they are kept once per message id
+
+CREATE TABLE twitch_event (
+    id                   INTEGER NOT NULL CHECK(TYPEOF(id) = 'integer')                                    PRIMARY KEY AUTOINCREMENT,
+    message_id           TEXT NOT NULL CHECK(TYPEOF(message_id) = 'text'),
+    event_type           TEXT NOT NULL CHECK(TYPEOF(event_type) = 'text'),
+    broadcaster_user_id  TEXT NOT NULL CHECK(TYPEOF(broadcaster_user_id) = 'text'),
+    event                TEXT NOT NULL CHECK(TYPEOF(event) = 'text' AND json_valid(event)),
+    event_millis         INTEGER NOT NULL CHECK(TYPEOF(event_millis) = 'integer'),
+    insert_time          INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                           DEFAULT(CAST(strftime('%s', 'now') AS INTEGER)),
+    UNIQUE(message_id)
+);
+
+CREATE INDEX twitch_event_type_millis ON twitch_event (event_type, event_millis);
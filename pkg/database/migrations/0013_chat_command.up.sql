@@ -0,0 +1,35 @@
+-- commands of the chat bot, the built in ones answer from strmr and any
+-- other answers with its response. Aliases are other names of a command.
+
+CREATE TABLE chat_command (
+    name                   TEXT NOT NULL COLLATE NOCASE CHECK(TYPEOF(name) = 'text' AND name <> '')                                                   PRIMARY KEY,
+    permission             TEXT NOT NULL CHECK(TYPEOF(permission) = 'text' AND permission IN ('broadcaster', 'moderator', 'subscriber', 'everyone'))  DEFAULT('everyone'),
+    cooldown_seconds       INTEGER NOT NULL CHECK(TYPEOF(cooldown_seconds) = 'integer' AND cooldown_seconds >= 0)                                     DEFAULT(0),
+    user_cooldown_seconds  INTEGER NOT NULL CHECK(TYPEOF(user_cooldown_seconds) = 'integer' AND user_cooldown_seconds >= 0)                           DEFAULT(0),
+    response               TEXT NOT NULL CHECK(TYPEOF(response) = 'text')                                                                             DEFAULT(''),
+    enabled                INTEGER NOT NULL CHECK(TYPEOF(enabled) = 'integer' AND enabled IN (0, 1))                                                  DEFAULT(1),
+    insert_time            INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                                                                    DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE TABLE chat_command_alias (
+    alias        TEXT NOT NULL COLLATE NOCASE CHECK(TYPEOF(alias) = 'text' AND alias <> '')  PRIMARY KEY,
+    command      TEXT NOT NULL COLLATE NOCASE CHECK(TYPEOF(command) = 'text')                REFERENCES chat_command(name) ON DELETE CASCADE ON UPDATE CASCADE,
+    insert_time  INTEGER NOT NULL CHECK(TYPEOF(insert_time) = 'integer')                     DEFAULT(CAST(strftime('%s', 'now') AS INTEGER))
+);
+
+CREATE INDEX chat_command_alias_command ON chat_command_alias (command);
+
+INSERT INTO chat_command (name, permission, cooldown_seconds, user_cooldown_seconds) VALUES
+    ('task', 'everyone', 10, 30),
+    ('title', 'everyone', 10, 30),
+    ('uptime', 'everyone', 10, 30),
+    ('socials', 'everyone', 30, 60),
+    ('say', 'subscriber', 0, 30);
+
+-- what chat asks the most
+INSERT INTO chat_command_alias (alias, command) VALUES
+    ('wat', 'task'),
+    ('project', 'task'),
+    ('working', 'task'),
+    ('links', 'socials'),
+    ('tts', 'say');
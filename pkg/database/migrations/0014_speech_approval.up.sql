@@ -0,0 +1,9 @@
+-- moderators approve or reject the text of viewers waiting to be spoken
+
+INSERT INTO chat_command (name, permission, cooldown_seconds, user_cooldown_seconds) VALUES
+    ('approve', 'moderator', 0, 0),
+    ('reject', 'moderator', 0, 0);
+
+INSERT INTO chat_command_alias (alias, command) VALUES
+    ('ok', 'approve'),
+    ('deny', 'reject');
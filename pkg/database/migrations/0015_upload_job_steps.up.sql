@@ -0,0 +1,8 @@
+-- steps finished after the video is uploaded are not repeated on a retry, and
+-- a video uploaded after its publish time waits private to be scheduled again
+
+ALTER TABLE upload_job ADD COLUMN caption_done INTEGER NOT NULL CHECK(TYPEOF(caption_done) = 'integer' AND caption_done IN (0, 1)) DEFAULT(0);
+
+ALTER TABLE upload_job ADD COLUMN playlist_done INTEGER NOT NULL CHECK(TYPEOF(playlist_done) = 'integer' AND playlist_done IN (0, 1)) DEFAULT(0);
+
+ALTER TABLE upload_job ADD COLUMN reschedule INTEGER NOT NULL CHECK(TYPEOF(reschedule) = 'integer' AND reschedule IN (0, 1)) DEFAULT(0);
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	PrivacyPrivate  = "private"
+	PrivacyUnlisted = "unlisted"
+	PrivacyPublic   = "public"
+
+	LicenseYouTube        = "youtube"
+	LicenseCreativeCommon = "creativeCommon"
+)
+
+// PublishPolicy controls how an uploaded video is published. Empty fields
+// are unset so policies can be layered: the defaults, then the category's
+// policy, then the upload request. PublishAfter schedules the video relative
+// to when the upload is queued and is turned into PublishAt by Schedule.
+type PublishPolicy struct {
+	Privacy           string `json:"privacy,omitempty"`
+	PublishAt         string `json:"publish_at,omitempty"`
+	PublishAfter      string `json:"publish_after,omitempty"`
+	Language          string `json:"language,omitempty"`
+	License           string `json:"license,omitempty"`
+	Embeddable        *bool  `json:"embeddable,omitempty"`
+	MadeForKids       *bool  `json:"made_for_kids,omitempty"`
+	NotifySubscribers *bool  `json:"notify_subscribers,omitempty"`
+}
+
+func DefaultPublishPolicy() PublishPolicy {
+	embeddable := true
+	made_for_kids := false
+	notify := true
+	return PublishPolicy{
+		Privacy:           PrivacyPrivate,
+		Language:          "en",
+		License:           LicenseYouTube,
+		Embeddable:        &embeddable,
+		MadeForKids:       &made_for_kids,
+		NotifySubscribers: &notify,
+	}
+}
+
+// Merge returns p with every field set in o replacing its own.
+func (p PublishPolicy) Merge(o PublishPolicy) PublishPolicy {
+	if o.Privacy != "" {
+		p.Privacy = o.Privacy
+		if o.Privacy != PrivacyPrivate {
+			// publishing now overrides a schedule from an earlier layer
+			p.PublishAt = ""
+			p.PublishAfter = ""
+		}
+	}
+	if o.PublishAt != "" || o.PublishAfter != "" {
+		// an absolute and a relative schedule from different layers would conflict
+		p.PublishAt = o.PublishAt
+		p.PublishAfter = o.PublishAfter
+	}
+	if o.Language != "" {
+		p.Language = o.Language
+	}
+	if o.License != "" {
+		p.License = o.License
+	}
+	if o.Embeddable != nil {
+		p.Embeddable = o.Embeddable
+	}
+	if o.MadeForKids != nil {
+		p.MadeForKids = o.MadeForKids
+	}
+	if o.NotifySubscribers != nil {
+		p.NotifySubscribers = o.NotifySubscribers
+	}
+	return p
+}
+
+// Schedule turns PublishAfter into PublishAt counting from now and checks
+// the publish time is in the future.
+func (p PublishPolicy) Schedule(now time.Time) (PublishPolicy, error) {
+	if p.PublishAfter != "" {
+		d, err := time.ParseDuration(p.PublishAfter)
+		if err != nil {
+			return p, errors.New("publish_after [" + p.PublishAfter + "] is not a duration: " + err.Error())
+		}
+		p.PublishAt = now.Add(d).UTC().Format(time.RFC3339)
+		p.PublishAfter = ""
+	}
+	if p.PublishAt != "" {
+		at, err := time.Parse(time.RFC3339, p.PublishAt)
+		if err != nil {
+			return p, errors.New("publish_at [" + p.PublishAt + "] is not an RFC3339 time: " + err.Error())
+		}
+		if !at.After(now) {
+			return p, errors.New("publish_at [" + p.PublishAt + "] is not in the future")
+		}
+	}
+	return p, nil
+}
+
+func (p PublishPolicy) Validate() error {
+	switch p.Privacy {
+	case "", PrivacyPrivate, PrivacyUnlisted, PrivacyPublic:
+	default:
+		return errors.New("privacy [" + p.Privacy + "] must be private, unlisted or public")
+	}
+	switch p.License {
+	case "", LicenseYouTube, LicenseCreativeCommon:
+	default:
+		return errors.New("license [" + p.License + "] must be youtube or creativeCommon")
+	}
+	if p.PublishAt != "" && p.PublishAfter != "" {
+		return errors.New("only one of publish_at and publish_after can be set")
+	}
+	if p.PublishAt != "" {
+		_, err := time.Parse(time.RFC3339, p.PublishAt)
+		if err != nil {
+			return errors.New("publish_at [" + p.PublishAt + "] is not an RFC3339 time: " + err.Error())
+		}
+	}
+	if p.PublishAfter != "" {
+		d, err := time.ParseDuration(p.PublishAfter)
+		if err != nil {
+			return errors.New("publish_after [" + p.PublishAfter + "] is not a duration: " + err.Error())
+		}
+		if d <= 0 {
+			return errors.New("publish_after [" + p.PublishAfter + "] must be positive")
+		}
+	}
+	// YouTube only schedules private videos, they become public at publish_at
+	if (p.PublishAt != "" || p.PublishAfter != "") && p.Privacy != "" && p.Privacy != PrivacyPrivate {
+		return errors.New("scheduled videos must be private until they are published")
+	}
+	return nil
+}
+
+func (p PublishPolicy) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (p *PublishPolicy) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("cannot scan %T into PublishPolicy", src)
+	}
+	return json.Unmarshal(b, p)
+}
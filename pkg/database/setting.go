@@ -0,0 +1,285 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnrprgmr/strmr/pkg/subtitle"
+)
+
+const (
+	SettingTaskConfig       string = "task_config"
+	SettingBackgroundConfig string = "task_background_config"
+	SettingOverlayConfig    string = "overlay_config"
+	SettingUploadTemplate   string = "upload_template"
+	SettingSubtitleStyle    string = "subtitle_style"
+)
+
+// Setting is a typed value stored as JSON under a fixed key, Validate is run
+// before every save and after every load.
+type Setting interface {
+	SettingKey() string
+	Validate() error
+}
+
+type SettingValue struct {
+	ID           int64  `db:"id"`
+	SettingKey   string `db:"setting_key"`
+	SettingValue string `db:"setting_value"`
+	InsertTime   int64  `db:"insert_time"`
+}
+
+// SourceConfig places a colored source in the scene, colors are the ABGR
+// integers used by OBS.
+type SourceConfig struct {
+	Color  int64   `json:"color"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	PosX   float64 `json:"pos_x"`
+	PosY   float64 `json:"pos_y"`
+}
+
+func (c SourceConfig) Validate() error {
+	err := validateColor("color", c.Color)
+	if err != nil {
+		return err
+	}
+	if c.Width <= 0 || c.Height <= 0 {
+		return errors.New("width and height must be greater than 0")
+	}
+	return nil
+}
+
+type TaskConfig struct {
+	SourceConfig
+}
+
+func (c *TaskConfig) SettingKey() string {
+	return SettingTaskConfig
+}
+
+type BackgroundConfig struct {
+	SourceConfig
+}
+
+func (c *BackgroundConfig) SettingKey() string {
+	return SettingBackgroundConfig
+}
+
+type OverlayConfig struct {
+	Text            string  `json:"text"`
+	TextColor       int64   `json:"text_color"`
+	BackgroundColor int64   `json:"background_color"`
+	Width           float64 `json:"width"`
+	Height          float64 `json:"height"`
+	PosX            float64 `json:"pos_x"`
+	PosY            float64 `json:"pos_y"`
+	Enabled         bool    `json:"enabled"`
+}
+
+func (c *OverlayConfig) SettingKey() string {
+	return SettingOverlayConfig
+}
+
+func (c *OverlayConfig) Validate() error {
+	err := validateColor("text_color", c.TextColor)
+	if err != nil {
+		return err
+	}
+	err = validateColor("background_color", c.BackgroundColor)
+	if err != nil {
+		return err
+	}
+	if c.Width <= 0 || c.Height <= 0 {
+		return errors.New("width and height must be greater than 0")
+	}
+	return nil
+}
+
+// UploadTemplate holds the text/template sources used for the title and
+// description of YouTube uploads, they are parsed by pkg/youtube. Every
+// account has its own template, the one without an account is used by
+// accounts that have not saved one.
+type UploadTemplate struct {
+	AccountID   int64  `json:"-"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+func (t *UploadTemplate) SettingKey() string {
+	if t.AccountID == 0 {
+		return SettingUploadTemplate
+	}
+	return SettingUploadTemplate + ":" + strconv.FormatInt(t.AccountID, 10)
+}
+
+func (t *UploadTemplate) Validate() error {
+	if strings.TrimSpace(t.Title) == "" {
+		return errors.New("title template cannot be empty")
+	}
+	if strings.TrimSpace(t.Description) == "" {
+		return errors.New("description template cannot be empty")
+	}
+	return nil
+}
+
+// SubtitleStyle is how subtitles exported as ASS look.
+type SubtitleStyle struct {
+	subtitle.Style
+}
+
+func (s *SubtitleStyle) SettingKey() string {
+	return SettingSubtitleStyle
+}
+
+func validateColor(name string, color int64) error {
+	if color < 0 || color > 0xFFFFFFFF {
+		return errors.New(name + " [" + strconv.FormatInt(color, 10) + "] is not a 32 bit color")
+	}
+	return nil
+}
+
+// DecodeSetting strictly decodes a stored value, unknown fields are an error
+// so a renamed field cannot silently reset to its zero value.
+func DecodeSetting(value string, setting Setting) error {
+	d := json.NewDecoder(bytes.NewBufferString(value))
+	d.DisallowUnknownFields()
+	err := d.Decode(setting)
+	if err != nil {
+		return errors.New("cannot decode setting [" + setting.SettingKey() + "]: " + err.Error())
+	}
+	err = setting.Validate()
+	if err != nil {
+		return errors.New("invalid setting [" + setting.SettingKey() + "]: " + err.Error())
+	}
+	return nil
+}
+
+// GetSetting loads the latest value for the setting's key into it and reports
+// whether one was found.
+func (database *Database) GetSetting(setting Setting) (bool, error) {
+	return database.GetSettingContext(context.Background(), setting)
+}
+
+func (database *Database) GetSettingContext(ctx context.Context, setting Setting) (bool, error) {
+	var values []SettingValue
+	err := database.read(ctx, "GetSetting", func(tx *sqlx.Tx) error {
+		var err error
+		values, err = database.getSettingHistory(tx, setting.SettingKey(), 1)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(values) == 0 {
+		return false, nil
+	}
+	err = DecodeSetting(values[0].SettingValue, setting)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (database *Database) SaveSetting(setting Setting) error {
+	return database.SaveSettingContext(context.Background(), setting)
+}
+
+func (database *Database) SaveSettingContext(ctx context.Context, setting Setting) error {
+	value, err := encodeSetting(setting)
+	if err != nil {
+		return err
+	}
+	return database.write(ctx, "SaveSetting", func(tx *sqlx.Tx) error {
+		return database.insertSetting(tx, setting.SettingKey(), value)
+	})
+}
+
+func (tx *Tx) SaveSetting(setting Setting) error {
+	value, err := encodeSetting(setting)
+	if err != nil {
+		return err
+	}
+	return tx.database.insertSetting(tx.tx, setting.SettingKey(), value)
+}
+
+func encodeSetting(setting Setting) (string, error) {
+	err := setting.Validate()
+	if err != nil {
+		return "", errors.New("invalid setting [" + setting.SettingKey() + "]: " + err.Error())
+	}
+	b, err := json.Marshal(setting)
+	if err != nil {
+		return "", errors.New("cannot encode setting [" + setting.SettingKey() + "]: " + err.Error())
+	}
+	return string(b), nil
+}
+
+func (database *Database) insertSetting(tx *sqlx.Tx, setting_key string, setting_value string) error {
+	cols := `setting_key, setting_value`
+	query := fmt.Sprintf(`INSERT INTO setting (%s) VALUES($1, $2)`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in insertSetting: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(setting_key, setting_value)
+	if err != nil {
+		msg := "cannot execute query in insertSetting: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// GetSettingHistory returns the latest values stored for a key, newest first.
+func (database *Database) GetSettingHistory(setting_key string, limit int) ([]SettingValue, error) {
+	return database.GetSettingHistoryContext(context.Background(), setting_key, limit)
+}
+
+func (database *Database) GetSettingHistoryContext(ctx context.Context, setting_key string, limit int) ([]SettingValue, error) {
+	var s []SettingValue
+	err := database.read(ctx, "GetSettingHistory", func(tx *sqlx.Tx) error {
+		var err error
+		s, err = database.getSettingHistory(tx, setting_key, limit)
+		return err
+	})
+	return s, err
+}
+
+func (database *Database) getSettingHistory(tx *sqlx.Tx, setting_key string, limit int) ([]SettingValue, error) {
+	cols := `id, setting_key, setting_value, insert_time`
+	query := fmt.Sprintf(`SELECT %s FROM setting WHERE setting_key = $1 ORDER BY insert_time DESC, id DESC LIMIT $2`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getSettingHistory: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx(setting_key, limit)
+	if err != nil {
+		msg := "cannot query settings from getSettingHistory: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	settings := []SettingValue{}
+	err = scanRows(rows, func() error {
+		var s SettingValue
+		err := rows.StructScan(&s)
+		if err != nil {
+			return err
+		}
+		settings = append(settings, s)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal setting from getSettingHistory: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	return settings, nil
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	SpeechSpoken  = "spoken"
+	SpeechSkipped = "skipped"
+	SpeechFailed  = "failed"
+)
+
+// SpeechLog is a message taken from the speech queue. Times are unix times
+// in milliseconds, start and end are when it was heard and are equal when it
+// failed before it could be played.
+type SpeechLog struct {
+	ID          int64   `db:"id" json:"id"`
+	Source      string  `db:"source" json:"source"`
+	Voice       string  `db:"voice" json:"voice"`
+	Text        string  `db:"text" json:"text"`
+	Priority    int64   `db:"priority" json:"priority"`
+	State       string  `db:"state" json:"state"`
+	Error       *string `db:"error" json:"error"`
+	QueueMillis int64   `db:"queue_millis" json:"queue_millis"`
+	StartMillis int64   `db:"start_millis" json:"start_millis"`
+	EndMillis   int64   `db:"end_millis" json:"end_millis"`
+	InsertTime  int64   `db:"insert_time" json:"insert_time"`
+}
+
+const speechLogCols = `id, source, voice, text, priority, state, error, queue_millis, start_millis, end_millis, insert_time`
+
+func (database *Database) InsertSpeechLog(log SpeechLog) error {
+	return database.InsertSpeechLogContext(context.Background(), log)
+}
+
+func (database *Database) InsertSpeechLogContext(ctx context.Context, log SpeechLog) error {
+	return database.write(ctx, "InsertSpeechLog", func(tx *sqlx.Tx) error {
+		return database.insertSpeechLog(tx, log)
+	})
+}
+
+func (database *Database) insertSpeechLog(tx *sqlx.Tx, log SpeechLog) error {
+	cols := `source, voice, text, priority, state, error, queue_millis, start_millis, end_millis`
+	query := fmt.Sprintf(`INSERT INTO speech_log (%s) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in insertSpeechLog: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(log.Source, log.Voice, log.Text, log.Priority, log.State, log.Error, log.QueueMillis, log.StartMillis, log.EndMillis)
+	if err != nil {
+		msg := "cannot execute query in insertSpeechLog: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// GetLatestSpeechLogs returns the last messages taken from the speech queue,
+// newest first.
+func (database *Database) GetLatestSpeechLogs(limit int64) ([]SpeechLog, error) {
+	return database.GetLatestSpeechLogsContext(context.Background(), limit)
+}
+
+func (database *Database) GetLatestSpeechLogsContext(ctx context.Context, limit int64) ([]SpeechLog, error) {
+	var l []SpeechLog
+	err := database.read(ctx, "GetLatestSpeechLogs", func(tx *sqlx.Tx) error {
+		var err error
+		l, err = database.getLatestSpeechLogs(tx, limit)
+		return err
+	})
+	return l, err
+}
+
+func (database *Database) getLatestSpeechLogs(tx *sqlx.Tx, limit int64) ([]SpeechLog, error) {
+	query := fmt.Sprintf(`SELECT %s FROM speech_log ORDER BY id DESC LIMIT $1`, speechLogCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getLatestSpeechLogs: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx(limit)
+	if err != nil {
+		msg := "cannot query speech logs from getLatestSpeechLogs: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	logs := []SpeechLog{}
+	err = scanRows(rows, func() error {
+		var l SpeechLog
+		err := rows.StructScan(&l)
+		if err != nil {
+			return err
+		}
+		logs = append(logs, l)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal speech log from getLatestSpeechLogs: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	return logs, nil
+}
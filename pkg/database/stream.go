@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -9,33 +10,24 @@ import (
 )
 
 type Stream struct {
-	ID         int64 `db:"id"`
-	StartTime  int64 `db:"start_time"`
-	EndTime    int64 `db:"end_time"`
-	InsertTime int64 `db:"insert_time"`
+	ID         int64  `db:"id"`
+	StartTime  int64  `db:"start_time"`
+	EndTime    *int64 `db:"end_time"`
+	InsertTime int64  `db:"insert_time"`
 }
 
 func (database *Database) GetStreamByID(id int64) (*Stream, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetStreamByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	s, err := database.getStreamByID(tx, id)
-	if err != nil {
-		msg := "cannot get task in GetStreamByID: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetStreamByID: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetStreamByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return s, nil
+	return database.GetStreamByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetStreamByIDContext(ctx context.Context, id int64) (*Stream, error) {
+	var s *Stream
+	err := database.read(ctx, "GetStreamByID", func(tx *sqlx.Tx) error {
+		var err error
+		s, err = database.getStreamByID(tx, id)
+		return err
+	})
+	return s, err
 }
 
 func (database *Database) getStreamByID(tx *sqlx.Tx, id int64) (*Stream, error) {
@@ -55,7 +47,7 @@ func (database *Database) getStreamByID(tx *sqlx.Tx, id int64) (*Stream, error)
 		case sql.ErrNoRows:
 			return nil, nil
 		default:
-			msg := "cannot unmarshal user from getStreamByID: " + err.Error()
+			msg := "cannot unmarshal stream from getStreamByID: " + err.Error()
 			return nil, errors.New(msg)
 		}
 	}
@@ -63,27 +55,17 @@ func (database *Database) getStreamByID(tx *sqlx.Tx, id int64) (*Stream, error)
 }
 
 func (database *Database) GetLatestStream() (*Stream, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetLatestStream: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	s, err := database.getLatestStream(tx)
-	if err != nil {
-		msg := "cannot get task in GetLatestStream: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetLatestStream: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetLatestStream: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return s, nil
+	return database.GetLatestStreamContext(context.Background())
+}
+
+func (database *Database) GetLatestStreamContext(ctx context.Context) (*Stream, error) {
+	var s *Stream
+	err := database.read(ctx, "GetLatestStream", func(tx *sqlx.Tx) error {
+		var err error
+		s, err = database.getLatestStream(tx)
+		return err
+	})
+	return s, err
 }
 
 func (database *Database) getLatestStream(tx *sqlx.Tx) (*Stream, error) {
@@ -103,7 +85,7 @@ func (database *Database) getLatestStream(tx *sqlx.Tx) (*Stream, error) {
 		case sql.ErrNoRows:
 			return nil, nil
 		default:
-			msg := "cannot unmarshal user from getLatestStream: " + err.Error()
+			msg := "cannot unmarshal stream from getLatestStream: " + err.Error()
 			return nil, errors.New(msg)
 		}
 	}
@@ -111,27 +93,17 @@ func (database *Database) getLatestStream(tx *sqlx.Tx) (*Stream, error) {
 }
 
 func (database *Database) EndActiveStreams() error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for EndActiveStreams: " + err.Error()
-		return errors.New(msg)
-	}
-	err = database.endActiveStreams(tx)
-	if err != nil {
-		msg := "cannot get task in EndActiveStreams: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in EndActiveStreams: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
-		return errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in EndActiveStreams: " + err.Error()
-		return errors.New(msg)
-	}
-	return nil
+	return database.EndActiveStreamsContext(context.Background())
+}
+
+func (database *Database) EndActiveStreamsContext(ctx context.Context) error {
+	return database.write(ctx, "EndActiveStreams", func(tx *sqlx.Tx) error {
+		return database.endActiveStreams(tx)
+	})
+}
+
+func (tx *Tx) EndActiveStreams() error {
+	return tx.database.endActiveStreams(tx.tx)
 }
 
 func (database *Database) endActiveStreams(tx *sqlx.Tx) error {
@@ -151,27 +123,17 @@ func (database *Database) endActiveStreams(tx *sqlx.Tx) error {
 }
 
 func (database *Database) InsertStream() error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for InsertStream: " + err.Error()
-		return errors.New(msg)
-	}
-	err = database.insertStream(tx)
-	if err != nil {
-		msg := "cannot insert stream in InsertStream: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback from insert in InsertStream: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
-		return errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in InsertStream: " + err.Error()
-		return errors.New(msg)
-	}
-	return nil
+	return database.InsertStreamContext(context.Background())
+}
+
+func (database *Database) InsertStreamContext(ctx context.Context) error {
+	return database.write(ctx, "InsertStream", func(tx *sqlx.Tx) error {
+		return database.insertStream(tx)
+	})
+}
+
+func (tx *Tx) InsertStream() error {
+	return tx.database.insertStream(tx.tx)
 }
 
 func (database *Database) insertStream(tx *sqlx.Tx) error {
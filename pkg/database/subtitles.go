@@ -1,113 +1,262 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// Subtitle is a line spoken from StartMillis to EndMillis, unix times in
+// milliseconds.
 type Subtitle struct {
-	ID         int64   `db:"id"`
-	Subtitle   string  `db:"subtitle"`
-	Duration   float64 `db:"duration"`
-	InsertTime int64   `db:"insert_time"`
+	ID          int64   `db:"id"`
+	Subtitle    string  `db:"subtitle"`
+	Duration    float64 `db:"duration"`
+	StartMillis int64   `db:"start_millis"`
+	EndMillis   int64   `db:"end_millis"`
+	InsertTime  int64   `db:"insert_time"`
 }
 
+// GetSubtitlesByTimeRange returns the subtitles that ended between the unix
+// times start and end, inclusive of the whole end second.
 func (database *Database) GetSubtitlesByTimeRange(start int64, end int64) ([]Subtitle, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetSubtitlesByTimeRange: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	u, err := database.getSubtitlesByTimeRange(tx, start, end)
-	if err != nil {
-		msg := "cannot get subtitles in GetSubtitlesByTimeRange: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetSubtitlesByTimeRange: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetSubtitlesByTimeRange: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return u, nil
+	return database.GetSubtitlesByTimeRangeContext(context.Background(), start, end)
+}
+
+func (database *Database) GetSubtitlesByTimeRangeContext(ctx context.Context, start int64, end int64) ([]Subtitle, error) {
+	var s []Subtitle
+	err := database.read(ctx, "GetSubtitlesByTimeRange", func(tx *sqlx.Tx) error {
+		var err error
+		s, err = database.getSubtitlesByTimeRange(tx, start, end)
+		return err
+	})
+	return s, err
 }
 
 func (database *Database) getSubtitlesByTimeRange(tx *sqlx.Tx, start int64, end int64) ([]Subtitle, error) {
-	cols := `id, subtitle, duration, insert_time`
-	query := fmt.Sprintf(`SELECT %s FROM subtitles WHERE insert_time >= $1 AND insert_time <= $2`, cols)
+	cols := `id, subtitle, duration, start_millis, end_millis, insert_time`
+	query := fmt.Sprintf(`SELECT %s FROM subtitles WHERE end_millis >= $1 AND end_millis < $2 ORDER BY start_millis`, cols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
 		msg := "cannot prepare statement in getSubtitlesByTimeRange: " + err.Error()
 		return nil, errors.New(msg)
 	}
 	defer stmt.Close()
-	rows, err := stmt.Queryx(start, end)
+	rows, err := stmt.Queryx(start*1000, (end+1)*1000)
 	if err != nil {
 		msg := "cannot query subtitles from getSubtitlesByTimeRange: " + err.Error()
 		return nil, errors.New(msg)
 	}
 	subtitles := []Subtitle{}
-	for rows.Next() {
+	err = scanRows(rows, func() error {
 		var s Subtitle
-		err = rows.StructScan(&s)
+		err := rows.StructScan(&s)
 		if err != nil {
-			switch err {
-			case sql.ErrNoRows:
-				return nil, nil
-			default:
-				msg := "cannot unmarshal subtitle from getSubtitlesByTimeRange: " + err.Error()
-				return nil, errors.New(msg)
-			}
+			return err
 		}
 		subtitles = append(subtitles, s)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal subtitle from getSubtitlesByTimeRange: " + err.Error()
+		return nil, errors.New(msg)
 	}
 	return subtitles, nil
 }
 
-func (database *Database) InsertSubtitle(text string, duration float64) error {
-	tx, err := database.db.Beginx()
+func (database *Database) InsertSubtitle(text string, start time.Time, end time.Time) error {
+	return database.InsertSubtitleContext(context.Background(), text, start, end)
+}
+
+func (database *Database) InsertSubtitleContext(ctx context.Context, text string, start time.Time, end time.Time) error {
+	return database.write(ctx, "InsertSubtitle", func(tx *sqlx.Tx) error {
+		return database.insertSubtitle(tx, text, start.UnixMilli(), end.UnixMilli())
+	})
+}
+
+// insertSubtitle keeps duration and insert_time, the end second, in step with
+// the millisecond times for rows read the old way.
+func (database *Database) insertSubtitle(tx *sqlx.Tx, text string, start_millis int64, end_millis int64) error {
+	cols := `subtitle, duration, start_millis, end_millis, insert_time`
+	query := fmt.Sprintf(`INSERT INTO subtitles (%s) VALUES($1, $2, $3, $4, $5)`, cols)
+	stmt, err := tx.Preparex(query)
 	if err != nil {
-		msg := "cannot begin transaction for InsertSubtitle: " + err.Error()
+		msg := "cannot prepare statement in insertSubtitle: " + err.Error()
 		return errors.New(msg)
 	}
-	err = database.insertSubtitle(tx, text, duration)
+	defer stmt.Close()
+	_, err = stmt.Exec(text, float64(end_millis-start_millis)/1000, start_millis, end_millis, end_millis/1000)
 	if err != nil {
-		msg := "cannot insert subtitle in InsertSubtitle: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback from insert in InsertSubtitle: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
+		msg := "cannot execute query in insertSubtitle: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (database *Database) GetSubtitleByID(id int64) (*Subtitle, error) {
+	return database.GetSubtitleByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetSubtitleByIDContext(ctx context.Context, id int64) (*Subtitle, error) {
+	var s *Subtitle
+	err := database.read(ctx, "GetSubtitleByID", func(tx *sqlx.Tx) error {
+		var err error
+		s, err = database.getSubtitleByID(tx, id)
+		return err
+	})
+	return s, err
+}
+
+func (database *Database) getSubtitleByID(tx *sqlx.Tx, id int64) (*Subtitle, error) {
+	cols := `id, subtitle, duration, start_millis, end_millis, insert_time`
+	query := fmt.Sprintf(`SELECT %s FROM subtitles WHERE id = $1`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getSubtitleByID: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowx(id)
+	var s Subtitle
+	err = row.StructScan(&s)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, nil
+		default:
+			msg := "cannot unmarshal subtitle from getSubtitleByID: " + err.Error()
+			return nil, errors.New(msg)
 		}
+	}
+	return &s, nil
+}
+
+// UpdateSubtitle changes the text and times of a subtitle, times are unix
+// times in milliseconds.
+func (database *Database) UpdateSubtitle(id int64, text string, start_millis int64, end_millis int64) error {
+	return database.UpdateSubtitleContext(context.Background(), id, text, start_millis, end_millis)
+}
+
+func (database *Database) UpdateSubtitleContext(ctx context.Context, id int64, text string, start_millis int64, end_millis int64) error {
+	return database.write(ctx, "UpdateSubtitle", func(tx *sqlx.Tx) error {
+		return database.updateSubtitle(tx, id, text, start_millis, end_millis)
+	})
+}
+
+func (database *Database) updateSubtitle(tx *sqlx.Tx, id int64, text string, start_millis int64, end_millis int64) error {
+	query := `UPDATE subtitles SET subtitle = $1, duration = $2, start_millis = $3, end_millis = $4, insert_time = $5 WHERE id = $6`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in updateSubtitle: " + err.Error()
 		return errors.New(msg)
 	}
-	err = tx.Commit()
+	defer stmt.Close()
+	_, err = stmt.Exec(text, float64(end_millis-start_millis)/1000, start_millis, end_millis, end_millis/1000, id)
 	if err != nil {
-		msg := "cannot commit transaction in InsertSubtitle: " + err.Error()
+		msg := "cannot execute query in updateSubtitle: " + err.Error()
 		return errors.New(msg)
 	}
 	return nil
 }
 
-func (database *Database) insertSubtitle(tx *sqlx.Tx, text string, duration float64) error {
-	cols := `subtitle, duration`
-	query := fmt.Sprintf(`INSERT INTO subtitles (%s) VALUES($1, $2)`, cols)
-	stmt, err := tx.Preparex(query)
+func (database *Database) DeleteSubtitle(id int64) error {
+	return database.DeleteSubtitleContext(context.Background(), id)
+}
+
+func (database *Database) DeleteSubtitleContext(ctx context.Context, id int64) error {
+	return database.write(ctx, "DeleteSubtitle", func(tx *sqlx.Tx) error {
+		return database.deleteSubtitle(tx, id)
+	})
+}
+
+func (database *Database) deleteSubtitle(tx *sqlx.Tx, id int64) error {
+	stmt, err := tx.Preparex(`DELETE FROM subtitles WHERE id = $1`)
 	if err != nil {
-		msg := "cannot prepare statement in insertSubtitle: " + err.Error()
+		msg := "cannot prepare statement in deleteSubtitle: " + err.Error()
 		return errors.New(msg)
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(text, duration)
+	_, err = stmt.Exec(id)
 	if err != nil {
-		msg := "cannot execute query in insertSubtitle: " + err.Error()
+		msg := "cannot execute query in deleteSubtitle: " + err.Error()
 		return errors.New(msg)
 	}
 	return nil
 }
+
+// MergeSubtitles joins subtitles into the one that starts first, which is
+// shown from the first start to the last end. The others are deleted.
+func (database *Database) MergeSubtitles(ids []int64) error {
+	return database.MergeSubtitlesContext(context.Background(), ids)
+}
+
+func (database *Database) MergeSubtitlesContext(ctx context.Context, ids []int64) error {
+	if len(ids) < 2 {
+		return errors.New("at least two subtitles are needed to merge")
+	}
+	return database.write(ctx, "MergeSubtitles", func(tx *sqlx.Tx) error {
+		subtitles := []Subtitle{}
+		for i := range ids {
+			s, err := database.getSubtitleByID(tx, ids[i])
+			if err != nil {
+				return err
+			}
+			if s == nil {
+				return errors.New("subtitle [" + strconv.FormatInt(ids[i], 10) + "] not found")
+			}
+			subtitles = append(subtitles, *s)
+		}
+		sort.SliceStable(subtitles, func(i, j int) bool {
+			return subtitles[i].StartMillis < subtitles[j].StartMillis
+		})
+		merged := subtitles[0]
+		texts := []string{}
+		for i := range subtitles {
+			texts = append(texts, subtitles[i].Subtitle)
+			if subtitles[i].EndMillis > merged.EndMillis {
+				merged.EndMillis = subtitles[i].EndMillis
+			}
+			if i == 0 {
+				continue
+			}
+			err := database.deleteSubtitle(tx, subtitles[i].ID)
+			if err != nil {
+				return err
+			}
+		}
+		return database.updateSubtitle(tx, merged.ID, strings.Join(texts, " "), merged.StartMillis, merged.EndMillis)
+	})
+}
+
+// SplitSubtitle ends a subtitle at at_millis with text and shows second_text
+// from there until the subtitle used to end.
+func (database *Database) SplitSubtitle(id int64, at_millis int64, text string, second_text string) error {
+	return database.SplitSubtitleContext(context.Background(), id, at_millis, text, second_text)
+}
+
+func (database *Database) SplitSubtitleContext(ctx context.Context, id int64, at_millis int64, text string, second_text string) error {
+	return database.write(ctx, "SplitSubtitle", func(tx *sqlx.Tx) error {
+		s, err := database.getSubtitleByID(tx, id)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return errors.New("subtitle [" + strconv.FormatInt(id, 10) + "] not found")
+		}
+		if at_millis <= s.StartMillis || at_millis >= s.EndMillis {
+			return errors.New("split time must be between the start and end of the subtitle")
+		}
+		err = database.updateSubtitle(tx, s.ID, text, s.StartMillis, at_millis)
+		if err != nil {
+			return err
+		}
+		return database.insertSubtitle(tx, second_text, at_millis, s.EndMillis)
+	})
+}
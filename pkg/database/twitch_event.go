@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TwitchEvent is a notification from twitch EventSub. Event is the event as
+// twitch sent it in JSON and EventMillis is when twitch sent it in unix
+// milliseconds.
+type TwitchEvent struct {
+	ID                int64  `db:"id" json:"id"`
+	MessageID         string `db:"message_id" json:"message_id"`
+	EventType         string `db:"event_type" json:"event_type"`
+	BroadcasterUserID string `db:"broadcaster_user_id" json:"broadcaster_user_id"`
+	Event             string `db:"event" json:"event"`
+	EventMillis       int64  `db:"event_millis" json:"event_millis"`
+	InsertTime        int64  `db:"insert_time" json:"insert_time"`
+}
+
+const twitchEventCols = `id, message_id, event_type, broadcaster_user_id, event, event_millis, insert_time`
+
+// InsertTwitchEvent stores an event and reports whether it is new, an event
+// with the same message id is not stored twice.
+func (database *Database) InsertTwitchEvent(event TwitchEvent) (bool, error) {
+	return database.InsertTwitchEventContext(context.Background(), event)
+}
+
+func (database *Database) InsertTwitchEventContext(ctx context.Context, event TwitchEvent) (bool, error) {
+	inserted := false
+	err := database.write(ctx, "InsertTwitchEvent", func(tx *sqlx.Tx) error {
+		var err error
+		inserted, err = database.insertTwitchEvent(tx, event)
+		return err
+	})
+	return inserted, err
+}
+
+func (database *Database) insertTwitchEvent(tx *sqlx.Tx, event TwitchEvent) (bool, error) {
+	cols := `message_id, event_type, broadcaster_user_id, event, event_millis`
+	query := fmt.Sprintf(`INSERT INTO twitch_event (%s) VALUES($1, $2, $3, $4, $5) ON CONFLICT (message_id) DO NOTHING`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in insertTwitchEvent: " + err.Error()
+		return false, errors.New(msg)
+	}
+	defer stmt.Close()
+	result, err := stmt.Exec(event.MessageID, event.EventType, event.BroadcasterUserID, event.Event, event.EventMillis)
+	if err != nil {
+		msg := "cannot execute query in insertTwitchEvent: " + err.Error()
+		return false, errors.New(msg)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		msg := "cannot get affected rows in insertTwitchEvent: " + err.Error()
+		return false, errors.New(msg)
+	}
+	return affected == 1, nil
+}
+
+// GetLatestTwitchEvents returns the last events of event_type, or of every
+// type when it is empty, newest first.
+func (database *Database) GetLatestTwitchEvents(event_type string, limit int64) ([]TwitchEvent, error) {
+	return database.GetLatestTwitchEventsContext(context.Background(), event_type, limit)
+}
+
+func (database *Database) GetLatestTwitchEventsContext(ctx context.Context, event_type string, limit int64) ([]TwitchEvent, error) {
+	var e []TwitchEvent
+	err := database.read(ctx, "GetLatestTwitchEvents", func(tx *sqlx.Tx) error {
+		var err error
+		e, err = database.getLatestTwitchEvents(tx, event_type, limit)
+		return err
+	})
+	return e, err
+}
+
+func (database *Database) getLatestTwitchEvents(tx *sqlx.Tx, event_type string, limit int64) ([]TwitchEvent, error) {
+	query := fmt.Sprintf(`SELECT %s FROM twitch_event WHERE $1 = '' OR event_type = $1 ORDER BY event_millis DESC, id DESC LIMIT $2`, twitchEventCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getLatestTwitchEvents: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx(event_type, limit)
+	if err != nil {
+		msg := "cannot query twitch events from getLatestTwitchEvents: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	events := []TwitchEvent{}
+	err = scanRows(rows, func() error {
+		var e TwitchEvent
+		err := rows.StructScan(&e)
+		if err != nil {
+			return errors.New("cannot unmarshal twitch event from getLatestTwitchEvents: " + err.Error())
+		}
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
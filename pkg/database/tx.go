@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type TxOptions struct {
+	ReadOnly bool
+}
+
+// Tx lets callers compose several repository operations into one
+// transaction, its methods mirror the Database methods of the same name.
+type Tx struct {
+	database *Database
+	tx       *sqlx.Tx
+}
+
+// Transaction runs fn in a single transaction which is committed when fn
+// returns nil and rolled back otherwise.
+func (database *Database) Transaction(ctx context.Context, opts *TxOptions, fn func(tx *Tx) error) error {
+	read_only := opts != nil && opts.ReadOnly
+	return database.transaction(ctx, "Transaction", read_only, func(tx *sqlx.Tx) error {
+		return fn(&Tx{database: database, tx: tx})
+	})
+}
+
+func (database *Database) read(ctx context.Context, name string, fn func(tx *sqlx.Tx) error) error {
+	return database.transaction(ctx, name, true, fn)
+}
+
+func (database *Database) write(ctx context.Context, name string, fn func(tx *sqlx.Tx) error) error {
+	return database.transaction(ctx, name, false, fn)
+}
+
+// transaction is the only place transactions are begun and ended. The sqlite
+// driver ignores sql.TxOptions.ReadOnly so every transaction sets query_only
+// on its connection instead, setting it each time means a read that was
+// cancelled part way cannot leave a pooled connection read only.
+func (database *Database) transaction(ctx context.Context, name string, read_only bool, fn func(tx *sqlx.Tx) error) error {
+	tx, err := database.db.BeginTxx(ctx, nil)
+	if err != nil {
+		msg := "cannot begin transaction for " + name + ": " + err.Error()
+		return errors.New(msg)
+	}
+	done := false
+	defer func() {
+		// fn panicked, do not leave the transaction open on the connection
+		if !done {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(fmt.Sprintf(`PRAGMA query_only = %t`, read_only))
+	if err == nil {
+		err = fn(tx)
+	}
+	done = true
+	if err != nil {
+		msg := "cannot run " + name + ": " + err.Error()
+		roll_err := tx.Rollback()
+		if roll_err != nil {
+			fatal := "cannot rollback in " + name + ": " + msg + ": " + roll_err.Error()
+			return errors.New(fatal)
+		}
+		return errors.New(msg)
+	}
+	err = tx.Commit()
+	if err != nil {
+		msg := "cannot commit transaction in " + name + ": " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// scanRows closes rows once they have all been scanned and reports any error
+// that ended the iteration early.
+func scanRows(rows *sqlx.Rows, scan func() error) error {
+	defer rows.Close()
+	for rows.Next() {
+		err := scan()
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	UploadJobQueued    = "queued"
+	UploadJobUploading = "uploading"
+	UploadJobFinishing = "finishing"
+	UploadJobDone      = "done"
+	UploadJobFailed    = "failed"
+)
+
+var ErrUploadJobExists = errors.New("recording or clip already has an upload job that has not failed")
+
+// StringList is stored as a JSON array.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = StringList{}
+	}
+	b, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (l *StringList) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+// UploadJob is an upload to YouTube. CaptionDone and PlaylistDone are set
+// once those steps succeed so a retry after a later step failed does not add
+// them twice. Reschedule is set when the upload ran past its publish time,
+// the video was uploaded private and has to be scheduled again.
+type UploadJob struct {
+	ID               int64         `db:"id"`
+	MediaRecordingID int64         `db:"media_recording_id"`
+	MediaClipID      *int64        `db:"media_clip_id"`
+	File             string        `db:"file"`
+	SubtitleFile     string        `db:"subtitle_file"`
+	ThumbnailFile    string        `db:"thumbnail_file"`
+	Title            string        `db:"title"`
+	Description      string        `db:"description"`
+	Tags             StringList    `db:"tags"`
+	CategoryID       string        `db:"category_id"`
+	PlaylistID       string        `db:"playlist_id"`
+	RecordingTime    string        `db:"recording_time"`
+	PublishPolicy    PublishPolicy `db:"publish_policy"`
+	State            string        `db:"state"`
+	SessionURL       *string       `db:"session_url"`
+	VideoID          *string       `db:"video_id"`
+	BytesSent        int64         `db:"bytes_sent"`
+	BytesTotal       int64         `db:"bytes_total"`
+	Attempts         int64         `db:"attempts"`
+	LastError        *string       `db:"last_error"`
+	NextAttemptTime  int64         `db:"next_attempt_time"`
+	CaptionDone      bool          `db:"caption_done"`
+	PlaylistDone     bool          `db:"playlist_done"`
+	Reschedule       bool          `db:"reschedule"`
+	UpdateTime       int64         `db:"update_time"`
+	InsertTime       int64         `db:"insert_time"`
+}
+
+const uploadJobCols = `id, media_recording_id, media_clip_id, file, subtitle_file, thumbnail_file, title, description, tags, category_id, playlist_id, recording_time, publish_policy, state, session_url, video_id, bytes_sent, bytes_total, attempts, last_error, next_attempt_time, caption_done, playlist_done, reschedule, update_time, insert_time`
+
+func (database *Database) GetUploadJobByID(id int64) (*UploadJob, error) {
+	return database.GetUploadJobByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetUploadJobByIDContext(ctx context.Context, id int64) (*UploadJob, error) {
+	var j *UploadJob
+	err := database.read(ctx, "GetUploadJobByID", func(tx *sqlx.Tx) error {
+		var err error
+		j, err = database.getUploadJob(tx, "getUploadJobByID", `WHERE id = $1`, id)
+		return err
+	})
+	return j, err
+}
+
+// GetNextUploadJob returns the oldest queued job whose retry time has
+// passed, or nil when there is nothing to do.
+func (database *Database) GetNextUploadJob(now int64) (*UploadJob, error) {
+	return database.GetNextUploadJobContext(context.Background(), now)
+}
+
+func (database *Database) GetNextUploadJobContext(ctx context.Context, now int64) (*UploadJob, error) {
+	var j *UploadJob
+	err := database.read(ctx, "GetNextUploadJob", func(tx *sqlx.Tx) error {
+		var err error
+		j, err = database.getUploadJob(tx, "getNextUploadJob", `WHERE state = $1 AND next_attempt_time <= $2 ORDER BY id LIMIT 1`, UploadJobQueued, now)
+		return err
+	})
+	return j, err
+}
+
+func (database *Database) getUploadJob(tx *sqlx.Tx, name string, where string, args ...interface{}) (*UploadJob, error) {
+	query := fmt.Sprintf(`SELECT %s FROM upload_job %s`, uploadJobCols, where)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in " + name + ": " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowx(args...)
+	var j UploadJob
+	err = row.StructScan(&j)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, nil
+		default:
+			msg := "cannot unmarshal upload job from " + name + ": " + err.Error()
+			return nil, errors.New(msg)
+		}
+	}
+	return &j, nil
+}
+
+// GetLatestUploadJobs returns the most recently queued jobs first.
+func (database *Database) GetLatestUploadJobs(limit int64) ([]UploadJob, error) {
+	return database.GetLatestUploadJobsContext(context.Background(), limit)
+}
+
+func (database *Database) GetLatestUploadJobsContext(ctx context.Context, limit int64) ([]UploadJob, error) {
+	var j []UploadJob
+	err := database.read(ctx, "GetLatestUploadJobs", func(tx *sqlx.Tx) error {
+		var err error
+		j, err = database.getLatestUploadJobs(tx, limit)
+		return err
+	})
+	return j, err
+}
+
+func (database *Database) getLatestUploadJobs(tx *sqlx.Tx, limit int64) ([]UploadJob, error) {
+	query := fmt.Sprintf(`SELECT %s FROM upload_job ORDER BY id DESC LIMIT $1`, uploadJobCols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in getLatestUploadJobs: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Queryx(limit)
+	if err != nil {
+		msg := "cannot query upload jobs from getLatestUploadJobs: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	jobs := []UploadJob{}
+	err = scanRows(rows, func() error {
+		var j UploadJob
+		err := rows.StructScan(&j)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, j)
+		return nil
+	})
+	if err != nil {
+		msg := "cannot unmarshal upload job from getLatestUploadJobs: " + err.Error()
+		return nil, errors.New(msg)
+	}
+	return jobs, nil
+}
+
+// InsertUploadJob queues a job and returns its id, a recording and each of
+// its clips can only have one job that has not failed.
+func (database *Database) InsertUploadJob(job UploadJob) (int64, error) {
+	return database.InsertUploadJobContext(context.Background(), job)
+}
+
+func (database *Database) InsertUploadJobContext(ctx context.Context, job UploadJob) (int64, error) {
+	var id int64
+	exists := false
+	err := database.write(ctx, "InsertUploadJob", func(tx *sqlx.Tx) error {
+		existing, err := database.getUploadJob(tx, "insertUploadJob", `WHERE media_recording_id = $1 AND media_clip_id IS $2 AND state != $3 LIMIT 1`, job.MediaRecordingID, job.MediaClipID, UploadJobFailed)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			exists = true
+			return nil
+		}
+		id, err = database.insertUploadJob(tx, job)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, ErrUploadJobExists
+	}
+	return id, nil
+}
+
+func (database *Database) insertUploadJob(tx *sqlx.Tx, job UploadJob) (int64, error) {
+	cols := `media_recording_id, media_clip_id, file, subtitle_file, thumbnail_file, title, description, tags, category_id, playlist_id, recording_time, publish_policy`
+	query := fmt.Sprintf(`INSERT INTO upload_job (%s) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`, cols)
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in insertUploadJob: " + err.Error()
+		return 0, errors.New(msg)
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(job.MediaRecordingID, job.MediaClipID, job.File, job.SubtitleFile, job.ThumbnailFile, job.Title, job.Description, job.Tags, job.CategoryID, job.PlaylistID, job.RecordingTime, job.PublishPolicy)
+	if err != nil {
+		msg := "cannot execute query in insertUploadJob: " + err.Error()
+		return 0, errors.New(msg)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		msg := "cannot get id in insertUploadJob: " + err.Error()
+		return 0, errors.New(msg)
+	}
+	return id, nil
+}
+
+// UpdateUploadJob stores the progress fields of job: state, session, video,
+// byte counts, attempts, error, retry time and the finished steps.
+func (database *Database) UpdateUploadJob(job UploadJob) error {
+	return database.UpdateUploadJobContext(context.Background(), job)
+}
+
+func (database *Database) UpdateUploadJobContext(ctx context.Context, job UploadJob) error {
+	return database.write(ctx, "UpdateUploadJob", func(tx *sqlx.Tx) error {
+		return database.updateUploadJob(tx, job)
+	})
+}
+
+func (database *Database) updateUploadJob(tx *sqlx.Tx, job UploadJob) error {
+	query := `UPDATE upload_job SET state = $1, session_url = $2, video_id = $3, bytes_sent = $4, bytes_total = $5, attempts = $6, last_error = $7, next_attempt_time = $8, caption_done = $9, playlist_done = $10, reschedule = $11, update_time = CAST(strftime('%s', 'now') AS INTEGER) WHERE id = $12`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in updateUploadJob: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(job.State, job.SessionURL, job.VideoID, job.BytesSent, job.BytesTotal, job.Attempts, job.LastError, job.NextAttemptTime, job.CaptionDone, job.PlaylistDone, job.Reschedule, job.ID)
+	if err != nil {
+		msg := "cannot execute query in updateUploadJob: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// FinishUploadJob marks the job done and its recording uploaded together,
+// uploading a clip leaves the recording as it is.
+func (database *Database) FinishUploadJob(job UploadJob) error {
+	return database.FinishUploadJobContext(context.Background(), job)
+}
+
+func (database *Database) FinishUploadJobContext(ctx context.Context, job UploadJob) error {
+	return database.write(ctx, "FinishUploadJob", func(tx *sqlx.Tx) error {
+		job.State = UploadJobDone
+		job.LastError = nil
+		err := database.updateUploadJob(tx, job)
+		if err != nil || job.MediaClipID != nil {
+			return err
+		}
+		return database.setMediaRecordingUploadedByID(tx, job.MediaRecordingID, true)
+	})
+}
+
+// RequeueInterruptedUploadJobs puts jobs that were running when strmr
+// stopped back in the queue, their session url is kept so they resume.
+func (database *Database) RequeueInterruptedUploadJobs() error {
+	return database.RequeueInterruptedUploadJobsContext(context.Background())
+}
+
+func (database *Database) RequeueInterruptedUploadJobsContext(ctx context.Context) error {
+	return database.write(ctx, "RequeueInterruptedUploadJobs", func(tx *sqlx.Tx) error {
+		return database.requeueInterruptedUploadJobs(tx)
+	})
+}
+
+func (database *Database) requeueInterruptedUploadJobs(tx *sqlx.Tx) error {
+	query := `UPDATE upload_job SET state = $1, next_attempt_time = 0 WHERE state IN ($2, $3)`
+	stmt, err := tx.Preparex(query)
+	if err != nil {
+		msg := "cannot prepare statement in requeueInterruptedUploadJobs: " + err.Error()
+		return errors.New(msg)
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(UploadJobQueued, UploadJobUploading, UploadJobFinishing)
+	if err != nil {
+		msg := "cannot execute query in requeueInterruptedUploadJobs: " + err.Error()
+		return errors.New(msg)
+	}
+	return nil
+}
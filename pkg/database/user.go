@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -9,39 +10,31 @@ import (
 )
 
 type User struct {
-	ID         int64  `db:"id"`
-	UserID     string `db:"user_id"`
-	UserType   string `db:"user_type"`
-	InsertTime int64  `db:"insert_time"`
+	ID         int64  `db:"id" json:"id"`
+	UserID     string `db:"user_id" json:"user_id"`
+	UserType   string `db:"user_type" json:"user_type"`
+	AccountID  *int64 `db:"account_id" json:"account_id"`
+	InsertTime int64  `db:"insert_time" json:"insert_time"`
 }
 
+const userCols = `id, user_id, user_type, account_id, insert_time`
+
 func (database *Database) GetUserByID(id int64) (*User, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetUserByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	u, err := database.getUserByID(tx, id)
-	if err != nil {
-		msg := "cannot get user in GetUserByID: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetUserByID: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetUserByID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return u, nil
+	return database.GetUserByIDContext(context.Background(), id)
+}
+
+func (database *Database) GetUserByIDContext(ctx context.Context, id int64) (*User, error) {
+	var u *User
+	err := database.read(ctx, "GetUserByID", func(tx *sqlx.Tx) error {
+		var err error
+		u, err = database.getUserByID(tx, id)
+		return err
+	})
+	return u, err
 }
 
 func (database *Database) getUserByID(tx *sqlx.Tx, id int64) (*User, error) {
-	cols := `id, user_id, user_type, insert_time`
-	query := fmt.Sprintf(`SELECT %s FROM user WHERE id = $1`, cols)
+	query := fmt.Sprintf(`SELECT %s FROM user WHERE id = $1`, userCols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
 		msg := "cannot prepare statement in getUserByID: " + err.Error()
@@ -64,32 +57,21 @@ func (database *Database) getUserByID(tx *sqlx.Tx, id int64) (*User, error) {
 }
 
 func (database *Database) GetUserByTypeAndID(user_type, user_id string) (*User, error) {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for GetUserByTypeAndID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	u, err := database.getUserByTypeAndID(tx, user_type, user_id)
-	if err != nil {
-		msg := "cannot get user in GetUserByTypeAndID: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback in GetUserByTypeAndID: " + msg + ": " + roll_err.Error()
-			return nil, errors.New(fatal)
-		}
-		return nil, errors.New(msg)
-	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in GetUserByTypeAndID: " + err.Error()
-		return nil, errors.New(msg)
-	}
-	return u, nil
+	return database.GetUserByTypeAndIDContext(context.Background(), user_type, user_id)
+}
+
+func (database *Database) GetUserByTypeAndIDContext(ctx context.Context, user_type, user_id string) (*User, error) {
+	var u *User
+	err := database.read(ctx, "GetUserByTypeAndID", func(tx *sqlx.Tx) error {
+		var err error
+		u, err = database.getUserByTypeAndID(tx, user_type, user_id)
+		return err
+	})
+	return u, err
 }
 
 func (database *Database) getUserByTypeAndID(tx *sqlx.Tx, user_type, user_id string) (*User, error) {
-	cols := `id, user_id, user_type, insert_time`
-	query := fmt.Sprintf(`SELECT %s FROM user WHERE user_type = $1 AND user_id = $2`, cols)
+	query := fmt.Sprintf(`SELECT %s FROM user WHERE user_type = LOWER($1) AND user_id = LOWER($2)`, userCols)
 	stmt, err := tx.Preparex(query)
 	if err != nil {
 		msg := "cannot prepare statement in getUserByTypeAndID: " + err.Error()
@@ -112,38 +94,28 @@ func (database *Database) getUserByTypeAndID(tx *sqlx.Tx, user_type, user_id str
 }
 
 func (database *Database) InsertUser(user_type string, user_id string) error {
-	tx, err := database.db.Beginx()
-	if err != nil {
-		msg := "cannot begin transaction for InsertUser: " + err.Error()
-		return errors.New(msg)
-	}
+	return database.InsertUserContext(context.Background(), user_type, user_id)
+}
+
+func (database *Database) InsertUserContext(ctx context.Context, user_type string, user_id string) error {
+	return database.write(ctx, "InsertUser", func(tx *sqlx.Tx) error {
+		return database.insertUserIfMissing(tx, user_type, user_id)
+	})
+}
+
+func (tx *Tx) InsertUser(user_type string, user_id string) error {
+	return tx.database.insertUserIfMissing(tx.tx, user_type, user_id)
+}
+
+func (database *Database) insertUserIfMissing(tx *sqlx.Tx, user_type string, user_id string) error {
 	u, err := database.getUserByTypeAndID(tx, user_type, user_id)
 	if err != nil {
-		msg := "cannot get user in InsertUser: " + err.Error()
-		roll_err := tx.Rollback()
-		if roll_err != nil {
-			fatal := "cannot rollback from select in InsertUser: " + msg + ": " + roll_err.Error()
-			return errors.New(fatal)
-		}
+		return err
 	}
-	if u == nil {
-		err := database.insertUser(tx, user_type, user_id)
-		if err != nil {
-			msg := "cannot insert user in InsertUser: " + err.Error()
-			roll_err := tx.Rollback()
-			if roll_err != nil {
-				fatal := "cannot rollback from insert in InsertUser: " + msg + ": " + roll_err.Error()
-				return errors.New(fatal)
-			}
-			return errors.New(msg)
-		}
+	if u != nil {
+		return nil
 	}
-	err = tx.Commit()
-	if err != nil {
-		msg := "cannot commit transaction in InsertUser: " + err.Error()
-		return errors.New(msg)
-	}
-	return nil
+	return database.insertUser(tx, user_type, user_id)
 }
 
 func (database *Database) insertUser(tx *sqlx.Tx, user_type string, user_id string) error {
@@ -0,0 +1,187 @@
+package obs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andreykaipov/goobs"
+	"github.com/andreykaipov/goobs/api/events"
+	"github.com/gorilla/websocket"
+)
+
+type ConnectionState string
+
+const (
+	ConnectionDisconnected ConnectionState = "disconnected"
+	ConnectionConnecting   ConnectionState = "connecting"
+	ConnectionConnected    ConnectionState = "connected"
+)
+
+const (
+	reconnectMinDelay = 1 * time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var ErrNotConnected = errors.New("OBS is not connected")
+
+type ConnectionStatus struct {
+	State     ConnectionState `json:"state"`
+	LastError string          `json:"last_error,omitempty"`
+	Since     int64           `json:"since"`
+	Attempts  int             `json:"attempts"`
+}
+
+type connection struct {
+	mu     sync.RWMutex
+	client *goobs.Client
+	status ConnectionStatus
+}
+
+func (obs *OBS) Client() *goobs.Client {
+	obs.connection.mu.RLock()
+	defer obs.connection.mu.RUnlock()
+	return obs.connection.client
+}
+
+// client returns the connected client, or an error when OBS is disconnected
+// or the context this OBS was bound to with WithContext is done. goobs
+// requests cannot be cancelled once sent so operations made of several
+// requests stop between them instead.
+func (obs *OBS) client() (*goobs.Client, error) {
+	if obs.ctx != nil && obs.ctx.Err() != nil {
+		return nil, obs.ctx.Err()
+	}
+	client := obs.Client()
+	if client == nil {
+		return nil, ErrNotConnected
+	}
+	return client, nil
+}
+
+// WithContext returns a view of this OBS sharing its connection and state
+// whose requests fail once ctx is done.
+func (obs *OBS) WithContext(ctx context.Context) Controller {
+	return obs.withContext(ctx)
+}
+
+func (obs *OBS) withContext(ctx context.Context) *OBS {
+	o := *obs
+	o.ctx = ctx
+	return &o
+}
+
+func (obs *OBS) setClient(client *goobs.Client) {
+	obs.connection.mu.Lock()
+	obs.connection.client = client
+	obs.connection.mu.Unlock()
+	obs.setStatus(ConnectionConnected, nil)
+}
+
+func (obs *OBS) setStatus(state ConnectionState, err error) {
+	obs.connection.mu.Lock()
+	defer obs.connection.mu.Unlock()
+	s := &obs.connection.status
+	if s.State != state {
+		s.Since = time.Now().Unix()
+	}
+	s.State = state
+	switch state {
+	case ConnectionConnected:
+		s.Attempts = 0
+		s.LastError = ""
+	case ConnectionConnecting:
+		s.Attempts++
+	}
+	if err != nil {
+		s.LastError = err.Error()
+	}
+}
+
+func (obs *OBS) Status() ConnectionStatus {
+	obs.connection.mu.RLock()
+	defer obs.connection.mu.RUnlock()
+	return obs.connection.status
+}
+
+func (obs *OBS) Connected() bool {
+	return obs.Status().State == ConnectionConnected
+}
+
+// Supervise keeps a connection to OBS open until the context is cancelled.
+// Failed connections are retried with exponential backoff and on_connect is
+// run after every successful connection, before the state is marked connected.
+func (obs *OBS) Supervise(ctx context.Context, connect func() (*goobs.Client, error), on_connect func() error) {
+	delay := reconnectMinDelay
+	for {
+		obs.setStatus(ConnectionConnecting, nil)
+		client, err := connect()
+		if err != nil {
+			obs.setStatus(ConnectionConnecting, err)
+			fmt.Println("Cannot connect to OBS, retrying in " + delay.String() + ": " + err.Error())
+			select {
+			case <-ctx.Done():
+				obs.setStatus(ConnectionDisconnected, nil)
+				return
+			case <-time.After(delay):
+			}
+			delay = delay * 2
+			if delay > reconnectMaxDelay {
+				delay = reconnectMaxDelay
+			}
+			continue
+		}
+		delay = reconnectMinDelay
+		obs.connection.mu.Lock()
+		obs.connection.client = client
+		obs.connection.mu.Unlock()
+		if on_connect != nil {
+			err = on_connect()
+			if err != nil {
+				fmt.Println("Error setting up OBS after connecting: " + err.Error())
+			}
+		}
+		obs.setStatus(ConnectionConnected, err)
+		fmt.Println("Connected to OBS")
+		err = obs.listen(ctx, client)
+		// handlers fail at once with ErrNotConnected instead of waiting on
+		// the dead client until we connect again
+		obs.connection.mu.Lock()
+		obs.connection.client = nil
+		obs.connection.mu.Unlock()
+		obs.State.Invalidate()
+		client.Disconnect()
+		if ctx.Err() != nil {
+			obs.setStatus(ConnectionDisconnected, nil)
+			return
+		}
+		obs.setStatus(ConnectionDisconnected, err)
+		fmt.Println("Lost connection to OBS: " + err.Error())
+	}
+}
+
+// listen applies events to the state cache until the connection is closed or
+// the context is cancelled.
+func (obs *OBS) listen(ctx context.Context, client *goobs.Client) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-client.IncomingEvents:
+			switch e := event.(type) {
+			case error:
+				var close_err *websocket.CloseError
+				if errors.As(e, &close_err) {
+					return e
+				}
+			case *events.ExitStarted:
+				obs.State.Apply(event)
+				return errors.New("OBS is shutting down")
+			default:
+				obs.State.Apply(event)
+			}
+		}
+	}
+}
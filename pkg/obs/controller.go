@@ -0,0 +1,52 @@
+package obs
+
+import (
+	"context"
+
+	"github.com/andreykaipov/goobs/api/requests/inputs"
+	"github.com/andreykaipov/goobs/api/requests/sceneitems"
+	"github.com/andreykaipov/goobs/api/requests/scenes"
+)
+
+// Controller is the set of OBS operations used outside of this package so
+// callers can be exercised against obstest.Server or a hand written fake.
+type Controller interface {
+	Names() SourceNames
+	Connected() bool
+	Status() ConnectionStatus
+	Snapshot() State
+	WithContext(ctx context.Context) Controller
+	ConvertIntToHex(c int64) (*string, error)
+
+	GetCurrentScene() (string, error)
+	GetSceneList() (*scenes.GetSceneListResponse, error)
+	CreateScene(name string) (*scenes.CreateSceneResponse, error)
+
+	GetInputSettings(name string) (*inputs.GetInputSettingsResponse, error)
+	SetInputSettings(name string, settings map[string]interface{}) (*inputs.SetInputSettingsResponse, error)
+	SetTask(task Task) error
+
+	GetSceneItemId(scene string, source string) float64
+	GetSceneItemTransform(item_id float64, name string) (*sceneitems.GetSceneItemTransformResponse, error)
+	SetSceneItemTransform(item_id float64, name string, posX, posY, width, height float64) (*sceneitems.SetSceneItemTransformResponse, error)
+	SetSceneItemEnabled(item_id float64, scene_name string, enabled bool) error
+
+	GetStreamStatus() (bool, error)
+	ToggleStream() (bool, error)
+	GetRecordStatus() (bool, error)
+	ToggleRecord() error
+	GetRecordDirectory() (string, error)
+
+	GetProfileParameter(parameter string) (string, error)
+	SetProfileParameter(parameter string, value string) error
+}
+
+var _ Controller = (*OBS)(nil)
+
+func (obs *OBS) Names() SourceNames {
+	return obs.SourceNames
+}
+
+func (obs *OBS) Snapshot() State {
+	return obs.State.Snapshot()
+}
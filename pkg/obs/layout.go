@@ -0,0 +1,116 @@
+package obs
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Layout describes every strmr managed source in a scene. Sources are listed
+// bottom to top, the order is used as the z-order of the managed scene items.
+type Layout struct {
+	Scene   string         `yaml:"scene" json:"scene"`
+	Sources []LayoutSource `yaml:"sources" json:"sources"`
+}
+
+type LayoutSource struct {
+	Name string `yaml:"name" json:"name"`
+	Kind string `yaml:"kind" json:"kind"`
+	// Settings are enforced on every reconcile, InitialSettings are only used
+	// when the input is created so values changed from the UI are kept.
+	Settings        map[string]interface{} `yaml:"settings" json:"settings"`
+	InitialSettings map[string]interface{} `yaml:"initial_settings" json:"initial_settings"`
+	Transform       *Transform             `yaml:"transform" json:"transform"`
+	Visible         *bool                  `yaml:"visible" json:"visible"`
+	// Press are buttons pressed when the input is created or its Settings
+	// change, e.g. refreshnocache to reload a browser source.
+	Press []string `yaml:"press" json:"press"`
+}
+
+type Transform struct {
+	PosX   float64 `yaml:"pos_x" json:"pos_x"`
+	PosY   float64 `yaml:"pos_y" json:"pos_y"`
+	Width  float64 `yaml:"width" json:"width"`
+	Height float64 `yaml:"height" json:"height"`
+}
+
+// LoadLayout reads a layout document, JSON is valid YAML so both are accepted.
+func LoadLayout(file_name string) (*Layout, error) {
+	b, err := ioutil.ReadFile(file_name)
+	if err != nil {
+		return nil, errors.New("Failed to read layout file: " + err.Error())
+	}
+	l := Layout{}
+	err = yaml.Unmarshal(b, &l)
+	if err != nil {
+		return nil, errors.New("Failed to unmarshal layout file: " + err.Error())
+	}
+	err = l.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (l *Layout) Validate() error {
+	names := map[string]bool{}
+	for i := range l.Sources {
+		s := l.Sources[i]
+		if s.Name == "" {
+			return errors.New("layout source name cannot be empty")
+		}
+		if s.Kind == "" {
+			return errors.New("layout source [" + s.Name + "] kind cannot be empty")
+		}
+		if names[s.Name] {
+			return errors.New("layout source [" + s.Name + "] is defined more than once")
+		}
+		names[s.Name] = true
+	}
+	return nil
+}
+
+func (l *Layout) Source(name string) *LayoutSource {
+	for i := range l.Sources {
+		if l.Sources[i].Name == name {
+			return &l.Sources[i]
+		}
+	}
+	return nil
+}
+
+// Copy returns a layout that can be modified without changing the original.
+func (l *Layout) Copy() *Layout {
+	c := &Layout{
+		Scene:   l.Scene,
+		Sources: make([]LayoutSource, len(l.Sources)),
+	}
+	for i := range l.Sources {
+		s := l.Sources[i]
+		s.Settings = copySettings(s.Settings)
+		s.InitialSettings = copySettings(s.InitialSettings)
+		if s.Transform != nil {
+			t := *s.Transform
+			s.Transform = &t
+		}
+		if s.Visible != nil {
+			v := *s.Visible
+			s.Visible = &v
+		}
+		s.Press = append([]string{}, s.Press...)
+		c.Sources[i] = s
+	}
+	return c
+}
+
+func copySettings(settings map[string]interface{}) map[string]interface{} {
+	if settings == nil {
+		return nil
+	}
+	c := map[string]interface{}{}
+	for k, v := range settings {
+		c[k] = v
+	}
+	return c
+}
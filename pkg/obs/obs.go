@@ -1,11 +1,11 @@
 package obs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/andreykaipov/goobs"
 	"github.com/andreykaipov/goobs/api/requests/config"
@@ -20,10 +20,10 @@ type Config struct {
 	Host         string `yaml:"host"`
 	Port         string `yaml:"port"`
 	RecordingDir string `yaml:"recording_dir"`
+	Layout       string `yaml:"layout"`
 }
 
-type OBS struct {
-	Client                      *goobs.Client
+type SourceNames struct {
 	ScreenSourceName            string
 	TaskSourceName              string
 	BackgroundSourceName        string
@@ -32,6 +32,14 @@ type OBS struct {
 	OverlayBackgroundSourceName string
 }
 
+type OBS struct {
+	SourceNames
+	connection *connection
+	State      *StateCache
+	// ctx is only set on copies made by WithContext
+	ctx context.Context
+}
+
 type Task struct {
 	Text       string      `json:"text"`
 	PosX       float64     `json:"pos_x"`
@@ -62,15 +70,22 @@ const (
 )
 
 func New(client *goobs.Client, screen_name, task_name, background_name, avatar_name, overlay_text_name, overlay_background_name string) *OBS {
-	return &OBS{
-		Client:                      client,
-		ScreenSourceName:            screen_name,
-		TaskSourceName:              task_name,
-		BackgroundSourceName:        background_name,
-		AvatarSourceName:            avatar_name,
-		OverlayTextSourceName:       overlay_text_name,
-		OverlayBackgroundSourceName: overlay_background_name,
+	o := &OBS{
+		SourceNames: SourceNames{
+			ScreenSourceName:            screen_name,
+			TaskSourceName:              task_name,
+			BackgroundSourceName:        background_name,
+			AvatarSourceName:            avatar_name,
+			OverlayTextSourceName:       overlay_text_name,
+			OverlayBackgroundSourceName: overlay_background_name,
+		},
+		connection: &connection{},
+		State:      NewStateCache(),
+	}
+	if client != nil {
+		o.setClient(client)
 	}
+	return o
 }
 
 func ConvertColor(c Color) (int64, error) {
@@ -90,240 +105,78 @@ func (obs *OBS) ConvertIntToHex(c int64) (*string, error) {
 	return &h, nil
 }
 
-func (obs *OBS) RefreshSources(background_config database.Metadata, task_text string, task_config database.Metadata) error {
-	screen_exists := true
-	task_exists := true
-	background_exists := true
-	avatar_exists := true
-	overlay_text_exists := true
-	overlay_background_exists := true
-	_, err := obs.GetInputSettings(obs.ScreenSourceName)
-	if err != nil {
-		task_exists = false
-	}
-	_, err = obs.GetInputSettings(obs.TaskSourceName)
-	if err != nil {
-		screen_exists = false
-	}
-	_, err = obs.GetInputSettings(obs.BackgroundSourceName)
-	if err != nil {
-		background_exists = false
-	}
-	_, err = obs.GetInputSettings(obs.AvatarSourceName)
-	if err != nil {
-		avatar_exists = false
-	}
-	_, err = obs.GetInputSettings(obs.OverlayTextSourceName)
-	if err != nil {
-		overlay_text_exists = false
-	}
-	_, err = obs.GetInputSettings(obs.OverlayBackgroundSourceName)
-	if err != nil {
-		overlay_background_exists = false
-	}
-	current_scene, err := obs.GetCurrentScene()
-	if err != nil {
-		return err
-	}
-	// Screen
-	screen_settings := map[string]interface{}{
-		"advanced": false,
-		"screen":   1, // hard coded
-	}
-	if !screen_exists {
-		_, err = obs.CreateInput(SourceScreenType, current_scene, obs.ScreenSourceName, true, screen_settings)
-		if err != nil {
-			return errors.New(err.Error())
+// SourceSettings are the stored settings applied on top of the layout, nil
+// settings leave the layout's own values in place.
+type SourceSettings struct {
+	TaskText   string
+	Task       *database.TaskConfig
+	Background *database.BackgroundConfig
+	Overlay    *database.OverlayConfig
+}
+
+// RefreshSources reconciles the layout after applying the stored settings on
+// top of it.
+func (obs *OBS) RefreshSources(layout *Layout, settings SourceSettings) error {
+	l := layout.Copy()
+	if background := l.Source(obs.BackgroundSourceName); background != nil && settings.Background != nil {
+		c := settings.Background
+		background.Settings = map[string]interface{}{
+			"color":  c.Color,
+			"width":  c.Width + 4,
+			"height": c.Height + 4,
 		}
-		time.Sleep(2 * time.Second)
-	}
-	// Background
-	vals := strings.Split(background_config.MetadataValue, ",")
-	if len(vals) != 5 {
-		return errors.New("background metadata config values not as expected")
-	}
-	color, err := strconv.Atoi(vals[0])
-	if err != nil {
-		return errors.New("background metadata config color not as expected: " + err.Error())
-	}
-	width, err := strconv.ParseFloat(vals[1], 64)
-	if err != nil {
-		return errors.New("background metadata config width not as expected: " + err.Error())
-	}
-	height, err := strconv.ParseFloat(vals[2], 64)
-	if err != nil {
-		return errors.New("background metadata config height not as expected: " + err.Error())
-	}
-	posx, err := strconv.ParseFloat(vals[3], 64)
-	if err != nil {
-		return errors.New("background metadata config posx not as expected: " + err.Error())
-	}
-	posy, err := strconv.ParseFloat(vals[4], 64)
-	if err != nil {
-		return errors.New("background metadata config posy not as expected: " + err.Error())
-	}
-	background_settings := map[string]interface{}{
-		"color":  color,
-		"width":  width + 4,
-		"height": height + 4,
-	}
-	if !background_exists {
-		_, err = obs.CreateInput(SourceColorBlockType, current_scene, obs.BackgroundSourceName, true, background_settings)
-		if err != nil {
-			return errors.New(err.Error())
+		background.Transform = &Transform{
+			PosX:   c.PosX - 2,
+			PosY:   c.PosY - 2,
+			Width:  c.Width + 4,
+			Height: c.Height + 4,
 		}
-		time.Sleep(2 * time.Second)
 	}
-	_, err = obs.SetSceneItemTransform(obs.GetSceneItemId(current_scene, obs.BackgroundSourceName), current_scene, posx-2, posy-2, width+4, height+4)
-	if err != nil {
-		return errors.New("Background: " + err.Error())
-	}
-	time.Sleep(2 * time.Second)
-	// Task
-	vals = strings.Split(task_config.MetadataValue, ",")
-	if len(vals) != 5 {
-		return errors.New("task metadata config values not as expected")
-	}
-	color, err = strconv.Atoi(vals[0])
-	if err != nil {
-		return errors.New("task metadata config color not as expected: " + err.Error())
-	}
-	width, err = strconv.ParseFloat(vals[1], 64)
-	if err != nil {
-		return errors.New("task metadata config width not as expected: " + err.Error())
-	}
-	height, err = strconv.ParseFloat(vals[2], 64)
-	if err != nil {
-		return errors.New("task metadata config height not as expected: " + err.Error())
-	}
-	posx, err = strconv.ParseFloat(vals[3], 64)
-	if err != nil {
-		return errors.New("task metadata config posx not as expected: " + err.Error())
-	}
-	posy, err = strconv.ParseFloat(vals[4], 64)
-	if err != nil {
-		return errors.New("task metadata config posy not as expected: " + err.Error())
-	}
-	task_settings := map[string]interface{}{
-		"text":   task_text,
-		"color1": color,
-		"color2": color,
-	}
-	if !task_exists {
-		_, err = obs.CreateInput(SourceTextType, current_scene, obs.TaskSourceName, true, task_settings)
-		if err != nil {
-			return errors.New(err.Error())
+	if task := l.Source(obs.TaskSourceName); task != nil {
+		if task.Settings == nil {
+			task.Settings = map[string]interface{}{}
 		}
-		time.Sleep(2 * time.Second)
-	}
-	_, err = obs.SetSceneItemTransform(obs.GetSceneItemId(current_scene, obs.TaskSourceName), current_scene, posx, posy, width, height)
-	if err != nil {
-		return errors.New("Task " + err.Error())
-	}
-	time.Sleep(2 * time.Second)
-	// Avatar
-	avatar_settings := map[string]interface{}{
-		"url":                 "http://localhost:8080/avatar",
-		"width":               400,
-		"height":              400,
-		"restart_when_active": true,
-	}
-	if !avatar_exists {
-		_, err = obs.CreateInput(SourceBrowser, current_scene, obs.AvatarSourceName, true, avatar_settings)
-		if err != nil {
-			return errors.New(err.Error())
+		// the task is stored without a style until the style is changed
+		if settings.TaskText != "" {
+			task.Settings["text"] = settings.TaskText
 		}
-		time.Sleep(2 * time.Second)
-	}
-	_, err = obs.SetSceneItemTransform(obs.GetSceneItemId(current_scene, obs.AvatarSourceName), current_scene, 1160, 475, 400, 400)
-	if err != nil {
-		return errors.New("Avatar: " + err.Error())
-	}
-	time.Sleep(2 * time.Second)
-	// Overlay background
-	color, err = strconv.Atoi("4271296285")
-	if err != nil {
-		return errors.New("overlay background metadata config color not as expected: " + err.Error())
-	}
-	width, err = strconv.ParseFloat("1600", 64)
-	if err != nil {
-		return errors.New("overlay background metadata config width not as expected: " + err.Error())
-	}
-	height, err = strconv.ParseFloat("900", 64)
-	if err != nil {
-		return errors.New("overlay background metadata config height not as expected: " + err.Error())
-	}
-	posx, err = strconv.ParseFloat("0", 64)
-	if err != nil {
-		return errors.New("overlay background metadata config posx not as expected: " + err.Error())
-	}
-	posy, err = strconv.ParseFloat("0", 64)
-	if err != nil {
-		return errors.New("overlay background metadata config posy not as expected: " + err.Error())
-	}
-	overlay_background_settings := map[string]interface{}{
-		"color":  color,
-		"width":  width,
-		"height": height,
-	}
-	if !overlay_background_exists {
-		_, err = obs.CreateInput(SourceColorBlockType, current_scene, obs.OverlayBackgroundSourceName, true, overlay_background_settings)
-		if err != nil {
-			return errors.New(err.Error())
+		if c := settings.Task; c != nil {
+			task.Settings["color1"] = c.Color
+			task.Settings["color2"] = c.Color
+			task.Transform = &Transform{
+				PosX:   c.PosX,
+				PosY:   c.PosY,
+				Width:  c.Width,
+				Height: c.Height,
+			}
 		}
-		time.Sleep(2 * time.Second)
-	}
-	_, err = obs.SetSceneItemTransform(obs.GetSceneItemId(current_scene, obs.OverlayBackgroundSourceName), current_scene, posx, posy, width, height)
-	if err != nil {
-		return errors.New("Overlay Background: " + err.Error())
 	}
-	time.Sleep(2 * time.Second)
-	// Overlay Text
-	color, err = strconv.Atoi("4291297280")
-	if err != nil {
-		return errors.New("overlay text metadata config color not as expected: " + err.Error())
-	}
-	width, err = strconv.ParseFloat("1600", 64)
-	if err != nil {
-		return errors.New("overlay text metadata config width not as expected: " + err.Error())
-	}
-	height, err = strconv.ParseFloat("150", 64)
-	if err != nil {
-		return errors.New("overlay text metadata config height not as expected: " + err.Error())
-	}
-	posx, err = strconv.ParseFloat("0", 64)
-	if err != nil {
-		return errors.New("overlay text metadata config posx not as expected: " + err.Error())
-	}
-	posy, err = strconv.ParseFloat("0", 64)
-	if err != nil {
-		return errors.New("overlay text metadata config posy not as expected: " + err.Error())
-	}
-	overlay_text_settings := map[string]interface{}{
-		"text":   "Starting Stream",
-		"color1": color,
-		"color2": color,
-	}
-	if !overlay_text_exists {
-		_, err = obs.CreateInput(SourceTextType, current_scene, obs.OverlayTextSourceName, true, overlay_text_settings)
-		if err != nil {
-			return errors.New(err.Error())
+	if settings.Overlay != nil {
+		c := settings.Overlay
+		if background := l.Source(obs.OverlayBackgroundSourceName); background != nil {
+			if background.Settings == nil {
+				background.Settings = map[string]interface{}{}
+			}
+			background.Settings["color"] = c.BackgroundColor
+			background.Visible = &c.Enabled
+		}
+		if text := l.Source(obs.OverlayTextSourceName); text != nil {
+			if text.Settings == nil {
+				text.Settings = map[string]interface{}{}
+			}
+			text.Settings["text"] = c.Text
+			text.Settings["color1"] = c.TextColor
+			text.Settings["color2"] = c.TextColor
+			text.Transform = &Transform{
+				PosX:   c.PosX,
+				PosY:   c.PosY,
+				Width:  c.Width,
+				Height: c.Height,
+			}
+			text.Visible = &c.Enabled
 		}
-		time.Sleep(2 * time.Second)
-	}
-	_, err = obs.SetSceneItemTransform(obs.GetSceneItemId(current_scene, obs.OverlayTextSourceName), current_scene, posx, posy, width, height)
-	if err != nil {
-		return errors.New("Overlay Text: " + err.Error())
-	}
-	time.Sleep(2 * time.Second)
-	err = obs.PressInputPropertiesButton(&inputs.PressInputPropertiesButtonParams{
-		InputName:    obs.AvatarSourceName,
-		PropertyName: "refreshnocache",
-	})
-	if err != nil {
-		return errors.New(err.Error())
 	}
-	return nil
+	return obs.Reconcile(l)
 }
 
 func (obs *OBS) ConvertIntToColor(c int64) (*Color, error) {
@@ -467,20 +320,36 @@ func (obs *OBS) SetTask(task Task) error {
 }
 
 func (obs *OBS) GetInputSettings(name string) (*inputs.GetInputSettingsResponse, error) {
-	return obs.Client.Inputs.GetInputSettings(&inputs.GetInputSettingsParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Inputs.GetInputSettings(&inputs.GetInputSettingsParams{
 		InputName: name,
 	})
 }
 
 func (obs *OBS) SetInputSettings(name string, settings map[string]interface{}) (*inputs.SetInputSettingsResponse, error) {
-	return obs.Client.Inputs.SetInputSettings(&inputs.SetInputSettingsParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Inputs.SetInputSettings(&inputs.SetInputSettingsParams{
 		InputName:     name,
 		InputSettings: settings,
 	})
+	if err == nil {
+		obs.State.setInputSettings(name, settings)
+	}
+	return resp, err
 }
 
 func (obs *OBS) GetSceneItemId(scene string, source string) float64 {
-	resp, err := obs.Client.SceneItems.GetSceneItemId(&sceneitems.GetSceneItemIdParams{
+	client, err := obs.client()
+	if err != nil {
+		return -1
+	}
+	resp, err := client.SceneItems.GetSceneItemId(&sceneitems.GetSceneItemIdParams{
 		SceneName:  scene,
 		SourceName: source,
 	})
@@ -491,21 +360,33 @@ func (obs *OBS) GetSceneItemId(scene string, source string) float64 {
 }
 
 func (obs *OBS) GetSceneItemTransform(item_id float64, name string) (*sceneitems.GetSceneItemTransformResponse, error) {
-	return obs.Client.SceneItems.GetSceneItemTransform(&sceneitems.GetSceneItemTransformParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.SceneItems.GetSceneItemTransform(&sceneitems.GetSceneItemTransformParams{
 		SceneItemId: item_id,
 		SceneName:   name,
 	})
 }
 
 func (obs *OBS) RemoveSceneItem(item_id float64, name string) (*sceneitems.RemoveSceneItemResponse, error) {
-	return obs.Client.SceneItems.RemoveSceneItem(&sceneitems.RemoveSceneItemParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.SceneItems.RemoveSceneItem(&sceneitems.RemoveSceneItemParams{
 		SceneItemId: item_id,
 		SceneName:   name,
 	})
 }
 
 func (obs *OBS) CreateInput(kind string, scene string, name string, enabled bool, settings map[string]interface{}) (*inputs.CreateInputResponse, error) {
-	resp, err := obs.Client.Inputs.CreateInput(&inputs.CreateInputParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Inputs.CreateInput(&inputs.CreateInputParams{
 		InputKind:        kind,
 		SceneName:        scene,
 		InputName:        name,
@@ -518,26 +399,42 @@ func (obs *OBS) CreateInput(kind string, scene string, name string, enabled bool
 	return resp, err
 }
 
-func (obs *OBS) CreateSceneItem(name string) (*sceneitems.CreateSceneItemResponse, error) {
-	enabled := true
-	return obs.Client.SceneItems.CreateSceneItem(&sceneitems.CreateSceneItemParams{
+func (obs *OBS) CreateSceneItem(scene string, name string, enabled bool) (*sceneitems.CreateSceneItemResponse, error) {
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.SceneItems.CreateSceneItem(&sceneitems.CreateSceneItemParams{
 		SceneItemEnabled: &enabled,
-		SceneName:        "Main",
+		SceneName:        scene,
 		SourceName:       name,
 	})
 }
 
-func (obs *OBS) SetSceneSourceVisible(item_id float64, name string, visible bool) error {
-	_, err := obs.Client.SceneItems.SetSceneItemEnabled(&sceneitems.SetSceneItemEnabledParams{
-		SceneItemEnabled: &visible,
-		SceneItemId:      item_id,
-		SceneName:        name,
+func (obs *OBS) GetSceneItemList(scene string) ([]*typedefs.SceneItem, error) {
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.SceneItems.GetSceneItemList(&sceneitems.GetSceneItemListParams{
+		SceneName: scene,
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return resp.SceneItems, nil
+}
+
+func (obs *OBS) SetSceneSourceVisible(item_id float64, name string, visible bool) error {
+	return obs.SetSceneItemEnabled(item_id, name, visible)
 }
 
 func (obs *OBS) GetSceneSourceVisible(item_id float64, name string) (*bool, error) {
-	settings, err := obs.Client.SceneItems.GetSceneItemEnabled(&sceneitems.GetSceneItemEnabledParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := client.SceneItems.GetSceneItemEnabled(&sceneitems.GetSceneItemEnabledParams{
 		SceneItemId: item_id,
 		SceneName:   name,
 	})
@@ -548,7 +445,11 @@ func (obs *OBS) GetSceneSourceVisible(item_id float64, name string) (*bool, erro
 }
 
 func (obs *OBS) SetSceneItemTransform(item_id float64, name string, posX, posY, width, height float64) (*sceneitems.SetSceneItemTransformResponse, error) {
-	return obs.Client.SceneItems.SetSceneItemTransform(&sceneitems.SetSceneItemTransformParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.SceneItems.SetSceneItemTransform(&sceneitems.SetSceneItemTransformParams{
 		SceneItemId: item_id,
 		SceneName:   name,
 		SceneItemTransform: &typedefs.SceneItemTransform{
@@ -565,14 +466,22 @@ func (obs *OBS) SetSceneItemTransform(item_id float64, name string, posX, posY,
 }
 
 func (obs *OBS) GetSceneItemIndex(item_id float64, name string) (*sceneitems.GetSceneItemIndexResponse, error) {
-	return obs.Client.SceneItems.GetSceneItemIndex(&sceneitems.GetSceneItemIndexParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.SceneItems.GetSceneItemIndex(&sceneitems.GetSceneItemIndexParams{
 		SceneItemId: item_id,
 		SceneName:   name,
 	})
 }
 
 func (obs *OBS) SetSceneItemIndex(item_id float64, item_index float64, name string) (*sceneitems.SetSceneItemIndexResponse, error) {
-	return obs.Client.SceneItems.SetSceneItemIndex(&sceneitems.SetSceneItemIndexParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.SceneItems.SetSceneItemIndex(&sceneitems.SetSceneItemIndexParams{
 		SceneItemId:    item_id,
 		SceneName:      name,
 		SceneItemIndex: item_index,
@@ -583,37 +492,74 @@ func (obs *OBS) CreateScene(name string) (*scenes.CreateSceneResponse, error) {
 	if len(name) == 0 {
 		return nil, errors.New("Scene name length must be greater than 0")
 	}
-	return obs.Client.Scenes.CreateScene(&scenes.CreateSceneParams{
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Scenes.CreateScene(&scenes.CreateSceneParams{
 		SceneName: name,
 	})
 }
 
 func (obs *OBS) GetSceneList() (*scenes.GetSceneListResponse, error) {
-	return obs.Client.Scenes.GetSceneList()
+	client, err := obs.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Scenes.GetSceneList()
 }
 
 func (obs *OBS) GetStreamStatus() (bool, error) {
-	status, err := obs.Client.Stream.GetStreamStatus()
-	return status.OutputActive, err
+	client, err := obs.client()
+	if err != nil {
+		return false, err
+	}
+	status, err := client.Stream.GetStreamStatus()
+	if err != nil {
+		return false, err
+	}
+	return status.OutputActive, nil
 }
 
 func (obs *OBS) ToggleStream() (bool, error) {
-	status, err := obs.Client.Stream.ToggleStream()
-	return status.OutputActive, err
+	client, err := obs.client()
+	if err != nil {
+		return false, err
+	}
+	status, err := client.Stream.ToggleStream()
+	if err != nil {
+		return false, err
+	}
+	return status.OutputActive, nil
 }
 
 func (obs *OBS) GetRecordStatus() (bool, error) {
-	status, err := obs.Client.Record.GetRecordStatus()
-	return status.OutputActive, err
+	client, err := obs.client()
+	if err != nil {
+		return false, err
+	}
+	status, err := client.Record.GetRecordStatus()
+	if err != nil {
+		return false, err
+	}
+	return status.OutputActive, nil
 }
 
 func (obs *OBS) ToggleRecord() error {
-	_, err := obs.Client.Record.ToggleRecord()
+	client, err := obs.client()
+	if err != nil {
+		return err
+	}
+	_, err = client.Record.ToggleRecord()
 	return err
 }
 
 func (obs *OBS) GetCurrentScene() (string, error) {
-	resp, err := obs.Client.Scenes.GetCurrentProgramScene()
+	client, err := obs.client()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Scenes.GetCurrentProgramScene()
 	if err != nil {
 		return "", err
 	}
@@ -624,7 +570,11 @@ func (obs *OBS) GetCurrentScene() (string, error) {
 }
 
 func (obs *OBS) GetRecordDirectory() (string, error) {
-	resp, err := obs.Client.Config.GetRecordDirectory()
+	client, err := obs.client()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Config.GetRecordDirectory()
 	if err != nil {
 		return "", errors.New("Cannot get current recording directory: " + err.Error())
 	}
@@ -635,7 +585,11 @@ func (obs *OBS) GetRecordDirectory() (string, error) {
 }
 
 func (obs *OBS) GetProfileParameter(parameter string) (string, error) {
-	resp, err := obs.Client.Config.GetProfileParameter(&config.GetProfileParameterParams{
+	client, err := obs.client()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Config.GetProfileParameter(&config.GetProfileParameterParams{
 		ParameterCategory: "Output",
 		ParameterName:     parameter,
 	})
@@ -649,7 +603,11 @@ func (obs *OBS) GetProfileParameter(parameter string) (string, error) {
 }
 
 func (obs *OBS) SetProfileParameter(parameter string, value string) error {
-	_, err := obs.Client.Config.SetProfileParameter(&config.SetProfileParameterParams{
+	client, err := obs.client()
+	if err != nil {
+		return err
+	}
+	_, err = client.Config.SetProfileParameter(&config.SetProfileParameterParams{
 		ParameterCategory: "Output",
 		ParameterName:     parameter,
 		ParameterValue:    value,
@@ -661,12 +619,20 @@ func (obs *OBS) SetProfileParameter(parameter string, value string) error {
 }
 
 func (obs *OBS) PressInputPropertiesButton(params *inputs.PressInputPropertiesButtonParams) error {
-	_, err := obs.Client.Inputs.PressInputPropertiesButton(params)
+	client, err := obs.client()
+	if err != nil {
+		return err
+	}
+	_, err = client.Inputs.PressInputPropertiesButton(params)
 	return err
 }
 
 func (obs *OBS) SetSceneItemEnabled(item_id float64, scene_name string, enabled bool) error {
-	_, err := obs.Client.SceneItems.SetSceneItemEnabled(&sceneitems.SetSceneItemEnabledParams{
+	client, err := obs.client()
+	if err != nil {
+		return err
+	}
+	_, err = client.SceneItems.SetSceneItemEnabled(&sceneitems.SetSceneItemEnabledParams{
 		SceneItemEnabled: &enabled,
 		SceneItemId:      item_id,
 		SceneName:        scene_name,
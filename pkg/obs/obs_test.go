@@ -0,0 +1,56 @@
+package obs_test
+
+import (
+	"testing"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/obs"
+	"github.com/jnrprgmr/strmr/pkg/obs/obstest"
+)
+
+func TestRefreshSourcesTask(t *testing.T) {
+	layout := &obs.Layout{Sources: []obs.LayoutSource{{
+		Name: "strmr-task-text",
+		Kind: obs.SourceTextType,
+		Settings: map[string]interface{}{
+			"text":   "Create Task",
+			"color1": float64(1),
+			"color2": float64(1),
+		},
+	}}}
+	tests := []struct {
+		name     string
+		settings obs.SourceSettings
+		text     string
+		color    float64
+	}{
+		{"nothing stored", obs.SourceSettings{}, "Create Task", 1},
+		{"task text without a style", obs.SourceSettings{TaskText: "writing tests"}, "writing tests", 1},
+		{"task text and style", obs.SourceSettings{TaskText: "writing tests", Task: &database.TaskConfig{SourceConfig: database.SourceConfig{Color: 2, Width: 300, Height: 50}}}, "writing tests", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := obstest.NewServer()
+			defer server.Close()
+			client, err := server.Connect()
+			if err != nil {
+				t.Fatal(err)
+			}
+			controller := obs.New(client, "strmr-screen", "strmr-task-text", "strmr-task-background", "strmr-avatar", "strmr-overlay-text", "strmr-overlay-background")
+			err = controller.RefreshSources(layout, test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			settings, ok := server.Input("strmr-task-text")
+			if !ok {
+				t.Fatal("task source was not created")
+			}
+			if settings["text"] != test.text || settings["color1"] != test.color {
+				t.Errorf("task source has %v, want text %q and color %v", settings, test.text, test.color)
+			}
+		})
+	}
+	if layout.Sources[0].Settings["text"] != "Create Task" {
+		t.Errorf("the layout was changed: %v", layout.Sources[0].Settings)
+	}
+}
@@ -0,0 +1,585 @@
+package obstest
+
+import (
+	"encoding/json"
+
+	"github.com/andreykaipov/goobs/api/events/subscriptions"
+	"github.com/andreykaipov/goobs/api/typedefs"
+)
+
+// request status codes from the obs-websocket protocol
+const (
+	statusSuccess               = 100
+	statusMissingRequestType    = 203
+	statusUnknownRequestType    = 204
+	statusMissingRequestField   = 300
+	statusInvalidRequestField   = 400
+	statusOutputRunning         = 500
+	statusOutputNotRunning      = 501
+	statusResourceNotFound      = 600
+	statusResourceAlreadyExists = 601
+	statusRequestProcessingFail = 702
+)
+
+type requestError struct {
+	code    int
+	comment string
+}
+
+type event struct {
+	subscription int
+	eventType    string
+	data         interface{}
+}
+
+type requestParams struct {
+	SceneName          *string                      `json:"sceneName"`
+	SourceName         *string                      `json:"sourceName"`
+	InputName          *string                      `json:"inputName"`
+	InputKind          *string                      `json:"inputKind"`
+	InputSettings      map[string]interface{}       `json:"inputSettings"`
+	Overlay            *bool                        `json:"overlay"`
+	PropertyName       *string                      `json:"propertyName"`
+	SceneItemID        *float64                     `json:"sceneItemId"`
+	SceneItemEnabled   *bool                        `json:"sceneItemEnabled"`
+	SceneItemIndex     *float64                     `json:"sceneItemIndex"`
+	SceneItemTransform map[string]interface{}       `json:"sceneItemTransform"`
+	ParameterCategory  *string                      `json:"parameterCategory"`
+	ParameterName      *string                      `json:"parameterName"`
+	ParameterValue     *string                      `json:"parameterValue"`
+	Transform          *typedefs.SceneItemTransform `json:"-"`
+}
+
+func (s *Server) request(c *conn, d json.RawMessage) {
+	var req struct {
+		Type string          `json:"requestType"`
+		ID   string          `json:"requestId"`
+		Data json.RawMessage `json:"requestData"`
+	}
+	json.Unmarshal(d, &req)
+	var params requestParams
+	if len(req.Data) > 0 {
+		json.Unmarshal(req.Data, &params)
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req.Type)
+	if req.Type != "" && req.Type == s.dropOn {
+		s.dropOn = ""
+		s.mu.Unlock()
+		s.DropConnections()
+		return
+	}
+	var data interface{}
+	var pending []event
+	var rerr *requestError
+	if n := s.failingRequests[req.Type]; n > 0 {
+		s.failingRequests[req.Type] = n - 1
+		rerr = &requestError{statusRequestProcessingFail, "failure injected by obstest"}
+	} else if req.Type == "" {
+		rerr = &requestError{statusMissingRequestType, "Your request is missing a `requestType`"}
+	} else {
+		data, pending, rerr = s.handle(req.Type, params)
+	}
+	s.mu.Unlock()
+	status := map[string]interface{}{
+		"result": rerr == nil,
+		"code":   statusSuccess,
+	}
+	if rerr != nil {
+		status["code"] = rerr.code
+		status["comment"] = rerr.comment
+	}
+	resp := map[string]interface{}{
+		"requestType":   req.Type,
+		"requestId":     req.ID,
+		"requestStatus": status,
+	}
+	if rerr == nil && data != nil {
+		resp["responseData"] = data
+	}
+	c.write(7, resp)
+	for i := range pending {
+		s.emit(pending[i].subscription, pending[i].eventType, pending[i].data)
+	}
+}
+
+func missing(field string) *requestError {
+	return &requestError{statusMissingRequestField, "Your request is missing the `" + field + "` field."}
+}
+
+func notFound(what string) *requestError {
+	return &requestError{statusResourceNotFound, "No " + what + " was found."}
+}
+
+// handle runs with s.mu held and returns the response data along with events
+// to send once the response has been written.
+func (s *Server) handle(request_type string, p requestParams) (interface{}, []event, *requestError) {
+	switch request_type {
+	case "GetVersion":
+		return map[string]interface{}{
+			"obsVersion":          "29.0.0",
+			"obsWebSocketVersion": "5.0.1",
+			"rpcVersion":          rpcVersion,
+			"platform":            "obstest",
+		}, nil, nil
+	case "GetSceneList":
+		scenes := []map[string]interface{}{}
+		for i := range s.scenes {
+			scenes = append(scenes, map[string]interface{}{
+				"sceneIndex": i,
+				"sceneName":  s.scenes[i],
+			})
+		}
+		return map[string]interface{}{
+			"currentProgramSceneName": s.currentScene,
+			"scenes":                  scenes,
+		}, nil, nil
+	case "GetCurrentProgramScene":
+		return map[string]interface{}{"currentProgramSceneName": s.currentScene}, nil, nil
+	case "SetCurrentProgramScene":
+		if p.SceneName == nil {
+			return nil, nil, missing("sceneName")
+		}
+		if _, ok := s.items[*p.SceneName]; !ok {
+			return nil, nil, notFound("scene")
+		}
+		s.currentScene = *p.SceneName
+		return nil, []event{{subscriptions.Scenes, "CurrentProgramSceneChanged", map[string]string{"sceneName": s.currentScene}}}, nil
+	case "CreateScene":
+		if p.SceneName == nil {
+			return nil, nil, missing("sceneName")
+		}
+		if _, ok := s.items[*p.SceneName]; ok {
+			return nil, nil, &requestError{statusResourceAlreadyExists, "A source already exists by that scene name."}
+		}
+		s.addScene(*p.SceneName)
+		return nil, []event{{subscriptions.Scenes, "SceneCreated", map[string]interface{}{"sceneName": *p.SceneName, "isGroup": false}}}, nil
+	case "GetInputList":
+		inputs := []map[string]string{}
+		for name, in := range s.inputs {
+			if p.InputKind != nil && *p.InputKind != in.Kind {
+				continue
+			}
+			inputs = append(inputs, map[string]string{
+				"inputName":            name,
+				"inputKind":            in.Kind,
+				"unversionedInputKind": in.Kind,
+			})
+		}
+		return map[string]interface{}{"inputs": inputs}, nil, nil
+	case "GetInputSettings":
+		in, rerr := s.input(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		return map[string]interface{}{"inputSettings": in.Settings, "inputKind": in.Kind}, nil, nil
+	case "SetInputSettings":
+		in, rerr := s.input(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.InputSettings == nil {
+			return nil, nil, missing("inputSettings")
+		}
+		if p.Overlay != nil && !*p.Overlay {
+			in.Settings = map[string]interface{}{}
+		}
+		for k, v := range p.InputSettings {
+			in.Settings[k] = v
+		}
+		return nil, nil, nil
+	case "CreateInput":
+		if p.SceneName == nil {
+			return nil, nil, missing("sceneName")
+		}
+		if p.InputName == nil {
+			return nil, nil, missing("inputName")
+		}
+		if p.InputKind == nil {
+			return nil, nil, missing("inputKind")
+		}
+		if _, ok := s.items[*p.SceneName]; !ok {
+			return nil, nil, notFound("scene")
+		}
+		if _, ok := s.inputs[*p.InputName]; ok {
+			return nil, nil, &requestError{statusResourceAlreadyExists, "A source already exists by that input name."}
+		}
+		settings := map[string]interface{}{}
+		for k, v := range p.InputSettings {
+			settings[k] = v
+		}
+		s.inputs[*p.InputName] = &input{Kind: *p.InputKind, Settings: settings}
+		enabled := p.SceneItemEnabled == nil || *p.SceneItemEnabled
+		item := s.addSceneItem(*p.SceneName, *p.InputName, enabled)
+		return map[string]interface{}{"sceneItemId": item.ID}, []event{
+			{subscriptions.Inputs, "InputCreated", map[string]interface{}{
+				"inputName":     *p.InputName,
+				"inputKind":     *p.InputKind,
+				"inputSettings": settings,
+			}},
+			s.sceneItemCreated(*p.SceneName, item),
+		}, nil
+	case "RemoveInput":
+		if _, rerr := s.input(p); rerr != nil {
+			return nil, nil, rerr
+		}
+		delete(s.inputs, *p.InputName)
+		pending := []event{}
+		for scene := range s.items {
+			kept := []*sceneItem{}
+			for _, item := range s.items[scene] {
+				if item.Source == *p.InputName {
+					pending = append(pending, event{subscriptions.SceneItems, "SceneItemRemoved", map[string]interface{}{
+						"sceneName":   scene,
+						"sourceName":  item.Source,
+						"sceneItemId": item.ID,
+					}})
+					continue
+				}
+				kept = append(kept, item)
+			}
+			s.items[scene] = kept
+		}
+		pending = append(pending, event{subscriptions.Inputs, "InputRemoved", map[string]string{"inputName": *p.InputName}})
+		return nil, pending, nil
+	case "PressInputPropertiesButton":
+		if _, rerr := s.input(p); rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.PropertyName == nil {
+			return nil, nil, missing("propertyName")
+		}
+		s.pressedButtons = append(s.pressedButtons, *p.InputName+"/"+*p.PropertyName)
+		return nil, nil, nil
+	case "GetSceneItemList":
+		scene, rerr := s.scene(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		items := []typedefs.SceneItem{}
+		for i, item := range s.items[scene] {
+			items = append(items, s.sceneItemInfo(item, i))
+		}
+		return map[string]interface{}{"sceneItems": items}, nil, nil
+	case "GetSceneItemId":
+		scene, rerr := s.scene(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.SourceName == nil {
+			return nil, nil, missing("sourceName")
+		}
+		for _, item := range s.items[scene] {
+			if item.Source == *p.SourceName {
+				return map[string]interface{}{"sceneItemId": item.ID}, nil, nil
+			}
+		}
+		return nil, nil, notFound("scene items")
+	case "CreateSceneItem":
+		scene, rerr := s.scene(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.SourceName == nil {
+			return nil, nil, missing("sourceName")
+		}
+		if _, ok := s.inputs[*p.SourceName]; !ok {
+			return nil, nil, notFound("source")
+		}
+		enabled := p.SceneItemEnabled == nil || *p.SceneItemEnabled
+		item := s.addSceneItem(scene, *p.SourceName, enabled)
+		return map[string]interface{}{"sceneItemId": item.ID}, []event{s.sceneItemCreated(scene, item)}, nil
+	case "RemoveSceneItem":
+		scene, index, rerr := s.sceneItem(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		item := s.items[scene][index]
+		s.items[scene] = append(s.items[scene][:index], s.items[scene][index+1:]...)
+		return nil, []event{{subscriptions.SceneItems, "SceneItemRemoved", map[string]interface{}{
+			"sceneName":   scene,
+			"sourceName":  item.Source,
+			"sceneItemId": item.ID,
+		}}}, nil
+	case "GetSceneItemTransform":
+		scene, index, rerr := s.sceneItem(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		return map[string]interface{}{"sceneItemTransform": s.items[scene][index].Transform}, nil, nil
+	case "SetSceneItemTransform":
+		scene, index, rerr := s.sceneItem(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.SceneItemTransform == nil {
+			return nil, nil, missing("sceneItemTransform")
+		}
+		item := s.items[scene][index]
+		item.Transform = mergeTransform(item.Transform, p.SceneItemTransform)
+		return nil, []event{{subscriptions.SceneItemTransformChanged, "SceneItemTransformChanged", map[string]interface{}{
+			"sceneName":          scene,
+			"sceneItemId":        item.ID,
+			"sceneItemTransform": item.Transform,
+		}}}, nil
+	case "GetSceneItemEnabled":
+		scene, index, rerr := s.sceneItem(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		return map[string]interface{}{"sceneItemEnabled": s.items[scene][index].Enabled}, nil, nil
+	case "SetSceneItemEnabled":
+		scene, index, rerr := s.sceneItem(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.SceneItemEnabled == nil {
+			return nil, nil, missing("sceneItemEnabled")
+		}
+		item := s.items[scene][index]
+		item.Enabled = *p.SceneItemEnabled
+		return nil, []event{{subscriptions.SceneItems, "SceneItemEnableStateChanged", map[string]interface{}{
+			"sceneName":        scene,
+			"sceneItemId":      item.ID,
+			"sceneItemEnabled": item.Enabled,
+		}}}, nil
+	case "GetSceneItemIndex":
+		_, index, rerr := s.sceneItem(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		return map[string]interface{}{"sceneItemIndex": index}, nil, nil
+	case "SetSceneItemIndex":
+		scene, index, rerr := s.sceneItem(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.SceneItemIndex == nil {
+			return nil, nil, missing("sceneItemIndex")
+		}
+		to := int(*p.SceneItemIndex)
+		items := s.items[scene]
+		if to < 0 || to >= len(items) {
+			return nil, nil, &requestError{statusInvalidRequestField, "The field value of `sceneItemIndex` is out of range."}
+		}
+		item := items[index]
+		items = append(items[:index], items[index+1:]...)
+		items = append(items[:to], append([]*sceneItem{item}, items[to:]...)...)
+		s.items[scene] = items
+		reindexed := []map[string]int{}
+		for i := range items {
+			reindexed = append(reindexed, map[string]int{"sceneItemId": items[i].ID, "sceneItemIndex": i})
+		}
+		return nil, []event{{subscriptions.SceneItems, "SceneItemListReindexed", map[string]interface{}{
+			"sceneName":  scene,
+			"sceneItems": reindexed,
+		}}}, nil
+	case "GetStreamStatus":
+		return map[string]interface{}{"outputActive": s.streaming}, nil, nil
+	case "ToggleStream":
+		s.streaming = !s.streaming
+		return map[string]interface{}{"outputActive": s.streaming}, []event{s.streamStateChanged()}, nil
+	case "StartStream":
+		if s.streaming {
+			return nil, nil, &requestError{statusOutputRunning, "The stream output is already running."}
+		}
+		s.streaming = true
+		return nil, []event{s.streamStateChanged()}, nil
+	case "StopStream":
+		if !s.streaming {
+			return nil, nil, &requestError{statusOutputNotRunning, "The stream output is not running."}
+		}
+		s.streaming = false
+		return nil, []event{s.streamStateChanged()}, nil
+	case "GetRecordStatus":
+		return map[string]interface{}{"outputActive": s.recording}, nil, nil
+	case "ToggleRecord":
+		s.recording = !s.recording
+		return nil, []event{s.recordStateChanged()}, nil
+	case "StartRecord":
+		if s.recording {
+			return nil, nil, &requestError{statusOutputRunning, "The record output is already running."}
+		}
+		s.recording = true
+		return nil, []event{s.recordStateChanged()}, nil
+	case "StopRecord":
+		if !s.recording {
+			return nil, nil, &requestError{statusOutputNotRunning, "The record output is not running."}
+		}
+		s.recording = false
+		return map[string]string{"outputPath": s.recordPath()}, []event{s.recordStateChanged()}, nil
+	case "GetRecordDirectory":
+		return map[string]string{"recordDirectory": s.recordDirectory}, nil, nil
+	case "GetProfileParameter":
+		key, rerr := profileKey(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		return map[string]string{"parameterValue": s.profile[key]}, nil, nil
+	case "SetProfileParameter":
+		key, rerr := profileKey(p)
+		if rerr != nil {
+			return nil, nil, rerr
+		}
+		if p.ParameterValue == nil {
+			return nil, nil, missing("parameterValue")
+		}
+		s.profile[key] = *p.ParameterValue
+		return nil, nil, nil
+	}
+	return nil, nil, &requestError{statusUnknownRequestType, "Your request type is not valid."}
+}
+
+func (s *Server) input(p requestParams) (*input, *requestError) {
+	if p.InputName == nil {
+		return nil, missing("inputName")
+	}
+	in, ok := s.inputs[*p.InputName]
+	if !ok {
+		return nil, notFound("source")
+	}
+	return in, nil
+}
+
+func (s *Server) scene(p requestParams) (string, *requestError) {
+	if p.SceneName == nil {
+		return "", missing("sceneName")
+	}
+	if _, ok := s.items[*p.SceneName]; !ok {
+		return "", notFound("scene")
+	}
+	return *p.SceneName, nil
+}
+
+func (s *Server) sceneItem(p requestParams) (string, int, *requestError) {
+	scene, rerr := s.scene(p)
+	if rerr != nil {
+		return "", 0, rerr
+	}
+	if p.SceneItemID == nil {
+		return "", 0, missing("sceneItemId")
+	}
+	for i, item := range s.items[scene] {
+		if item.ID == int(*p.SceneItemID) {
+			return scene, i, nil
+		}
+	}
+	return "", 0, notFound("scene item")
+}
+
+func profileKey(p requestParams) (string, *requestError) {
+	if p.ParameterCategory == nil {
+		return "", missing("parameterCategory")
+	}
+	if p.ParameterName == nil {
+		return "", missing("parameterName")
+	}
+	return *p.ParameterCategory + "/" + *p.ParameterName, nil
+}
+
+func (s *Server) addScene(name string) {
+	s.scenes = append(s.scenes, name)
+	s.items[name] = []*sceneItem{}
+}
+
+func (s *Server) addSceneItem(scene string, source string, enabled bool) *sceneItem {
+	item := &sceneItem{
+		ID:      s.nextItemID,
+		Source:  source,
+		Enabled: enabled,
+		Transform: typedefs.SceneItemTransform{
+			ScaleX:     1,
+			ScaleY:     1,
+			Alignment:  5,
+			BoundsType: "OBS_BOUNDS_NONE",
+		},
+	}
+	s.nextItemID++
+	s.items[scene] = append(s.items[scene], item)
+	return item
+}
+
+func (s *Server) sceneItemInfo(item *sceneItem, index int) typedefs.SceneItem {
+	kind := ""
+	if in, ok := s.inputs[item.Source]; ok {
+		kind = in.Kind
+	}
+	return typedefs.SceneItem{
+		InputKind:          kind,
+		SceneItemEnabled:   item.Enabled,
+		SceneItemID:        item.ID,
+		SceneItemIndex:     index,
+		SceneItemTransform: item.Transform,
+		SourceName:         item.Source,
+		SourceType:         "OBS_SOURCE_TYPE_INPUT",
+	}
+}
+
+func (s *Server) sceneItemCreated(scene string, item *sceneItem) event {
+	index := 0
+	for i := range s.items[scene] {
+		if s.items[scene][i] == item {
+			index = i
+		}
+	}
+	return event{subscriptions.SceneItems, "SceneItemCreated", map[string]interface{}{
+		"sceneName":      scene,
+		"sourceName":     item.Source,
+		"sceneItemId":    item.ID,
+		"sceneItemIndex": index,
+	}}
+}
+
+func (s *Server) streamStateChanged() event {
+	state := "OBS_WEBSOCKET_OUTPUT_STOPPED"
+	if s.streaming {
+		state = "OBS_WEBSOCKET_OUTPUT_STARTED"
+	}
+	return event{subscriptions.Outputs, "StreamStateChanged", map[string]interface{}{
+		"outputActive": s.streaming,
+		"outputState":  state,
+	}}
+}
+
+func (s *Server) recordStateChanged() event {
+	state := "OBS_WEBSOCKET_OUTPUT_STOPPED"
+	data := map[string]interface{}{
+		"outputActive": s.recording,
+	}
+	if s.recording {
+		state = "OBS_WEBSOCKET_OUTPUT_STARTED"
+	} else {
+		data["outputPath"] = s.recordPath()
+	}
+	data["outputState"] = state
+	return event{subscriptions.Outputs, "RecordStateChanged", data}
+}
+
+func (s *Server) recordPath() string {
+	name := s.profile["Output/FilenameFormatting"]
+	if name == "" {
+		name = "recording"
+	}
+	return s.recordDirectory + "/" + name + ".mkv"
+}
+
+// mergeTransform only overwrites the fields present in the request, the same
+// as OBS does.
+func mergeTransform(current typedefs.SceneItemTransform, changes map[string]interface{}) typedefs.SceneItemTransform {
+	b, err := json.Marshal(current)
+	if err != nil {
+		return current
+	}
+	merged := map[string]interface{}{}
+	json.Unmarshal(b, &merged)
+	for k, v := range changes {
+		merged[k] = v
+	}
+	b, err = json.Marshal(merged)
+	if err != nil {
+		return current
+	}
+	t := current
+	json.Unmarshal(b, &t)
+	return t
+}
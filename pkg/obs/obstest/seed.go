@@ -0,0 +1,132 @@
+package obstest
+
+import (
+	"github.com/andreykaipov/goobs/api/events/subscriptions"
+	"github.com/andreykaipov/goobs/api/typedefs"
+)
+
+// AddScene creates a scene as if it was added in the OBS UI.
+func (s *Server) AddScene(name string) {
+	s.mu.Lock()
+	if _, ok := s.items[name]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.addScene(name)
+	s.mu.Unlock()
+	s.emit(subscriptions.Scenes, "SceneCreated", map[string]interface{}{"sceneName": name, "isGroup": false})
+}
+
+// AddInput creates an input and a scene item for it in the given scene as if
+// it was added in the OBS UI, the scene is created first if it is missing.
+func (s *Server) AddInput(scene string, name string, kind string, settings map[string]interface{}) int {
+	s.AddScene(scene)
+	s.mu.Lock()
+	copied := map[string]interface{}{}
+	for k, v := range settings {
+		copied[k] = v
+	}
+	s.inputs[name] = &input{Kind: kind, Settings: copied}
+	item := s.addSceneItem(scene, name, true)
+	created := s.sceneItemCreated(scene, item)
+	s.mu.Unlock()
+	s.emit(subscriptions.Inputs, "InputCreated", map[string]interface{}{
+		"inputName":     name,
+		"inputKind":     kind,
+		"inputSettings": copied,
+	})
+	s.emit(created.subscription, created.eventType, created.data)
+	return item.ID
+}
+
+// SetStreaming starts or stops the stream as if it was done in the OBS UI.
+func (s *Server) SetStreaming(streaming bool) {
+	s.mu.Lock()
+	s.streaming = streaming
+	e := s.streamStateChanged()
+	s.mu.Unlock()
+	s.emit(e.subscription, e.eventType, e.data)
+}
+
+// SetRecording starts or stops the recording as if it was done in the OBS UI.
+func (s *Server) SetRecording(recording bool) {
+	s.mu.Lock()
+	s.recording = recording
+	e := s.recordStateChanged()
+	s.mu.Unlock()
+	s.emit(e.subscription, e.eventType, e.data)
+}
+
+// FailNext makes the next n requests of the given type fail.
+func (s *Server) FailNext(request_type string, n int) {
+	s.mu.Lock()
+	s.failingRequests[request_type] = n
+	s.mu.Unlock()
+}
+
+// DropOn makes the server drop every connection instead of answering the
+// next request of the given type, like OBS crashing in the middle of a
+// handler.
+func (s *Server) DropOn(request_type string) {
+	s.mu.Lock()
+	s.dropOn = request_type
+	s.mu.Unlock()
+}
+
+// Input returns a copy of the settings of an input.
+func (s *Server) Input(name string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	in, ok := s.inputs[name]
+	if !ok {
+		return nil, false
+	}
+	settings := map[string]interface{}{}
+	for k, v := range in.Settings {
+		settings[k] = v
+	}
+	return settings, true
+}
+
+// SceneItems returns the items of a scene from the bottom up.
+func (s *Server) SceneItems(scene string) []typedefs.SceneItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := []typedefs.SceneItem{}
+	for i, item := range s.items[scene] {
+		items = append(items, s.sceneItemInfo(item, i))
+	}
+	return items
+}
+
+func (s *Server) CurrentScene() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.currentScene
+}
+
+func (s *Server) Streaming() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streaming
+}
+
+func (s *Server) Recording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recording
+}
+
+// Requests returns the type of every request received, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// PressedButtons returns every button pressed as "input/property".
+func (s *Server) PressedButtons() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.pressedButtons...)
+}
@@ -0,0 +1,283 @@
+// Package obstest provides an in-process OBS websocket v5 server that speaks
+// enough of the protocol for goobs to connect and drive scenes, inputs, scene
+// items, streaming, recording and profile parameters.
+package obstest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreykaipov/goobs"
+	"github.com/andreykaipov/goobs/api/events/subscriptions"
+	"github.com/andreykaipov/goobs/api/typedefs"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	rpcVersion = 1
+	salt       = "c3RybXItc2FsdA=="
+	challenge  = "c3RybXItY2hhbGxlbmdl"
+)
+
+type message struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+type input struct {
+	Kind     string
+	Settings map[string]interface{}
+}
+
+type sceneItem struct {
+	ID        int
+	Source    string
+	Enabled   bool
+	Transform typedefs.SceneItemTransform
+}
+
+type conn struct {
+	mu            sync.Mutex
+	ws            *websocket.Conn
+	subscriptions int
+	identified    bool
+}
+
+func (c *conn) write(op int, d interface{}) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(message{Op: op, D: b})
+}
+
+// Server is a fake OBS. It starts with a single "Scene" as the current program
+// scene and no inputs, use the seeding methods to build up a starting state.
+type Server struct {
+	Password string
+
+	http     *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu              sync.Mutex
+	serving         sync.WaitGroup
+	conns           map[*conn]bool
+	scenes          []string
+	currentScene    string
+	inputs          map[string]*input
+	items           map[string][]*sceneItem
+	nextItemID      int
+	streaming       bool
+	recording       bool
+	recordDirectory string
+	profile         map[string]string
+	requests        []string
+	pressedButtons  []string
+	failingRequests map[string]int
+	dropOn          string
+}
+
+func NewServer() *Server {
+	s := &Server{
+		conns:           map[*conn]bool{},
+		scenes:          []string{"Scene"},
+		currentScene:    "Scene",
+		inputs:          map[string]*input{},
+		items:           map[string][]*sceneItem{"Scene": {}},
+		nextItemID:      1,
+		recordDirectory: "/tmp",
+		profile:         map[string]string{},
+		failingRequests: map[string]int{},
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Addr is the host:port to pass to goobs.New.
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.http.URL, "http://")
+}
+
+func (s *Server) Connect(opts ...goobs.Option) (*goobs.Client, error) {
+	opts = append([]goobs.Option{goobs.WithPassword(s.Password)}, opts...)
+	return goobs.New(s.Addr(), opts...)
+}
+
+// DropConnections closes every client connection without a close handshake,
+// which looks the same to clients as OBS crashing.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = map[*conn]bool{}
+	s.mu.Unlock()
+	for c := range conns {
+		c.ws.UnderlyingConn().Close()
+	}
+}
+
+// Close shuts the server down the way OBS does when it exits, every client
+// is sent a close message and the server waits a moment for them to answer.
+// Dropping connections with unread data resets them, which goobs does not
+// stop reading after.
+func (s *Server) Close() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = map[*conn]bool{}
+	s.mu.Unlock()
+	for c := range conns {
+		s.closeWith(c, websocket.CloseGoingAway, "OBS is shutting down")
+	}
+	closed := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		for c := range conns {
+			c.ws.UnderlyingConn().Close()
+		}
+	}
+	s.http.Close()
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{ws: ws}
+	s.mu.Lock()
+	s.conns[c] = true
+	s.serving.Add(1)
+	s.mu.Unlock()
+	defer s.serving.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		ws.Close()
+	}()
+	hello := map[string]interface{}{
+		"obsWebSocketVersion": "5.0.1",
+		"rpcVersion":          rpcVersion,
+	}
+	if s.Password != "" {
+		hello["authentication"] = map[string]string{
+			"challenge": challenge,
+			"salt":      salt,
+		}
+	}
+	if c.write(0, hello) != nil {
+		return
+	}
+	for {
+		var m message
+		err := ws.ReadJSON(&m)
+		if err != nil {
+			return
+		}
+		switch m.Op {
+		case 1:
+			if !s.identify(c, m.D) {
+				return
+			}
+		case 3:
+			var d struct {
+				EventSubscriptions *int `json:"eventSubscriptions"`
+			}
+			json.Unmarshal(m.D, &d)
+			if d.EventSubscriptions != nil {
+				c.mu.Lock()
+				c.subscriptions = *d.EventSubscriptions
+				c.mu.Unlock()
+			}
+		case 6:
+			if !c.identified {
+				s.closeWith(c, 4007, "not identified")
+				return
+			}
+			s.request(c, m.D)
+		default:
+			// goobs probes with a v4 message first, a real server closes
+			s.closeWith(c, 4006, "unknown op code")
+			return
+		}
+	}
+}
+
+func (s *Server) closeWith(c *conn, code int, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+}
+
+func (s *Server) identify(c *conn, d json.RawMessage) bool {
+	var identify struct {
+		RPCVersion         int    `json:"rpcVersion"`
+		Authentication     string `json:"authentication"`
+		EventSubscriptions *int   `json:"eventSubscriptions"`
+	}
+	err := json.Unmarshal(d, &identify)
+	if err != nil {
+		s.closeWith(c, 4005, "invalid identify")
+		return false
+	}
+	if s.Password != "" && identify.Authentication != expectedAuthentication(s.Password) {
+		s.closeWith(c, 4009, "Authentication failed.")
+		return false
+	}
+	c.mu.Lock()
+	c.subscriptions = subscriptions.All
+	if identify.EventSubscriptions != nil {
+		c.subscriptions = *identify.EventSubscriptions
+	}
+	c.identified = true
+	c.mu.Unlock()
+	return c.write(2, map[string]int{"negotiatedRpcVersion": rpcVersion}) == nil
+}
+
+func expectedAuthentication(password string) string {
+	secret := sha256.Sum256([]byte(password + salt))
+	s := base64.StdEncoding.EncodeToString(secret[:])
+	auth := sha256.Sum256([]byte(s + challenge))
+	return base64.StdEncoding.EncodeToString(auth[:])
+}
+
+// emit sends an event to every identified client subscribed to its category,
+// it must be called without s.mu held.
+func (s *Server) emit(subscription int, event_type string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	conns := []*conn{}
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for i := range conns {
+		c := conns[i]
+		c.mu.Lock()
+		subscribed := c.identified && c.subscriptions&subscription != 0
+		c.mu.Unlock()
+		if !subscribed {
+			continue
+		}
+		c.write(5, map[string]interface{}{
+			"eventType":   event_type,
+			"eventIntent": subscription,
+			"eventData":   json.RawMessage(b),
+		})
+	}
+}
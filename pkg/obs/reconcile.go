@@ -0,0 +1,254 @@
+package obs
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/andreykaipov/goobs/api/requests/inputs"
+	"github.com/andreykaipov/goobs/api/typedefs"
+)
+
+// Reconcile diffs the layout against the live scene and only sends the
+// requests needed to make the scene match, running it twice is a no-op.
+// Buttons are only pressed on sources that were created or had their
+// settings changed.
+func (obs *OBS) Reconcile(layout *Layout) error {
+	scene := layout.Scene
+	if scene == "" {
+		current_scene, err := obs.GetCurrentScene()
+		if err != nil {
+			return errors.New("Cannot get current scene in Reconcile: " + err.Error())
+		}
+		scene = current_scene
+	}
+	items, err := obs.GetSceneItemList(scene)
+	if err != nil {
+		return errors.New("Cannot get scene items in Reconcile: " + err.Error())
+	}
+	existing := map[string]*typedefs.SceneItem{}
+	for i := range items {
+		existing[items[i].SourceName] = items[i]
+	}
+	created := false
+	// changed are the sources created or with new settings, the ones whose
+	// buttons are pressed
+	changed := map[string]bool{}
+	for i := range layout.Sources {
+		source := layout.Sources[i]
+		item, ok := existing[source.Name]
+		if !ok {
+			err = obs.createLayoutSource(scene, source)
+			if err != nil {
+				return errors.New("Cannot create [" + source.Name + "] in Reconcile: " + err.Error())
+			}
+			created = true
+			changed[source.Name] = true
+			continue
+		}
+		settings_changed, err := obs.updateLayoutSource(scene, source, item)
+		if err != nil {
+			return errors.New("Cannot update [" + source.Name + "] in Reconcile: " + err.Error())
+		}
+		changed[source.Name] = settings_changed
+	}
+	if created {
+		items, err = obs.GetSceneItemList(scene)
+		if err != nil {
+			return errors.New("Cannot get scene items in Reconcile: " + err.Error())
+		}
+	}
+	err = obs.reorderSceneItems(scene, layout, items)
+	if err != nil {
+		return errors.New("Cannot reorder scene items in Reconcile: " + err.Error())
+	}
+	for i := range layout.Sources {
+		source := layout.Sources[i]
+		if !changed[source.Name] {
+			continue
+		}
+		for j := range source.Press {
+			err = obs.PressInputPropertiesButton(&inputs.PressInputPropertiesButtonParams{
+				InputName:    source.Name,
+				PropertyName: source.Press[j],
+			})
+			if err != nil {
+				return errors.New("Cannot press [" + source.Press[j] + "] on [" + source.Name + "] in Reconcile: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (obs *OBS) createLayoutSource(scene string, source LayoutSource) error {
+	settings := copySettings(source.InitialSettings)
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	for k, v := range source.Settings {
+		settings[k] = v
+	}
+	enabled := true
+	if source.Visible != nil {
+		enabled = *source.Visible
+	}
+	client, err := obs.client()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Inputs.CreateInput(&inputs.CreateInputParams{
+		InputKind:        source.Kind,
+		SceneName:        scene,
+		InputName:        source.Name,
+		SceneItemEnabled: &enabled,
+		InputSettings:    settings,
+	})
+	item_id := float64(0)
+	if err != nil {
+		if !strings.Contains(err.Error(), "601") { // resource already exists
+			return err
+		}
+		// the input lives in another scene so only the scene item is missing
+		item, err := obs.CreateSceneItem(scene, source.Name, enabled)
+		if err != nil {
+			return err
+		}
+		item_id = item.SceneItemId
+		if len(source.Settings) > 0 {
+			_, err = obs.SetInputSettings(source.Name, source.Settings)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		item_id = resp.SceneItemId
+	}
+	if source.Transform != nil {
+		t := source.Transform
+		_, err = obs.SetSceneItemTransform(item_id, scene, t.PosX, t.PosY, t.Width, t.Height)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateLayoutSource makes an existing scene item match source and reports
+// whether its settings had to be changed.
+func (obs *OBS) updateLayoutSource(scene string, source LayoutSource, item *typedefs.SceneItem) (bool, error) {
+	item_id := float64(item.SceneItemID)
+	settings_changed := false
+	if len(source.Settings) > 0 {
+		current, err := obs.GetInputSettings(source.Name)
+		if err != nil {
+			return false, err
+		}
+		if !settingsMatch(source.Settings, current.InputSettings) {
+			_, err = obs.SetInputSettings(source.Name, source.Settings)
+			if err != nil {
+				return false, err
+			}
+			settings_changed = true
+		}
+	}
+	if source.Transform != nil && !transformMatches(*source.Transform, item.SceneItemTransform) {
+		t := source.Transform
+		_, err := obs.SetSceneItemTransform(item_id, scene, t.PosX, t.PosY, t.Width, t.Height)
+		if err != nil {
+			return settings_changed, err
+		}
+	}
+	if source.Visible != nil && *source.Visible != item.SceneItemEnabled {
+		err := obs.SetSceneItemEnabled(item_id, scene, *source.Visible)
+		if err != nil {
+			return settings_changed, err
+		}
+	}
+	return settings_changed, nil
+}
+
+// reorderSceneItems keeps unmanaged items where they are and fills the slots
+// used by managed items in layout order, index 0 being the bottom of the scene.
+func (obs *OBS) reorderSceneItems(scene string, layout *Layout, items []*typedefs.SceneItem) error {
+	current := append([]*typedefs.SceneItem{}, items...)
+	sort.Slice(current, func(i, j int) bool {
+		return current[i].SceneItemIndex < current[j].SceneItemIndex
+	})
+	by_name := map[string]*typedefs.SceneItem{}
+	for i := range current {
+		by_name[current[i].SourceName] = current[i]
+	}
+	managed := []*typedefs.SceneItem{}
+	for i := range layout.Sources {
+		if item, ok := by_name[layout.Sources[i].Name]; ok {
+			managed = append(managed, item)
+		}
+	}
+	target := make([]*typedefs.SceneItem, len(current))
+	next := 0
+	for i := range current {
+		if layout.Source(current[i].SourceName) != nil {
+			target[i] = managed[next]
+			next++
+			continue
+		}
+		target[i] = current[i]
+	}
+	// walk down from the top so items only ever move up, goobs drops a
+	// sceneItemIndex of 0 from the request since the field is omitempty
+	for p := len(target) - 1; p >= 0; p-- {
+		if current[p] == target[p] {
+			continue
+		}
+		q := p - 1
+		for q >= 0 && current[q] != target[p] {
+			q--
+		}
+		if q < 0 {
+			return errors.New("scene item [" + target[p].SourceName + "] disappeared while reordering")
+		}
+		_, err := obs.SetSceneItemIndex(float64(target[p].SceneItemID), float64(p), scene)
+		if err != nil {
+			return err
+		}
+		moved := current[q]
+		copy(current[q:p], current[q+1:p+1])
+		current[p] = moved
+	}
+	return nil
+}
+
+// settingsMatch compares through JSON since OBS returns every number as a
+// float64 while the layout document decodes whole numbers as ints.
+func settingsMatch(want map[string]interface{}, have map[string]interface{}) bool {
+	for k, v := range want {
+		h, ok := have[k]
+		if !ok {
+			return false
+		}
+		wb, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		hb, err := json.Marshal(h)
+		if err != nil {
+			return false
+		}
+		if string(wb) != string(hb) {
+			return false
+		}
+	}
+	return true
+}
+
+func transformMatches(want Transform, have typedefs.SceneItemTransform) bool {
+	const epsilon = 0.5
+	return math.Abs(want.PosX-have.PositionX) < epsilon &&
+		math.Abs(want.PosY-have.PositionY) < epsilon &&
+		math.Abs(want.Width-have.BoundsWidth) < epsilon &&
+		math.Abs(want.Height-have.BoundsHeight) < epsilon &&
+		have.Alignment == 5 &&
+		have.BoundsType == "OBS_BOUNDS_MAX_ONLY"
+}
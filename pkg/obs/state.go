@@ -0,0 +1,342 @@
+package obs
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/andreykaipov/goobs/api/events"
+	"github.com/andreykaipov/goobs/api/typedefs"
+)
+
+type SceneItemState struct {
+	SceneName  string
+	SourceName string
+	ItemID     float64
+	Index      int
+	Enabled    bool
+	Transform  typedefs.SceneItemTransform
+}
+
+// State is a snapshot of OBS kept up to date from websocket events. The
+// protocol has no event for input settings changes so Inputs only reflects
+// settings made through strmr and the settings inputs were created with.
+type State struct {
+	CurrentScene string
+	Scenes       []string
+	Streaming    bool
+	Recording    bool
+	RecordPath   string
+	Inputs       map[string]map[string]interface{}
+	SceneItems   map[string][]SceneItemState
+}
+
+func (s State) SceneItem(scene string, source string) (SceneItemState, bool) {
+	items := s.SceneItems[scene]
+	for i := range items {
+		if items[i].SourceName == source {
+			return items[i], true
+		}
+	}
+	return SceneItemState{}, false
+}
+
+func (s State) InputSettings(name string) (map[string]interface{}, bool) {
+	settings, ok := s.Inputs[name]
+	return settings, ok
+}
+
+func (s State) copy() State {
+	c := s
+	c.Scenes = append([]string{}, s.Scenes...)
+	c.Inputs = map[string]map[string]interface{}{}
+	for k, v := range s.Inputs {
+		c.Inputs[k] = copySettings(v)
+	}
+	c.SceneItems = map[string][]SceneItemState{}
+	for k, v := range s.SceneItems {
+		c.SceneItems[k] = append([]SceneItemState{}, v...)
+	}
+	return c
+}
+
+// StateCache is safe for concurrent use, subscribers receive every goobs
+// event after it has been applied to the cached state.
+type StateCache struct {
+	mu          sync.RWMutex
+	state       State
+	synced      bool
+	subscribers map[chan interface{}]bool
+}
+
+func NewStateCache() *StateCache {
+	return &StateCache{
+		state: State{
+			Inputs:     map[string]map[string]interface{}{},
+			SceneItems: map[string][]SceneItemState{},
+		},
+		subscribers: map[chan interface{}]bool{},
+	}
+}
+
+func (c *StateCache) Snapshot() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state.copy()
+}
+
+func (c *StateCache) Synced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.synced
+}
+
+// Invalidate marks the cached state as stale until the next Set.
+func (c *StateCache) Invalidate() {
+	c.mu.Lock()
+	c.synced = false
+	c.mu.Unlock()
+}
+
+func (c *StateCache) Set(state State) {
+	c.mu.Lock()
+	c.state = state.copy()
+	c.synced = true
+	c.mu.Unlock()
+}
+
+// Subscribe returns a channel of events and a function to stop receiving
+// them. Slow subscribers miss events instead of blocking the cache.
+func (c *StateCache) Subscribe() (<-chan interface{}, func()) {
+	ch := make(chan interface{}, 100)
+	c.mu.Lock()
+	c.subscribers[ch] = true
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		if c.subscribers[ch] {
+			delete(c.subscribers, ch)
+			close(ch)
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *StateCache) publish(event interface{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for ch := range c.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Apply updates the cached state from a goobs event and notifies subscribers.
+func (c *StateCache) Apply(event interface{}) {
+	c.mu.Lock()
+	s := &c.state
+	switch e := event.(type) {
+	case *events.CurrentProgramSceneChanged:
+		s.CurrentScene = e.SceneName
+	case *events.SceneListChanged:
+		s.Scenes = []string{}
+		for i := range e.Scenes {
+			s.Scenes = append(s.Scenes, e.Scenes[i].SceneName)
+		}
+	case *events.SceneCreated:
+		if !e.IsGroup {
+			s.Scenes = append(removeString(s.Scenes, e.SceneName), e.SceneName)
+		}
+	case *events.SceneRemoved:
+		s.Scenes = removeString(s.Scenes, e.SceneName)
+		delete(s.SceneItems, e.SceneName)
+	case *events.SceneNameChanged:
+		for i := range s.Scenes {
+			if s.Scenes[i] == e.OldSceneName {
+				s.Scenes[i] = e.SceneName
+			}
+		}
+		if items, ok := s.SceneItems[e.OldSceneName]; ok {
+			for i := range items {
+				items[i].SceneName = e.SceneName
+			}
+			s.SceneItems[e.SceneName] = items
+			delete(s.SceneItems, e.OldSceneName)
+		}
+		if s.CurrentScene == e.OldSceneName {
+			s.CurrentScene = e.SceneName
+		}
+	case *events.StreamStateChanged:
+		s.Streaming = e.OutputActive
+	case *events.RecordStateChanged:
+		s.Recording = e.OutputActive
+		if e.OutputPath != "" {
+			s.RecordPath = e.OutputPath
+		}
+	case *events.InputCreated:
+		s.Inputs[e.InputName] = normalizeSettings(e.InputSettings)
+	case *events.InputRemoved:
+		delete(s.Inputs, e.InputName)
+	case *events.InputNameChanged:
+		s.Inputs[e.InputName] = s.Inputs[e.OldInputName]
+		delete(s.Inputs, e.OldInputName)
+		for scene := range s.SceneItems {
+			items := s.SceneItems[scene]
+			for i := range items {
+				if items[i].SourceName == e.OldInputName {
+					items[i].SourceName = e.InputName
+				}
+			}
+		}
+	case *events.SceneItemCreated:
+		// events queued before SyncState can describe items it already loaded
+		if item := s.sceneItemByID(e.SceneName, e.SceneItemId); item != nil {
+			break
+		}
+		s.SceneItems[e.SceneName] = append(s.SceneItems[e.SceneName], SceneItemState{
+			SceneName:  e.SceneName,
+			SourceName: e.SourceName,
+			ItemID:     e.SceneItemId,
+			Index:      int(e.SceneItemIndex),
+			Enabled:    true,
+		})
+	case *events.SceneItemRemoved:
+		items := s.SceneItems[e.SceneName]
+		for i := range items {
+			if items[i].ItemID == e.SceneItemId {
+				s.SceneItems[e.SceneName] = append(items[:i], items[i+1:]...)
+				break
+			}
+		}
+	case *events.SceneItemEnableStateChanged:
+		if item := s.sceneItemByID(e.SceneName, e.SceneItemId); item != nil {
+			item.Enabled = e.SceneItemEnabled
+		}
+	case *events.SceneItemTransformChanged:
+		if item := s.sceneItemByID(e.SceneName, e.SceneItemId); item != nil && e.SceneItemTransform != nil {
+			item.Transform = *e.SceneItemTransform
+		}
+	case *events.SceneItemListReindexed:
+		for i := range e.SceneItems {
+			if item := s.sceneItemByID(e.SceneName, float64(e.SceneItems[i].SceneItemID)); item != nil {
+				item.Index = e.SceneItems[i].SceneItemIndex
+			}
+		}
+	}
+	c.mu.Unlock()
+	c.publish(event)
+}
+
+// setInputSettings mirrors a successful SetInputSettings request, which merges
+// the given settings into the existing ones.
+func (c *StateCache) setInputSettings(name string, settings map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current, ok := c.state.Inputs[name]
+	if !ok {
+		current = map[string]interface{}{}
+	}
+	for k, v := range normalizeSettings(settings) {
+		current[k] = v
+	}
+	c.state.Inputs[name] = current
+}
+
+func (s *State) sceneItemByID(scene string, item_id float64) *SceneItemState {
+	items := s.SceneItems[scene]
+	for i := range items {
+		if items[i].ItemID == item_id {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+// normalizeSettings round trips through JSON so numbers are always float64,
+// the same as settings returned by OBS.
+func normalizeSettings(settings map[string]interface{}) map[string]interface{} {
+	normalized := map[string]interface{}{}
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return copySettings(settings)
+	}
+	err = json.Unmarshal(b, &normalized)
+	if err != nil {
+		return copySettings(settings)
+	}
+	return normalized
+}
+
+func removeString(values []string, value string) []string {
+	kept := []string{}
+	for i := range values {
+		if values[i] != value {
+			kept = append(kept, values[i])
+		}
+	}
+	return kept
+}
+
+// SyncState replaces the cached state with the live OBS state, events queued
+// while it runs are applied on top of it once the supervisor listens again.
+func (obs *OBS) SyncState() error {
+	state := State{
+		Inputs:     map[string]map[string]interface{}{},
+		SceneItems: map[string][]SceneItemState{},
+	}
+	current_scene, err := obs.GetCurrentScene()
+	if err != nil {
+		return errors.New("Cannot get current scene in SyncState: " + err.Error())
+	}
+	state.CurrentScene = current_scene
+	scenes, err := obs.GetSceneList()
+	if err != nil {
+		return errors.New("Cannot get scenes in SyncState: " + err.Error())
+	}
+	for i := range scenes.Scenes {
+		scene := scenes.Scenes[i].SceneName
+		state.Scenes = append(state.Scenes, scene)
+		items, err := obs.GetSceneItemList(scene)
+		if err != nil {
+			return errors.New("Cannot get scene items for [" + scene + "] in SyncState: " + err.Error())
+		}
+		for j := range items {
+			state.SceneItems[scene] = append(state.SceneItems[scene], SceneItemState{
+				SceneName:  scene,
+				SourceName: items[j].SourceName,
+				ItemID:     float64(items[j].SceneItemID),
+				Index:      items[j].SceneItemIndex,
+				Enabled:    items[j].SceneItemEnabled,
+				Transform:  items[j].SceneItemTransform,
+			})
+		}
+	}
+	client, err := obs.client()
+	if err != nil {
+		return errors.New("Cannot get inputs in SyncState: " + err.Error())
+	}
+	input_list, err := client.Inputs.GetInputList()
+	if err != nil {
+		return errors.New("Cannot get inputs in SyncState: " + err.Error())
+	}
+	for i := range input_list.Inputs {
+		name := input_list.Inputs[i].InputName
+		settings, err := obs.GetInputSettings(name)
+		if err != nil {
+			return errors.New("Cannot get input settings for [" + name + "] in SyncState: " + err.Error())
+		}
+		state.Inputs[name] = normalizeSettings(settings.InputSettings)
+	}
+	state.Streaming, err = obs.GetStreamStatus()
+	if err != nil {
+		return errors.New("Cannot get stream status in SyncState: " + err.Error())
+	}
+	state.Recording, err = obs.GetRecordStatus()
+	if err != nil {
+		return errors.New("Cannot get record status in SyncState: " + err.Error())
+	}
+	obs.State.Set(state)
+	return nil
+}
@@ -0,0 +1,256 @@
+package subtitle
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatSRT    = "srt"
+	FormatWebVTT = "vtt"
+	FormatASS    = "ass"
+)
+
+// MinDuration is how long a cue is shown at least, shorter cues are
+// stretched and cues trimmed below it by an overlap are merged instead.
+const MinDuration = 500 * time.Millisecond
+
+// Cue is a caption shown from Start to End, counted from the start of the
+// video.
+type Cue struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Style is how ASS subtitles look, colors are the ABGR integers OBS uses
+// and Alignment is the numpad position, 2 is bottom center.
+type Style struct {
+	Font         string `json:"font"`
+	Size         int64  `json:"size"`
+	Color        int64  `json:"color"`
+	OutlineColor int64  `json:"outline_color"`
+	Outline      int64  `json:"outline"`
+	Bold         bool   `json:"bold"`
+	Alignment    int64  `json:"alignment"`
+	MarginV      int64  `json:"margin_v"`
+}
+
+func DefaultStyle() Style {
+	return Style{
+		Font:         "Arial",
+		Size:         48,
+		Color:        0xFFFFFFFF,
+		OutlineColor: 0xFF000000,
+		Outline:      2,
+		Bold:         false,
+		Alignment:    2,
+		MarginV:      40,
+	}
+}
+
+func (s Style) Validate() error {
+	if strings.TrimSpace(s.Font) == "" || strings.ContainsAny(s.Font, ",\n") {
+		return errors.New("font [" + s.Font + "] must be a name without commas")
+	}
+	if s.Size <= 0 {
+		return errors.New("size must be greater than 0")
+	}
+	for name, color := range map[string]int64{"color": s.Color, "outline_color": s.OutlineColor} {
+		if color < 0 || color > 0xFFFFFFFF {
+			return errors.New(name + " [" + strconv.FormatInt(color, 10) + "] is not a 32 bit color")
+		}
+	}
+	if s.Outline < 0 || s.MarginV < 0 {
+		return errors.New("outline and margin_v cannot be negative")
+	}
+	if s.Alignment < 1 || s.Alignment > 9 {
+		return errors.New("alignment [" + strconv.FormatInt(s.Alignment, 10) + "] must be 1 to 9")
+	}
+	return nil
+}
+
+// Extension is the file extension of format, it is empty for unknown formats.
+func Extension(format string) string {
+	switch format {
+	case FormatSRT, FormatWebVTT, FormatASS:
+		return "." + format
+	}
+	return ""
+}
+
+// Validate lists what Fix would change about cues of a video length long.
+func Validate(cues []Cue, length time.Duration) []string {
+	problems := []string{}
+	for i := range cues {
+		c := cues[i]
+		at := "cue " + strconv.Itoa(i+1) + " at " + srtTime(c.Start)
+		if strings.TrimSpace(c.Text) == "" {
+			problems = append(problems, at+" is empty")
+		}
+		if c.Start < 0 {
+			problems = append(problems, at+" starts before the video")
+		}
+		if c.End > length {
+			problems = append(problems, at+" ends after the video")
+		}
+		if c.End-c.Start < MinDuration {
+			problems = append(problems, at+" is shown for less than "+MinDuration.String())
+		}
+		if i > 0 && c.Start < cues[i-1].Start {
+			problems = append(problems, at+" is out of order")
+		}
+		if i > 0 && c.Start < cues[i-1].End {
+			problems = append(problems, at+" overlaps the cue before it")
+		}
+	}
+	return problems
+}
+
+// Fix sorts cues, drops empty ones, keeps them inside a video length long and
+// shown for at least MinDuration where the video allows, and ends every cue
+// before the next one starts. Cues that start too close together to be shown
+// one after the other are merged.
+func Fix(cues []Cue, length time.Duration) []Cue {
+	sorted := []Cue{}
+	for i := range cues {
+		c := cues[i]
+		c.Text = strings.TrimSpace(c.Text)
+		if c.Text == "" || c.Start >= length {
+			continue
+		}
+		if c.Start < 0 {
+			c.Start = 0
+		}
+		if c.End < c.Start+MinDuration {
+			c.End = c.Start + MinDuration
+		}
+		if c.End > length {
+			c.End = length
+		}
+		sorted = append(sorted, c)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	fixed := []Cue{}
+	for i := range sorted {
+		c := sorted[i]
+		if len(fixed) > 0 {
+			last := &fixed[len(fixed)-1]
+			if c.Start-last.Start < MinDuration {
+				last.Text = last.Text + "\n" + c.Text
+				if c.End > last.End {
+					last.End = c.End
+				}
+				continue
+			}
+			if last.End > c.Start {
+				last.End = c.Start
+			}
+		}
+		fixed = append(fixed, c)
+	}
+	return fixed
+}
+
+// Render writes cues in format, style is only used by ASS.
+func Render(format string, cues []Cue, style Style) (string, error) {
+	switch format {
+	case FormatSRT:
+		return SRT(cues), nil
+	case FormatWebVTT:
+		return WebVTT(cues), nil
+	case FormatASS:
+		return ASS(cues, style), nil
+	}
+	return "", errors.New("unknown subtitle format [" + format + "], use srt, vtt or ass")
+}
+
+func SRT(cues []Cue) string {
+	var b strings.Builder
+	for i := range cues {
+		c := cues[i]
+		b.WriteString(strconv.Itoa(i+1) + "\n")
+		b.WriteString(srtTime(c.Start) + " --> " + srtTime(c.End) + "\n")
+		b.WriteString(c.Text + "\n\n")
+	}
+	return b.String()
+}
+
+func WebVTT(cues []Cue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	for i := range cues {
+		c := cues[i]
+		b.WriteString(strconv.Itoa(i+1) + "\n")
+		b.WriteString(vttTime(c.Start) + " --> " + vttTime(c.End) + "\n")
+		// a blank line would end the cue early
+		b.WriteString(escape.Replace(strings.ReplaceAll(c.Text, "\n\n", "\n")) + "\n\n")
+	}
+	return b.String()
+}
+
+func ASS(cues []Cue, style Style) string {
+	bold := 0
+	if style.Bold {
+		bold = -1
+	}
+	var b strings.Builder
+	b.WriteString("[Script Info]\n")
+	b.WriteString("ScriptType: v4.00+\n")
+	b.WriteString("PlayResX: 1920\n")
+	b.WriteString("PlayResY: 1080\n")
+	b.WriteString("WrapStyle: 0\n")
+	b.WriteString("ScaledBorderAndShadow: yes\n\n")
+	b.WriteString("[V4+ Styles]\n")
+	b.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	b.WriteString(fmt.Sprintf("Style: Default,%s,%d,%s,%s,%s,%s,%d,0,0,0,100,100,0,0,1,%d,0,%d,40,40,%d,1\n\n",
+		style.Font, style.Size, assColor(style.Color), assColor(style.Color), assColor(style.OutlineColor), assColor(style.OutlineColor),
+		bold, style.Outline, style.Alignment, style.MarginV))
+	b.WriteString("[Events]\n")
+	b.WriteString("Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	// braces start override tags and there is no escape for them
+	escape := strings.NewReplacer("{", "(", "}", ")", "\n", `\N`)
+	for i := range cues {
+		c := cues[i]
+		b.WriteString("Dialogue: 0," + assTime(c.Start) + "," + assTime(c.End) + ",Default,,0,0,0,," + escape.Replace(c.Text) + "\n")
+	}
+	return b.String()
+}
+
+func clock(d time.Duration) (int64, int64, int64, int64) {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return ms / 3600000, ms / 60000 % 60, ms / 1000 % 60, ms % 1000
+}
+
+func srtTime(d time.Duration) string {
+	h, m, s, ms := clock(d)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
+
+func vttTime(d time.Duration) string {
+	h, m, s, ms := clock(d)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+// assTime is in centiseconds, the most ASS can hold.
+func assTime(d time.Duration) string {
+	h, m, s, ms := clock(d)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, ms/10)
+}
+
+// assColor turns an OBS ABGR color into &HAABBGGRR where the alpha counts
+// transparency instead of opacity.
+func assColor(abgr int64) string {
+	alpha := 0xFF - (abgr>>24)&0xFF
+	return fmt.Sprintf("&H%02X%06X", alpha, abgr&0xFFFFFF)
+}
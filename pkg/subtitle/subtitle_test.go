@@ -0,0 +1,117 @@
+package subtitle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var renderCues = []Cue{
+	{Text: "Hello", Start: 1500 * time.Millisecond, End: 3250 * time.Millisecond},
+	{Text: "a <b> & {c}\n\nline", Start: time.Hour + 2*time.Minute + 3004*time.Millisecond, End: time.Hour + 2*time.Minute + 5999*time.Millisecond},
+}
+
+func TestSRT(t *testing.T) {
+	want := "1\n" +
+		"00:00:01,500 --> 00:00:03,250\n" +
+		"Hello\n\n" +
+		"2\n" +
+		"01:02:03,004 --> 01:02:05,999\n" +
+		"a <b> & {c}\n\nline\n\n"
+	if got := SRT(renderCues); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWebVTT(t *testing.T) {
+	want := "WEBVTT\n\n" +
+		"1\n" +
+		"00:00:01.500 --> 00:00:03.250\n" +
+		"Hello\n\n" +
+		"2\n" +
+		"01:02:03.004 --> 01:02:05.999\n" +
+		"a &lt;b&gt; &amp; {c}\nline\n\n"
+	if got := WebVTT(renderCues); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestASS(t *testing.T) {
+	want := "[Script Info]\n" +
+		"ScriptType: v4.00+\n" +
+		"PlayResX: 1920\n" +
+		"PlayResY: 1080\n" +
+		"WrapStyle: 0\n" +
+		"ScaledBorderAndShadow: yes\n\n" +
+		"[V4+ Styles]\n" +
+		"Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n" +
+		"Style: Default,Arial,48,&H00FFFFFF,&H00FFFFFF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,0,2,40,40,40,1\n\n" +
+		"[Events]\n" +
+		"Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+		"Dialogue: 0,0:00:01.50,0:00:03.25,Default,,0,0,0,,Hello\n" +
+		"Dialogue: 0,1:02:03.00,1:02:05.99,Default,,0,0,0,,a <b> & (c)\\N\\Nline\n"
+	if got := ASS(renderCues, DefaultStyle()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestASSColor(t *testing.T) {
+	tests := map[int64]string{
+		0xFFFFFFFF: "&H00FFFFFF",
+		0xFF000000: "&H00000000",
+		0x80FF0000: "&H7FFF0000",
+		0x000000FF: "&HFF0000FF",
+	}
+	for abgr, want := range tests {
+		if got := assColor(abgr); got != want {
+			t.Errorf("assColor(%X) is %s, want %s", abgr, got, want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	for _, format := range []string{FormatSRT, FormatWebVTT, FormatASS} {
+		_, err := Render(format, renderCues, DefaultStyle())
+		if err != nil {
+			t.Errorf("cannot render %s: %v", format, err)
+		}
+	}
+	_, err := Render("sub", renderCues, DefaultStyle())
+	if err == nil {
+		t.Error("rendered an unknown format")
+	}
+}
+
+func TestFix(t *testing.T) {
+	length := 10 * time.Second
+	cues := []Cue{
+		{Text: "  second ", Start: 3 * time.Second, End: 6 * time.Second},
+		{Text: "first", Start: -time.Second, End: 4 * time.Second},
+		{Text: " ", Start: 5 * time.Second, End: 6 * time.Second},
+		{Text: "merged", Start: 3200 * time.Millisecond, End: 7 * time.Second},
+		{Text: "short", Start: 7 * time.Second, End: 7100 * time.Millisecond},
+		{Text: "late", Start: 9400 * time.Millisecond, End: 12 * time.Second},
+		{Text: "after", Start: 10 * time.Second, End: 11 * time.Second},
+	}
+	want := []Cue{
+		{Text: "first", Start: 0, End: 3 * time.Second},
+		{Text: "second\nmerged", Start: 3 * time.Second, End: 7 * time.Second},
+		{Text: "short", Start: 7 * time.Second, End: 7500 * time.Millisecond},
+		{Text: "late", Start: 9400 * time.Millisecond, End: 10 * time.Second},
+	}
+	fixed := Fix(cues, length)
+	if !reflect.DeepEqual(fixed, want) {
+		t.Errorf("got %+v, want %+v", fixed, want)
+	}
+
+	problems := strings.Join(Validate(cues, length), "\n")
+	for _, problem := range []string{"is empty", "starts before the video", "ends after the video", "is shown for less than", "is out of order", "overlaps the cue before it"} {
+		if !strings.Contains(problems, problem) {
+			t.Errorf("Validate did not report a cue that %s:\n%s", problem, problems)
+		}
+	}
+	if problems := Validate(fixed, length); len(problems) != 0 {
+		t.Errorf("fixed cues still have problems %v", problems)
+	}
+}
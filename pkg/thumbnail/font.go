@@ -0,0 +1,125 @@
+package thumbnail
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The overlay text is drawn with the Go fonts, which are bundled with
+// golang.org/x/image so no font files need to be installed.
+const (
+	titleSize = 64
+	labelSize = 40
+	taskSize  = 32
+)
+
+// faces holds the font faces the overlay is drawn with.
+type faces struct {
+	title font.Face
+	label font.Face
+	task  font.Face
+}
+
+func newFaces() (faces, error) {
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return faces{}, errors.New("Cannot parse bold font: " + err.Error())
+	}
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return faces{}, errors.New("Cannot parse regular font: " + err.Error())
+	}
+	f := faces{}
+	f.title, err = opentype.NewFace(bold, &opentype.FaceOptions{Size: titleSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return faces{}, errors.New("Cannot create title font: " + err.Error())
+	}
+	f.label, err = opentype.NewFace(bold, &opentype.FaceOptions{Size: labelSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return faces{}, errors.New("Cannot create label font: " + err.Error())
+	}
+	f.task, err = opentype.NewFace(regular, &opentype.FaceOptions{Size: taskSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return faces{}, errors.New("Cannot create task font: " + err.Error())
+	}
+	return f, nil
+}
+
+// lineHeight is the height of a line of text drawn with face.
+func lineHeight(face font.Face) int {
+	return face.Metrics().Height.Ceil()
+}
+
+// textWidth is how wide text is drawn with face.
+func textWidth(face font.Face, text string) int {
+	return font.MeasureString(face, text).Ceil()
+}
+
+// drawText draws text with the top left corner of its line at p.
+func drawText(dst *image.RGBA, face font.Face, text string, p image.Point, c color.Color) {
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(p.X, p.Y+face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(text)
+}
+
+// wrapText splits text into lines no wider than width when drawn with face,
+// breaking on spaces where it can. At most max_lines are returned and the
+// last one ends in "..." when text did not fit.
+func wrapText(face font.Face, text string, width int, max_lines int) []string {
+	if max_lines < 1 || textWidth(face, "MMMM") > width {
+		return nil
+	}
+	fits := func(s string) bool {
+		return textWidth(face, s) <= width
+	}
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for !fits(word) {
+			// a word longer than a line is split
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			r := []rune(word)
+			n := len(r) - 1
+			for n > 1 && !fits(string(r[:n])) {
+				n--
+			}
+			lines = append(lines, string(r[:n]))
+			word = string(r[n:])
+		}
+		if line == "" {
+			line = word
+		} else if fits(line + " " + word) {
+			line = line + " " + word
+		} else {
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > max_lines {
+		lines = lines[:max_lines]
+		last := []rune(lines[max_lines-1])
+		for len(last) > 0 && !fits(strings.TrimRight(string(last), " ")+"...") {
+			last = last[:len(last)-1]
+		}
+		lines[max_lines-1] = strings.TrimRight(string(last), " ") + "..."
+	}
+	return lines
+}
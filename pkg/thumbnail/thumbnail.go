@@ -0,0 +1,305 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// YouTube recommends 1280x720 thumbnails and rejects files over 2MB.
+const (
+	Width       = 1280
+	Height      = 720
+	MaxFileSize = 2 * 1024 * 1024
+)
+
+// Box art is requested at the size the Twitch directory uses.
+const (
+	boxArtWidth  = 285
+	boxArtHeight = 380
+)
+
+const defaultFrames = 4
+
+type Config struct {
+	// FFmpeg is the ffmpeg executable, found on the PATH when empty.
+	FFmpeg string `yaml:"ffmpeg"`
+	// Template is an optional image drawn over every frame, transparent
+	// areas let the frame show through.
+	Template string `yaml:"template"`
+	// Frames is how many candidate frames are taken from a recording.
+	Frames int `yaml:"frames"`
+}
+
+// Overlay is the text and box art drawn onto a frame.
+type Overlay struct {
+	Title    string
+	Category string
+	Task     string
+	BoxArt   image.Image
+}
+
+type Generator struct {
+	ffmpeg   string
+	template image.Image
+	frames   int
+	client   http.Client
+	// faces cannot be drawn with concurrently, composing holds drawing
+	drawing sync.Mutex
+	faces   faces
+}
+
+func New(c Config) (*Generator, error) {
+	g := &Generator{
+		ffmpeg: c.FFmpeg,
+		frames: c.Frames,
+		client: http.Client{Timeout: 10 * time.Second},
+	}
+	if g.ffmpeg == "" {
+		g.ffmpeg = "ffmpeg"
+	}
+	if g.frames <= 0 {
+		g.frames = defaultFrames
+	}
+	var err error
+	g.faces, err = newFaces()
+	if err != nil {
+		return nil, err
+	}
+	if c.Template != "" {
+		f, err := os.Open(c.Template)
+		if err != nil {
+			return nil, errors.New("Cannot open thumbnail template: " + err.Error())
+		}
+		defer f.Close()
+		g.template, _, err = image.Decode(f)
+		if err != nil {
+			return nil, errors.New("Cannot decode thumbnail template " + c.Template + ": " + err.Error())
+		}
+	}
+	return g, nil
+}
+
+// Offsets spreads the candidate frames evenly over a recording of length,
+// leaving out the very start and end which are usually a starting or
+// ending screen.
+func (g *Generator) Offsets(length time.Duration) []time.Duration {
+	offsets := []time.Duration{}
+	for i := 1; i <= g.frames; i++ {
+		offsets = append(offsets, length*time.Duration(i)/time.Duration(g.frames+1))
+	}
+	return offsets
+}
+
+// ExtractFrame decodes the frame of file at offset with ffmpeg.
+func (g *Generator) ExtractFrame(ctx context.Context, file string, offset time.Duration) (image.Image, error) {
+	seconds := strconv.FormatFloat(offset.Seconds(), 'f', 3, 64)
+	cmd := exec.CommandContext(ctx, g.ffmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-ss", seconds, "-i", file,
+		"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.New("Cannot extract frame at " + seconds + "s of " + file + ": " + err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, errors.New("No frame at " + seconds + "s of " + file)
+	}
+	frame, _, err := image.Decode(&stdout)
+	if err != nil {
+		return nil, errors.New("Cannot decode frame at " + seconds + "s of " + file + ": " + err.Error())
+	}
+	return frame, nil
+}
+
+var boxArtSize = regexp.MustCompile(`-\d+x\d+(\.\w+)$`)
+
+// BoxArt downloads Twitch box art. The url may have {width} and {height}
+// placeholders or a size already filled in, search results come sized for
+// a list so a bigger size is asked for either way.
+func (g *Generator) BoxArt(ctx context.Context, url string) (image.Image, error) {
+	url = strings.NewReplacer("{width}", strconv.Itoa(boxArtWidth), "{height}", strconv.Itoa(boxArtHeight)).Replace(url)
+	url = boxArtSize.ReplaceAllString(url, "-"+strconv.Itoa(boxArtWidth)+"x"+strconv.Itoa(boxArtHeight)+"$1")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.New("Cannot create box art request: " + err.Error())
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, errors.New("Cannot get box art " + url + ": " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Cannot get box art " + url + ": status " + strconv.Itoa(resp.StatusCode))
+	}
+	art, _, err := image.Decode(resp.Body)
+	if err != nil {
+		return nil, errors.New("Cannot decode box art " + url + ": " + err.Error())
+	}
+	return art, nil
+}
+
+var (
+	shade     = color.RGBA{0, 0, 0, 176}
+	textColor = color.RGBA{255, 255, 255, 255}
+	taskColor = color.RGBA{255, 214, 0, 255}
+)
+
+const (
+	margin  = 40
+	lineGap = 12
+)
+
+// Compose draws the frame scaled to fill the thumbnail, the template over
+// it, the title in a band along the top, and the box art, category and task
+// along the bottom.
+func (g *Generator) Compose(frame image.Image, overlay Overlay) *image.RGBA {
+	g.drawing.Lock()
+	defer g.drawing.Unlock()
+	dst := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	drawCover(dst, dst.Bounds(), frame)
+	if g.template != nil {
+		scaled := image.NewRGBA(dst.Bounds())
+		drawCover(scaled, scaled.Bounds(), g.template)
+		draw.Draw(dst, dst.Bounds(), scaled, image.Point{}, draw.Over)
+	}
+	title := wrapText(g.faces.title, overlay.Title, Width-2*margin, 2)
+	if len(title) > 0 {
+		line_height := lineHeight(g.faces.title) + lineGap
+		drawRect(dst, image.Rect(0, 0, Width, margin+len(title)*line_height), image.NewUniform(shade))
+		for i := range title {
+			drawText(dst, g.faces.title, title[i], image.Pt(margin, margin/2+lineGap+i*line_height), textColor)
+		}
+	}
+	text_x := margin
+	if overlay.BoxArt != nil {
+		art := image.Rect(margin, Height-margin-boxArtHeight*3/4, margin+boxArtWidth*3/4, Height-margin)
+		drawRect(dst, art.Inset(-4), image.NewUniform(textColor))
+		drawCover(dst, art, overlay.BoxArt)
+		text_x = art.Max.X + margin
+	}
+	category := wrapText(g.faces.label, overlay.Category, Width-margin-text_x, 1)
+	task := wrapText(g.faces.task, overlay.Task, Width-margin-text_x, 2)
+	if len(category) > 0 || len(task) > 0 {
+		height := len(category)*(lineHeight(g.faces.label)+lineGap) + len(task)*(lineHeight(g.faces.task)+lineGap)
+		y := Height - margin - height
+		drawRect(dst, image.Rect(text_x-margin/2, y-margin/2, Width, Height), image.NewUniform(shade))
+		for i := range category {
+			drawText(dst, g.faces.label, category[i], image.Pt(text_x, y), textColor)
+			y = y + lineHeight(g.faces.label) + lineGap
+		}
+		for i := range task {
+			drawText(dst, g.faces.task, task[i], image.Pt(text_x, y), taskColor)
+			y = y + lineHeight(g.faces.task) + lineGap
+		}
+	}
+	return dst
+}
+
+// Encode encodes img as a JPEG, lowering the quality until it fits in
+// MaxFileSize.
+func Encode(img image.Image) ([]byte, error) {
+	for quality := 90; quality > 0; quality = quality - 10 {
+		var b bytes.Buffer
+		err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality})
+		if err != nil {
+			return nil, errors.New("Cannot encode thumbnail: " + err.Error())
+		}
+		if b.Len() <= MaxFileSize {
+			return b.Bytes(), nil
+		}
+	}
+	return nil, errors.New("Cannot encode thumbnail smaller than " + strconv.Itoa(MaxFileSize) + " bytes")
+}
+
+func drawRect(dst *image.RGBA, r image.Rectangle, src image.Image) {
+	draw.Draw(dst, r, src, image.Point{}, draw.Over)
+}
+
+// drawCover scales src to cover r keeping its aspect ratio, cropping the
+// middle of whichever side is too long, with bilinear sampling.
+func drawCover(dst *image.RGBA, r image.Rectangle, src image.Image) {
+	b := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	} else {
+		rgba = rgba.SubImage(b).(*image.RGBA)
+	}
+	sw, sh := float64(b.Dx()), float64(b.Dy())
+	dw, dh := float64(r.Dx()), float64(r.Dy())
+	if sw == 0 || sh == 0 || dw == 0 || dh == 0 {
+		return
+	}
+	scale := dw / sw
+	if dh/sh > scale {
+		scale = dh / sh
+	}
+	off_x := (sw - dw/scale) / 2
+	off_y := (sh - dh/scale) / 2
+	min := rgba.Bounds().Min
+	for y := 0; y < r.Dy(); y++ {
+		fy := off_y + (float64(y)+0.5)/scale - 0.5
+		y0, wy := split(fy, b.Dy())
+		for x := 0; x < r.Dx(); x++ {
+			fx := off_x + (float64(x)+0.5)/scale - 0.5
+			x0, wx := split(fx, b.Dx())
+			var c [4]float64
+			for _, s := range [4]struct {
+				x, y int
+				w    float64
+			}{
+				{x0, y0, (1 - wx) * (1 - wy)},
+				{clamp(x0+1, b.Dx()), y0, wx * (1 - wy)},
+				{x0, clamp(y0+1, b.Dy()), (1 - wx) * wy},
+				{clamp(x0+1, b.Dx()), clamp(y0+1, b.Dy()), wx * wy},
+			} {
+				i := rgba.PixOffset(min.X+s.x, min.Y+s.y)
+				for k := range c {
+					c[k] = c[k] + float64(rgba.Pix[i+k])*s.w
+				}
+			}
+			i := dst.PixOffset(r.Min.X+x, r.Min.Y+y)
+			for k := range c {
+				dst.Pix[i+k] = uint8(c[k] + 0.5)
+			}
+		}
+	}
+}
+
+// split returns the pixel before f and how far f is past it.
+func split(f float64, n int) (int, float64) {
+	if f < 0 {
+		return 0, 0
+	}
+	i := int(f)
+	if i >= n-1 {
+		return n - 1, 0
+	}
+	return i, f - float64(i)
+}
+
+func clamp(i int, n int) int {
+	if i > n-1 {
+		return n - 1
+	}
+	return i
+}
@@ -0,0 +1,181 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	EngineEspeak   = "espeak"
+	EnginePiper    = "piper"
+	EngineFestival = "festival"
+	EnginePico     = "pico2wave"
+)
+
+// TTS renders speech to a WAV file, so how long it lasts is known before it
+// is played.
+type TTS interface {
+	Render(ctx context.Context, text string, file string) error
+}
+
+// Voice configures an engine. Rate and Pitch scale the normal speed and pitch
+// of the voice, 0 counts as 1. Engines that cannot change the pitch reject
+// any other value.
+type Voice struct {
+	Engine string `yaml:"engine"`
+	// Command is the executable of the engine, the engine name is used when
+	// empty. Festival is run through text2wave.
+	Command string `yaml:"command"`
+	// Voice is the espeak voice, the festival voice without its voice_
+	// prefix, the pico2wave language or the path of the piper model.
+	Voice string  `yaml:"voice"`
+	Rate  float64 `yaml:"rate"`
+	Pitch float64 `yaml:"pitch"`
+}
+
+func (v Voice) rate() float64 {
+	if v.Rate == 0 {
+		return 1
+	}
+	return v.Rate
+}
+
+func (v Voice) pitch() float64 {
+	if v.Pitch == 0 {
+		return 1
+	}
+	return v.Pitch
+}
+
+// NewEngine makes the engine of v.
+func NewEngine(v Voice) (TTS, error) {
+	if v.Rate < 0 || v.Pitch < 0 {
+		return nil, errors.New("rate and pitch cannot be negative")
+	}
+	command := func(name string) string {
+		if v.Command != "" {
+			return v.Command
+		}
+		return name
+	}
+	switch v.Engine {
+	case EngineEspeak:
+		return &espeak{command: command("espeak"), voice: v}, nil
+	case EnginePiper:
+		if v.Voice == "" {
+			return nil, errors.New("piper needs the model file as its voice")
+		}
+		if v.pitch() != 1 {
+			return nil, errors.New("piper cannot change the pitch")
+		}
+		return &piper{command: command("piper"), voice: v}, nil
+	case EngineFestival:
+		if v.pitch() != 1 {
+			return nil, errors.New("festival cannot change the pitch")
+		}
+		return &festival{command: command("text2wave"), voice: v}, nil
+	case EnginePico:
+		return &pico{command: command("pico2wave"), voice: v}, nil
+	}
+	return nil, errors.New("unknown engine [" + v.Engine + "], use espeak, piper, festival or pico2wave")
+}
+
+type espeak struct {
+	command string
+	voice   Voice
+}
+
+// Render speaks at 175 words per minute and pitch 50 out of 99 scaled by the
+// voice, the espeak defaults.
+func (e *espeak) Render(ctx context.Context, text string, file string) error {
+	args := []string{
+		"-s", strconv.Itoa(int(175 * e.voice.rate())),
+		"-p", strconv.Itoa(clamp(int(50*e.voice.pitch()), 0, 99)),
+		"-w", file,
+	}
+	if e.voice.Voice != "" {
+		args = append(args, "-v", e.voice.Voice)
+	}
+	// the text is read from stdin so text starting with - is not an option
+	args = append(args, "--stdin")
+	return run(ctx, e.command, strings.NewReader(text), args...)
+}
+
+type piper struct {
+	command string
+	voice   Voice
+}
+
+func (p *piper) Render(ctx context.Context, text string, file string) error {
+	return run(ctx, p.command, strings.NewReader(text),
+		"--model", p.voice.Voice,
+		"--length_scale", strconv.FormatFloat(1/p.voice.rate(), 'f', 3, 64),
+		"--output_file", file,
+	)
+}
+
+type festival struct {
+	command string
+	voice   Voice
+}
+
+func (f *festival) Render(ctx context.Context, text string, file string) error {
+	args := []string{
+		"-o", file,
+		"-eval", "(Parameter.set 'Duration_Stretch " + strconv.FormatFloat(1/f.voice.rate(), 'f', 3, 64) + ")",
+	}
+	if f.voice.Voice != "" {
+		args = append(args, "-eval", "(voice_"+f.voice.Voice+")")
+	}
+	return run(ctx, f.command, strings.NewReader(text), args...)
+}
+
+type pico struct {
+	command string
+	voice   Voice
+}
+
+// Render changes the speed and pitch with the markup pico2wave reads, in
+// percent of normal.
+func (p *pico) Render(ctx context.Context, text string, file string) error {
+	var escaped bytes.Buffer
+	err := xml.EscapeText(&escaped, []byte(text))
+	if err != nil {
+		return errors.New("Cannot escape text for pico2wave: " + err.Error())
+	}
+	markup := "<speed level=\"" + strconv.Itoa(int(100*p.voice.rate())) + "\"><pitch level=\"" + strconv.Itoa(int(100*p.voice.pitch())) + "\">" + escaped.String() + "</pitch></speed>"
+	args := []string{"-w", file}
+	if p.voice.Voice != "" {
+		args = append(args, "-l", p.voice.Voice)
+	}
+	args = append(args, "--", markup)
+	return run(ctx, p.command, nil, args...)
+}
+
+func run(ctx context.Context, command string, stdin io.Reader, args ...string) error {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return errors.New("Cannot run " + command + ": " + err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func clamp(n int, min int, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
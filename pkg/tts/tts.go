@@ -0,0 +1,159 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultVoice = "default"
+
+// EnvelopeStep is how often the loudness of speech is measured.
+const EnvelopeStep = 40 * time.Millisecond
+
+type Config struct {
+	// Default is the voice used for sources without one, the voice named
+	// default when empty.
+	Default string `yaml:"default"`
+	// Voices are the voices by name. Without any, espeak speaks with the
+	// en+m4 voice.
+	Voices map[string]Voice `yaml:"voices"`
+	// Sources picks the voice by where a message comes from, e.g. chat or
+	// alerts.
+	Sources map[string]string `yaml:"sources"`
+	// Player plays a WAV file given as its last argument, aplay -q when empty.
+	Player []string `yaml:"player"`
+	// Directory is where speech is rendered, the system temp directory when
+	// empty.
+	Directory string `yaml:"directory"`
+}
+
+// Speech is text rendered to a WAV file that plays for Duration. Envelope
+// is its loudness every EnvelopeStep, empty when the file cannot be measured.
+type Speech struct {
+	Text     string
+	Voice    string
+	File     string
+	Duration time.Duration
+	Envelope []float64
+}
+
+// Remove deletes the rendered file once the speech is not needed anymore.
+func (s *Speech) Remove() error {
+	return os.Remove(s.File)
+}
+
+// Speaker renders text with the voice of its source and plays it.
+type Speaker struct {
+	engines      map[string]TTS
+	sources      map[string]string
+	default_name string
+	player       []string
+	directory    string
+}
+
+func New(c Config) (*Speaker, error) {
+	s := &Speaker{
+		engines:      map[string]TTS{},
+		sources:      c.Sources,
+		default_name: c.Default,
+		player:       c.Player,
+		directory:    c.Directory,
+	}
+	voices := c.Voices
+	if len(voices) == 0 {
+		voices = map[string]Voice{
+			defaultVoice: {Engine: EngineEspeak, Voice: "en+m4"},
+		}
+	}
+	for name, voice := range voices {
+		engine, err := NewEngine(voice)
+		if err != nil {
+			return nil, errors.New("Cannot make voice [" + name + "]: " + err.Error())
+		}
+		s.engines[name] = engine
+	}
+	if s.default_name == "" {
+		s.default_name = defaultVoice
+	}
+	if _, ok := s.engines[s.default_name]; !ok {
+		return nil, errors.New("default voice [" + s.default_name + "] is not one of " + strings.Join(s.Voices(), ", "))
+	}
+	for source, name := range s.sources {
+		if _, ok := s.engines[name]; !ok {
+			return nil, errors.New("voice [" + name + "] of source [" + source + "] is not one of " + strings.Join(s.Voices(), ", "))
+		}
+	}
+	if len(s.player) == 0 {
+		s.player = []string{"aplay", "-q"}
+	}
+	if s.directory == "" {
+		s.directory = os.TempDir()
+	}
+	return s, nil
+}
+
+// Voices are the names of the configured voices in order.
+func (s *Speaker) Voices() []string {
+	names := []string{}
+	for name := range s.engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VoiceFor is the name of the voice that speaks messages from source.
+func (s *Speaker) VoiceFor(source string) string {
+	if name, ok := s.sources[source]; ok {
+		return name
+	}
+	return s.default_name
+}
+
+// Render renders text in the voice of source. The file of the speech is
+// removed again if anything fails.
+func (s *Speaker) Render(ctx context.Context, source string, text string) (*Speech, error) {
+	name := s.VoiceFor(source)
+	f, err := ioutil.TempFile(s.directory, "strmr-tts-*.wav")
+	if err != nil {
+		return nil, errors.New("Cannot make speech file: " + err.Error())
+	}
+	f.Close()
+	speech := &Speech{
+		Text:  text,
+		Voice: name,
+		File:  f.Name(),
+	}
+	err = s.engines[name].Render(ctx, text, speech.File)
+	if err != nil {
+		speech.Remove()
+		return nil, err
+	}
+	speech.Duration, err = WAVDuration(speech.File)
+	if err != nil {
+		speech.Remove()
+		return nil, err
+	}
+	// speech plays without an envelope, the mouth only opens and closes then
+	speech.Envelope, err = WAVEnvelope(speech.File, EnvelopeStep)
+	if err != nil {
+		fmt.Println("Cannot measure speech loudness: " + err.Error())
+	}
+	return speech, nil
+}
+
+// Play plays speech and returns once it has been heard.
+func (s *Speaker) Play(ctx context.Context, speech *Speech) error {
+	args := append(append([]string{}, s.player[1:]...), speech.File)
+	err := run(ctx, s.player[0], nil, args...)
+	if err != nil {
+		return errors.New("Cannot play speech: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package tts
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// wav is the format and place of the samples in a WAV file.
+type wav struct {
+	format      uint16
+	byte_rate   uint32
+	block_align uint16
+	bits        uint16
+	data_offset int64
+	data_size   int64
+}
+
+// readWAV reads the header of a WAV file. Engines that stream their output
+// leave the data size unknown, the rest of the file is used then.
+func readWAV(f *os.File) (wav, error) {
+	w := wav{}
+	info, err := f.Stat()
+	if err != nil {
+		return w, errors.New("Cannot stat " + f.Name() + ": " + err.Error())
+	}
+	var riff [12]byte
+	_, err = io.ReadFull(f, riff[:])
+	if err != nil || string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return w, errors.New(f.Name() + " is not a WAV file")
+	}
+	offset := int64(len(riff))
+	for {
+		var header [8]byte
+		_, err = io.ReadFull(f, header[:])
+		if err != nil {
+			return w, errors.New(f.Name() + " has no data chunk")
+		}
+		offset = offset + int64(len(header))
+		id := string(header[0:4])
+		size := binary.LittleEndian.Uint32(header[4:8])
+		switch id {
+		case "fmt ":
+			var format [16]byte
+			if size < uint32(len(format)) {
+				return w, errors.New(f.Name() + " has a short fmt chunk")
+			}
+			_, err = io.ReadFull(f, format[:])
+			if err != nil {
+				return w, errors.New(f.Name() + " has a short fmt chunk")
+			}
+			w.format = binary.LittleEndian.Uint16(format[0:2])
+			w.byte_rate = binary.LittleEndian.Uint32(format[8:12])
+			w.block_align = binary.LittleEndian.Uint16(format[12:14])
+			w.bits = binary.LittleEndian.Uint16(format[14:16])
+			_, err = f.Seek(int64(size)-int64(len(format)), io.SeekCurrent)
+		case "data":
+			if w.byte_rate == 0 || w.block_align == 0 {
+				return w, errors.New(f.Name() + " has no format before its data")
+			}
+			w.data_offset = offset
+			w.data_size = int64(size)
+			if rest := info.Size() - offset; w.data_size > rest {
+				w.data_size = rest
+			}
+			return w, nil
+		default:
+			_, err = f.Seek(int64(size), io.SeekCurrent)
+		}
+		if err != nil {
+			return w, errors.New("Cannot read " + f.Name() + ": " + err.Error())
+		}
+		// chunks are padded to an even size
+		if size%2 == 1 {
+			_, err = f.Seek(1, io.SeekCurrent)
+			if err != nil {
+				return w, errors.New("Cannot read " + f.Name() + ": " + err.Error())
+			}
+		}
+		offset, err = f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return w, errors.New("Cannot read " + f.Name() + ": " + err.Error())
+		}
+	}
+}
+
+// WAVDuration reads how long a WAV file plays from its header.
+func WAVDuration(file string) (time.Duration, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, errors.New("Cannot open " + file + ": " + err.Error())
+	}
+	defer f.Close()
+	w, err := readWAV(f)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(w.data_size) * time.Second / time.Duration(w.byte_rate), nil
+}
+
+// WAVEnvelope is how loud a WAV file is every step, from 0 for silence to 1
+// for its loudest step, for animating a mouth in time with it. Only 8 and 16
+// bit PCM can be read, which every supported engine writes.
+func WAVEnvelope(file string, step time.Duration) ([]float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.New("Cannot open " + file + ": " + err.Error())
+	}
+	defer f.Close()
+	w, err := readWAV(f)
+	if err != nil {
+		return nil, err
+	}
+	if w.format != 1 || (w.bits != 8 && w.bits != 16) {
+		return nil, errors.New(file + " is not 8 or 16 bit PCM")
+	}
+	_, err = f.Seek(w.data_offset, io.SeekStart)
+	if err != nil {
+		return nil, errors.New("Cannot read " + file + ": " + err.Error())
+	}
+	data := make([]byte, w.data_size)
+	_, err = io.ReadFull(f, data)
+	if err != nil {
+		return nil, errors.New("Cannot read " + file + ": " + err.Error())
+	}
+	// whole frames of every channel per step
+	step_bytes := int(int64(w.byte_rate) * int64(step) / int64(time.Second))
+	step_bytes = step_bytes - step_bytes%int(w.block_align)
+	if step_bytes <= 0 {
+		return nil, errors.New("step " + step.String() + " is shorter than a frame")
+	}
+	sample_bytes := int(w.bits / 8)
+	levels := []float64{}
+	loudest := 0.0
+	for start := 0; start < len(data); start = start + step_bytes {
+		end := start + step_bytes
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := 0.0
+		count := 0
+		for i := start; i+sample_bytes <= end; i = i + sample_bytes {
+			var sample float64
+			if sample_bytes == 1 {
+				sample = (float64(data[i]) - 128) / 128
+			} else {
+				sample = float64(int16(binary.LittleEndian.Uint16(data[i:]))) / 32768
+			}
+			sum = sum + sample*sample
+			count++
+		}
+		level := 0.0
+		if count > 0 {
+			level = math.Sqrt(sum / float64(count))
+		}
+		if level > loudest {
+			loudest = level
+		}
+		levels = append(levels, level)
+	}
+	for i := range levels {
+		if loudest > 0 {
+			levels[i] = math.Round(levels[i]/loudest*100) / 100
+		}
+	}
+	return levels, nil
+}
@@ -0,0 +1,320 @@
+// Package chat connects to twitch chat over IRC on a WebSocket, reads the
+// messages of a channel and answers commands in it.
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jnrprgmr/strmr/pkg/twitch"
+)
+
+const (
+	defaultURL = "wss://irc-ws.chat.twitch.tv:443"
+	// twitch pings every 5 minutes
+	readTimeout  = 6 * time.Minute
+	writeTimeout = 10 * time.Second
+	// twitch allows 20 messages every 30 seconds
+	sendInterval = 1500 * time.Millisecond
+	// maxLength is the most characters twitch takes in one message.
+	maxLength   = 500
+	maxBackoff  = time.Minute
+	maxOutgoing = 20
+	buffer      = 64
+)
+
+// Scopes are the scopes the user access token needs to read and send chat.
+var Scopes = []string{"chat:read", "chat:edit"}
+
+var ErrBusy = errors.New("too many chat messages are waiting to be sent")
+
+// errReconnect ends a session when twitch asks to connect again.
+var errReconnect = errors.New("twitch asked to reconnect")
+
+type Config struct {
+	// Enabled connects to chat. It needs the scopes in Scopes, a token
+	// authorized before they were asked for has to be authorized again.
+	Enabled bool `yaml:"enabled"`
+	// URL is the chat WebSocket, wss://irc-ws.chat.twitch.tv:443 when empty.
+	URL string `yaml:"url"`
+	// Channel is the channel joined, the channel of the twitch user when
+	// empty.
+	Channel string `yaml:"channel"`
+}
+
+type outgoing struct {
+	text     string
+	reply_to string
+}
+
+// Client stays in the chat of a channel as the twitch user. Messages sent
+// to the channel go to the subscribers, messages to send wait their turn so
+// twitch does not drop them.
+type Client struct {
+	twitch      *twitch.Twitch
+	url         string
+	channel     string
+	outgoing    chan outgoing
+	mu          sync.Mutex
+	conn        *websocket.Conn
+	subscribers map[chan PrivateMessage]struct{}
+}
+
+func New(t *twitch.Twitch, c Config) *Client {
+	client := &Client{
+		twitch:      t,
+		url:         c.URL,
+		channel:     strings.ToLower(strings.TrimPrefix(c.Channel, "#")),
+		outgoing:    make(chan outgoing, maxOutgoing),
+		subscribers: map[chan PrivateMessage]struct{}{},
+	}
+	if client.url == "" {
+		client.url = defaultURL
+	}
+	return client
+}
+
+// Subscribe returns the messages sent to the channel and a func to stop
+// them. Messages are dropped for a subscriber that falls behind.
+func (c *Client) Subscribe() (<-chan PrivateMessage, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	messages := make(chan PrivateMessage, buffer)
+	c.subscribers[messages] = struct{}{}
+	return messages, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.subscribers[messages]; ok {
+			delete(c.subscribers, messages)
+			close(messages)
+		}
+	}
+}
+
+func (c *Client) publish(msg PrivateMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for messages := range c.subscribers {
+		select {
+		case messages <- msg:
+		default:
+		}
+	}
+}
+
+// Say sends text to the channel, as a reply to the message reply_to unless
+// it is empty. Text longer than twitch takes is cut.
+func (c *Client) Say(text string, reply_to string) error {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return nil
+	}
+	if runes := []rune(text); len(runes) > maxLength {
+		text = string(runes[:maxLength])
+	}
+	select {
+	case c.outgoing <- outgoing{text: text, reply_to: reply_to}:
+		return nil
+	default:
+		return ErrBusy
+	}
+}
+
+// Run stays in chat until ctx is cancelled, connecting again with backoff
+// when the connection is lost. While twitch is not authorized it waits for
+// it to be.
+func (c *Client) Run(ctx context.Context) {
+	token_events, stop_token_events := c.twitch.Tokens.Subscribe()
+	defer stop_token_events()
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}()
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == errReconnect {
+			backoff = time.Second
+			continue
+		}
+		if err == twitch.ErrReauthorize {
+			fmt.Println("Twitch chat waits for twitch to be authorized")
+			for authorized := false; !authorized; {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-token_events:
+					authorized = event.Type == twitch.TokenAuthorized
+				}
+			}
+			backoff = time.Second
+			continue
+		}
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+		fmt.Println("Lost twitch chat connection, connecting again in " + backoff.String() + ": " + err.Error())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = backoff * 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// write sends lines to conn, which only takes one writer at a time.
+func write(conn *websocket.Conn, write_mu *sync.Mutex, lines ...string) error {
+	write_mu.Lock()
+	defer write_mu.Unlock()
+	for _, line := range lines {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err := conn.WriteMessage(websocket.TextMessage, []byte(line+"\r\n"))
+		if err != nil {
+			return errors.New("Cannot write to twitch chat: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// session logs in as the user of the token, joins the channel and reads
+// until the connection is lost.
+func (c *Client) session(ctx context.Context) error {
+	token, err := c.twitch.Tokens.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := c.twitch.GetUsersContext(ctx, nil)
+	if err != nil {
+		return err
+	}
+	login := ""
+	for l := range users {
+		login = l
+	}
+	if login == "" {
+		return errors.New("Cannot find the twitch user of the token")
+	}
+	channel := c.channel
+	if channel == "" {
+		channel = login
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.url, nil)
+	if err != nil {
+		return errors.New("Cannot connect to " + c.url + ": " + err.Error())
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+	}()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var write_mu sync.Mutex
+	err = write(conn, &write_mu,
+		"CAP REQ :twitch.tv/tags twitch.tv/commands",
+		"PASS oauth:"+token,
+		"NICK "+login,
+		"JOIN #"+channel,
+	)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go c.send(conn, &write_mu, channel, done)
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return errors.New("Cannot read from twitch chat: " + err.Error())
+		}
+		for _, line := range strings.Split(string(b), "\r\n") {
+			if line == "" {
+				continue
+			}
+			m, err := ParseMessage(line)
+			if err != nil {
+				continue
+			}
+			err = c.handle(ctx, conn, &write_mu, token, login, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) handle(ctx context.Context, conn *websocket.Conn, write_mu *sync.Mutex, token string, login string, m Message) error {
+	switch m.Command {
+	case "PING":
+		return write(conn, write_mu, "PONG :"+strings.Join(m.Params, " "))
+	case "RECONNECT":
+		return errReconnect
+	case "NOTICE":
+		text := strings.Join(m.Params, " ")
+		if strings.Contains(text, "Login authentication failed") || strings.Contains(text, "Improperly formatted auth") {
+			_, err := c.twitch.Tokens.Refresh(ctx, token)
+			if err != nil {
+				return err
+			}
+			return errors.New("twitch chat refused the token, it was refreshed")
+		}
+		fmt.Println("Twitch chat: " + text)
+	case "JOIN":
+		if m.Nick() == login && len(m.Params) > 0 {
+			fmt.Println("Joined twitch chat of " + strings.TrimPrefix(m.Params[0], "#"))
+		}
+	case "PRIVMSG":
+		c.publish(privateMessage(m))
+	}
+	return nil
+}
+
+// send writes the waiting messages to channel, no faster than twitch takes
+// them, until done is closed. A message that cannot be written is lost with
+// the connection.
+func (c *Client) send(conn *websocket.Conn, write_mu *sync.Mutex, channel string, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case msg := <-c.outgoing:
+			line := "PRIVMSG #" + channel + " :" + msg.text
+			if msg.reply_to != "" {
+				line = "@reply-parent-msg-id=" + msg.reply_to + " " + line
+			}
+			err := write(conn, write_mu, line)
+			if err != nil {
+				fmt.Println("Cannot send twitch chat message: " + err.Error())
+				return
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(sendInterval):
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+)
+
+// Message is an IRC message with the tags twitch adds to it.
+type Message struct {
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// unescapeTag undoes the IRCv3 escaping of a tag value. Other escaped
+// characters stand for themselves and a trailing backslash is dropped.
+func unescapeTag(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// ParseMessage parses a line of IRC without its line ending.
+func ParseMessage(line string) (Message, error) {
+	m := Message{Tags: map[string]string{}}
+	if strings.HasPrefix(line, "@") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return m, errors.New("message has only tags: " + line)
+		}
+		for _, tag := range strings.Split(line[1:i], ";") {
+			key, value, _ := strings.Cut(tag, "=")
+			m.Tags[key] = unescapeTag(value)
+		}
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return m, errors.New("message has only a prefix: " + line)
+		}
+		m.Prefix = line[1:i]
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		param, rest, _ := strings.Cut(line, " ")
+		if m.Command == "" {
+			m.Command = param
+		} else {
+			m.Params = append(m.Params, param)
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	if m.Command == "" {
+		return m, errors.New("message has no command")
+	}
+	return m, nil
+}
+
+// Nick is the nick of the prefix, the login of the user on twitch.
+func (m Message) Nick() string {
+	nick, _, _ := strings.Cut(m.Prefix, "!")
+	return nick
+}
+
+// PrivateMessage is a message sent to a channel. Badges are the badges of
+// the user by name with their version, e.g. subscriber/12.
+type PrivateMessage struct {
+	ID          string            `json:"id"`
+	Channel     string            `json:"channel"`
+	UserID      string            `json:"user_id"`
+	UserLogin   string            `json:"user_login"`
+	DisplayName string            `json:"display_name"`
+	Text        string            `json:"text"`
+	Badges      map[string]string `json:"badges"`
+	Time        time.Time         `json:"time"`
+}
+
+func privateMessage(m Message) PrivateMessage {
+	msg := PrivateMessage{
+		ID:          m.Tags["id"],
+		UserID:      m.Tags["user-id"],
+		UserLogin:   m.Nick(),
+		DisplayName: m.Tags["display-name"],
+		Badges:      map[string]string{},
+		Time:        time.Now(),
+	}
+	if len(m.Params) > 0 {
+		msg.Channel = strings.TrimPrefix(m.Params[0], "#")
+	}
+	if len(m.Params) > 1 {
+		msg.Text = m.Params[1]
+	}
+	if msg.DisplayName == "" {
+		msg.DisplayName = msg.UserLogin
+	}
+	if millis, err := strconv.ParseInt(m.Tags["tmi-sent-ts"], 10, 64); err == nil {
+		msg.Time = time.UnixMilli(millis)
+	}
+	for _, badge := range strings.Split(m.Tags["badges"], ",") {
+		name, version, _ := strings.Cut(badge, "/")
+		if name != "" {
+			msg.Badges[name] = version
+		}
+	}
+	return msg
+}
+
+func (msg PrivateMessage) Broadcaster() bool {
+	_, ok := msg.Badges["broadcaster"]
+	return ok
+}
+
+func (msg PrivateMessage) Moderator() bool {
+	_, ok := msg.Badges["moderator"]
+	return ok || msg.Broadcaster()
+}
+
+// Subscriber is true for founders too, they are subscribers with a
+// different badge.
+func (msg PrivateMessage) Subscriber() bool {
+	_, subscriber := msg.Badges["subscriber"]
+	_, founder := msg.Badges["founder"]
+	return subscriber || founder || msg.Moderator()
+}
+
+// Allowed reports whether the sender has permission, one of the permissions
+// of database.ChatCommand.
+func (msg PrivateMessage) Allowed(permission string) bool {
+	switch permission {
+	case database.PermissionBroadcaster:
+		return msg.Broadcaster()
+	case database.PermissionModerator:
+		return msg.Moderator()
+	case database.PermissionSubscriber:
+		return msg.Subscriber()
+	case database.PermissionEveryone:
+		return true
+	}
+	return false
+}
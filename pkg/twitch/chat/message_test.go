@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		message Message
+	}{
+		{
+			name:    "ping",
+			line:    "PING :tmi.twitch.tv",
+			message: Message{Tags: map[string]string{}, Command: "PING", Params: []string{"tmi.twitch.tv"}},
+		},
+		{
+			name: "numeric with several params",
+			line: ":tmi.twitch.tv 001 strmr :Welcome, GLHF!",
+			message: Message{
+				Tags:    map[string]string{},
+				Prefix:  "tmi.twitch.tv",
+				Command: "001",
+				Params:  []string{"strmr", "Welcome, GLHF!"},
+			},
+		},
+		{
+			name: "privmsg",
+			line: "@badge-info=;badges=turbo/1;color=#0D4200;display-name=ronni;emotes=25:0-4,12-16/1902:6-10;id=b34ccfc7-4977-403a-8a94-33c6bac34fb8;mod=0;room-id=1337;subscriber=0;tmi-sent-ts=1507246572675;turbo=1;user-id=1337;user-type=global_mod :ronni!ronni@ronni.tmi.twitch.tv PRIVMSG #ronni :Kappa Keepo Kappa",
+			message: Message{
+				Tags: map[string]string{
+					"badge-info":   "",
+					"badges":       "turbo/1",
+					"color":        "#0D4200",
+					"display-name": "ronni",
+					"emotes":       "25:0-4,12-16/1902:6-10",
+					"id":           "b34ccfc7-4977-403a-8a94-33c6bac34fb8",
+					"mod":          "0",
+					"room-id":      "1337",
+					"subscriber":   "0",
+					"tmi-sent-ts":  "1507246572675",
+					"turbo":        "1",
+					"user-id":      "1337",
+					"user-type":    "global_mod",
+				},
+				Prefix:  "ronni!ronni@ronni.tmi.twitch.tv",
+				Command: "PRIVMSG",
+				Params:  []string{"#ronni", "Kappa Keepo Kappa"},
+			},
+		},
+		{
+			name: "escaped tag values",
+			line: `@badges=;reply-parent-display-name=Some\sOne;reply-parent-msg-body=a\sb\:c\\d\re\nf;unknown=\x\;empty= :ronni!ronni@ronni.tmi.twitch.tv PRIVMSG #ronni :@Some One :)`,
+			message: Message{
+				Tags: map[string]string{
+					"badges":                    "",
+					"reply-parent-display-name": "Some One",
+					"reply-parent-msg-body":     "a b;c\\d\re\nf",
+					"unknown":                   "x",
+					"empty":                     "",
+				},
+				Prefix:  "ronni!ronni@ronni.tmi.twitch.tv",
+				Command: "PRIVMSG",
+				Params:  []string{"#ronni", "@Some One :)"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ParseMessage(test.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, test.message) {
+				t.Errorf("got %#v, want %#v", m, test.message)
+			}
+		})
+	}
+	for _, line := range []string{"@badges=broadcaster/1", ":ronni!ronni@ronni.tmi.twitch.tv", "@badges= :tmi.twitch.tv "} {
+		if _, err := ParseMessage(line); err == nil {
+			t.Errorf("parsed %q", line)
+		}
+	}
+}
+
+func TestPrivateMessage(t *testing.T) {
+	m, err := ParseMessage(`@badge-info=subscriber/14;badges=subscriber/12,bits/100;color=;display-name=Foo\sBar;emotes=;first-msg=0;flags=;id=3f3f2c1e-6b2d-4a83-9d7b-1f0e2a4c5d6e;mod=0;room-id=12345;subscriber=1;tmi-sent-ts=1643904084794;turbo=0;user-id=67890;user-type= :foo!foo@foo.tmi.twitch.tv PRIVMSG #strmr :!task`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PrivateMessage{
+		ID:          "3f3f2c1e-6b2d-4a83-9d7b-1f0e2a4c5d6e",
+		Channel:     "strmr",
+		UserID:      "67890",
+		UserLogin:   "foo",
+		DisplayName: "Foo Bar",
+		Text:        "!task",
+		Badges:      map[string]string{"subscriber": "12", "bits": "100"},
+		Time:        time.UnixMilli(1643904084794),
+	}
+	if msg := privateMessage(m); !reflect.DeepEqual(msg, want) {
+		t.Errorf("got %+v, want %+v", msg, want)
+	}
+
+	// without a display name or a sent time
+	m, err = ParseMessage(":foo!foo@foo.tmi.twitch.tv PRIVMSG #strmr :hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := privateMessage(m)
+	if msg.DisplayName != "foo" || len(msg.Badges) != 0 || time.Since(msg.Time) > time.Minute {
+		t.Errorf("got %+v", msg)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	prefix := " :foo!foo@foo.tmi.twitch.tv PRIVMSG #strmr :!so bar"
+	tests := []struct {
+		name    string
+		tags    string
+		allowed []string
+	}{
+		{"broadcaster", "@badge-info=;badges=broadcaster/1,premium/1", []string{database.PermissionBroadcaster, database.PermissionModerator, database.PermissionSubscriber, database.PermissionEveryone}},
+		{"moderator", "@badge-info=;badges=moderator/1", []string{database.PermissionModerator, database.PermissionSubscriber, database.PermissionEveryone}},
+		{"founder", "@badge-info=founder/3;badges=founder/0,premium/1", []string{database.PermissionSubscriber, database.PermissionEveryone}},
+		{"subscriber", "@badge-info=subscriber/14;badges=subscriber/12", []string{database.PermissionSubscriber, database.PermissionEveryone}},
+		{"viewer", "@badge-info=;badges=glhf-pledge/1", []string{database.PermissionEveryone}},
+		{"no badges", "@badge-info=;badges=", []string{database.PermissionEveryone}},
+	}
+	permissions := []string{database.PermissionBroadcaster, database.PermissionModerator, database.PermissionSubscriber, database.PermissionEveryone, "nobody"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ParseMessage(test.tags + prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := privateMessage(m)
+			for _, permission := range permissions {
+				want := false
+				for _, allowed := range test.allowed {
+					want = want || allowed == permission
+				}
+				if msg.Allowed(permission) != want {
+					t.Errorf("Allowed(%s) is %v, want %v", permission, !want, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+)
+
+// Prefix starts every command in chat.
+const Prefix = "!"
+
+// Command is a command used in chat. Name is the name of the command even
+// when it was used by an alias and Args is the text after it.
+type Command struct {
+	Name    string
+	Args    string
+	Message PrivateMessage
+}
+
+// Handler answers a command, nothing is said for an empty answer.
+type Handler func(ctx context.Context, command Command) (string, error)
+
+// Router answers the commands configured in the chat_command table. Built in
+// commands are answered by their handler and the others with their response.
+// Commands are read for every message, so changes apply at once.
+type Router struct {
+	database  *database.Database
+	handlers  map[string]Handler
+	mu        sync.Mutex
+	used      map[string]time.Time
+	user_used map[string]time.Time
+}
+
+func NewRouter(db *database.Database) *Router {
+	return &Router{
+		database:  db,
+		handlers:  map[string]Handler{},
+		used:      map[string]time.Time{},
+		user_used: map[string]time.Time{},
+	}
+}
+
+// Handle makes handler answer the command name. The command still has to be
+// in the chat_command table to be used.
+func (r *Router) Handle(name string, handler Handler) {
+	r.handlers[strings.ToLower(name)] = handler
+}
+
+// cool reports whether command is off cooldown for the sender of msg and
+// starts its cooldowns when it is. Moderators have no cooldowns.
+func (r *Router) cool(command *database.ChatCommand, msg PrivateMessage, now time.Time) bool {
+	if msg.Moderator() {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user_key := command.Name + " " + msg.UserID
+	if now.Sub(r.used[command.Name]) < time.Duration(command.CooldownSeconds)*time.Second {
+		return false
+	}
+	if now.Sub(r.user_used[user_key]) < time.Duration(command.UserCooldownSeconds)*time.Second {
+		return false
+	}
+	r.used[command.Name] = now
+	r.user_used[user_key] = now
+	return true
+}
+
+// Route answers msg when it is a command the sender may use and is not
+// cooling down, otherwise the answer is empty.
+func (r *Router) Route(ctx context.Context, msg PrivateMessage) (string, error) {
+	text := strings.TrimSpace(msg.Text)
+	if !strings.HasPrefix(text, Prefix) {
+		return "", nil
+	}
+	name, args, _ := strings.Cut(strings.TrimPrefix(text, Prefix), " ")
+	if name == "" {
+		return "", nil
+	}
+	command, err := r.database.GetChatCommandContext(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if command == nil || !command.Enabled || !msg.Allowed(command.Permission) {
+		return "", nil
+	}
+	if !r.cool(command, msg, time.Now()) {
+		return "", nil
+	}
+	handler, ok := r.handlers[strings.ToLower(command.Name)]
+	if !ok {
+		return command.Response, nil
+	}
+	return handler(ctx, Command{
+		Name:    command.Name,
+		Args:    strings.TrimSpace(args),
+		Message: msg,
+	})
+}
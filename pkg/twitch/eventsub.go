@@ -0,0 +1,621 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jnrprgmr/strmr/pkg/database"
+)
+
+const (
+	defaultEventSubURL      = "wss://eventsub.wss.twitch.tv/ws"
+	defaultSubscriptionsURL = "https://api.twitch.tv/helix/eventsub/subscriptions"
+	// eventSubWelcome is how long a new connection may take to be welcomed.
+	eventSubWelcome = 10 * time.Second
+	// eventSubMargin is added to the keepalive of the session before the
+	// connection is taken as lost.
+	eventSubMargin = 10 * time.Second
+	// eventSubDrain is how long the old connection of a reconnect is read
+	// after the new one is welcomed, twitch closes it sooner.
+	eventSubDrain      = 10 * time.Second
+	maxEventSubBackoff = time.Minute
+	eventBuffer        = 16
+)
+
+// The subscription types of EventSub that strmr subscribes to.
+const (
+	EventStreamOnline  = "stream.online"
+	EventStreamOffline = "stream.offline"
+	EventChannelUpdate = "channel.update"
+	EventFollow        = "channel.follow"
+	EventSubscribe     = "channel.subscribe"
+	EventCheer         = "channel.cheer"
+	EventRaid          = "channel.raid"
+	EventRedemption    = "channel.channel_points_custom_reward_redemption.add"
+)
+
+// EventScopes are the scopes the user access token needs for every event.
+var EventScopes = []string{"moderator:read:followers", "channel:read:subscriptions", "bits:read", "channel:read:redemptions"}
+
+type eventSubType struct {
+	version   string
+	condition func(user_id string) map[string]string
+}
+
+func broadcasterCondition(user_id string) map[string]string {
+	return map[string]string{"broadcaster_user_id": user_id}
+}
+
+var eventSubTypes = map[string]eventSubType{
+	EventStreamOnline:  {version: "1", condition: broadcasterCondition},
+	EventStreamOffline: {version: "1", condition: broadcasterCondition},
+	EventChannelUpdate: {version: "2", condition: broadcasterCondition},
+	EventFollow: {version: "2", condition: func(user_id string) map[string]string {
+		// the broadcaster is a moderator of their own channel
+		return map[string]string{"broadcaster_user_id": user_id, "moderator_user_id": user_id}
+	}},
+	EventSubscribe: {version: "1", condition: broadcasterCondition},
+	EventCheer:     {version: "1", condition: broadcasterCondition},
+	EventRaid: {version: "1", condition: func(user_id string) map[string]string {
+		// raids into the channel, not out of it
+		return map[string]string{"to_broadcaster_user_id": user_id}
+	}},
+	EventRedemption: {version: "1", condition: broadcasterCondition},
+}
+
+type EventSubConfig struct {
+	// Enabled connects to EventSub. It needs the scopes in EventScopes, a
+	// token authorized before they were asked for has to be authorized again.
+	Enabled bool `yaml:"enabled"`
+	// URL is the EventSub WebSocket, wss://eventsub.wss.twitch.tv/ws when
+	// empty. ws://127.0.0.1:8080/ws for the mock server of the twitch CLI.
+	URL string `yaml:"url"`
+	// SubscriptionsURL creates the subscriptions,
+	// https://api.twitch.tv/helix/eventsub/subscriptions when empty.
+	// http://127.0.0.1:8080/eventsub/subscriptions for the twitch CLI.
+	SubscriptionsURL string `yaml:"subscriptions_url"`
+	// Types are the subscription types to subscribe to, every one strmr
+	// knows when empty.
+	Types []string `yaml:"types"`
+}
+
+// Event is a notification from EventSub. ID is the id of the message, which
+// twitch can send more than once, and Data is the event of the subscription
+// type as twitch sent it.
+type Event struct {
+	ID                string          `json:"id"`
+	Type              string          `json:"type"`
+	Version           string          `json:"version"`
+	BroadcasterUserID string          `json:"broadcaster_user_id"`
+	Time              time.Time       `json:"time"`
+	Data              json.RawMessage `json:"data"`
+}
+
+// Decode unmarshals the data of the event into v, e.g. a *FollowEvent.
+func (e Event) Decode(v interface{}) error {
+	err := json.Unmarshal(e.Data, v)
+	if err != nil {
+		return errors.New("Cannot decode " + e.Type + " event: " + err.Error())
+	}
+	return nil
+}
+
+type StreamOnlineEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type ChannelUpdateEvent struct {
+	Title        string `json:"title"`
+	Language     string `json:"language"`
+	CategoryID   string `json:"category_id"`
+	CategoryName string `json:"category_name"`
+}
+
+type FollowEvent struct {
+	UserID     string    `json:"user_id"`
+	UserLogin  string    `json:"user_login"`
+	UserName   string    `json:"user_name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type SubscribeEvent struct {
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+	Tier      string `json:"tier"`
+	IsGift    bool   `json:"is_gift"`
+}
+
+// CheerEvent has no user when IsAnonymous.
+type CheerEvent struct {
+	IsAnonymous bool   `json:"is_anonymous"`
+	UserID      string `json:"user_id"`
+	UserLogin   string `json:"user_login"`
+	UserName    string `json:"user_name"`
+	Message     string `json:"message"`
+	Bits        int    `json:"bits"`
+}
+
+type RaidEvent struct {
+	FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+	FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+	Viewers                  int    `json:"viewers"`
+}
+
+type RedemptionEvent struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	UserLogin string `json:"user_login"`
+	UserName  string `json:"user_name"`
+	UserInput string `json:"user_input"`
+	Status    string `json:"status"`
+	Reward    struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Cost   int    `json:"cost"`
+		Prompt string `json:"prompt"`
+	} `json:"reward"`
+}
+
+type eventSubSession struct {
+	ID                      string `json:"id"`
+	Status                  string `json:"status"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+type eventSubSubscription struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Status    string            `json:"status"`
+	Condition map[string]string `json:"condition"`
+}
+
+type eventSubMessage struct {
+	Metadata struct {
+		MessageID           string    `json:"message_id"`
+		MessageType         string    `json:"message_type"`
+		MessageTimestamp    time.Time `json:"message_timestamp"`
+		SubscriptionType    string    `json:"subscription_type"`
+		SubscriptionVersion string    `json:"subscription_version"`
+	} `json:"metadata"`
+	Payload struct {
+		Session      *eventSubSession      `json:"session"`
+		Subscription *eventSubSubscription `json:"subscription"`
+		Event        json.RawMessage       `json:"event"`
+	} `json:"payload"`
+}
+
+// EventSub receives the events of the channel from the EventSub WebSocket of
+// twitch. Every event is stored in the twitch_event table once and sent to
+// the subscribers of its type.
+type EventSub struct {
+	twitch            *Twitch
+	db                *database.Database
+	url               string
+	subscriptions_url string
+	types             []string
+	mu                sync.Mutex
+	subscribers       map[chan Event][]string
+}
+
+func NewEventSub(t *Twitch, db *database.Database, c EventSubConfig) (*EventSub, error) {
+	e := &EventSub{
+		twitch:            t,
+		db:                db,
+		url:               c.URL,
+		subscriptions_url: c.SubscriptionsURL,
+		types:             c.Types,
+		subscribers:       map[chan Event][]string{},
+	}
+	if e.url == "" {
+		e.url = defaultEventSubURL
+	}
+	if e.subscriptions_url == "" {
+		e.subscriptions_url = defaultSubscriptionsURL
+	}
+	known := []string{}
+	for event_type := range eventSubTypes {
+		known = append(known, event_type)
+	}
+	sort.Strings(known)
+	if len(e.types) == 0 {
+		e.types = known
+	}
+	for _, event_type := range e.types {
+		if _, ok := eventSubTypes[event_type]; !ok {
+			return nil, errors.New("EventSub type [" + event_type + "] is not one of " + strings.Join(known, ", "))
+		}
+	}
+	return e, nil
+}
+
+// Subscribe returns the events of types, or of every type without any, and
+// a func to stop them. Events are dropped for a subscriber that falls
+// behind.
+func (e *EventSub) Subscribe(types ...string) (<-chan Event, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	events := make(chan Event, eventBuffer)
+	e.subscribers[events] = types
+	return events, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subscribers[events]; ok {
+			delete(e.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+func (e *EventSub) publish(event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for events, types := range e.subscribers {
+		wanted := len(types) == 0
+		for _, event_type := range types {
+			wanted = wanted || event_type == event.Type
+		}
+		if !wanted {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Run stays connected to EventSub until ctx is cancelled. A lost connection
+// is made again with backoff and subscribed again, while twitch is not
+// authorized it waits for it to be.
+func (e *EventSub) Run(ctx context.Context) {
+	token_events, stop_token_events := e.twitch.Tokens.Subscribe()
+	defer stop_token_events()
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := e.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == ErrReauthorize {
+			fmt.Println("Twitch EventSub waits for twitch to be authorized")
+			for authorized := false; !authorized; {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-token_events:
+					authorized = event.Type == TokenAuthorized
+				}
+			}
+			backoff = time.Second
+			continue
+		}
+		if time.Since(started) > maxEventSubBackoff {
+			backoff = time.Second
+		}
+		fmt.Println("Lost twitch EventSub connection, connecting again in " + backoff.String() + ": " + err.Error())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = backoff * 2
+		if backoff > maxEventSubBackoff {
+			backoff = maxEventSubBackoff
+		}
+	}
+}
+
+// dial connects to url and waits for the welcome of the session.
+func (e *EventSub) dial(ctx context.Context, url string) (*websocket.Conn, *eventSubSession, error) {
+	dial_ctx, cancel := context.WithTimeout(ctx, eventSubWelcome)
+	defer cancel()
+	conn, _, err := websocket.DefaultDialer.DialContext(dial_ctx, url, nil)
+	if err != nil {
+		return nil, nil, errors.New("Cannot connect to " + url + ": " + err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(eventSubWelcome))
+	var msg eventSubMessage
+	err = conn.ReadJSON(&msg)
+	if err != nil {
+		conn.Close()
+		return nil, nil, errors.New("Cannot read welcome from " + url + ": " + err.Error())
+	}
+	if msg.Metadata.MessageType != "session_welcome" || msg.Payload.Session == nil {
+		conn.Close()
+		return nil, nil, errors.New("Expected a welcome from " + url + ", got " + msg.Metadata.MessageType)
+	}
+	return conn, msg.Payload.Session, nil
+}
+
+// eventSubRead is a message read from a connection, or why reading stopped.
+type eventSubRead struct {
+	msg eventSubMessage
+	err error
+}
+
+// eventSubDial is a connection made for a reconnect.
+type eventSubDial struct {
+	conn    *websocket.Conn
+	session *eventSubSession
+	err     error
+}
+
+// read sends the messages of conn to reads until reading fails, which is
+// sent last, or done is closed. Each read waits for the keepalive of the
+// session and a margin.
+func (e *EventSub) read(conn *websocket.Conn, session *eventSubSession, reads chan<- eventSubRead, done <-chan struct{}) {
+	keepalive := time.Duration(session.KeepaliveTimeoutSeconds) * time.Second
+	for {
+		conn.SetReadDeadline(time.Now().Add(keepalive + eventSubMargin))
+		var msg eventSubMessage
+		err := conn.ReadJSON(&msg)
+		select {
+		case reads <- eventSubRead{msg: msg, err: err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// session connects, subscribes and handles messages until the connection
+// is lost or ctx is cancelled. A reconnect from twitch dials the new
+// connection while the old one is still read, the old one is read until it
+// closes, or for eventSubDrain, after the new one is welcomed and then the
+// new one takes over with the subscriptions.
+func (e *EventSub) session(ctx context.Context) error {
+	_, err := e.twitch.Tokens.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	conn, session, err := e.dial(ctx, e.url)
+	if err != nil {
+		return err
+	}
+	// next is the welcomed connection of a reconnect while the old one drains
+	var next *eventSubDial
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		conn.Close()
+		if next != nil {
+			next.conn.Close()
+		}
+	}()
+	err = e.subscribe(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Connected to twitch EventSub session " + session.ID)
+	reads := make(chan eventSubRead)
+	go e.read(conn, session, reads, done)
+	var dialed chan eventSubDial
+	var drained <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d := <-dialed:
+			dialed = nil
+			if d.err != nil {
+				return d.err
+			}
+			next = &d
+			drained = time.After(eventSubDrain)
+		case <-drained:
+			// reading fails and the new connection takes over
+			conn.Close()
+		case r := <-reads:
+			if r.err != nil && dialed != nil {
+				// twitch closes the old connection as soon as the new one
+				// is welcomed, which can be before the dial returns
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case d := <-dialed:
+					dialed = nil
+					if d.err != nil {
+						return d.err
+					}
+					next = &d
+				}
+			}
+			if r.err != nil && next != nil {
+				conn.Close()
+				conn, session = next.conn, next.session
+				next = nil
+				drained = nil
+				reads = make(chan eventSubRead)
+				go e.read(conn, session, reads, done)
+				fmt.Println("Reconnected to twitch EventSub session " + session.ID)
+				continue
+			}
+			if r.err != nil {
+				return errors.New("Cannot read from twitch EventSub: " + r.err.Error())
+			}
+			msg := r.msg
+			switch msg.Metadata.MessageType {
+			case "notification":
+				e.notify(ctx, msg)
+			case "session_reconnect":
+				if msg.Payload.Session == nil || msg.Payload.Session.ReconnectURL == "" || dialed != nil || next != nil {
+					continue
+				}
+				dialed = make(chan eventSubDial)
+				go func(url string, dialed chan<- eventSubDial) {
+					conn, session, err := e.dial(ctx, url)
+					select {
+					case dialed <- eventSubDial{conn: conn, session: session, err: err}:
+					case <-done:
+						if conn != nil {
+							conn.Close()
+						}
+					}
+				}(msg.Payload.Session.ReconnectURL, dialed)
+			case "revocation":
+				if s := msg.Payload.Subscription; s != nil {
+					fmt.Println("Twitch revoked EventSub subscription " + s.Type + ": " + s.Status)
+				}
+			}
+		}
+	}
+}
+
+// subscribe subscribes session to every type for the user of the token.
+// Types twitch refuses are left out, e.g. for a missing scope.
+func (e *EventSub) subscribe(ctx context.Context, session_id string) error {
+	users, err := e.twitch.GetUsersContext(ctx, nil)
+	if err != nil {
+		return err
+	}
+	user_id := ""
+	for _, id := range users {
+		user_id = id
+	}
+	if user_id == "" {
+		return errors.New("Cannot find the twitch user of the token")
+	}
+	subscribed := 0
+	for _, event_type := range e.types {
+		err := e.create(ctx, session_id, event_type, user_id)
+		if err != nil {
+			fmt.Println("Cannot subscribe to twitch EventSub " + event_type + ": " + err.Error())
+			continue
+		}
+		subscribed++
+	}
+	if subscribed == 0 {
+		return errors.New("Cannot subscribe to any twitch EventSub type")
+	}
+	return nil
+}
+
+// create makes a subscription of event_type for session. One that exists
+// already is fine.
+func (e *EventSub) create(ctx context.Context, session_id string, event_type string, user_id string) error {
+	t := eventSubTypes[event_type]
+	body, err := json.Marshal(map[string]interface{}{
+		"type":      event_type,
+		"version":   t.version,
+		"condition": t.condition(user_id),
+		"transport": map[string]string{
+			"method":     "websocket",
+			"session_id": session_id,
+		},
+	})
+	if err != nil {
+		return errors.New("Cannot marshal subscription: " + err.Error())
+	}
+	token, err := e.twitch.Tokens.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	for retried := false; ; retried = true {
+		status, msg, err := e.post(ctx, token, body)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusUnauthorized && !retried {
+			token, err = e.twitch.Tokens.Refresh(ctx, token)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if status == http.StatusConflict {
+			return nil
+		}
+		if status >= 400 {
+			return errors.New("twitch answered " + strconv.Itoa(status) + ": " + msg)
+		}
+		return nil
+	}
+}
+
+// post sends a subscription, helix cannot as its transport has no session.
+func (e *EventSub) post(ctx context.Context, token string, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.subscriptions_url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", errors.New("Cannot make subscription request: " + err.Error())
+	}
+	req.Header.Set("Client-Id", e.twitch.options.ClientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	var resp *http.Response
+	if e.twitch.options.HTTPClient != nil {
+		resp, err = e.twitch.options.HTTPClient.Do(req)
+	} else {
+		resp, err = http.DefaultClient.Do(req)
+	}
+	if err != nil {
+		return 0, "", errors.New("Cannot send subscription request: " + err.Error())
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", errors.New("Cannot read subscription response: " + err.Error())
+	}
+	var answer struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(b, &answer)
+	return resp.StatusCode, answer.Message, nil
+}
+
+// notify stores the event of a notification and publishes it, unless it has
+// been received before.
+func (e *EventSub) notify(ctx context.Context, msg eventSubMessage) {
+	event := Event{
+		ID:      msg.Metadata.MessageID,
+		Type:    msg.Metadata.SubscriptionType,
+		Version: msg.Metadata.SubscriptionVersion,
+		Time:    msg.Metadata.MessageTimestamp,
+		Data:    msg.Payload.Event,
+	}
+	if s := msg.Payload.Subscription; s != nil {
+		event.BroadcasterUserID = s.Condition["broadcaster_user_id"]
+		if event.BroadcasterUserID == "" {
+			event.BroadcasterUserID = s.Condition["to_broadcaster_user_id"]
+		}
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if len(event.Data) == 0 {
+		event.Data = json.RawMessage("{}")
+	}
+	inserted, err := e.db.InsertTwitchEventContext(ctx, database.TwitchEvent{
+		MessageID:         event.ID,
+		EventType:         event.Type,
+		BroadcasterUserID: event.BroadcasterUserID,
+		Event:             string(event.Data),
+		EventMillis:       event.Time.UnixMilli(),
+	})
+	if err != nil {
+		// still worth passing on
+		fmt.Println("Cannot store twitch event " + event.ID + ": " + err.Error())
+	} else if !inserted {
+		return
+	}
+	e.publish(event)
+}
@@ -0,0 +1,144 @@
+package twitch
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/twitch/eventsubtest"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nicklaw5/helix/v2"
+)
+
+// newEventSub runs an EventSub for follows against a fake, with an
+// authorized token and a fresh database.
+func newEventSub(t *testing.T) (*eventsubtest.Server, <-chan Event) {
+	t.Helper()
+	server := eventsubtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AccessToken = "token"
+	dir := t.TempDir()
+	conn, err := sqlx.Open("sqlite3", filepath.Join(dir, "strmr.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	err = database.Migrate(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.New(conn)
+	tw, err := New(&helix.Options{ClientID: "client", APIBaseURL: server.HelixURL()}, db, Config{KeyFile: filepath.Join(dir, "twitch.key")}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tw.Tokens.save(context.Background(), Token{AccessToken: "token", RefreshToken: "refresh", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventsub, err := NewEventSub(tw, db, EventSubConfig{
+		Enabled:          true,
+		URL:              server.URL(),
+		SubscriptionsURL: server.SubscriptionsURL(),
+		Types:            []string{EventFollow},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, stop_events := eventsub.Subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		eventsub.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+		stop_events()
+	})
+	return server, events
+}
+
+func waitForEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func waitForSubscriptions(t *testing.T, server *eventsubtest.Server) []eventsubtest.Subscription {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if subscriptions := server.Subscriptions(); len(subscriptions) > 0 {
+			return subscriptions
+		}
+	}
+	t.Fatal("EventSub did not subscribe")
+	return nil
+}
+
+func follow(login string) FollowEvent {
+	return FollowEvent{UserID: "1", UserLogin: login, UserName: login, FollowedAt: time.Now()}
+}
+
+func TestEventSub(t *testing.T) {
+	server, events := newEventSub(t)
+
+	subscriptions := waitForSubscriptions(t, server)
+	if len(subscriptions) != 1 || subscriptions[0].Type != EventFollow || subscriptions[0].Condition["broadcaster_user_id"] != "1234" {
+		t.Fatalf("subscribed to %v", subscriptions)
+	}
+
+	if server.Notify("first", EventFollow, follow("alice")) != 1 {
+		t.Fatal("the fake sent no notification")
+	}
+	event := waitForEvent(t, events)
+	var data FollowEvent
+	err := event.Decode(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != "first" || event.Type != EventFollow || event.BroadcasterUserID != "1234" || data.UserLogin != "alice" {
+		t.Errorf("got event %+v of %+v", event, data)
+	}
+
+	// twitch can send a message twice, only the first one is an event
+	server.Notify("first", EventFollow, follow("alice"))
+	server.Notify("second", EventFollow, follow("bob"))
+	if event := waitForEvent(t, events); event.ID != "second" {
+		t.Errorf("got event %s after the duplicate, want second", event.ID)
+	}
+
+	// sent to the old connection while the client dials the new one
+	server.Reconnect()
+	server.Notify("during-reconnect", EventFollow, follow("carol"))
+	if event := waitForEvent(t, events); event.ID != "during-reconnect" {
+		t.Errorf("got event %s, want during-reconnect", event.ID)
+	}
+	// the new connection gets the notifications once the old one is closed
+	received := false
+	for i := 0; i < 50 && !received; i++ {
+		server.Notify("after-reconnect-"+strconv.Itoa(i), EventFollow, follow("dave"))
+		select {
+		case event := <-events:
+			received = event.ID == "after-reconnect-"+strconv.Itoa(i)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if !received {
+		t.Fatal("no event received after the reconnect")
+	}
+	after := server.Subscriptions()
+	if len(after) != 1 || after[0].ID != subscriptions[0].ID {
+		t.Errorf("want the session and its subscription kept, got %v", after)
+	}
+}
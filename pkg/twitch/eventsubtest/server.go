@@ -0,0 +1,422 @@
+// Package eventsubtest provides an in-process twitch EventSub server, the
+// WebSocket at /ws, the subscriptions endpoint at /eventsub/subscriptions and
+// the helix users endpoint at /helix/users, with enough of them for the
+// EventSub client of strmr to find its user, connect, subscribe, receive
+// notifications and follow a reconnect.
+package eventsubtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subscription is a subscription created through the fake.
+type Subscription struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Status    string            `json:"status"`
+	Condition map[string]string `json:"condition"`
+	SessionID string            `json:"-"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type conn struct {
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	session string
+}
+
+// delivery is a message for a connection, made while the server is locked
+// and written after.
+type delivery struct {
+	conn    *conn
+	message map[string]interface{}
+}
+
+func (c *conn) write(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(v)
+}
+
+func (c *conn) close(code int, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
+
+// Server is a fake EventSub. Keepalive is the keepalive timeout of new
+// sessions in seconds, 10 when 0. When AccessToken is set subscriptions with
+// any other token are refused with 401. UserID and UserLogin are the user of
+// the token, 1234 and strmr when empty.
+type Server struct {
+	Keepalive   int
+	AccessToken string
+	UserID      string
+	UserLogin   string
+
+	http     *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu            sync.Mutex
+	conns         map[string]*conn
+	subscriptions []Subscription
+}
+
+func NewServer() *Server {
+	s := &Server{
+		conns: map[string]*conn{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.serve)
+	mux.HandleFunc("/eventsub/subscriptions", s.subscribe)
+	mux.HandleFunc("/helix/users", s.users)
+	s.http = httptest.NewServer(mux)
+	return s
+}
+
+// URL is the EventSub WebSocket.
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.http.URL, "http") + "/ws"
+}
+
+// SubscriptionsURL is where subscriptions are created.
+func (s *Server) SubscriptionsURL() string {
+	return s.http.URL + "/eventsub/subscriptions"
+}
+
+// HelixURL is the base URL of the helix API, for helix.Options.APIBaseURL.
+func (s *Server) HelixURL() string {
+	return s.http.URL + "/helix"
+}
+
+// Subscriptions are the subscriptions of the sessions that are connected.
+func (s *Server) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriptions := []Subscription{}
+	for _, subscription := range s.subscriptions {
+		if _, ok := s.conns[subscription.SessionID]; ok {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions
+}
+
+// DropConnections closes every connection without a close handshake, the
+// subscriptions of their sessions are gone like on twitch.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = map[string]*conn{}
+	s.subscriptions = nil
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.ws.UnderlyingConn().Close()
+	}
+}
+
+func (s *Server) Close() {
+	s.DropConnections()
+	s.http.Close()
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func message(message_type string, subscription *Subscription, payload map[string]interface{}) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"message_id":        randomID(),
+		"message_type":      message_type,
+		"message_timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if subscription != nil {
+		metadata["subscription_type"] = subscription.Type
+		metadata["subscription_version"] = subscription.Version
+	}
+	return map[string]interface{}{
+		"metadata": metadata,
+		"payload":  payload,
+	}
+}
+
+// keepalive is the keepalive timeout of sessions in seconds.
+func (s *Server) keepalive() int {
+	if s.Keepalive <= 0 {
+		return 10
+	}
+	return s.Keepalive
+}
+
+func (s *Server) session(id string, status string, reconnect_url string) map[string]interface{} {
+	session := map[string]interface{}{
+		"id":                        id,
+		"status":                    status,
+		"connected_at":              time.Now().UTC().Format(time.RFC3339Nano),
+		"keepalive_timeout_seconds": s.keepalive(),
+		"reconnect_url":             nil,
+	}
+	if reconnect_url != "" {
+		session["reconnect_url"] = reconnect_url
+	}
+	return session
+}
+
+// serve welcomes a new session, or the session of ?reconnect which moves to
+// the new connection with its subscriptions, and sends keepalives. The old
+// connection of a reconnect is closed once the new one is welcomed.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+	session := r.URL.Query().Get("reconnect")
+	if session == "" {
+		session = randomID()
+	}
+	c := &conn{ws: ws, session: session}
+	s.mu.Lock()
+	old := s.conns[session]
+	s.conns[session] = c
+	welcome := message("session_welcome", nil, map[string]interface{}{
+		"session": s.session(session, "connected", ""),
+	})
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if s.conns[session] == c {
+			delete(s.conns, session)
+		}
+		s.mu.Unlock()
+	}()
+	if c.write(welcome) != nil {
+		return
+	}
+	if old != nil {
+		old.close(websocket.CloseNormalClosure, "session moved to a new connection")
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(time.Duration(s.keepalive()) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if c.write(message("session_keepalive", nil, map[string]interface{}{})) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	answer := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	fail := func(status int, msg string) {
+		answer(status, map[string]interface{}{
+			"error":   http.StatusText(status),
+			"status":  status,
+			"message": msg,
+		})
+	}
+	if r.Header.Get("Client-Id") == "" {
+		fail(http.StatusUnauthorized, "Client-Id header required")
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || (s.AccessToken != "" && token != s.AccessToken) {
+		fail(http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+	var req struct {
+		Type      string            `json:"type"`
+		Version   string            `json:"version"`
+		Condition map[string]string `json:"condition"`
+		Transport struct {
+			Method    string `json:"method"`
+			SessionID string `json:"session_id"`
+		} `json:"transport"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Type == "" || req.Version == "" || req.Transport.Method != "websocket" {
+		fail(http.StatusBadRequest, "invalid subscription")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.conns[req.Transport.SessionID]; !ok {
+		fail(http.StatusBadRequest, "session does not exist or has already disconnected")
+		return
+	}
+	for _, subscription := range s.subscriptions {
+		if subscription.SessionID == req.Transport.SessionID && subscription.Type == req.Type && subscription.Version == req.Version && sameCondition(subscription.Condition, req.Condition) {
+			fail(http.StatusConflict, "subscription already exists")
+			return
+		}
+	}
+	subscription := Subscription{
+		ID:        randomID(),
+		Type:      req.Type,
+		Version:   req.Version,
+		Status:    "enabled",
+		Condition: req.Condition,
+		SessionID: req.Transport.SessionID,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.subscriptions = append(s.subscriptions, subscription)
+	answer(http.StatusAccepted, map[string]interface{}{
+		"data":           []Subscription{subscription},
+		"total":          len(s.subscriptions),
+		"total_cost":     0,
+		"max_total_cost": 10,
+	})
+}
+
+// users answers with the user of the token whatever logins are asked for,
+// which is all EventSub asks for.
+func (s *Server) users(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || (s.AccessToken != "" && token != s.AccessToken) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   http.StatusText(http.StatusUnauthorized),
+			"status":  http.StatusUnauthorized,
+			"message": "Invalid OAuth token",
+		})
+		return
+	}
+	id, login := s.UserID, s.UserLogin
+	if id == "" {
+		id = "1234"
+	}
+	if login == "" {
+		login = "strmr"
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": []map[string]string{{"id": id, "login": login, "display_name": login}},
+	})
+}
+
+func sameCondition(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Notify sends event to every session subscribed to event_type and returns
+// how many there were. The message gets message_id, a new one when empty,
+// so a message twitch sends twice can be sent again with the same id.
+func (s *Server) Notify(message_id string, event_type string, event interface{}) int {
+	s.mu.Lock()
+	deliveries := []delivery{}
+	for i := range s.subscriptions {
+		subscription := s.subscriptions[i]
+		c, ok := s.conns[subscription.SessionID]
+		if !ok || subscription.Type != event_type {
+			continue
+		}
+		m := message("notification", &subscription, map[string]interface{}{
+			"subscription": subscription,
+			"event":        event,
+		})
+		if message_id != "" {
+			m["metadata"].(map[string]interface{})["message_id"] = message_id
+		}
+		deliveries = append(deliveries, delivery{conn: c, message: m})
+	}
+	s.mu.Unlock()
+	sent := 0
+	for _, d := range deliveries {
+		if d.conn.write(d.message) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Reconnect asks every session to move to a new connection, like twitch
+// does before it restarts an edge server.
+func (s *Server) Reconnect() {
+	s.mu.Lock()
+	deliveries := []delivery{}
+	for session, c := range s.conns {
+		url := s.URL() + "?reconnect=" + session
+		deliveries = append(deliveries, delivery{conn: c, message: message("session_reconnect", nil, map[string]interface{}{
+			"session": s.session(session, "reconnecting", url),
+		})})
+	}
+	s.mu.Unlock()
+	for _, d := range deliveries {
+		d.conn.write(d.message)
+	}
+}
+
+// Revoke revokes the subscriptions of event_type with status, e.g.
+// authorization_revoked.
+func (s *Server) Revoke(event_type string, status string) {
+	s.mu.Lock()
+	deliveries := []delivery{}
+	kept := []Subscription{}
+	for i := range s.subscriptions {
+		subscription := s.subscriptions[i]
+		c, ok := s.conns[subscription.SessionID]
+		if subscription.Type != event_type {
+			kept = append(kept, subscription)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		subscription.Status = status
+		deliveries = append(deliveries, delivery{conn: c, message: message("revocation", &subscription, map[string]interface{}{
+			"subscription": subscription,
+		})})
+	}
+	s.subscriptions = kept
+	s.mu.Unlock()
+	for _, d := range deliveries {
+		d.conn.write(d.message)
+	}
+}
@@ -0,0 +1,540 @@
+package twitch
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/nicklaw5/helix/v2"
+)
+
+const (
+	// legacyCredential is the row of the credential table the tokens were in
+	// before every account had its own.
+	legacyCredential = "twitch"
+	defaultKeyFile   = "conf/twitch.key"
+	// twitch asks apps to validate their tokens every hour
+	defaultValidateEvery = time.Hour
+	defaultRefreshBefore = 10 * time.Minute
+	// tokenCheck is how often Run looks whether a check is due.
+	tokenCheck = time.Minute
+	keySize    = 32
+)
+
+const (
+	TokenAuthorized  = "authorized"
+	TokenRefreshed   = "refreshed"
+	TokenReauthorize = "reauthorize"
+)
+
+var (
+	ErrReauthorize = errors.New("twitch needs to be authorized again")
+	// ErrNoAccount is returned when twitch is authorized without an account,
+	// the tokens would be stored where no account can use them.
+	ErrNoAccount = errors.New("no account to authorize twitch for, set account in conf/local.yaml")
+)
+
+type Config struct {
+	// KeyFile holds the key the tokens are encrypted with, it is made when
+	// missing. conf/twitch.key when empty.
+	KeyFile string `yaml:"key_file"`
+	// ValidateEvery is how often the access token is validated, 1h when 0.
+	ValidateEvery time.Duration `yaml:"validate_every"`
+	// RefreshBefore is how long before it expires the access token is
+	// refreshed, 10m when 0.
+	RefreshBefore time.Duration `yaml:"refresh_before"`
+	// EventSub receives the events of the channel.
+	EventSub EventSubConfig `yaml:"eventsub"`
+}
+
+// Token is what is stored, encrypted, in the credential table. ExpiresAt is
+// zero until the token has been validated.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Scopes       []string  `json:"scopes"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// TokenEvent is sent to subscribers when the tokens change, Error says why
+// twitch has to be authorized again.
+type TokenEvent struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+type TokenStatus struct {
+	Authorized bool      `json:"authorized"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Validated  time.Time `json:"validated"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// credentialName is the row of the credential table the tokens of the
+// account are in.
+func credentialName(account_id int64) string {
+	return "twitch:" + strconv.FormatInt(account_id, 10)
+}
+
+// Tokens keeps the user access token an account authorized twitch with
+// valid. It validates the token on a schedule, refreshes it before it
+// expires, one refresh at a time, and stores it encrypted with a local key.
+type Tokens struct {
+	database       *database.Database
+	account_id     int64
+	credential     string
+	options        helix.Options
+	aead           cipher.AEAD
+	validate_every time.Duration
+	refresh_before time.Duration
+	// refresh is held for the whole of a refresh, mu only to read and set
+	refresh     sync.Mutex
+	mu          sync.Mutex
+	token       Token
+	validated   time.Time
+	reauthorize bool
+	last_error  string
+	subscribers map[chan TokenEvent]struct{}
+}
+
+func NewTokens(db *database.Database, options helix.Options, c Config, account_id int64) (*Tokens, error) {
+	t := &Tokens{
+		database:       db,
+		account_id:     account_id,
+		credential:     credentialName(account_id),
+		options:        options,
+		validate_every: c.ValidateEvery,
+		refresh_before: c.RefreshBefore,
+		subscribers:    map[chan TokenEvent]struct{}{},
+	}
+	if t.validate_every <= 0 {
+		t.validate_every = defaultValidateEvery
+	}
+	if t.refresh_before <= 0 {
+		t.refresh_before = defaultRefreshBefore
+	}
+	key_file := c.KeyFile
+	if key_file == "" {
+		key_file = defaultKeyFile
+	}
+	key, err := loadKey(key_file)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("Cannot use key " + key_file + ": " + err.Error())
+	}
+	t.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.New("Cannot use key " + key_file + ": " + err.Error())
+	}
+	return t, nil
+}
+
+// loadKey reads the hex encoded key in file, making one if there is none.
+func loadKey(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		key := make([]byte, keySize)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, errors.New("Cannot make key: " + err.Error())
+		}
+		err = os.MkdirAll(filepath.Dir(file), 0700)
+		if err != nil {
+			return nil, errors.New("Cannot make key " + file + ": " + err.Error())
+		}
+		err = ioutil.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"), 0600)
+		if err != nil {
+			return nil, errors.New("Cannot write key " + file + ": " + err.Error())
+		}
+		fmt.Println("Made key " + file + " to encrypt twitch tokens with")
+		return key, nil
+	}
+	if err != nil {
+		return nil, errors.New("Cannot read key " + file + ": " + err.Error())
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != keySize {
+		return nil, errors.New("key " + file + " must be " + strconv.Itoa(keySize) + " hex encoded bytes")
+	}
+	return key, nil
+}
+
+// Load reads the stored tokens. Tokens stored before every account had its
+// own, in the shared credential or in metadata before that, are taken by the
+// first account loading its tokens, the account of the profile as it is
+// loaded at start. Without an account, account_id 0, nothing is read and
+// twitch stays unauthorized.
+func (t *Tokens) Load(ctx context.Context) error {
+	if t.account_id == 0 {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.reauthorize = true
+		t.last_error = ErrNoAccount.Error()
+		return nil
+	}
+	token, found, err := t.open(ctx, t.credential)
+	if err != nil {
+		return err
+	}
+	if !found {
+		token, err = t.importLegacy(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+	t.reauthorize = token.AccessToken == "" && token.RefreshToken == ""
+	return nil
+}
+
+// open reads and decrypts the tokens stored in the credential name.
+func (t *Tokens) open(ctx context.Context, name string) (Token, bool, error) {
+	token := Token{}
+	credential, err := t.database.GetCredentialContext(ctx, name)
+	if err != nil || credential == nil {
+		return token, false, err
+	}
+	b, err := t.aead.Open(nil, credential.Nonce, credential.Secret, []byte(name))
+	if err != nil {
+		return token, false, errors.New("Cannot decrypt twitch tokens, was the key changed? " + err.Error())
+	}
+	err = json.Unmarshal(b, &token)
+	if err != nil {
+		return token, false, errors.New("Cannot read twitch tokens: " + err.Error())
+	}
+	return token, true, nil
+}
+
+// importLegacy moves the tokens of the shared credential, or of metadata,
+// to the credential of the account.
+func (t *Tokens) importLegacy(ctx context.Context) (Token, error) {
+	token, found, err := t.open(ctx, legacyCredential)
+	if err != nil {
+		return token, err
+	}
+	moved := "shared twitch tokens"
+	if !found {
+		token, err = t.metadataTokens(ctx)
+		if err != nil {
+			return token, err
+		}
+		moved = "twitch tokens in metadata"
+	}
+	if token.AccessToken == "" && token.RefreshToken == "" {
+		return token, nil
+	}
+	nonce, secret, err := t.seal(token)
+	if err != nil {
+		return token, err
+	}
+	err = t.database.Transaction(ctx, nil, func(tx *database.Tx) error {
+		err := tx.SaveCredential(t.credential, nonce, secret)
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteCredential(legacyCredential)
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteMetadataByKey("access_token")
+		if err != nil {
+			return err
+		}
+		return tx.DeleteMetadataByKey("refresh_token")
+	})
+	if err != nil {
+		return token, errors.New("Cannot move " + moved + ": " + err.Error())
+	}
+	fmt.Println("Moved " + moved + " to account " + strconv.FormatInt(t.account_id, 10))
+	return token, nil
+}
+
+func (t *Tokens) metadataTokens(ctx context.Context) (Token, error) {
+	token := Token{}
+	access_token, err := t.database.GetLatestMetadataByKeyContext(ctx, "access_token", 1)
+	if err != nil {
+		return token, err
+	}
+	if len(access_token) == 1 {
+		token.AccessToken = access_token[0].MetadataValue
+	}
+	refresh_token, err := t.database.GetLatestMetadataByKeyContext(ctx, "refresh_token", 1)
+	if err != nil {
+		return token, err
+	}
+	if len(refresh_token) == 1 {
+		token.RefreshToken = refresh_token[0].MetadataValue
+	}
+	return token, nil
+}
+
+func (t *Tokens) seal(token Token) ([]byte, []byte, error) {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return nil, nil, errors.New("Cannot encode twitch tokens: " + err.Error())
+	}
+	nonce := make([]byte, t.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, errors.New("Cannot make nonce: " + err.Error())
+	}
+	return nonce, t.aead.Seal(nil, nonce, b, []byte(t.credential)), nil
+}
+
+// save stores token and makes it the current one.
+func (t *Tokens) save(ctx context.Context, token Token) error {
+	nonce, secret, err := t.seal(token)
+	if err != nil {
+		return err
+	}
+	err = t.database.SaveCredentialContext(ctx, t.credential, nonce, secret)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+	t.validated = time.Now()
+	t.reauthorize = false
+	t.last_error = ""
+	return nil
+}
+
+// helix is a client for the token endpoints bound to ctx.
+func (t *Tokens) helix(ctx context.Context) (*helix.Client, error) {
+	options := t.options
+	options.UserAccessToken = ""
+	client, err := helix.NewClientWithContext(ctx, &options)
+	if err != nil {
+		return nil, errors.New("Cannot create twitch client: " + err.Error())
+	}
+	return client, nil
+}
+
+func expiresAt(expires_in int) time.Time {
+	if expires_in <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(expires_in) * time.Second)
+}
+
+// Authorize swaps the code twitch redirected back with for tokens.
+func (t *Tokens) Authorize(ctx context.Context, code string) error {
+	if t.account_id == 0 {
+		return ErrNoAccount
+	}
+	t.refresh.Lock()
+	defer t.refresh.Unlock()
+	client, err := t.helix(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := client.RequestUserAccessToken(code)
+	if err != nil {
+		return errors.New("Cannot request twitch tokens: " + err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Cannot request twitch tokens: " + resp.ErrorMessage)
+	}
+	err = t.save(ctx, Token{
+		AccessToken:  resp.Data.AccessToken,
+		RefreshToken: resp.Data.RefreshToken,
+		Scopes:       resp.Data.Scopes,
+		ExpiresAt:    expiresAt(resp.Data.ExpiresIn),
+	})
+	if err != nil {
+		return err
+	}
+	t.publish(TokenEvent{Type: TokenAuthorized})
+	return nil
+}
+
+// AccessToken is the current access token, refreshed first when it is about
+// to expire.
+func (t *Tokens) AccessToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	token := t.token
+	reauthorize := t.reauthorize
+	t.mu.Unlock()
+	if reauthorize {
+		return "", ErrReauthorize
+	}
+	if token.AccessToken == "" || (!token.ExpiresAt.IsZero() && time.Until(token.ExpiresAt) < tokenCheck) {
+		return t.Refresh(ctx, token.AccessToken)
+	}
+	return token.AccessToken, nil
+}
+
+// Refresh refreshes the access token stale, unless it has been refreshed
+// already by the time the refresh before it is done.
+func (t *Tokens) Refresh(ctx context.Context, stale string) (string, error) {
+	t.refresh.Lock()
+	defer t.refresh.Unlock()
+	t.mu.Lock()
+	token := t.token
+	reauthorize := t.reauthorize
+	t.mu.Unlock()
+	if reauthorize {
+		return "", ErrReauthorize
+	}
+	if token.AccessToken != stale && token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	if token.RefreshToken == "" {
+		t.requireReauthorize("there is no refresh token")
+		return "", ErrReauthorize
+	}
+	client, err := t.helix(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.RefreshUserAccessToken(token.RefreshToken)
+	if err != nil {
+		return "", errors.New("Cannot refresh twitch token: " + err.Error())
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized:
+		// the refresh token was revoked or the password changed
+		t.requireReauthorize(resp.ErrorMessage)
+		return "", ErrReauthorize
+	default:
+		return "", errors.New("Cannot refresh twitch token: " + strconv.Itoa(resp.StatusCode) + " " + resp.ErrorMessage)
+	}
+	token = Token{
+		AccessToken:  resp.Data.AccessToken,
+		RefreshToken: resp.Data.RefreshToken,
+		Scopes:       resp.Data.Scopes,
+		ExpiresAt:    expiresAt(resp.Data.ExpiresIn),
+	}
+	err = t.save(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	t.publish(TokenEvent{Type: TokenRefreshed})
+	return token.AccessToken, nil
+}
+
+func (t *Tokens) requireReauthorize(reason string) {
+	t.mu.Lock()
+	already := t.reauthorize
+	t.reauthorize = true
+	t.last_error = reason
+	t.mu.Unlock()
+	if !already {
+		t.publish(TokenEvent{Type: TokenReauthorize, Error: reason})
+	}
+}
+
+// Validate asks twitch whether the access token is still valid and refreshes
+// it when it is not.
+func (t *Tokens) Validate(ctx context.Context) error {
+	t.mu.Lock()
+	token := t.token
+	reauthorize := t.reauthorize
+	t.mu.Unlock()
+	if reauthorize {
+		return ErrReauthorize
+	}
+	client, err := t.helix(ctx)
+	if err != nil {
+		return err
+	}
+	valid, resp, err := client.ValidateToken(token.AccessToken)
+	if err != nil {
+		return errors.New("Cannot validate twitch token: " + err.Error())
+	}
+	if !valid {
+		_, err = t.Refresh(ctx, token.AccessToken)
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.validated = time.Now()
+	// a refresh while validating has the newer expiry already
+	if t.token.AccessToken == token.AccessToken {
+		t.token.ExpiresAt = expiresAt(resp.Data.ExpiresIn)
+	}
+	return nil
+}
+
+func (t *Tokens) Status() TokenStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TokenStatus{
+		Authorized: !t.reauthorize,
+		ExpiresAt:  t.token.ExpiresAt,
+		Validated:  t.validated,
+		Error:      t.last_error,
+	}
+}
+
+// Subscribe returns the token events and a func to stop them. Slow
+// subscribers miss events instead of blocking a refresh.
+func (t *Tokens) Subscribe() (<-chan TokenEvent, func()) {
+	events := make(chan TokenEvent, 16)
+	t.mu.Lock()
+	t.subscribers[events] = struct{}{}
+	t.mu.Unlock()
+	return events, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.subscribers[events]; ok {
+			delete(t.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+func (t *Tokens) publish(event TokenEvent) {
+	event.Time = time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for events := range t.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// check validates the token when it is due and refreshes it before it
+// expires.
+func (t *Tokens) check(ctx context.Context) error {
+	t.mu.Lock()
+	token := t.token
+	validated := t.validated
+	reauthorize := t.reauthorize
+	t.mu.Unlock()
+	if reauthorize {
+		return nil
+	}
+	if time.Since(validated) >= t.validate_every || token.ExpiresAt.IsZero() {
+		return t.Validate(ctx)
+	}
+	if time.Until(token.ExpiresAt) <= t.refresh_before {
+		_, err := t.Refresh(ctx, token.AccessToken)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package twitch
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jnrprgmr/strmr/pkg/database"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nicklaw5/helix/v2"
+)
+
+func TestTokensLegacyImport(t *testing.T) {
+	dir := t.TempDir()
+	conn, err := sqlx.Open("sqlite3", filepath.Join(dir, "strmr.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = database.Migrate(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.New(conn)
+	err = db.InsertMetadata("access_token", "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertMetadata("refresh_token", "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{KeyFile: filepath.Join(dir, "twitch.key")}
+	ctx := context.Background()
+
+	// without an account the tokens are left for the account configured later
+	tw, err := New(&helix.Options{ClientID: "client"}, db, c, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tw.Tokens.Status().Authorized {
+		t.Error("authorized without an account")
+	}
+	if err := tw.Tokens.Authorize(ctx, "code"); err != ErrNoAccount {
+		t.Errorf("authorizing without an account returned %v, want ErrNoAccount", err)
+	}
+	credential, err := db.GetCredential(credentialName(0))
+	if err != nil || credential != nil {
+		t.Fatalf("stored %v for no account: %v", credential, err)
+	}
+	left, err := db.GetLatestMetadataByKey("access_token", 1)
+	if err != nil || len(left) != 1 {
+		t.Fatalf("legacy access token removed without an account: %v", err)
+	}
+
+	account, err := tw.Account(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.Tokens.Status().Authorized {
+		t.Error("account 1 did not take the legacy tokens")
+	}
+	token, found, err := account.Tokens.open(ctx, credentialName(1))
+	if err != nil || !found || token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("account 1 stored %+v, %v: %v", token, found, err)
+	}
+	left, err = db.GetLatestMetadataByKey("access_token", 1)
+	if err != nil || len(left) != 0 {
+		t.Errorf("legacy access token kept after the import: %v", err)
+	}
+}
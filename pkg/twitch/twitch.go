@@ -1,16 +1,34 @@
 package twitch
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/jnrprgmr/strmr/pkg/database"
 	"github.com/nicklaw5/helix/v2"
 )
 
+// Twitch calls the twitch API with the tokens of one account, Account gives
+// the client of another.
 type Twitch struct {
-	Client       *helix.Client
-	Code         string
-	Token        string
-	RefreshToken string
+	Client   *helix.Client
+	Tokens   *Tokens
+	options  helix.Options
+	database *database.Database
+	config   Config
+	accounts *accountTokens
+}
+
+// accountTokens are the tokens of every account used so far, by account id.
+type accountTokens struct {
+	mu     sync.Mutex
+	tokens map[int64]*Tokens
 }
 
 type Category struct {
@@ -18,32 +36,156 @@ type Category struct {
 	BoxArtUrl string
 }
 
-func New(client *helix.Client) *Twitch {
-	return &Twitch{
-		Client:       client,
-		Code:         "",
-		Token:        "",
-		RefreshToken: "",
+// New makes a twitch client with the tokens of the account of account_id
+// stored in db, the account of the profile.
+func New(options *helix.Options, db *database.Database, c Config, account_id int64) (*Twitch, error) {
+	client, err := helix.NewClient(options)
+	if err != nil {
+		return nil, err
+	}
+	t := &Twitch{
+		Client:   client,
+		options:  *options,
+		database: db,
+		config:   c,
+		accounts: &accountTokens{tokens: map[int64]*Tokens{}},
+	}
+	t.Tokens, err = t.accountTokens(context.Background(), account_id)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// accountTokens returns the tokens of the account, loading them the first
+// time.
+func (t *Twitch) accountTokens(ctx context.Context, account_id int64) (*Tokens, error) {
+	t.accounts.mu.Lock()
+	defer t.accounts.mu.Unlock()
+	tokens, ok := t.accounts.tokens[account_id]
+	if ok {
+		return tokens, nil
+	}
+	tokens, err := NewTokens(t.database, t.options, t.config, account_id)
+	if err != nil {
+		return nil, err
+	}
+	err = tokens.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.accounts.tokens[account_id] = tokens
+	return tokens, nil
+}
+
+// Account returns a client calling twitch with the tokens of the account of
+// account_id.
+func (t *Twitch) Account(ctx context.Context, account_id int64) (*Twitch, error) {
+	tokens, err := t.accountTokens(ctx, account_id)
+	if err != nil {
+		return nil, err
+	}
+	account := *t
+	account.Tokens = tokens
+	return &account, nil
+}
+
+// Run keeps the tokens of every account valid until ctx is cancelled.
+func (t *Twitch) Run(ctx context.Context) {
+	ticker := time.NewTicker(tokenCheck)
+	defer ticker.Stop()
+	for {
+		t.accounts.mu.Lock()
+		tokens := []*Tokens{}
+		for _, account_tokens := range t.accounts.tokens {
+			tokens = append(tokens, account_tokens)
+		}
+		t.accounts.mu.Unlock()
+		for i := range tokens {
+			err := tokens[i].check(ctx)
+			if err != nil && err != ErrReauthorize && ctx.Err() == nil {
+				// tried again on the next tick
+				fmt.Println("Cannot keep twitch token of account " + strconv.FormatInt(tokens[i].account_id, 10) + " valid: " + err.Error())
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// client returns a helix client bound to ctx carrying the current user
+// access token, helix fixes the context of a client when it is created.
+func (t *Twitch) client(ctx context.Context, token string) (*helix.Client, error) {
+	options := t.options
+	options.UserAccessToken = token
+	client, err := helix.NewClientWithContext(ctx, &options)
+	if err != nil {
+		return nil, errors.New("Cannot create twitch client: " + err.Error())
+	}
+	return client, nil
+}
+
+// call makes a request with the user access token. When twitch rejects the
+// token it is refreshed and the request is made once more.
+func (t *Twitch) call(ctx context.Context, request func(client *helix.Client) (*helix.ResponseCommon, error)) error {
+	token, err := t.Tokens.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	for retried := false; ; retried = true {
+		client, err := t.client(ctx, token)
+		if err != nil {
+			return err
+		}
+		resp, err := request(client)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !retried {
+			token, err = t.Tokens.Refresh(ctx, token)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return errors.New("twitch answered " + strconv.Itoa(resp.StatusCode) + ": " + resp.ErrorMessage)
+		}
+		return nil
 	}
 }
 
 func (t *Twitch) ChangeStream(username string, title string, category_id string, tags []string) error {
-	authorized, _, err := t.Client.ValidateToken(t.Token)
-	if !authorized {
-		return errors.New("Not authorized to change stream title: " + err.Error())
+	return t.ChangeStreamContext(context.Background(), username, title, category_id, tags)
+}
+
+func (t *Twitch) ChangeStreamContext(ctx context.Context, username string, title string, category_id string, tags []string) error {
+	// logins are lower case, display names do not have to be
+	username = strings.ToLower(username)
+	users, err := t.GetUsersContext(ctx, []string{username})
+	if err != nil {
+		return errors.New("Could not find twitch user: " + err.Error())
 	}
-	users, err := t.GetUser([]string{username})
 	broadcaster_id, ok := users[username]
 	if !ok {
-		return errors.New("Could not find twith user: " + err.Error())
-	}
-	_, err = t.Client.EditChannelInformation(&helix.EditChannelInformationParams{
-		BroadcasterID:       broadcaster_id,
-		GameID:              category_id,
-		BroadcasterLanguage: "en",
-		Title:               title,
-		Tags:                tags,
-		Delay:               0,
+		return errors.New("Could not find twitch user " + username)
+	}
+	err = t.call(ctx, func(client *helix.Client) (*helix.ResponseCommon, error) {
+		resp, err := client.EditChannelInformation(&helix.EditChannelInformationParams{
+			BroadcasterID:       broadcaster_id,
+			GameID:              category_id,
+			BroadcasterLanguage: "en",
+			Title:               title,
+			Tags:                tags,
+			Delay:               0,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.ResponseCommon, nil
 	})
 	if err != nil {
 		return errors.New("Error changing twitch stream title: " + err.Error())
@@ -52,12 +194,20 @@ func (t *Twitch) ChangeStream(username string, title string, category_id string,
 }
 
 func (t *Twitch) GetUser(usernames []string) (map[string]string, error) {
-	authorized, _, err := t.Client.ValidateToken(t.Token)
-	if !authorized {
-		return nil, errors.New("Not authorized to get user: " + err.Error())
-	}
-	usersResp, err := t.Client.GetUsers(&helix.UsersParams{
-		Logins: usernames,
+	return t.GetUserContext(context.Background(), usernames)
+}
+
+func (t *Twitch) GetUserContext(ctx context.Context, usernames []string) (map[string]string, error) {
+	var usersResp *helix.UsersResponse
+	err := t.call(ctx, func(client *helix.Client) (*helix.ResponseCommon, error) {
+		var err error
+		usersResp, err = client.GetUsers(&helix.UsersParams{
+			Logins: usernames,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &usersResp.ResponseCommon, nil
 	})
 	if err != nil {
 		return nil, errors.New("Could not get users: " + err.Error())
@@ -71,18 +221,23 @@ func (t *Twitch) GetUser(usernames []string) (map[string]string, error) {
 }
 
 func (t *Twitch) GetGames(names []string) (map[string]string, error) {
-	authorized, _, err := t.Client.ValidateToken(t.Token)
-	if !authorized {
-		return nil, errors.New("Not authorized to get games")
-	}
-	if err != nil {
-		return nil, errors.New("Error getting games in GetGames: " + err.Error())
-	}
-	gamesResp, err := t.Client.GetGames(&helix.GamesParams{
-		Names: names,
+	return t.GetGamesContext(context.Background(), names)
+}
+
+func (t *Twitch) GetGamesContext(ctx context.Context, names []string) (map[string]string, error) {
+	var gamesResp *helix.GamesResponse
+	err := t.call(ctx, func(client *helix.Client) (*helix.ResponseCommon, error) {
+		var err error
+		gamesResp, err = client.GetGames(&helix.GamesParams{
+			Names: names,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &gamesResp.ResponseCommon, nil
 	})
 	if err != nil {
-		return nil, errors.New("Could not get users: " + err.Error())
+		return nil, errors.New("Error getting games in GetGames: " + err.Error())
 	}
 	games := map[string]string{}
 	for i := range gamesResp.Data.Games {
@@ -93,18 +248,23 @@ func (t *Twitch) GetGames(names []string) (map[string]string, error) {
 }
 
 func (t *Twitch) GetUsers(names []string) (map[string]string, error) {
-	authorized, _, err := t.Client.ValidateToken(t.Token)
-	if !authorized {
-		return nil, errors.New("Not authorized to get user")
-	}
-	if err != nil {
-		return nil, errors.New("Error getting user in GetUsers: " + err.Error())
-	}
-	usersResp, err := t.Client.GetUsers(&helix.UsersParams{
-		Logins: names,
+	return t.GetUsersContext(context.Background(), names)
+}
+
+func (t *Twitch) GetUsersContext(ctx context.Context, names []string) (map[string]string, error) {
+	var usersResp *helix.UsersResponse
+	err := t.call(ctx, func(client *helix.Client) (*helix.ResponseCommon, error) {
+		var err error
+		usersResp, err = client.GetUsers(&helix.UsersParams{
+			Logins: names,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &usersResp.ResponseCommon, nil
 	})
 	if err != nil {
-		return nil, errors.New("Could not get users: " + err.Error())
+		return nil, errors.New("Error getting user in GetUsers: " + err.Error())
 	}
 	users := map[string]string{}
 	for i := range usersResp.Data.Users {
@@ -122,15 +282,20 @@ type Channel struct {
 }
 
 func (t *Twitch) GetChannelInformation(ids []string) (map[string]Channel, error) {
-	authorized, _, err := t.Client.ValidateToken(t.Token)
-	if !authorized {
-		return nil, errors.New("Not authorized to get channels")
-	}
-	if err != nil {
-		return nil, errors.New("Error getting channels in SearchChannels: " + err.Error())
-	}
-	channelsResp, err := t.Client.GetChannelInformation(&helix.GetChannelInformationParams{
-		BroadcasterIDs: ids,
+	return t.GetChannelInformationContext(context.Background(), ids)
+}
+
+func (t *Twitch) GetChannelInformationContext(ctx context.Context, ids []string) (map[string]Channel, error) {
+	var channelsResp *helix.GetChannelInformationResponse
+	err := t.call(ctx, func(client *helix.Client) (*helix.ResponseCommon, error) {
+		var err error
+		channelsResp, err = client.GetChannelInformation(&helix.GetChannelInformationParams{
+			BroadcasterIDs: ids,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &channelsResp.ResponseCommon, nil
 	})
 	if err != nil {
 		return nil, errors.New("Could not get channels: " + err.Error())
@@ -149,15 +314,20 @@ func (t *Twitch) GetChannelInformation(ids []string) (map[string]Channel, error)
 }
 
 func (t *Twitch) SearchCategories(query string) (map[string]Category, error) {
-	authorized, _, err := t.Client.ValidateToken(t.Token)
-	if !authorized {
-		return nil, errors.New("Not authorized to get categories")
-	}
-	if err != nil {
-		return nil, errors.New("Error getting categories in SearchCategories: " + err.Error())
-	}
-	categoriesResp, err := t.Client.SearchCategories(&helix.SearchCategoriesParams{
-		Query: query,
+	return t.SearchCategoriesContext(context.Background(), query)
+}
+
+func (t *Twitch) SearchCategoriesContext(ctx context.Context, query string) (map[string]Category, error) {
+	var categoriesResp *helix.SearchCategoriesResponse
+	err := t.call(ctx, func(client *helix.Client) (*helix.ResponseCommon, error) {
+		var err error
+		categoriesResp, err = client.SearchCategories(&helix.SearchCategoriesParams{
+			Query: query,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &categoriesResp.ResponseCommon, nil
 	})
 	if err != nil {
 		return nil, errors.New("Could not search categories: " + err.Error())
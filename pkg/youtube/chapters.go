@@ -0,0 +1,149 @@
+package youtube
+
+import (
+	"sort"
+	"strings"
+)
+
+// YouTube only turns description timestamps into chapters when the first is
+// at 00:00, there are at least MinChapters of them in ascending order and
+// each lasts at least MinChapterLength seconds.
+const (
+	MinChapters      = 3
+	MinChapterLength = 10
+)
+
+const (
+	DroppedRepeat   = "repeats the previous chapter"
+	DroppedTooShort = "shorter than 10 seconds"
+)
+
+type Chapter struct {
+	Start int64
+	Title string
+}
+
+type DroppedChapter struct {
+	Chapter
+	Reason string
+}
+
+type ChapterList struct {
+	Chapters []Chapter
+	Dropped  []DroppedChapter
+}
+
+// Valid reports whether YouTube will recognise the list as chapters.
+func (c ChapterList) Valid() bool {
+	return len(c.Chapters) >= MinChapters
+}
+
+func (c ChapterList) Text() string {
+	text := ""
+	for i := range c.Chapters {
+		text = text + GetTimestamp(c.Chapters[i].Start) + " " + c.Chapters[i].Title + "\n"
+	}
+	return text
+}
+
+type chapterEvent struct {
+	Metadata
+	category bool
+}
+
+// BuildChapters merges task and category changes into chapters titled
+// "category: task", length is the recording length in seconds. Metadata set
+// before the recording started counts from 00:00 and initial titles the
+// chapter at 00:00 while neither is known. Chapters that repeat the previous
+// one or are too short are dropped and reported, a short chapter at 00:00
+// hands 00:00 to the chapter after it.
+func BuildChapters(tasks []Metadata, categories []Metadata, length int64, initial string) ChapterList {
+	events := []chapterEvent{}
+	for i := range tasks {
+		events = append(events, chapterEvent{tasks[i], false})
+	}
+	for i := range categories {
+		events = append(events, chapterEvent{categories[i], true})
+	}
+	for i := range events {
+		if events[i].Start < 0 {
+			events[i].Start = 0
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start < events[j].Start
+	})
+	candidates := []Chapter{}
+	category := ""
+	task := ""
+	for i := 0; i < len(events); {
+		start := events[i].Start
+		// apply everything that changed in the same second before titling it
+		for ; i < len(events) && events[i].Start == start; i++ {
+			if events[i].category {
+				category = strings.TrimSpace(events[i].Text)
+			} else {
+				task = strings.TrimSpace(events[i].Text)
+			}
+		}
+		candidates = append(candidates, Chapter{Start: start, Title: chapterTitle(category, task, initial)})
+	}
+	if len(candidates) == 0 || candidates[0].Start > 0 {
+		candidates = append([]Chapter{{Start: 0, Title: initial}}, candidates...)
+	}
+	list := ChapterList{
+		Chapters: []Chapter{},
+		Dropped:  []DroppedChapter{},
+	}
+	// pending is the chapter being checked, how long it lasts is only known
+	// once the next chapter that is kept starts
+	var pending *Chapter
+	for i := range candidates {
+		c := candidates[i]
+		if pending != nil && pending.Title == c.Title {
+			list.Dropped = append(list.Dropped, DroppedChapter{c, DroppedRepeat})
+			continue
+		}
+		if pending != nil {
+			if c.Start-pending.Start < MinChapterLength {
+				list.Dropped = append(list.Dropped, DroppedChapter{*pending, DroppedTooShort})
+				if len(list.Chapters) == 0 {
+					c.Start = 0
+				}
+			} else {
+				list.Chapters = append(list.Chapters, *pending)
+			}
+			pending = nil
+		}
+		last := len(list.Chapters) - 1
+		if last >= 0 && list.Chapters[last].Title == c.Title {
+			list.Dropped = append(list.Dropped, DroppedChapter{c, DroppedRepeat})
+			continue
+		}
+		pending = &c
+	}
+	if pending != nil {
+		if length > 0 && length-pending.Start < MinChapterLength {
+			list.Dropped = append(list.Dropped, DroppedChapter{*pending, DroppedTooShort})
+		} else {
+			list.Chapters = append(list.Chapters, *pending)
+		}
+	}
+	sort.SliceStable(list.Dropped, func(i, j int) bool {
+		return list.Dropped[i].Start < list.Dropped[j].Start
+	})
+	return list
+}
+
+func chapterTitle(category string, task string, initial string) string {
+	switch {
+	case category != "" && task != "":
+		return category + ": " + task
+	case category != "":
+		return category
+	case task != "":
+		return task
+	default:
+		return initial
+	}
+}
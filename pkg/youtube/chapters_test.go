@@ -0,0 +1,108 @@
+package youtube
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildChapters(t *testing.T) {
+	tests := []struct {
+		name       string
+		tasks      []Metadata
+		categories []Metadata
+		length     int64
+		chapters   []Chapter
+		dropped    []DroppedChapter
+	}{
+		{
+			name:     "nothing set",
+			length:   600,
+			chapters: []Chapter{{0, "Starting stream"}},
+		},
+		{
+			name:     "first chapter at 00:00",
+			tasks:    []Metadata{{"tests", 60}, {"docs", 300}},
+			length:   600,
+			chapters: []Chapter{{0, "Starting stream"}, {60, "tests"}, {300, "docs"}},
+		},
+		{
+			name:       "set before the recording and in the same second",
+			tasks:      []Metadata{{"tests", -30}, {"docs", 120}},
+			categories: []Metadata{{"Software", -300}, {"Writing", 120}},
+			length:     600,
+			chapters:   []Chapter{{0, "Software: tests"}, {120, "Writing: docs"}},
+		},
+		{
+			name:     "repeats dropped",
+			tasks:    []Metadata{{"tests", 0}, {"tests", 60}, {"docs", 120}, {"docs", 200}},
+			length:   600,
+			chapters: []Chapter{{0, "tests"}, {120, "docs"}},
+			dropped:  []DroppedChapter{{Chapter{60, "tests"}, DroppedRepeat}, {Chapter{200, "docs"}, DroppedRepeat}},
+		},
+		{
+			name:     "too short dropped",
+			tasks:    []Metadata{{"tests", 0}, {"typo", 60}, {"docs", 65}},
+			length:   600,
+			chapters: []Chapter{{0, "tests"}, {65, "docs"}},
+			dropped:  []DroppedChapter{{Chapter{60, "typo"}, DroppedTooShort}},
+		},
+		{
+			name:     "short first chapter hands 00:00 on",
+			tasks:    []Metadata{{"tests", 0}, {"docs", 5}, {"review", 300}},
+			length:   600,
+			chapters: []Chapter{{0, "docs"}, {300, "review"}},
+			dropped:  []DroppedChapter{{Chapter{0, "tests"}, DroppedTooShort}},
+		},
+		{
+			name:     "length up to the next kept chapter",
+			tasks:    []Metadata{{"tests", 0}, {"docs", 100}, {"docs", 105}, {"review", 200}},
+			length:   600,
+			chapters: []Chapter{{0, "tests"}, {100, "docs"}, {200, "review"}},
+			dropped:  []DroppedChapter{{Chapter{105, "docs"}, DroppedRepeat}},
+		},
+		{
+			name:     "repeat of the chapter before a short one",
+			tasks:    []Metadata{{"tests", 0}, {"typo", 50}, {"tests", 55}, {"docs", 100}},
+			length:   600,
+			chapters: []Chapter{{0, "tests"}, {100, "docs"}},
+			dropped:  []DroppedChapter{{Chapter{50, "typo"}, DroppedTooShort}, {Chapter{55, "tests"}, DroppedRepeat}},
+		},
+		{
+			name:     "short last chapter",
+			tasks:    []Metadata{{"tests", 0}, {"docs", 595}},
+			length:   600,
+			chapters: []Chapter{{0, "tests"}},
+			dropped:  []DroppedChapter{{Chapter{595, "docs"}, DroppedTooShort}},
+		},
+		{
+			name:     "last chapter kept without a length",
+			tasks:    []Metadata{{"tests", 0}, {"docs", 595}},
+			chapters: []Chapter{{0, "tests"}, {595, "docs"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list := BuildChapters(test.tasks, test.categories, test.length, "Starting stream")
+			if test.dropped == nil {
+				test.dropped = []DroppedChapter{}
+			}
+			if !reflect.DeepEqual(list.Chapters, test.chapters) {
+				t.Errorf("got chapters %v, want %v", list.Chapters, test.chapters)
+			}
+			if !reflect.DeepEqual(list.Dropped, test.dropped) {
+				t.Errorf("got dropped %v, want %v", list.Dropped, test.dropped)
+			}
+			if list.Valid() != (len(test.chapters) >= MinChapters) {
+				t.Errorf("Valid is %v with %d chapters", list.Valid(), len(list.Chapters))
+			}
+		})
+	}
+}
+
+func TestChapterListText(t *testing.T) {
+	list := ChapterList{Chapters: []Chapter{{0, "Starting stream"}, {75, "tests"}, {3725, "docs"}}}
+	want := "00:00:00 Starting stream\n00:01:15 tests\n01:02:05 docs\n"
+	if list.Text() != want {
+		t.Errorf("got %q, want %q", list.Text(), want)
+	}
+}
@@ -0,0 +1,177 @@
+package youtube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// ChunkSize is the default size of a resumable upload chunk, the API needs
+// chunks to be a multiple of 256KiB.
+const ChunkSize = 8 * 1024 * 1024
+
+// ErrSessionExpired means the resumable upload session is gone and the upload
+// has to start again with a new session.
+var ErrSessionExpired = errors.New("youtube upload session expired")
+
+// StatusError is an unexpected response from the resumable upload API.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return "youtube upload returned status " + strconv.Itoa(e.Code) + ": " + e.Body
+}
+
+// Retryable reports whether retrying err later may succeed. Everything is
+// retryable except client errors from the upload API other than timeouts and
+// rate limits.
+func Retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500 || status.Code == http.StatusRequestTimeout || status.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// NewVideo builds the video resource for an upload, policy is expected to be
+// complete, e.g. database.DefaultPublishPolicy merged with any overrides.
+func NewVideo(title string, description string, tags []string, recording_time string, category string, policy database.PublishPolicy) *youtube.Video {
+	video := &youtube.Video{
+		Snippet: &youtube.VideoSnippet{
+			Title:                title,
+			Description:          description,
+			CategoryId:           category,
+			DefaultAudioLanguage: policy.Language,
+			DefaultLanguage:      policy.Language,
+			Tags:                 tags,
+		},
+		Status: &youtube.VideoStatus{
+			PrivacyStatus: policy.Privacy,
+			PublishAt:     policy.PublishAt,
+			License:       policy.License,
+			ForceSendFields: []string{
+				"SelfDeclaredMadeForKids",
+				"Embeddable",
+			},
+		},
+		RecordingDetails: &youtube.VideoRecordingDetails{
+			RecordingDate: recording_time,
+		},
+	}
+	if policy.MadeForKids != nil {
+		video.Status.SelfDeclaredMadeForKids = *policy.MadeForKids
+	}
+	video.Status.Embeddable = policy.Embeddable == nil || *policy.Embeddable
+	return video
+}
+
+// StartResumableUpload creates an upload session for a video of size bytes
+// and returns its url, the url stays valid for about a week.
+func (yt *YouTube) StartResumableUpload(ctx context.Context, video *youtube.Video, size int64, notify_subscribers bool) (string, error) {
+	body, err := json.Marshal(video)
+	if err != nil {
+		return "", errors.New("Cannot encode video for upload: " + err.Error())
+	}
+	url := googleapi.ResolveRelative(yt.service.BasePath, "upload/youtube/v3/videos") + "?uploadType=resumable&part=snippet,status,recordingDetails&notifySubscribers=" + strconv.FormatBool(notify_subscribers)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", errors.New("Cannot create upload session request: " + err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("X-Upload-Content-Type", "video/*")
+	resp, err := yt.client.Do(req)
+	if err != nil {
+		return "", errors.New("Cannot start upload session: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(resp)
+	}
+	session_url := resp.Header.Get("Location")
+	if session_url == "" {
+		return "", errors.New("Upload session response has no location")
+	}
+	return session_url, nil
+}
+
+// ResumableUploadOffset asks the session how many bytes it has stored. The
+// video id is returned instead once the upload is complete.
+func (yt *YouTube) ResumableUploadOffset(ctx context.Context, session_url string, size int64) (int64, *string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session_url, nil)
+	if err != nil {
+		return 0, nil, errors.New("Cannot create upload status request: " + err.Error())
+	}
+	req.Header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+	return yt.resumableRequest(req, size)
+}
+
+// UploadChunk sends length bytes of r starting at offset and returns the
+// offset the session has stored up to, which may be less than requested.
+func (yt *YouTube) UploadChunk(ctx context.Context, session_url string, r io.ReaderAt, offset int64, length int64, size int64) (int64, *string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, session_url, io.NewSectionReader(r, offset, length))
+	if err != nil {
+		return 0, nil, errors.New("Cannot create upload chunk request: " + err.Error())
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", "video/*")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	return yt.resumableRequest(req, size)
+}
+
+func (yt *YouTube) resumableRequest(req *http.Request, size int64) (int64, *string, error) {
+	resp, err := yt.client.Do(req)
+	if err != nil {
+		return 0, nil, errors.New("Cannot reach upload session: " + err.Error())
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var video youtube.Video
+		err = json.NewDecoder(resp.Body).Decode(&video)
+		if err != nil {
+			return 0, nil, errors.New("Cannot decode uploaded video: " + err.Error())
+		}
+		return size, &video.Id, nil
+	case http.StatusPermanentRedirect:
+		// "Resume Incomplete", Range is absent until the first byte is stored
+		r := resp.Header.Get("Range")
+		if r == "" {
+			return 0, nil, nil
+		}
+		parts := strings.SplitN(strings.TrimPrefix(r, "bytes="), "-", 2)
+		if len(parts) != 2 {
+			return 0, nil, errors.New("Cannot parse upload range [" + r + "]")
+		}
+		last, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, nil, errors.New("Cannot parse upload range [" + r + "]: " + err.Error())
+		}
+		return last + 1, nil, nil
+	case http.StatusNotFound, http.StatusGone:
+		return 0, nil, ErrSessionExpired
+	default:
+		return 0, nil, statusError(resp)
+	}
+}
+
+func statusError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &StatusError{
+		Code: resp.StatusCode,
+		Body: strings.TrimSpace(string(b)),
+	}
+}
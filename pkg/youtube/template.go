@@ -0,0 +1,166 @@
+package youtube
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jnrprgmr/strmr/pkg/database"
+)
+
+// YouTube rejects titles and descriptions over these lengths or containing
+// angle brackets.
+const (
+	MaxTitleLength       = 100
+	MaxDescriptionLength = 5000
+)
+
+const DefaultTitleTemplate = `{{ join (texts .Data.Titles) "/" }}`
+
+const DefaultDescriptionTemplate = `Categories:
+{{ join (texts .Data.Categories) "\n" }}
+
+{{ join (texts .Data.Descriptions) "\n" }}
+
+Timestamps:
+{{ .Data.Chapters.Text }}
+Socials
+{{ range .Account.Socials }}{{ .Name }}: {{ .URL }}
+{{ end }}
+{{ join (hashtags .Tags) " " }}
+Streamed: {{ rfc3339 .Start }}`
+
+// ClipDescriptionTemplate describes a clip cut for a task, Data.Titles
+// holds the stream title at the start of the clip.
+const ClipDescriptionTemplate = `{{ .Task }}
+
+{{ with last .Data.Titles }}From the stream "{{ . }}", {{ end }}streamed {{ rfc3339 .Start }}
+{{ with hashtags .Tags }}
+{{ join . " " }}{{ end }}`
+
+// TemplateData is what title and description templates are executed with.
+// Account is the account the video is uploaded for, Task is the task a clip
+// was cut for and empty for whole recordings.
+type TemplateData struct {
+	Account  database.Account
+	Data     YouTubeData
+	Tags     []string
+	Task     string
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+}
+
+// UniqueTags splits the comma separated tag metadata into sorted unique tags.
+func UniqueTags(tags []Metadata) []string {
+	unique := map[string]bool{}
+	for i := range tags {
+		split := strings.Split(tags[i].Text, ",")
+		for j := range split {
+			tag := strings.TrimSpace(split[j])
+			if tag != "" {
+				unique[tag] = true
+			}
+		}
+	}
+	result := []string{}
+	for tag := range unique {
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	return result
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"texts": func(metadata []Metadata) []string {
+		texts := []string{}
+		for i := range metadata {
+			texts = append(texts, metadata[i].Text)
+		}
+		return texts
+	},
+	"first": func(metadata []Metadata) string {
+		if len(metadata) == 0 {
+			return ""
+		}
+		return metadata[0].Text
+	},
+	"last": func(metadata []Metadata) string {
+		if len(metadata) == 0 {
+			return ""
+		}
+		return metadata[len(metadata)-1].Text
+	},
+	"hashtags": func(tags []string) []string {
+		hashtags := []string{}
+		for i := range tags {
+			hashtags = append(hashtags, "#"+strings.ReplaceAll(tags[i], " ", ""))
+		}
+		return hashtags
+	},
+	"timestamp": GetTimestamp,
+	"rfc3339": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339Nano)
+	},
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"duration": func(d time.Duration) string {
+		return GetTimestamp(int64(d.Seconds()))
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// ParseTemplate parses a title or description template with the helper
+// functions available.
+func ParseTemplate(name string, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.New("Cannot parse " + name + " template: " + err.Error())
+	}
+	return t, nil
+}
+
+// RenderTemplate parses and executes text with data.
+func RenderTemplate(name string, text string, data TemplateData) (string, error) {
+	t, err := ParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", errors.New("Cannot render " + name + " template: " + err.Error())
+	}
+	return b.String(), nil
+}
+
+// CheckVideoText lists why YouTube would reject a rendered title or
+// description, it is empty when both are fine.
+func CheckVideoText(title string, description string) []string {
+	problems := []string{}
+	if strings.TrimSpace(title) == "" {
+		problems = append(problems, "title is empty")
+	}
+	if n := utf8.RuneCountInString(title); n > MaxTitleLength {
+		problems = append(problems, "title is "+strconv.Itoa(n)+" characters, the limit is "+strconv.Itoa(MaxTitleLength))
+	}
+	if n := len(description); n > MaxDescriptionLength {
+		problems = append(problems, "description is "+strconv.Itoa(n)+" bytes, the limit is "+strconv.Itoa(MaxDescriptionLength))
+	}
+	if strings.ContainsAny(title, "<>") {
+		problems = append(problems, "title cannot contain < or >")
+	}
+	if strings.ContainsAny(description, "<>") {
+		problems = append(problems, "description cannot contain < or >")
+	}
+	return problems
+}
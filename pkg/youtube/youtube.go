@@ -1,17 +1,23 @@
 package youtube
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 
+	"github.com/jnrprgmr/strmr/pkg/database"
+	"github.com/jnrprgmr/strmr/pkg/subtitle"
+	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
 type YouTube struct {
 	service *youtube.Service
+	client  *http.Client
 }
 
 type Playlist struct {
@@ -33,21 +39,6 @@ func GetTimestamp(ts int64) string {
 	return fmt.Sprintf("%02s", strconv.Itoa(int(hours))) + ":" + fmt.Sprintf("%02s", strconv.Itoa(int(min))) + ":" + fmt.Sprintf("%02s", strconv.Itoa(int(sec)))
 }
 
-type Subtitle struct {
-	Text  string
-	Start int64
-	End   int64
-}
-
-func CreateSubtitleText(subtitles []Subtitle) string {
-	text := ""
-	for i := range subtitles {
-		s := subtitles[i]
-		text = text + strconv.Itoa(i+1) + "\n" + GetTimestamp(s.Start) + ",000 --> " + GetTimestamp(s.End) + ",000\n" + s.Text + "\n\n"
-	}
-	return text
-}
-
 type YouTubeData struct {
 	File         string
 	Categories   []Metadata
@@ -55,7 +46,8 @@ type YouTubeData struct {
 	Tags         []Metadata
 	Descriptions []Metadata
 	Tasks        []Metadata
-	Subtitles    []Subtitle
+	Subtitles    []subtitle.Cue
+	Chapters     ChapterList
 }
 
 func CreateMetadataText(metadata []Metadata, initial string) string {
@@ -74,41 +66,36 @@ func CreateTitleText(titles []Metadata, delimiter string) string {
 	return strings.Join(text, delimiter)
 }
 
-func New(svc *youtube.Service) *YouTube {
+// New creates the YouTube client from an authorized http client, the http
+// client is also used directly for resumable uploads.
+func New(client *http.Client) (*YouTube, error) {
+	svc, err := youtube.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, errors.New("Cannot create youtube service: " + err.Error())
+	}
 	return &YouTube{
 		service: svc,
-	}
+		client:  client,
+	}, nil
+}
+
+func (yt *YouTube) UploadVideo(file_name string, title string, description string, tags []string, recording_time string, category string, policy database.PublishPolicy) (*string, error) {
+	return yt.UploadVideoContext(context.Background(), file_name, title, description, tags, recording_time, category, policy)
 }
 
-func (yt *YouTube) UploadVideo(file_name string, title string, description string, tags []string, recording_time string, category string) (*string, error) {
+func (yt *YouTube) UploadVideoContext(ctx context.Context, file_name string, title string, description string, tags []string, recording_time string, category string, policy database.PublishPolicy) (*string, error) {
 	fmt.Println("starting video upload")
-	upload := &youtube.Video{
-		Snippet: &youtube.VideoSnippet{
-			Title:                title,
-			Description:          description,
-			CategoryId:           category,
-			DefaultAudioLanguage: "en",
-			DefaultLanguage:      "en",
-			Tags:                 tags,
-		},
-		Status: &youtube.VideoStatus{
-			PrivacyStatus:           "private",
-			SelfDeclaredMadeForKids: false,
-			ForceSendFields: []string{
-				"SelfDeclaredMadeForKids",
-			},
-		},
-		RecordingDetails: &youtube.VideoRecordingDetails{
-			RecordingDate: recording_time,
-		},
-	}
+	upload := NewVideo(title, description, tags, recording_time, category, policy)
 	call := yt.service.Videos.Insert([]string{"snippet", "status", "recordingDetails"}, upload)
+	if policy.NotifySubscribers != nil {
+		call = call.NotifySubscribers(*policy.NotifySubscribers)
+	}
 	file, err := os.Open(file_name)
 	if err != nil {
 		return nil, errors.New("Error opening " + file_name + ": " + err.Error())
 	}
 	defer file.Close()
-	response, err := call.Media(file).Do()
+	response, err := call.Media(file).Context(ctx).Do()
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
@@ -117,11 +104,15 @@ func (yt *YouTube) UploadVideo(file_name string, title string, description strin
 }
 
 func (yt *YouTube) GetPlaylists() ([]Playlist, error) {
+	return yt.GetPlaylistsContext(context.Background())
+}
+
+func (yt *YouTube) GetPlaylistsContext(ctx context.Context) ([]Playlist, error) {
 	call := yt.service.Playlists.List([]string{
 		"snippet",
 		"id",
 	})
-	resp, err := call.Mine(true).Do()
+	resp, err := call.Mine(true).Context(ctx).Do()
 	if err != nil {
 		return nil, errors.New("Cannot get youtube playlists: " + err.Error())
 	}
@@ -136,6 +127,10 @@ func (yt *YouTube) GetPlaylists() ([]Playlist, error) {
 }
 
 func (yt *YouTube) InsertPlaylist(video_id string, playlist_id string) error {
+	return yt.InsertPlaylistContext(context.Background(), video_id, playlist_id)
+}
+
+func (yt *YouTube) InsertPlaylistContext(ctx context.Context, video_id string, playlist_id string) error {
 	fmt.Println("Adding to playlist")
 	playlist := &youtube.PlaylistItem{
 		Snippet: &youtube.PlaylistItemSnippet{
@@ -146,7 +141,7 @@ func (yt *YouTube) InsertPlaylist(video_id string, playlist_id string) error {
 			},
 		},
 	}
-	response, err := yt.service.PlaylistItems.Insert([]string{"snippet"}, playlist).Do()
+	response, err := yt.service.PlaylistItems.Insert([]string{"snippet"}, playlist).Context(ctx).Do()
 	if err != nil {
 		return errors.New("Could not add video to playlist: " + err.Error())
 	}
@@ -155,6 +150,10 @@ func (yt *YouTube) InsertPlaylist(video_id string, playlist_id string) error {
 }
 
 func (yt *YouTube) InsertCaption(video_id string, file_name string) error {
+	return yt.InsertCaptionContext(context.Background(), video_id, file_name)
+}
+
+func (yt *YouTube) InsertCaptionContext(ctx context.Context, video_id string, file_name string) error {
 	fmt.Println("starting caption upload")
 	caption := &youtube.Caption{
 		Snippet: &youtube.CaptionSnippet{
@@ -169,7 +168,7 @@ func (yt *YouTube) InsertCaption(video_id string, file_name string) error {
 		return errors.New("Error opening " + file_name + ": " + err.Error())
 	}
 	defer file.Close()
-	response, err := call.Media(file).Do()
+	response, err := call.Media(file).Context(ctx).Do()
 	if err != nil {
 		return errors.New(err.Error())
 	}
@@ -177,13 +176,35 @@ func (yt *YouTube) InsertCaption(video_id string, file_name string) error {
 	return nil
 }
 
+func (yt *YouTube) SetThumbnail(video_id string, file_name string) error {
+	return yt.SetThumbnailContext(context.Background(), video_id, file_name)
+}
+
+func (yt *YouTube) SetThumbnailContext(ctx context.Context, video_id string, file_name string) error {
+	file, err := os.Open(file_name)
+	if err != nil {
+		return errors.New("Error opening " + file_name + ": " + err.Error())
+	}
+	defer file.Close()
+	_, err = yt.service.Thumbnails.Set(video_id).Media(file).Context(ctx).Do()
+	if err != nil {
+		return errors.New("Could not set thumbnail: " + err.Error())
+	}
+	fmt.Println("Set thumbnail of video " + video_id)
+	return nil
+}
+
 type Category struct {
 	ID    string
 	Title string
 }
 
 func (yt *YouTube) GetCategories() ([]Category, error) {
-	resp, err := yt.service.VideoCategories.List([]string{"id"}).RegionCode("US").Do()
+	return yt.GetCategoriesContext(context.Background())
+}
+
+func (yt *YouTube) GetCategoriesContext(ctx context.Context) ([]Category, error) {
+	resp, err := yt.service.VideoCategories.List([]string{"id"}).RegionCode("US").Context(ctx).Do()
 	if err != nil {
 		return nil, errors.New("Cannot get youtube categories: " + err.Error())
 	}
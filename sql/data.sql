@@ -1,3 +0,0 @@
-INSERT INTO metadata (metadata_key, metadata_value) VALUES('task_background_config', '4271296285,1600,50,0,0');
-INSERT INTO metadata (metadata_key, metadata_value) VALUES('task_config', '4291297280,1600,50,0,0');
-INSERT INTO metadata (metadata_key, metadata_value) VALUES('task', 'Create Task');
\ No newline at end of file
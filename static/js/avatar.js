@@ -1,21 +1,86 @@
 $(() => {
-    setInterval(
-        function(){ 
-            $.ajax({
-                type: 'GET',
-                url: "/avatar_status",
-                success: function(resultData) {
-                    console.log("here")
-                    $("#mouth").addClass("open")
-                    $("#mouth").removeClass("close")
-                },
-                error: function() {
-                    console.log("bad")
-                    $("#mouth").removeClass("open")
-                    $("#mouth").addClass("close")
-                }
-            });
-        }, 
-        500
-    );
-});
\ No newline at end of file
+    var expression = "idle"
+    var assets = {closed: "", open: ""}
+    // mouth heights in px, closed and fully open
+    var closed = 10
+    var open = 90
+    var speaking = null
+    var started = 0
+    var retry = 1000
+
+    // expressions with images replace the drawn face
+    function setExpression(name, images) {
+        $("#face").removeClass("expression-" + expression)
+        $("#face").addClass("expression-" + name)
+        expression = name
+        assets = images
+        if (assets.closed) {
+            $("#face").hide()
+            $("#avatar-image").show()
+        } else {
+            $("#avatar-image").hide()
+            $("#face").show()
+        }
+    }
+
+    function setMouth(level) {
+        $("#mouth").css("height", (closed + (open - closed) * level) + "px")
+        if (assets.closed) {
+            var src = level > 0.3 && assets.open ? assets.open : assets.closed
+            if ($("#avatar-image").attr("src") !== src) {
+                $("#avatar-image").attr("src", src)
+            }
+        }
+    }
+
+    function animate() {
+        if (speaking === null) {
+            setMouth(0)
+            return
+        }
+        var elapsed = performance.now() - started
+        var level = 1
+        if (speaking.envelope && speaking.envelope.length > 0) {
+            var i = Math.floor(elapsed / speaking.step_millis)
+            level = i < speaking.envelope.length ? speaking.envelope[i] : 0
+        }
+        setMouth(level)
+        requestAnimationFrame(animate)
+    }
+
+    function apply(event) {
+        setExpression(event.expression, event.assets)
+        var was_speaking = speaking !== null
+        speaking = event.speaking
+        if (speaking === null) {
+            $("#speech-text").text("")
+            setMouth(0)
+            return
+        }
+        // a late connection starts as far into the speech as the server is
+        started = performance.now() - Math.max(0, event.time_millis - speaking.start_millis)
+        $("#speech-text").text(speaking.text)
+        if (!was_speaking) {
+            requestAnimationFrame(animate)
+        }
+    }
+
+    function connect() {
+        var scheme = location.protocol === "https:" ? "wss://" : "ws://"
+        var socket = new WebSocket(scheme + location.host + "/avatar/events")
+        socket.onopen = function() {
+            retry = 1000
+        }
+        socket.onmessage = function(message) {
+            apply(JSON.parse(message.data))
+        }
+        socket.onclose = function() {
+            speaking = null
+            $("#speech-text").text("")
+            setTimeout(connect, retry)
+            retry = Math.min(retry * 2, 30000)
+        }
+    }
+
+    connect()
+});
@@ -88,6 +88,43 @@ $(() => {
         })
         $("#avatar-text").val("")
     })
+    $("#speech-skip").on("click", function() {
+        $.ajax({
+            type: 'POST',
+            url: "/speech/skip"
+        })
+    })
+    $("#speech-clear").on("click", function() {
+        $.ajax({
+            type: 'POST',
+            url: "/speech/clear"
+        })
+    })
+    $("#speech-paused").on("change", function() {
+        $.ajax({
+            type: 'POST',
+            url: "/speech/pause",
+            data: JSON.stringify({
+                paused: $(this).is(":checked")
+            })
+        })
+    })
+    $.ajax({
+        type: 'GET',
+        url: "/avatar/expression",
+        success: function(state) {
+            $("#avatar-expression").val(state.expression)
+        }
+    })
+    $("#avatar-expression").on("change", function() {
+        $.ajax({
+            type: 'POST',
+            url: "/avatar/expression",
+            data: JSON.stringify({
+                expression: $(this).val()
+            })
+        })
+    })
     $("#update-stream").on("click", function() {
         var streamEnabled = $("#stream-enabled").is(":checked")
         var recordEnabled = $("#record-enabled").is(":checked")
@@ -0,0 +1,104 @@
+function recordingID() {
+    return parseInt($("#recording").data("recording-id")) || -1
+}
+
+function toSeconds(millis) {
+    return (millis / 1000).toFixed(3)
+}
+
+function toMillis(seconds) {
+    return Math.round(parseFloat(seconds) * 1000)
+}
+
+function showCues(data) {
+    var problems = $("#problems").empty()
+    data.problems.forEach(function(problem) {
+        problems.append($("<li>").text(problem))
+    })
+    var length = parseInt($("#recording").data("length"))
+    var rows = $("#cues tbody").empty()
+    data.cues.forEach(function(cue) {
+        var row = $("<tr>").addClass("cue").attr("id", "cue-" + cue.id).data("id", cue.id)
+        row.append($("<td>").append($("<input>").attr("type", "checkbox").addClass("cue-select")))
+        row.append($("<td>").append($("<input>").attr({type: "number", step: "0.001", min: 0, max: toSeconds(length)}).addClass("cue-start").val(toSeconds(cue.start))))
+        row.append($("<td>").append($("<input>").attr({type: "number", step: "0.001", min: 0, max: toSeconds(length)}).addClass("cue-end").val(toSeconds(cue.end))))
+        row.append($("<td>").append($("<textarea>").attr({rows: 2, cols: 80}).addClass("cue-text").val(cue.text)))
+        var buttons = $("<td>")
+        buttons.append($("<button>").addClass("cue-save").text("Save"))
+        buttons.append($("<button>").addClass("cue-split").text("Split at cursor"))
+        buttons.append($("<button>").addClass("cue-delete").text("Delete"))
+        row.append(buttons)
+        rows.append(row)
+    })
+}
+
+function editCues(url, data) {
+    data.recording_id = recordingID()
+    $.ajax({
+        type: 'POST',
+        url: url,
+        data: JSON.stringify(data),
+        contentType: "application/json; charset=utf-8",
+        success: showCues,
+        error: function(request, status, error) {
+            alert(request.responseText);
+        }
+    });
+}
+
+$(() => {
+    $.ajax({
+        type: 'GET',
+        url: "/subtitles/cues?recording_id=" + recordingID(),
+        success: showCues,
+        error: function(request, status, error) {
+            alert(request.responseText);
+        }
+    });
+    $("#cues").on("click", ".cue-save", function() {
+        var row = $(this).closest(".cue")
+        editCues("/subtitles/cues/update", {
+            id: row.data("id"),
+            text: row.find(".cue-text").val(),
+            start: toMillis(row.find(".cue-start").val()),
+            end: toMillis(row.find(".cue-end").val())
+        })
+    });
+    $("#cues").on("click", ".cue-delete", function() {
+        var row = $(this).closest(".cue")
+        if (!confirm("Delete \"" + row.find(".cue-text").val() + "\"?")) {
+            return
+        }
+        editCues("/subtitles/cues/delete", {
+            id: row.data("id")
+        })
+    });
+    // the text is split at the cursor and the time in the same proportion
+    $("#cues").on("click", ".cue-split", function() {
+        var row = $(this).closest(".cue")
+        var textarea = row.find(".cue-text")
+        var text = textarea.val()
+        var cursor = textarea[0].selectionStart
+        if (cursor <= 0 || cursor >= text.length) {
+            alert("Place the cursor in the text where it should be split")
+            return
+        }
+        var start = toMillis(row.find(".cue-start").val())
+        var end = toMillis(row.find(".cue-end").val())
+        editCues("/subtitles/cues/split", {
+            id: row.data("id"),
+            at: start + Math.round((end - start) * cursor / text.length),
+            text: text.substring(0, cursor),
+            second_text: text.substring(cursor)
+        })
+    });
+    $("#merge").on("click", function() {
+        var ids = []
+        $(".cue-select:checked").each(function() {
+            ids.push($(this).closest(".cue").data("id"))
+        })
+        editCues("/subtitles/cues/merge", {
+            ids: ids
+        })
+    });
+});
@@ -1,4 +1,9 @@
 $(() => {
+    // requests are made for the account the page was opened for
+    var account = new URLSearchParams(location.search).get("account")
+    if (account) {
+        $.ajaxSetup({headers: {"X-Strmr-Account": account}})
+    }
     $("#change-stream").on("click", function() {
         var $tags = $("#tags").find(".tag")
         var tags = []
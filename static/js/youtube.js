@@ -1,16 +1,237 @@
+function formatBytes(bytes) {
+    var units = ["B", "KB", "MB", "GB", "TB"]
+    var i = 0
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes = bytes / 1024
+        i++
+    }
+    return bytes.toFixed(1) + " " + units[i]
+}
+
+function formatETA(seconds) {
+    if (seconds === null) {
+        return ""
+    }
+    var h = Math.floor(seconds / 3600)
+    var m = Math.floor((seconds % 3600) / 60)
+    var s = seconds % 60
+    return " ETA " + h + "h " + m + "m " + s + "s"
+}
+
+function refreshUploads() {
+    $.ajax({
+        type: 'GET',
+        url: "/youtube/uploads",
+        success: function(jobs) {
+            var uploads = $("#uploads").empty()
+            jobs.forEach(function(job) {
+                var percent = job.bytes_total > 0 ? Math.floor(job.bytes_sent * 100 / job.bytes_total) : 0
+                var text = job.title + " [" + job.state + "] " + percent + "% " +
+                    formatBytes(job.bytes_sent) + " / " + formatBytes(job.bytes_total)
+                if (job.bytes_per_second > 0) {
+                    text = text + " " + formatBytes(job.bytes_per_second) + "/s" + formatETA(job.eta_seconds)
+                }
+                if (job.state == "queued" && job.last_error) {
+                    text = text + " retrying at " + new Date(job.next_attempt_time * 1000).toLocaleTimeString()
+                }
+                if (job.last_error) {
+                    text = text + " (" + job.last_error + ")"
+                }
+                if (job.reschedule) {
+                    text = text + " missed its publish time, uploaded private to be scheduled again"
+                }
+                var row = $("<div>").addClass("upload-job").attr("id", "upload-" + job.id).text(text)
+                if (job.video_id) {
+                    row.append(" ", $("<a>").attr("href", "https://youtu.be/" + job.video_id).text(job.video_id))
+                }
+                uploads.append(row)
+            })
+        }
+    });
+}
+
+// waitForJob polls a background job until it is done or failed.
+function waitForJob(job, done, failed) {
+    if (job.state == "done") {
+        done(job.result)
+        return
+    }
+    if (job.state == "failed") {
+        failed(job.error)
+        return
+    }
+    setTimeout(function() {
+        $.ajax({
+            type: 'GET',
+            url: "/youtube/job?id=" + job.id,
+            success: function(job) {
+                waitForJob(job, done, failed)
+            },
+            error: function(request, status, error) {
+                failed(request.responseText)
+            }
+        });
+    }, 2000)
+}
+
 $(() => {
+    // requests are made for the account the page was opened for
+    var account = new URLSearchParams(location.search).get("account")
+    if (account) {
+        $.ajaxSetup({headers: {"X-Strmr-Account": account}})
+    }
+    refreshUploads()
+    setInterval(refreshUploads, 2000)
+    $.ajax({
+        type: 'GET',
+        url: "/youtube/template",
+        success: function(template) {
+            $("#title-template").val(template.title)
+            $("#description-template").val(template.description)
+        }
+    });
+    $("#save-template").on("click", function() {
+        $.ajax({
+            type: 'POST',
+            url: "/youtube/template",
+            data: JSON.stringify({
+                title: $("#title-template").val(),
+                description: $("#description-template").val()
+            }),
+            contentType: "application/json; charset=utf-8",
+            success: function(resultData) {},
+            error: function(request, status, error) {
+                alert(request.responseText);
+            }
+        });
+    });
+    $("#videos").on("click", ".preview", function() {
+        var id = $(this).parent().attr("id")
+        $.ajax({
+            type: 'POST',
+            url: "/youtube/template/preview",
+            data: JSON.stringify({
+                recording_id: parseInt(id) || -1,
+                title: $("#title-template").val(),
+                description: $("#description-template").val()
+            }),
+            contentType: "application/json; charset=utf-8",
+            success: function(preview) {
+                var text = preview.title + "\n\n" + preview.description
+                if (preview.problems.length > 0) {
+                    text = "Problems: " + preview.problems.join(", ") + "\n\n" + text
+                }
+                $("#template-preview").text(text)
+            },
+            error: function(request, status, error) {
+                alert(request.responseText);
+            }
+        });
+    });
+    $("#videos").on("click", ".thumbnails", function() {
+        var id = $(this).parent().attr("id")
+        var candidates = $(this).siblings(".thumbnail-candidates").text("Making thumbnails...")
+        $.ajax({
+            type: 'POST',
+            url: "/youtube/thumbnails",
+            data: JSON.stringify({
+                recording_id: parseInt(id) || -1,
+                regenerate: $(this).siblings("label").find(".thumbnail-regenerate").is(":checked")
+            }),
+            contentType: "application/json; charset=utf-8",
+            success: function(job) {
+                waitForJob(job, function(thumbnails) {
+                    candidates.empty()
+                    for (var i = 0; i < thumbnails.length; i++) {
+                        var thumbnail = thumbnails[i]
+                        var label = $("<label>").addClass("thumbnail-candidate")
+                        label.append($("<input>").attr({type: "radio", name: "thumbnail-" + id, value: thumbnail.index}))
+                        label.append($("<img>").attr({src: thumbnail.url, alt: "Thumbnail at " + thumbnail.offset + "s"}))
+                        candidates.append(label)
+                    }
+                }, function(error) {
+                    candidates.empty()
+                    alert(error);
+                })
+            },
+            error: function(request, status, error) {
+                candidates.empty()
+                alert(request.responseText);
+            }
+        });
+    });
+    $("#videos").on("click", ".clips", function() {
+        var id = $(this).parent().attr("id")
+        var privacy = $(this).siblings(".upload-privacy").find(":selected").val()
+        var publish_at = $(this).siblings(".upload-publish-at").val()
+        var results = $(this).siblings(".clip-results").empty().append($("<li>").text("Cutting clips..."))
+        $.ajax({
+            type: 'POST',
+            url: "/youtube/clips",
+            data: JSON.stringify({
+                recording_id: parseInt(id) || -1,
+                short: $(this).siblings("label").find(".clip-short").is(":checked"),
+                upload: $(this).siblings("label").find(".clip-upload").is(":checked"),
+                playlist_id: $(this).siblings(".select-playlist").find(":selected").val() || "",
+                publish_policy: {
+                    privacy: privacy || "",
+                    publish_at: publish_at ? new Date(publish_at).toISOString() : ""
+                }
+            }),
+            contentType: "application/json; charset=utf-8",
+            success: function(job) {
+                waitForJob(job, function(clips) {
+                    results.empty()
+                    for (var i = 0; i < clips.length; i++) {
+                        var clip = clips[i]
+                        var text = clip.start + "s-" + clip.end + "s " + clip.task
+                        if (clip.skipped) {
+                            text = text + " (skipped, " + clip.skipped + ")"
+                        } else {
+                            text = text + ": " + clip.file
+                        }
+                        if (clip.upload_job_id !== null) {
+                            text = text + " (upload " + clip.upload_job_id + " queued)"
+                        }
+                        if (clip.upload_error) {
+                            text = text + " (not uploaded: " + clip.upload_error + ")"
+                        }
+                        results.append($("<li>").text(text))
+                    }
+                    refreshUploads()
+                }, function(error) {
+                    results.empty()
+                    alert(error);
+                })
+            },
+            error: function(request, status, error) {
+                results.empty()
+                alert(request.responseText);
+            }
+        });
+    });
     $("#videos").on("click", ".upload", function(){
         var id = $(this).parent().attr("id")
         var playlist_id = $(this).siblings(".select-playlist").find(":selected").val()
+        var privacy = $(this).siblings(".upload-privacy").find(":selected").val()
+        var publish_at = $(this).siblings(".upload-publish-at").val()
+        var thumbnail = $(this).siblings(".thumbnail-candidates").find("input:checked").val()
         var saveData = $.ajax({
             type: 'POST',
             url: "/youtube_upload",
             data: JSON.stringify({
                 recording_id: parseInt(id) || -1,
-                playlist_id: playlist_id || ""
+                playlist_id: playlist_id || "",
+                publish_policy: {
+                    privacy: privacy || "",
+                    publish_at: publish_at ? new Date(publish_at).toISOString() : ""
+                },
+                thumbnail: thumbnail === undefined ? null : parseInt(thumbnail)
             }),
             contentType: "application/json; charset=utf-8",
-            success: function(resultData) {},
+            success: function(resultData) {
+                refreshUploads()
+            },
             error: function(request, status, error) {
                 alert(request.responseText);
             }
@@ -19,12 +240,18 @@ $(() => {
     $("#categories").on("click", ".save-category", function() {
         var related_id = $(this).siblings(".category-options").find(":selected").val()
         var category_name = $(this).siblings(".category-name").text()
+        var privacy = $(this).siblings(".category-privacy").find(":selected").val()
+        var publish_after = $(this).siblings(".category-publish-after").val()
         var saveData = $.ajax({
             type: 'POST',
             url: "/youtube_category",
             data: JSON.stringify({
                 related_id: related_id || "",
-                category_name: category_name || ""
+                category_name: category_name || "",
+                publish_policy: {
+                    privacy: privacy || "",
+                    publish_after: publish_after || ""
+                }
             }),
             contentType: "application/json; charset=utf-8",
             success: function(resultData) {},